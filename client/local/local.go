@@ -15,13 +15,17 @@
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"iter"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/netip"
+	"net/textproto"
 	"net/url"
 	"os/exec"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
@@ -787,6 +791,68 @@ func (lc *Client) PushFile(ctx context.Context, target tailcfg.StableNodeID, siz
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// PushDir sends the files of the directory fsys listed in manifest to
+// target, to be received as a directory named name with their layout
+// preserved. The manifest, with the files' sizes and SHA-256 checksums, is
+// sent ahead of them, and the target checks the files it receives against
+// it. Files the target already received intact in an earlier attempt are
+// not sent again.
+func (lc *Client) PushDir(ctx context.Context, target tailcfg.StableNodeID, name string, fsys fs.FS, manifest []apitype.FileManifestEntry) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeDirParts(mw, fsys, manifest))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put-dir/"+string(target)+"/"+url.PathEscape(name), pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == 200 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	all, _ := io.ReadAll(res.Body)
+	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+}
+
+// writeDirParts writes the manifest of a directory, and then the files of
+// fsys it lists, to mw as the parts of a PushDir request.
+func writeDirParts(mw *multipart.Writer, fsys fs.FS, manifest []apitype.FileManifestEntry) error {
+	w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		return err
+	}
+	for _, f := range manifest {
+		w, err := mw.CreateFormFile(f.Path, path.Base(f.Path))
+		if err != nil {
+			return err
+		}
+		src, err := fsys.Open(f.Path)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.LimitReader(src, f.Size))
+		src.Close()
+		if err != nil {
+			return err
+		}
+		if n != f.Size {
+			return fmt.Errorf("%s: file changed while sending", f.Path)
+		}
+	}
+	return mw.Close()
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -1453,6 +1519,16 @@ func (lc *Client) GetServeConfig(ctx context.Context) (*ipn.ServeConfig, error)
 	return sc, nil
 }
 
+// ServeBackendStatus returns the state of the load balanced serve backends,
+// keyed by the comma-separated list of backends of each load balancer.
+func (lc *Client) ServeBackendStatus(ctx context.Context) (map[string][]ipn.ServeBackendStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/serve-backends")
+	if err != nil {
+		return nil, fmt.Errorf("getting serve backend status: %w", err)
+	}
+	return decodeJSON[map[string][]ipn.ServeBackendStatus](body)
+}
+
 func getServeConfigFromJSON(body []byte) (sc *ipn.ServeConfig, err error) {
 	if err := json.Unmarshal(body, &sc); err != nil {
 		return nil, err
@@ -1606,6 +1682,44 @@ func (lc *Client) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterR
 	return decodeJSON[[]tailcfg.FilterRule](body)
 }
 
+// DebugFilterStats returns the counters of the packet filter's rules.
+func (lc *Client) DebugFilterStats(ctx context.Context) (*apitype.FilterStats, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-filter-stats", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.FilterStats](body)
+}
+
+// DebugFilterConnTrack returns the packet filter's connection tracking table.
+func (lc *Client) DebugFilterConnTrack(ctx context.Context) (*apitype.FilterConnTrack, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-filter-conntrack", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.FilterConnTrack](body)
+}
+
+// DebugFilterExplain reports whether the packet filter allows a new incoming
+// connection of protocol proto (such as "tcp" or "udp") from src to dst:port,
+// and which rule decided it. If dst is the zero value, the node's own
+// Tailscale IP of the same address family as src is used.
+func (lc *Client) DebugFilterExplain(ctx context.Context, src, dst netip.Addr, port uint16, proto string) (*apitype.FilterExplanation, error) {
+	v := url.Values{
+		"src":   {src.String()},
+		"port":  {strconv.Itoa(int(port))},
+		"proto": {proto},
+	}
+	if dst.IsValid() {
+		v.Set("dst", dst.String())
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-filter-explain?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.FilterExplanation](body)
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
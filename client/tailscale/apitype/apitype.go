@@ -5,6 +5,9 @@
 package apitype
 
 import (
+	"net/netip"
+	"time"
+
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/ctxkey"
@@ -56,6 +59,19 @@ type WaitingFile struct {
 	Size int64
 }
 
+// FileManifestEntry is a file of a directory sent with Taildrop, as listed
+// in the manifest sent ahead of the files.
+type FileManifestEntry struct {
+	// Path is the slash-separated path of the file, relative to the
+	// directory.
+	Path string
+
+	Size int64
+
+	// SHA256 is the hex-encoded SHA-256 checksum of the file's contents.
+	SHA256 string
+}
+
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
 type SetPushDeviceTokenRequest struct {
 	// PushDeviceToken is the iOS/macOS APNs device token (and any future Android equivalent).
@@ -94,3 +110,82 @@ type DNSQueryResponse struct {
 	// Resolvers is the list of resolvers that the forwarder deemed able to resolve the query.
 	Resolvers []*dnstype.Resolver
 }
+
+// FilterRuleStats is a rule of the packet filter and its counters, as
+// returned by the LocalAPI debug-filter-stats endpoint.
+type FilterRuleStats struct {
+	// Index is the index of the rule in the node's packet filter, and
+	// of the rule from the control plane it was derived from.
+	Index int
+
+	// Rule is the rule, in the packet filter's notation.
+	Rule string
+
+	// Hits is the number of incoming new connections and packets the
+	// rule accepted.
+	Hits uint64
+}
+
+// FilterStats is the response to a LocalAPI debug-filter-stats request.
+// Its counters start at zero whenever the packet filter changes.
+type FilterStats struct {
+	Rules []FilterRuleStats
+
+	// Drops is the number of incoming packets dropped, by reason.
+	Drops map[string]uint64 `json:",omitempty"`
+}
+
+// FilterExplanation is the response to a LocalAPI debug-filter-explain
+// request, explaining the packet filter's verdict on an incoming
+// connection.
+type FilterExplanation struct {
+	Verdict string // "Accept" or "Drop"
+	Reason  string // as in tailscaled's logs
+
+	// Index is the index of the rule that accepted the connection in the
+	// node's packet filter, or -1 if no rule did.
+	Index int
+
+	// Rule is the rule that accepted the connection, in the packet
+	// filter's notation, if any.
+	Rule string `json:",omitempty"`
+
+	// FilterRule is the rule from the control plane that Rule was derived
+	// from, if known.
+	FilterRule *tailcfg.FilterRule `json:",omitempty"`
+}
+
+// FilterConnTrack is the response to a LocalAPI debug-filter-conntrack
+// request: the packet filter's table of the flows this node initiated, whose
+// return traffic it lets in.
+type FilterConnTrack struct {
+	// Flows are the flows in the table, from the most to the least
+	// recently active.
+	Flows []FilterFlow
+
+	// MaxEntries is the size of the table of UDP and SCTP flows, and
+	// MaxICMPEntries that of ICMP Echo flows.
+	MaxEntries     int
+	MaxICMPEntries int
+
+	// Evicted is the number of flows evicted from the full table while
+	// still active, and Expired the number that timed out.
+	Evicted, Expired uint64
+}
+
+// FilterFlow is a flow in a FilterConnTrack.
+type FilterFlow struct {
+	Proto string // "udp", "sctp", "icmp" or "ipv6-icmp"
+
+	// Src and Dst are the source and destination of the return traffic:
+	// the remote end and this node. For ICMP, Dst's port is the Echo
+	// identifier.
+	Src, Dst netip.AddrPort
+
+	// Idle is the time since the flow's last packet.
+	Idle time.Duration
+
+	// Timeout is how long the flow may be idle before it expires, or
+	// zero if it only leaves the table when evicted.
+	Timeout time.Duration `json:",omitempty"`
+}
@@ -37,6 +37,16 @@ type dnsEntryMap struct {
 	bootstrapLookupMap  syncs.Map[string, bool]
 )
 
+// bootstrapDNSNames and unpublishedDNSNames are the comma-separated lists
+// of names served at /bootstrap-dns, from the flags or the config file.
+var (
+	bootstrapDNSNames   syncs.AtomicValue[string]
+	unpublishedDNSNames syncs.AtomicValue[string]
+
+	// refreshBootstrapDNSNow is signaled when the names change.
+	refreshBootstrapDNSNow = make(chan struct{}, 1)
+)
+
 var (
 	bootstrapDNSRequests        = expvar.NewInt("counter_bootstrap_dns_requests")
 	publishedDNSHits            = expvar.NewInt("counter_bootstrap_dns_published_hits")
@@ -52,24 +62,45 @@ func init() {
 	}))
 }
 
-func refreshBootstrapDNSLoop() {
-	if *bootstrapDNS == "" && *unpublishedDNS == "" {
-		return
+// setBootstrapDNSNames sets the published and unpublished lists of names
+// served at /bootstrap-dns, and resolves them again if they changed.
+func setBootstrapDNSNames(published, unpublished string) {
+	oldPublished := bootstrapDNSNames.Swap(published)
+	oldUnpublished := unpublishedDNSNames.Swap(unpublished)
+	if oldPublished != published || oldUnpublished != unpublished {
+		select {
+		case refreshBootstrapDNSNow <- struct{}{}:
+		default:
+		}
 	}
+}
+
+func refreshBootstrapDNSLoop() {
 	for {
+		// Drain any pending signal; the names are about to be resolved anyway.
+		select {
+		case <-refreshBootstrapDNSNow:
+		default:
+		}
 		refreshBootstrapDNS()
 		refreshUnpublishedDNS()
-		time.Sleep(10 * time.Minute)
+		select {
+		case <-time.After(10 * time.Minute):
+		case <-refreshBootstrapDNSNow:
+		}
 	}
 }
 
 func refreshBootstrapDNS() {
-	if *bootstrapDNS == "" {
+	names := bootstrapDNSNames.Load()
+	if names == "" {
+		dnsCache.Store(nil)
+		dnsCacheBytes.Store(nil)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *bootstrapDNS)
+	dnsEntries := resolveList(ctx, names)
 	// Randomize the order of the IPs for each name to avoid the client biasing
 	// to IPv6
 	for _, vv := range dnsEntries.IPs {
@@ -86,12 +117,14 @@ func refreshBootstrapDNS() {
 }
 
 func refreshUnpublishedDNS() {
-	if *unpublishedDNS == "" {
+	names := unpublishedDNSNames.Load()
+	if names == "" {
+		unpublishedDNSCache.Store(nil)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *unpublishedDNS)
+	dnsEntries := resolveList(ctx, names)
 	unpublishedDNSCache.Store(dnsEntries)
 }
 
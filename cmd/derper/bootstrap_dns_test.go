@@ -15,12 +15,12 @@
 	"reflect"
 	"testing"
 
-	"tailscale.com/tstest"
 	"tailscale.com/tstest/nettest"
 )
 
 func BenchmarkHandleBootstrapDNS(b *testing.B) {
-	tstest.Replace(b, bootstrapDNS, "log.tailscale.com,login.tailscale.com,controlplane.tailscale.com,login.us.tailscale.com")
+	prev := bootstrapDNSNames.Swap("log.tailscale.com,login.tailscale.com,controlplane.tailscale.com,login.us.tailscale.com")
+	b.Cleanup(func() { bootstrapDNSNames.Store(prev) })
 	refreshBootstrapDNS()
 	w := new(bitbucketResponseWriter)
 	req, _ := http.NewRequest("GET", "https://localhost/bootstrap-dns?q="+url.QueryEscape("log.tailscale.com"), nil)
@@ -65,12 +65,11 @@ func TestUnpublishedDNS(t *testing.T) {
 	const published = "login.tailscale.com"
 	const unpublished = "log.tailscale.com"
 
-	prev1, prev2 := *bootstrapDNS, *unpublishedDNS
-	*bootstrapDNS = published
-	*unpublishedDNS = unpublished
+	prev1 := bootstrapDNSNames.Swap(published)
+	prev2 := unpublishedDNSNames.Swap(unpublished)
 	t.Cleanup(func() {
-		*bootstrapDNS = prev1
-		*unpublishedDNS = prev2
+		bootstrapDNSNames.Store(prev1)
+		unpublishedDNSNames.Store(prev2)
 	})
 
 	refreshBootstrapDNS()
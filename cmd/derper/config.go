@@ -0,0 +1,232 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/tailscale/hujson"
+	"golang.org/x/time/rate"
+	"tailscale.com/derp"
+	"tailscale.com/syncs"
+	"tailscale.com/types/key"
+)
+
+// config is the contents of the derper config file (the -c flag). It is
+// HuJSON: JSON with comments and trailing commas.
+//
+// Fields other than PrivateKey are optional. When set, they take precedence
+// over the corresponding flags, and they can be changed without restarting
+// derper or dropping client connections by sending derper a SIGHUP.
+type config struct {
+	PrivateKey key.NodePrivate
+
+	// Bandwidth, if non-nil, limits the rate at which each client may send
+	// packets through the server.
+	Bandwidth *derp.BandwidthLimits `json:",omitempty"`
+
+	// MeshWith, if non-nil, replaces --mesh-with. Each entry is a hostname
+	// to mesh with, optionally followed by a slash and the hostname to dial.
+	MeshWith []string `json:",omitempty"`
+
+	// VerifyClientURL, if non-nil, replaces --verify-client-url.
+	VerifyClientURL *string `json:",omitempty"`
+
+	// VerifyClientURLFailOpen, if non-nil, replaces
+	// --verify-client-url-fail-open.
+	VerifyClientURLFailOpen *bool `json:",omitempty"`
+
+	// AcceptConnectionLimit and AcceptConnectionBurst, if non-nil, replace
+	// --accept-connection-limit and --accept-connection-burst.
+	AcceptConnectionLimit *float64 `json:",omitempty"`
+	AcceptConnectionBurst *int     `json:",omitempty"`
+
+	// Home, if non-nil, replaces --home.
+	Home *string `json:",omitempty"`
+
+	// BootstrapDNSNames and UnpublishedBootstrapDNSNames, if non-nil,
+	// replace --bootstrap-dns-names and --unpublished-bootstrap-dns-names.
+	// Each entry is one name in the format of the flag.
+	BootstrapDNSNames            []string `json:",omitempty"`
+	UnpublishedBootstrapDNSNames []string `json:",omitempty"`
+}
+
+// parseConfig parses and validates the HuJSON config file contents b.
+func parseConfig(b []byte) (config, error) {
+	b, err := hujson.Standardize(b)
+	if err != nil {
+		return config{}, err
+	}
+	var cfg config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return config{}, err
+	}
+	if err := cfg.validate(); err != nil {
+		return config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *config) validate() error {
+	for _, hostTuple := range c.meshWith() {
+		if _, _, err := parseMeshHostTuple(hostTuple); err != nil {
+			return err
+		}
+	}
+	if l := c.acceptConnLimit(); l < 0 || math.IsNaN(l) {
+		return fmt.Errorf("invalid accept connection limit %v", l)
+	}
+	if b := c.acceptConnBurst(); b < 0 {
+		return fmt.Errorf("invalid accept connection burst %v", b)
+	}
+	if _, ok := getHomeHandler(c.home()); !ok {
+		return fmt.Errorf("unknown home value %q", c.home())
+	}
+	for _, name := range slices.Concat(c.BootstrapDNSNames, c.UnpublishedBootstrapDNSNames) {
+		if name == "" || strings.Contains(name, ",") {
+			return fmt.Errorf("invalid bootstrap DNS name %q", name)
+		}
+	}
+	return nil
+}
+
+// configOrFlag returns *v if v is non-nil, else the flag value def.
+func configOrFlag[T any](v *T, def T) T {
+	if v != nil {
+		return *v
+	}
+	return def
+}
+
+func (c *config) meshWith() []string {
+	if c.MeshWith != nil {
+		return c.MeshWith
+	}
+	if *meshWith == "" {
+		return nil
+	}
+	return strings.Split(*meshWith, ",")
+}
+
+func (c *config) verifyClientURL() string {
+	return configOrFlag(c.VerifyClientURL, *verifyClientURL)
+}
+
+func (c *config) verifyClientURLFailOpen() bool {
+	return configOrFlag(c.VerifyClientURLFailOpen, *verifyFailOpen)
+}
+
+func (c *config) acceptConnLimit() float64 {
+	return configOrFlag(c.AcceptConnectionLimit, *acceptConnLimit)
+}
+
+func (c *config) acceptConnBurst() int {
+	return configOrFlag(c.AcceptConnectionBurst, *acceptConnBurst)
+}
+
+func (c *config) home() string {
+	return configOrFlag(c.Home, *flagHome)
+}
+
+func (c *config) bootstrapDNSNames() string {
+	if c.BootstrapDNSNames != nil {
+		return strings.Join(c.BootstrapDNSNames, ",")
+	}
+	return *bootstrapDNS
+}
+
+func (c *config) unpublishedDNSNames() string {
+	if c.UnpublishedBootstrapDNSNames != nil {
+		return strings.Join(c.UnpublishedBootstrapDNSNames, ",")
+	}
+	return *unpublishedDNS
+}
+
+// liveConfig holds the parts of derper that are reconfigured when the
+// config file is reloaded.
+type liveConfig struct {
+	s          *derp.Server
+	mesh       *meshClients
+	home       syncs.AtomicValue[http.Handler]
+	acceptConn *rate.Limiter // shared by the TLS listener
+}
+
+func newLiveConfig(s *derp.Server) *liveConfig {
+	return &liveConfig{
+		s:          s,
+		mesh:       &meshClients{s: s},
+		acceptConn: rate.NewLimiter(rate.Inf, 0),
+	}
+}
+
+// apply applies the reloadable parts of cfg, which must have been
+// validated.
+func (lc *liveConfig) apply(cfg config) error {
+	home, ok := getHomeHandler(cfg.home())
+	if !ok {
+		return fmt.Errorf("unknown home value %q", cfg.home())
+	}
+	if err := lc.mesh.set(cfg.meshWith()); err != nil {
+		return err
+	}
+	lc.s.SetVerifyClientURL(cfg.verifyClientURL())
+	lc.s.SetVerifyClientURLFailOpen(cfg.verifyClientURLFailOpen())
+	lc.s.SetBandwidthLimits(configOrFlag(cfg.Bandwidth, derp.BandwidthLimits{}))
+	lc.acceptConn.SetLimit(rate.Limit(cfg.acceptConnLimit()))
+	lc.acceptConn.SetBurst(cfg.acceptConnBurst())
+	lc.home.Store(home)
+	setBootstrapDNSNames(cfg.bootstrapDNSNames(), cfg.unpublishedDNSNames())
+	return nil
+}
+
+// reload re-reads the config file and applies it. If the file is invalid,
+// the previous config stays in effect.
+func (lc *liveConfig) reload() error {
+	if *configPath == "" {
+		return errors.New("no config file in use")
+	}
+	b, err := os.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg, err := parseConfig(b)
+	if err != nil {
+		return err
+	}
+	if !cfg.PrivateKey.Equal(lc.s.PrivateKey()) {
+		return errors.New("PrivateKey cannot be changed without a restart")
+	}
+	return lc.apply(cfg)
+}
+
+// reloadOnSIGHUP reloads the config file each time derper receives SIGHUP,
+// until ctx is done.
+func (lc *liveConfig) reloadOnSIGHUP(ctx context.Context) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigc:
+		}
+		if err := lc.reload(); err != nil {
+			log.Printf("derper: config reload failed, keeping previous config: %v", err)
+			continue
+		}
+		log.Printf("derper: reloaded config from %s", *configPath)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/derp"
+	"tailscale.com/types/key"
+)
+
+func TestParseConfig(t *testing.T) {
+	k := key.NewNode()
+	kText, _ := k.MarshalText()
+
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+		check   func(t *testing.T, cfg config)
+	}{
+		{
+			name: "key_only",
+			in:   fmt.Sprintf(`{"PrivateKey": %q}`, kText),
+			check: func(t *testing.T, cfg config) {
+				if !cfg.PrivateKey.Equal(k) {
+					t.Errorf("PrivateKey mismatch")
+				}
+				if got, want := cfg.home(), *flagHome; got != want {
+					t.Errorf("home = %q; want flag value %q", got, want)
+				}
+			},
+		},
+		{
+			name: "hujson_overrides",
+			in: fmt.Sprintf(`{
+				"PrivateKey": %q,
+				// Comments and trailing commas are allowed.
+				"MeshWith": ["derp1a.example.com", "derp1b.example.com/10.0.0.2"],
+				"VerifyClientURL": "https://admit.example.com/",
+				"AcceptConnectionLimit": 100,
+				"AcceptConnectionBurst": 10,
+				"Home": "blank",
+				"Bandwidth": {"Default": {"BytesPerSecond": 1000000}},
+				"BootstrapDNSNames": ["login.example.com", "log.example.com"],
+				"UnpublishedBootstrapDNSNames": [],
+			}`, kText),
+			check: func(t *testing.T, cfg config) {
+				if got, want := cfg.meshWith(), []string{"derp1a.example.com", "derp1b.example.com/10.0.0.2"}; !slices.Equal(got, want) {
+					t.Errorf("meshWith = %q; want %q", got, want)
+				}
+				if got, want := cfg.verifyClientURL(), "https://admit.example.com/"; got != want {
+					t.Errorf("verifyClientURL = %q; want %q", got, want)
+				}
+				if got := cfg.acceptConnLimit(); got != 100 {
+					t.Errorf("acceptConnLimit = %v; want 100", got)
+				}
+				if got := cfg.acceptConnBurst(); got != 10 {
+					t.Errorf("acceptConnBurst = %v; want 10", got)
+				}
+				if got := cfg.home(); got != "blank" {
+					t.Errorf("home = %q; want blank", got)
+				}
+				if cfg.Bandwidth == nil || cfg.Bandwidth.Default.BytesPerSecond != 1000000 {
+					t.Errorf("Bandwidth = %+v; want default of 1000000 bytes per second", cfg.Bandwidth)
+				}
+				if got, want := cfg.bootstrapDNSNames(), "login.example.com,log.example.com"; got != want {
+					t.Errorf("bootstrapDNSNames = %q; want %q", got, want)
+				}
+				if got := cfg.unpublishedDNSNames(); got != "" {
+					t.Errorf("unpublishedDNSNames = %q; want empty, replacing the flag", got)
+				}
+			},
+		},
+		{
+			name:    "bad_home",
+			in:      `{"Home": "ftp://example.com"}`,
+			wantErr: "unknown home value",
+		},
+		{
+			name:    "bad_mesh_host",
+			in:      `{"MeshWith": ["a/b/c"]}`,
+			wantErr: "too many components",
+		},
+		{
+			name:    "bad_bootstrap_dns_name",
+			in:      `{"BootstrapDNSNames": ["a.example.com,b.example.com"]}`,
+			wantErr: "invalid bootstrap DNS name",
+		},
+		{
+			name:    "negative_limit",
+			in:      `{"AcceptConnectionLimit": -1}`,
+			wantErr: "invalid accept connection limit",
+		},
+		{
+			name:    "not_json",
+			in:      `PrivateKey = foo`,
+			wantErr: "invalid literal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig([]byte(tt.in))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseConfig error = %v; want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfig: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLiveConfigReload(t *testing.T) {
+	k := key.NewNode()
+	kText, _ := k.MarshalText()
+	path := filepath.Join(t.TempDir(), "derper.key")
+	oldConfigPath := *configPath
+	*configPath = path
+	t.Cleanup(func() { *configPath = oldConfigPath })
+	t.Cleanup(func() { setBootstrapDNSNames("", "") })
+
+	writeConfig := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	homeStatus := func(lc *liveConfig) int {
+		rec := httptest.NewRecorder()
+		lc.home.Load().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+
+	s := derp.NewServer(k, t.Logf)
+	defer s.Close()
+	lc := newLiveConfig(s)
+	if err := lc.apply(config{PrivateKey: k}); err != nil {
+		t.Fatal(err)
+	}
+	if got := homeStatus(lc); got != http.StatusOK {
+		t.Errorf("home status = %v; want %v", got, http.StatusOK)
+	}
+
+	writeConfig(fmt.Sprintf(`{
+		"PrivateKey": %q,
+		"Home": "https://example.com/",
+		"AcceptConnectionLimit": 5,
+		"AcceptConnectionBurst": 2,
+		"BootstrapDNSNames": ["login.example.com"],
+	}`, kText))
+	if err := lc.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := homeStatus(lc); got != http.StatusFound {
+		t.Errorf("home status = %v; want %v", got, http.StatusFound)
+	}
+	if got, want := lc.acceptConn.Limit(), rate.Limit(5); got != want {
+		t.Errorf("accept limit = %v; want %v", got, want)
+	}
+	if got := lc.acceptConn.Burst(); got != 2 {
+		t.Errorf("accept burst = %v; want 2", got)
+	}
+	if got := bootstrapDNSNames.Load(); got != "login.example.com" {
+		t.Errorf("bootstrap DNS names = %q; want login.example.com", got)
+	}
+	select {
+	case <-refreshBootstrapDNSNow:
+	default:
+		t.Errorf("changing the bootstrap DNS names didn't trigger a refresh")
+	}
+
+	// Invalid configs are rejected, leaving the previous one in place.
+	writeConfig(fmt.Sprintf(`{"PrivateKey": %q, "Home": "bogus"}`, kText))
+	if err := lc.reload(); err == nil {
+		t.Errorf("reload of invalid config succeeded")
+	}
+	other, _ := key.NewNode().MarshalText()
+	writeConfig(fmt.Sprintf(`{"PrivateKey": %q, "Home": "blank"}`, other))
+	if err := lc.reload(); err == nil || !strings.Contains(err.Error(), "PrivateKey") {
+		t.Errorf("reload with new PrivateKey = %v; want error", err)
+	}
+	if got := homeStatus(lc); got != http.StatusFound {
+		t.Errorf("home status after failed reloads = %v; want %v", got, http.StatusFound)
+	}
+}
+
+func TestMeshClientsSet(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	m := &meshClients{s: s}
+
+	if err := m.set([]string{"derp1a.example.invalid"}); err == nil {
+		t.Fatal("set without a mesh key succeeded")
+	}
+	if err := m.set(nil); err != nil {
+		t.Fatalf("set(nil) without a mesh key: %v", err)
+	}
+
+	if err := s.SetMeshKey(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	running := func() []string {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return slices.Sorted(maps.Keys(m.stop))
+	}
+	if err := m.set([]string{"derp1a.example.invalid", "derp1b.example.invalid"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := running(), []string{"derp1a.example.invalid", "derp1b.example.invalid"}; !slices.Equal(got, want) {
+		t.Errorf("running = %q; want %q", got, want)
+	}
+	if err := m.set([]string{"derp1b.example.invalid", "derp1c.example.invalid/127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := running(), []string{"derp1b.example.invalid", "derp1c.example.invalid/127.0.0.1"}; !slices.Equal(got, want) {
+		t.Errorf("running = %q; want %q", got, want)
+	}
+	if err := m.set(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := running(); len(got) != 0 {
+		t.Errorf("running = %q; want none", got)
+	}
+}
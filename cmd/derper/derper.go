@@ -106,10 +106,6 @@ func init() {
 	expvar.Publish("gauge_derper_tls_active_version", tlsActiveVersion)
 }
 
-type config struct {
-	PrivateKey key.NodePrivate
-}
-
 func loadConfig() config {
 	if *dev {
 		return config{PrivateKey: key.NewNode()}
@@ -130,8 +126,8 @@ func loadConfig() config {
 		log.Fatal(err)
 		panic("unreachable")
 	default:
-		var cfg config
-		if err := json.Unmarshal(b, &cfg); err != nil {
+		cfg, err := parseConfig(b)
+		if err != nil {
 			log.Fatalf("derper: config: %v", err)
 		}
 		return cfg
@@ -189,8 +185,6 @@ func main() {
 	s := derp.NewServer(cfg.PrivateKey, log.Printf)
 	s.SetVerifyClient(*verifyClients)
 	s.SetTailscaledSocketPath(*socket)
-	s.SetVerifyClientURL(*verifyClientURL)
-	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
 	s.SetTCPWriteTimeout(*tcpWriteTimeout)
 
 	var meshKey string
@@ -241,16 +235,13 @@ func main() {
 		log.Println("DERP mesh key configured")
 	}
 
-	if err := startMesh(s); err != nil {
-		log.Fatalf("startMesh: %v", err)
+	live := newLiveConfig(s)
+	if err := live.apply(cfg); err != nil {
+		log.Fatalf("derper: %v", err)
 	}
+	go live.reloadOnSIGHUP(ctx)
 	expvar.Publish("derp", s.ExpVar())
 
-	handleHome, ok := getHomeHandler(*flagHome)
-	if !ok {
-		log.Fatalf("unknown --home value %q", *flagHome)
-	}
-
 	mux := http.NewServeMux()
 	if *runDERP {
 		derpHandler := derphttp.Handler(s)
@@ -271,7 +262,7 @@ func main() {
 	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tsweb.AddBrowserHeaders(w)
-		handleHome.ServeHTTP(w, r)
+		live.home.Load().ServeHTTP(w, r)
 	}))
 	mux.Handle("/robots.txt", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tsweb.AddBrowserHeaders(w)
@@ -406,7 +397,7 @@ func main() {
 				}
 			}()
 		}
-		err = rateLimitedListenAndServeTLS(httpsrv, &lc)
+		err = rateLimitedListenAndServeTLS(httpsrv, &lc, live.acceptConn)
 	} else {
 		log.Printf("derper: serving on %s", *addr)
 		var ln net.Listener
@@ -447,12 +438,14 @@ func defaultMeshPSKFile() string {
 	return ""
 }
 
-func rateLimitedListenAndServeTLS(srv *http.Server, lc *net.ListenConfig) error {
+// rateLimitedListenAndServeTLS serves srv, accepting connections at the rate
+// allowed by lim.
+func rateLimitedListenAndServeTLS(srv *http.Server, lc *net.ListenConfig, lim *rate.Limiter) error {
 	ln, err := lc.Listen(context.Background(), "tcp", cmp.Or(srv.Addr, ":https"))
 	if err != nil {
 		return err
 	}
-	rln := newRateLimitedListener(ln, rate.Limit(*acceptConnLimit), *acceptConnBurst)
+	rln := newRateLimitedListener(ln, lim)
 	expvar.Publish("tls_listener", rln.ExpVar())
 	defer rln.Close()
 	return srv.ServeTLS(rln, "", "")
@@ -470,8 +463,8 @@ type rateLimitedListener struct {
 	lim *rate.Limiter
 }
 
-func newRateLimitedListener(ln net.Listener, limit rate.Limit, burst int) *rateLimitedListener {
-	return &rateLimitedListener{Listener: ln, lim: rate.NewLimiter(limit, burst)}
+func newRateLimitedListener(ln net.Listener, lim *rate.Limiter) *rateLimitedListener {
+	return &rateLimitedListener{Listener: ln, lim: lim}
 }
 
 func (l *rateLimitedListener) ExpVar() expvar.Var {
@@ -10,34 +10,62 @@
 	"log"
 	"net"
 	"strings"
+	"sync"
 
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
 )
 
-func startMesh(s *derp.Server) error {
-	if *meshWith == "" {
-		return nil
-	}
-	if !s.HasMeshKey() {
+// meshClients manages the server's mesh connections to the other DERP
+// servers in its region.
+type meshClients struct {
+	s *derp.Server
+
+	mu   sync.Mutex
+	stop map[string]func() // by host tuple
+}
+
+// set starts and stops mesh connections as needed for the server to mesh
+// with exactly hostTuples, in the form documented on --mesh-with.
+// Connections to hosts that remain in the list are left alone.
+func (m *meshClients) set(hostTuples []string) error {
+	if len(hostTuples) > 0 && !m.s.HasMeshKey() {
 		return errors.New("--mesh-with requires --mesh-psk-file")
 	}
-	for _, hostTuple := range strings.Split(*meshWith, ",") {
-		if err := startMeshWithHost(s, hostTuple); err != nil {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	want := set.Of(hostTuples...)
+	for hostTuple, stop := range m.stop {
+		if !want.Contains(hostTuple) {
+			log.Printf("mesh: no longer meshing with %q", hostTuple)
+			stop()
+			delete(m.stop, hostTuple)
+		}
+	}
+	for _, hostTuple := range hostTuples {
+		if _, ok := m.stop[hostTuple]; ok {
+			continue
+		}
+		stop, err := startMeshWithHost(m.s, hostTuple)
+		if err != nil {
 			return err
 		}
+		mak.Set(&m.stop, hostTuple, stop)
 	}
 	return nil
 }
 
-func startMeshWithHost(s *derp.Server, hostTuple string) error {
-	var host string
-	var dialHost string
+// parseMeshHostTuple parses a --mesh-with entry into the host to mesh with
+// and the host to dial.
+func parseMeshHostTuple(hostTuple string) (host, dialHost string, err error) {
 	hostParts := strings.Split(hostTuple, "/")
 	if len(hostParts) > 2 {
-		return fmt.Errorf("too many components in host tuple %q", hostTuple)
+		return "", "", fmt.Errorf("too many components in host tuple %q", hostTuple)
 	}
 	host = hostParts[0]
 	if len(hostParts) == 2 {
@@ -45,12 +73,22 @@ func startMeshWithHost(s *derp.Server, hostTuple string) error {
 	} else {
 		dialHost = hostParts[0]
 	}
+	return host, dialHost, nil
+}
+
+// startMeshWithHost starts meshing with the host described by hostTuple.
+// It returns a func that stops meshing with it.
+func startMeshWithHost(s *derp.Server, hostTuple string) (stop func(), err error) {
+	host, dialHost, err := parseMeshHostTuple(hostTuple)
+	if err != nil {
+		return nil, err
+	}
 
 	logf := logger.WithPrefix(log.Printf, fmt.Sprintf("mesh(%q): ", host))
 	netMon := netmon.NewStatic() // good enough for cmd/derper; no need for netns fanciness
 	c, err := derphttp.NewClient(s.PrivateKey(), "https://"+host+"/derp", logf, netMon)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	c.MeshKey = s.MeshKey()
 	c.WatchConnectionChanges = true
@@ -72,6 +110,10 @@ func startMeshWithHost(s *derp.Server, hostTuple string) error {
 
 	add := func(m derp.PeerPresentMessage) { s.AddPacketForwarder(m.Key, c) }
 	remove := func(m derp.PeerGoneMessage) { s.RemovePacketForwarder(m.Peer, c) }
-	go c.RunWatchConnectionLoop(context.Background(), s.PublicKey(), logf, add, remove)
-	return nil
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.RunWatchConnectionLoop(ctx, s.PublicKey(), logf, add, remove)
+	return func() {
+		cancel()
+		c.Close()
+	}, nil
 }
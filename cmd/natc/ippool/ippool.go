@@ -7,6 +7,7 @@
 import (
 	"errors"
 	"log"
+	"maps"
 	"math/big"
 	"net/netip"
 	"sync"
@@ -14,6 +15,7 @@
 
 	"github.com/gaissmai/bart"
 	"go4.org/netipx"
+	"tailscale.com/jsondb"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/util/dnsname"
@@ -22,6 +24,11 @@
 
 var ErrNoIPsAvailable = errors.New("no IPs available")
 
+// persistDelay is how long after allocating an address a persistent
+// [SingleMachineIPPool] saves its allocations, so that those made in a
+// burst are saved together.
+const persistDelay = 5 * time.Second
+
 // IPPool allocates IPv4 addresses from a pool to DNS domains, on a per tailcfg.NodeID basis.
 // For each tailcfg.NodeID, IPv4 addresses are associated with at most one DNS domain.
 // Addresses may be reused across other tailcfg.NodeID's for the same or other domains.
@@ -36,9 +43,53 @@ type IPPool interface {
 	IPForDomain(tailcfg.NodeID, string) (netip.Addr, error)
 }
 
+// SingleMachineIPPool implements an [IPPool] for a single natc node. Its
+// allocations are kept in memory, and additionally saved to disk if it was
+// created by [NewPersistentIPPool].
 type SingleMachineIPPool struct {
 	perPeerMap syncs.Map[tailcfg.NodeID, *perPeerState]
 	IPSet      *netipx.IPSet
+
+	saveMu    sync.Mutex                       // serializes saves to db
+	db        *jsondb.DB[persistedAllocations] // nil if not persistent
+	timerMu   sync.Mutex                       // guards saveTimer
+	saveTimer *time.Timer                      // pending save, or nil
+}
+
+// persistedAllocations is the on-disk form of the allocations of a
+// persistent [SingleMachineIPPool].
+type persistedAllocations struct {
+	// Peers maps each node to the addresses allocated to it, by domain.
+	Peers map[tailcfg.NodeID]map[string]netip.Addr
+}
+
+// NewPersistentIPPool returns a [SingleMachineIPPool] allocating from ipset
+// that saves its allocations to the JSON file at path, and restores them from
+// it, so that they remain stable across restarts.
+//
+// New allocations are saved a few seconds after they're made, together with
+// any others made meanwhile, or when [SingleMachineIPPool.Flush] is called.
+//
+// Saved allocations of addresses that are no longer in ipset are dropped.
+func NewPersistentIPPool(ipset *netipx.IPSet, path string) (*SingleMachineIPPool, error) {
+	db, err := jsondb.Open[persistedAllocations](path)
+	if err != nil {
+		return nil, err
+	}
+	ipp := &SingleMachineIPPool{
+		IPSet: ipset,
+		db:    db,
+	}
+	for nid, allocs := range db.Data.Peers {
+		ps := &perPeerState{ipset: ipset}
+		for domain, addr := range allocs {
+			if !ps.restoreLocked(domain, addr) {
+				log.Printf("ippool: dropping saved allocation of %v to %q for %v", addr, domain, nid)
+			}
+		}
+		ipp.perPeerMap.Store(nid, ps)
+	}
+	return ipp, nil
 }
 
 func (ipp *SingleMachineIPPool) DomainForIP(from tailcfg.NodeID, addr netip.Addr, _ time.Time) (string, bool) {
@@ -60,7 +111,60 @@ func (ipp *SingleMachineIPPool) IPForDomain(from tailcfg.NodeID, domain string)
 		ipset: ipp.IPSet,
 	}
 	ps, _ := ipp.perPeerMap.LoadOrStore(from, npps)
-	return ps.ipForDomain(domain)
+	addr, assigned, err := ps.ipForDomain(domain)
+	if assigned && ipp.db != nil {
+		ipp.scheduleSave()
+	}
+	return addr, err
+}
+
+// scheduleSave saves the allocations after persistDelay, unless a save is
+// already pending.
+func (ipp *SingleMachineIPPool) scheduleSave() {
+	ipp.timerMu.Lock()
+	defer ipp.timerMu.Unlock()
+	if ipp.saveTimer != nil {
+		return
+	}
+	ipp.saveTimer = time.AfterFunc(persistDelay, func() {
+		ipp.timerMu.Lock()
+		ipp.saveTimer = nil
+		ipp.timerMu.Unlock()
+		if err := ipp.save(); err != nil {
+			log.Printf("ippool: saving allocations: %v", err)
+		}
+	})
+}
+
+// Flush saves the allocations now, rather than waiting for a pending save,
+// if ipp is persistent.
+func (ipp *SingleMachineIPPool) Flush() error {
+	if ipp.db == nil {
+		return nil
+	}
+	ipp.timerMu.Lock()
+	if ipp.saveTimer != nil {
+		ipp.saveTimer.Stop()
+		ipp.saveTimer = nil
+	}
+	ipp.timerMu.Unlock()
+	return ipp.save()
+}
+
+// save writes the current allocations to ipp.db.
+func (ipp *SingleMachineIPPool) save() error {
+	ipp.saveMu.Lock()
+	defer ipp.saveMu.Unlock()
+	peers := map[tailcfg.NodeID]map[string]netip.Addr{}
+	for nid, ps := range ipp.perPeerMap.All() {
+		ps.mu.Lock()
+		if len(ps.domainToAddr) > 0 {
+			peers[nid] = maps.Clone(ps.domainToAddr)
+		}
+		ps.mu.Unlock()
+	}
+	ipp.db.Data.Peers = peers
+	return ipp.db.Save()
 }
 
 // perPeerState holds the state for a single peer.
@@ -87,23 +191,53 @@ func (ps *perPeerState) domainForIP(ip netip.Addr) (_ string, ok bool) {
 // ipForDomain assigns a pair of unique IP addresses for the given domain and
 // returns them. The first address is an IPv4 address and the second is an IPv6
 // address. If the domain already has assigned addresses, it returns them.
-func (ps *perPeerState) ipForDomain(domain string) (netip.Addr, error) {
+// assigned reports whether a new address was assigned.
+func (ps *perPeerState) ipForDomain(domain string) (_ netip.Addr, assigned bool, _ error) {
 	fqdn, err := dnsname.ToFQDN(domain)
 	if err != nil {
-		return netip.Addr{}, err
+		return netip.Addr{}, false, err
 	}
 	domain = fqdn.WithoutTrailingDot()
 
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	if addr, ok := ps.domainToAddr[domain]; ok {
-		return addr, nil
+		return addr, false, nil
 	}
 	addr := ps.assignAddrsLocked(domain)
 	if !addr.IsValid() {
-		return netip.Addr{}, ErrNoIPsAvailable
+		return netip.Addr{}, false, ErrNoIPsAvailable
 	}
-	return addr, nil
+	return addr, true, nil
+}
+
+// restoreLocked restores a saved allocation of addr to domain. It reports
+// false if addr is not in ps.ipset or is already allocated.
+// ps.mu must be held, or ps not yet shared.
+func (ps *perPeerState) restoreLocked(domain string, addr netip.Addr) bool {
+	if !addr.Is4() {
+		return false
+	}
+	i := indexOfAddr(addr, ps.ipset)
+	if i < 0 {
+		return false
+	}
+	if ps.addrInUse == nil {
+		ps.addrInUse = big.NewInt(0)
+	}
+	if ps.addrInUse.Bit(i) != 0 {
+		return false
+	}
+	if _, ok := ps.domainToAddr[domain]; ok {
+		return false
+	}
+	if ps.addrToDomain == nil {
+		ps.addrToDomain = &bart.Table[string]{}
+	}
+	ps.addrInUse.SetBit(ps.addrInUse, i, 1)
+	mak.Set(&ps.domainToAddr, domain, addr)
+	ps.addrToDomain.Insert(netip.PrefixFrom(addr, addr.BitLen()), domain)
+	return true
 }
 
 // unusedIPv4Locked returns an unused IPv4 address from the available ranges.
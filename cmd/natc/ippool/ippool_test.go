@@ -7,6 +7,8 @@
 	"errors"
 	"fmt"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -106,3 +108,62 @@ func TestIPPool(t *testing.T) {
 		t.Errorf("ipForDomain() second call = %v, want %v", addr2, addr)
 	}
 }
+
+func TestPersistentIPPool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ippool.json")
+	var ipsb netipx.IPSetBuilder
+	ipsb.AddPrefix(netip.MustParsePrefix("100.64.1.0/24"))
+	addrPool := must.Get(ipsb.IPSet())
+
+	pool := must.Get(NewPersistentIPPool(addrPool, path))
+	from := tailcfg.NodeID(12345)
+	other := tailcfg.NodeID(67890)
+	addrA := must.Get(pool.IPForDomain(from, "a.example.com"))
+	addrB := must.Get(pool.IPForDomain(from, "b.example.com"))
+	addrOther := must.Get(pool.IPForDomain(other, "a.example.com"))
+
+	// The allocations are saved together, after a delay.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("allocations saved without delay: %v", err)
+	}
+	if err := pool.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new pool from the same file has the same allocations.
+	pool = must.Get(NewPersistentIPPool(addrPool, path))
+	for _, tt := range []struct {
+		from   tailcfg.NodeID
+		domain string
+		want   netip.Addr
+	}{
+		{from, "a.example.com", addrA},
+		{from, "b.example.com", addrB},
+		{other, "a.example.com", addrOther},
+	} {
+		if got, ok := pool.DomainForIP(tt.from, tt.want, time.Now()); !ok || got != tt.domain {
+			t.Errorf("after reload, DomainForIP(%v, %v) = %q, %v; want %q", tt.from, tt.want, got, ok, tt.domain)
+		}
+		if got := must.Get(pool.IPForDomain(tt.from, tt.domain)); got != tt.want {
+			t.Errorf("after reload, IPForDomain(%v, %q) = %v; want %v", tt.from, tt.domain, got, tt.want)
+		}
+	}
+
+	// New allocations don't reuse restored addresses.
+	addrC := must.Get(pool.IPForDomain(from, "c.example.com"))
+	if addrC == addrA || addrC == addrB {
+		t.Errorf("c.example.com allocated %v, already in use", addrC)
+	}
+	must.Do(pool.Flush())
+
+	// Allocations outside of a changed address range are dropped.
+	var smaller netipx.IPSetBuilder
+	smaller.AddPrefix(netip.PrefixFrom(addrA, 32))
+	pool = must.Get(NewPersistentIPPool(must.Get(smaller.IPSet()), path))
+	if _, ok := pool.DomainForIP(from, addrA, time.Now()); !ok {
+		t.Errorf("allocation of %v in the new range was dropped", addrA)
+	}
+	if _, ok := pool.DomainForIP(from, addrB, time.Now()); ok {
+		t.Errorf("allocation of %v outside the new range was kept", addrB)
+	}
+}
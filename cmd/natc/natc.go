@@ -18,8 +18,10 @@
 	"net/http"
 	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gaissmai/bart"
@@ -41,6 +43,9 @@
 	"tailscale.com/wgengine/netstack"
 )
 
+// defaultV4Pfx is the --v4-pfx used without a --policy-file.
+const defaultV4Pfx = "100.64.1.0/24"
+
 func main() {
 	hostinfo.SetApp("natc")
 	if !envknob.UseWIPCode() {
@@ -53,7 +58,7 @@ func main() {
 		debugPort       = fs.Int("debug-port", 8893, "Listening port for debug/metrics endpoint")
 		hostname        = fs.String("hostname", "", "Hostname to register the service under")
 		siteID          = fs.Uint("site-id", 1, "an integer site ID to use for the ULA prefix which allows for multiple proxies to act in a HA configuration")
-		v4PfxStr        = fs.String("v4-pfx", "100.64.1.0/24", "comma-separated list of IPv4 prefixes to advertise")
+		v4PfxStr        = fs.String("v4-pfx", "", "comma-separated list of IPv4 prefixes to advertise (default "+defaultV4Pfx+", or none with --policy-file)")
 		dnsServers      = fs.String("dns-servers", "", "comma separated list of upstream DNS to use, including host and port (use system if empty)")
 		verboseTSNet    = fs.Bool("verbose-tsnet", false, "enable verbose logging in tsnet")
 		printULA        = fs.Bool("print-ula", false, "print the ULA prefix and exit")
@@ -62,6 +67,8 @@ func main() {
 		clusterTag      = fs.String("cluster-tag", "", "optionally run in a consensus cluster with other nodes with this tag")
 		server          = fs.String("login-server", ipn.DefaultControlURL, "the base URL of control server")
 		stateDir        = fs.String("state-dir", "", "path to directory in which to store app state")
+		policyPath      = fs.String("policy-file", "", "optional path to a HuJSON policy file assigning sets of domains to their own IPv4 prefixes and allowed peer tags")
+		persistIPPool   = fs.Bool("persist-ip-pool", false, "save IP address allocations in the state directory so they remain stable across restarts (not used with --cluster-tag)")
 	)
 	ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("TS_NATC"))
 
@@ -144,22 +151,63 @@ func main() {
 		log.Fatalf("ts.Up: %v", err)
 	}
 
+	if *v4PfxStr == "" && *policyPath == "" {
+		*v4PfxStr = defaultV4Pfx
+	}
 	var prefixes []netip.Prefix
-	for _, s := range strings.Split(*v4PfxStr, ",") {
-		p := netip.MustParsePrefix(strings.TrimSpace(s))
+	for s := range strings.SplitSeq(*v4PfxStr, ",") {
+		s := strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		p := netip.MustParsePrefix(s)
 		if p.Masked() != p {
 			log.Fatalf("v4 prefix %v is not a masked prefix", p)
 		}
 		prefixes = append(prefixes, p)
 	}
-	routes, dnsAddr, addrPool := calculateAddresses(prefixes)
+	routes := must.Get(new(netipx.IPSetBuilder).IPSet())
+	var dnsAddr netip.Addr
+	var addrPool *netipx.IPSet
+	if len(prefixes) > 0 {
+		routes, dnsAddr, addrPool = calculateAddresses(prefixes)
+	}
 
 	v6ULA := ula(uint16(*siteID))
 
+	var domainSets []*domainSet
+	if *policyPath != "" {
+		if *clusterTag != "" {
+			log.Fatalf("--policy-file is not yet supported with --cluster-tag")
+		}
+		domainSets, err = loadPolicyFile(*policyPath, routes)
+		if err != nil {
+			log.Fatalf("loading policy file: %v", err)
+		}
+		if len(domainSets) == 0 && len(prefixes) == 0 {
+			log.Fatalf("--policy-file has no domain sets, so --v4-pfx is required")
+		}
+		var ipsb netipx.IPSetBuilder
+		ipsb.AddSet(routes)
+		for _, ds := range domainSets {
+			ipsb.AddSet(ds.prefixes)
+			pool := ds.prefixes
+			if !dnsAddr.IsValid() {
+				// Without --v4-pfx, DNS is served on the first address
+				// of the first domain set.
+				_, dnsAddr, pool = calculateAddresses(ds.prefixes.Prefixes())
+			}
+			ds.ipPool = newSingleMachineIPPool(pool, *persistIPPool, *stateDir, "ippool-"+ds.name+".json")
+		}
+		routes = must.Get(ipsb.IPSet())
+	}
+
 	var ipp ippool.IPPool
-	if *clusterTag != "" {
+	if domainSets != nil {
+		// Every NATed domain is in a domain set, with its own pool.
+	} else if *clusterTag != "" {
 		cipp := ippool.NewConsensusIPPool(addrPool)
-		clusterStateDir, err := getClusterStatePath(*stateDir)
+		clusterStateDir, err := getStatePath(*stateDir, "cluster")
 		if err != nil {
 			log.Fatalf("Creating cluster state dir failed: %v", err)
 		}
@@ -175,7 +223,7 @@ func main() {
 		}()
 		ipp = cipp
 	} else {
-		ipp = &ippool.SingleMachineIPPool{IPSet: addrPool}
+		ipp = newSingleMachineIPPool(addrPool, *persistIPPool, *stateDir, "ippool.json")
 	}
 
 	c := &connector{
@@ -184,11 +232,34 @@ func main() {
 		v6ULA:      v6ULA,
 		ignoreDsts: ignoreDstTable,
 		ipPool:     ipp,
+		domainSets: domainSets,
 		routes:     routes,
 		dnsAddr:    dnsAddr,
 		resolver:   getResolver(*dnsServers),
 	}
-	c.run(ctx, lc)
+	// Serve until interrupted, then save the IP pools' latest allocations.
+	runCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	c.run(runCtx, lc)
+	c.flushIPPools()
+}
+
+// newSingleMachineIPPool returns an IP pool allocating from addrs. If
+// persist is set, its allocations are saved to the named file in the state
+// directory.
+func newSingleMachineIPPool(addrs *netipx.IPSet, persist bool, stateDirFlag, name string) ippool.IPPool {
+	if !persist {
+		return &ippool.SingleMachineIPPool{IPSet: addrs}
+	}
+	dir, err := getStatePath(stateDirFlag, "ippool")
+	if err != nil {
+		log.Fatalf("Creating IP pool state dir failed: %v", err)
+	}
+	ipp, err := ippool.NewPersistentIPPool(addrs, filepath.Join(dir, name))
+	if err != nil {
+		log.Fatalf("Loading IP pool: %v", err)
+	}
+	return ipp
 }
 
 // getResolver parses serverFlag and returns either the default resolver, or a
@@ -264,9 +335,15 @@ type connector struct {
 	// natc behavior, which would return a dummy ip address pointing at natc).
 	ignoreDsts *bart.Table[bool]
 
-	// ipPool contains the per-peer IPv4 address assignments.
+	// ipPool contains the per-peer IPv4 address assignments. It is nil if
+	// domainSets is non-nil.
 	ipPool ippool.IPPool
 
+	// domainSets are the domain sets of the --policy-file, in order of
+	// precedence, each with its own IP pool. If nil, there is no policy and
+	// all domains are NATed using ipPool.
+	domainSets []*domainSet
+
 	// resolver is used to lookup IP addresses for DNS queries.
 	resolver lookupNetIPer
 }
@@ -298,15 +375,36 @@ func (c *connector) run(ctx context.Context, lc *local.Client) {
 		log.Fatalf("failed to advertise routes: %v", err)
 	}
 	c.ts.RegisterFallbackTCPHandler(c.handleTCPFlow)
-	c.serveDNS()
+	c.serveDNS(ctx)
 }
 
-func (c *connector) serveDNS() {
+// flushIPPools saves the allocations of c's persistent IP pools that are
+// still pending.
+func (c *connector) flushIPPools() {
+	pools := []ippool.IPPool{c.ipPool}
+	for _, ds := range c.domainSets {
+		pools = append(pools, ds.ipPool)
+	}
+	for _, p := range pools {
+		if smp, ok := p.(*ippool.SingleMachineIPPool); ok {
+			if err := smp.Flush(); err != nil {
+				log.Printf("saving IP pool: %v", err)
+			}
+		}
+	}
+}
+
+// serveDNS serves DNS requests until ctx is done.
+func (c *connector) serveDNS(ctx context.Context) {
 	pc, err := c.ts.ListenPacket("udp", net.JoinHostPort(c.dnsAddr.String(), "53"))
 	if err != nil {
 		log.Fatalf("failed listening on port 53: %v", err)
 	}
 	defer pc.Close()
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
 	log.Printf("Listening for DNS on %s", pc.LocalAddr().String())
 	for {
 		buf := make([]byte, 1500)
@@ -326,7 +424,8 @@ func (c *connector) serveDNS() {
 // It generates a response based on the request and the node that sent it.
 //
 // Each node is assigned a unique pair of IP addresses for each domain it
-// queries. This assignment is done lazily and is not persisted across restarts.
+// queries. This assignment is done lazily and is only persisted across
+// restarts with --persist-ip-pool or --cluster-tag.
 // A per-peer assignment allows the connector to reuse a limited number of IP
 // addresses across multiple nodes and domains. It also allows for clear
 // failover behavior when an app connector is restarted.
@@ -357,6 +456,11 @@ func (c *connector) handleDNS(pc net.PacketConn, buf []byte, remoteAddr *net.UDP
 		}
 		addrQCount++
 		if _, ok := resolves[q.Name.String()]; !ok {
+			pool, ds := c.ipPoolForDomain(q.Name.String())
+			if ds != nil && !ds.allows(who.Node) {
+				log.Printf("HandleDNS(remote=%s): %s not allowed by domain set %q", remoteAddr.String(), q.Name.String(), ds.name)
+				continue
+			}
 			addrs, err := c.resolver.LookupNetIP(ctx, "ip", q.Name.String())
 			var dnsErr *net.DNSError
 			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
@@ -372,8 +476,8 @@ func (c *connector) handleDNS(pc net.PacketConn, buf []byte, remoteAddr *net.UDP
 			// This could result in some odd split-routing if there was a mix of
 			// ignored and non-ignored addresses, but it's currently the user
 			// preferred behavior.
-			if !c.ignoreDestination(addrs) {
-				addr, err := c.ipPool.IPForDomain(who.Node.ID, q.Name.String())
+			if pool != nil && !c.ignoreDestination(addrs) {
+				addr, err := pool.IPForDomain(who.Node.ID, q.Name.String())
 				if err != nil {
 					log.Printf("HandleDNS(remote=%s): lookup destination failed: %v\n", remoteAddr.String(), err)
 					return
@@ -509,7 +613,15 @@ func (c *connector) handleTCPFlow(src, dst netip.AddrPort) (handler func(net.Con
 	if dstAddr.Is6() {
 		dstAddr = v4ForV6(dstAddr)
 	}
-	domain, ok := c.ipPool.DomainForIP(who.Node.ID, dstAddr, time.Now())
+	pool, ds := c.ipPoolForAddr(dstAddr)
+	if pool == nil {
+		return nil, false
+	}
+	if ds != nil && !ds.allows(who.Node) {
+		log.Printf("HandleTCPFlow: %v not allowed by domain set %q", src.Addr(), ds.name)
+		return nil, false
+	}
+	domain, ok := pool.DomainForIP(who.Node.ID, dstAddr, time.Now())
 	if !ok {
 		return nil, false
 	}
@@ -518,6 +630,34 @@ func (c *connector) handleTCPFlow(src, dst netip.AddrPort) (handler func(net.Con
 	}, true
 }
 
+// ipPoolForDomain returns the IP pool to allocate an address for domain
+// from, and the domain set that domain belongs to if a policy is in effect.
+// The pool is nil if domain is not to be NATed.
+func (c *connector) ipPoolForDomain(domain string) (ippool.IPPool, *domainSet) {
+	if c.domainSets == nil {
+		return c.ipPool, nil
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, ds := range c.domainSets {
+		if ds.matches(domain) {
+			return ds.ipPool, ds
+		}
+	}
+	return nil, nil
+}
+
+// ipPoolForAddr returns the IP pool that addr is allocated from, and its
+// domain set if a policy is in effect. The pool is nil if addr is not in any
+// pool.
+func (c *connector) ipPoolForAddr(addr netip.Addr) (ippool.IPPool, *domainSet) {
+	for _, ds := range c.domainSets {
+		if ds.prefixes.Contains(addr) {
+			return ds.ipPool, ds
+		}
+	}
+	return c.ipPool, nil
+}
+
 // ignoreDestination reports whether any of the provided dstAddrs match the prefixes configured
 // in --ignore-destinations
 func (c *connector) ignoreDestination(dstAddrs []netip.Addr) bool {
@@ -604,7 +744,9 @@ func proxyTCPConn(c net.Conn, dest string, ctor *connector) {
 	p.Start()
 }
 
-func getClusterStatePath(stateDirFlag string) (string, error) {
+// getStatePath returns the path of the named subdirectory of the natc state
+// directory, creating it if needed.
+func getStatePath(stateDirFlag, subdir string) (string, error) {
 	var dirPath string
 	if stateDirFlag != "" {
 		dirPath = stateDirFlag
@@ -615,7 +757,7 @@ func getClusterStatePath(stateDirFlag string) (string, error) {
 		}
 		dirPath = filepath.Join(confDir, "nat-connector-state")
 	}
-	dirPath = filepath.Join(dirPath, "cluster")
+	dirPath = filepath.Join(dirPath, subdir)
 
 	if err := os.MkdirAll(dirPath, 0700); err != nil {
 		return "", err
@@ -0,0 +1,180 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tailscale/hujson"
+	"go4.org/netipx"
+	"tailscale.com/cmd/natc/ippool"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/dnsname"
+)
+
+// policyFile is the contents of the --policy-file, in HuJSON.
+//
+// It splits the domains served by natc into sets, each with its own IPv4
+// prefixes to allocate addresses from and its own list of peers allowed to
+// use it. Domains that match no set are not NATed: DNS queries for them are
+// answered with their real addresses.
+type policyFile struct {
+	DomainSets []policyDomainSet
+}
+
+// policyDomainSet is a domain set in a [policyFile].
+type policyDomainSet struct {
+	// Name identifies the set. It must be unique and consist of lowercase
+	// letters, digits and dashes. It names the set's allocation file when
+	// --persist-ip-pool is set, so renaming a set discards its allocations.
+	Name string
+
+	// Domains are the domains in the set. An entry "*.example.com" matches
+	// all subdomains of example.com, but not example.com itself; "*" matches
+	// all domains. When several sets match a domain, the first one wins.
+	Domains []string
+
+	// V4Prefixes are the prefixes addresses for the set's domains are
+	// allocated from. They are advertised as routes, and must not overlap
+	// --v4-pfx or another set's prefixes. Without --v4-pfx, the first
+	// address of the first set is where natc serves DNS.
+	V4Prefixes []netip.Prefix
+
+	// AllowedTags, if non-empty, restricts the set's domains to peers with
+	// at least one of these tags. Other peers get NXDOMAIN for them, and
+	// their connections are refused.
+	AllowedTags []string `json:",omitempty"`
+}
+
+// domainSet is a domain set of the policy in effect.
+type domainSet struct {
+	name        string
+	domains     []string // normalized, without trailing dots
+	prefixes    *netipx.IPSet
+	allowedTags []string
+	ipPool      ippool.IPPool
+}
+
+var validDomainSetName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// loadPolicyFile reads and validates the policy file at path. reserved is
+// the set of addresses already in use by --v4-pfx.
+func loadPolicyFile(path string, reserved *netipx.IPSet) ([]*domainSet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePolicy(b, reserved)
+}
+
+func parsePolicy(b []byte, reserved *netipx.IPSet) ([]*domainSet, error) {
+	b, err := hujson.Standardize(b)
+	if err != nil {
+		return nil, err
+	}
+	var pf policyFile
+	if err := json.Unmarshal(b, &pf); err != nil {
+		return nil, err
+	}
+	var used netipx.IPSetBuilder
+	used.AddSet(reserved)
+	var sets []*domainSet
+	for _, ps := range pf.DomainSets {
+		if !validDomainSetName.MatchString(ps.Name) {
+			return nil, fmt.Errorf("invalid domain set name %q", ps.Name)
+		}
+		if slices.ContainsFunc(sets, func(ds *domainSet) bool { return ds.name == ps.Name }) {
+			return nil, fmt.Errorf("duplicate domain set %q", ps.Name)
+		}
+		if len(ps.Domains) == 0 {
+			return nil, fmt.Errorf("domain set %q has no domains", ps.Name)
+		}
+		if len(ps.V4Prefixes) == 0 {
+			return nil, fmt.Errorf("domain set %q has no V4Prefixes", ps.Name)
+		}
+		ds := &domainSet{
+			name:        ps.Name,
+			allowedTags: ps.AllowedTags,
+		}
+		for _, d := range ps.Domains {
+			nd, err := normalizeDomainPattern(d)
+			if err != nil {
+				return nil, fmt.Errorf("domain set %q: %w", ps.Name, err)
+			}
+			ds.domains = append(ds.domains, nd)
+		}
+		for _, tag := range ps.AllowedTags {
+			if err := tailcfg.CheckTag(tag); err != nil {
+				return nil, fmt.Errorf("domain set %q: %w", ps.Name, err)
+			}
+		}
+		var ipsb netipx.IPSetBuilder
+		usedSet, err := used.IPSet()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ps.V4Prefixes {
+			if !p.Addr().Is4() || p.Masked() != p {
+				return nil, fmt.Errorf("domain set %q: %v is not a masked IPv4 prefix", ps.Name, p)
+			}
+			if usedSet.OverlapsPrefix(p) {
+				return nil, fmt.Errorf("domain set %q: %v overlaps --v4-pfx or another domain set", ps.Name, p)
+			}
+			ipsb.AddPrefix(p)
+			used.AddPrefix(p)
+		}
+		if ds.prefixes, err = ipsb.IPSet(); err != nil {
+			return nil, err
+		}
+		sets = append(sets, ds)
+	}
+	return sets, nil
+}
+
+// normalizeDomainPattern validates a domain set entry and returns it in
+// lowercase without a trailing dot.
+func normalizeDomainPattern(d string) (string, error) {
+	if d == "*" {
+		return d, nil
+	}
+	name, isWildcard := strings.CutPrefix(d, "*.")
+	fqdn, err := dnsname.ToFQDN(name)
+	if err != nil {
+		return "", err
+	}
+	name = strings.ToLower(fqdn.WithoutTrailingDot())
+	if isWildcard {
+		return "*." + name, nil
+	}
+	return name, nil
+}
+
+// matches reports whether domain, which must be normalized, is in ds.
+func (ds *domainSet) matches(domain string) bool {
+	for _, pattern := range ds.domains {
+		if pattern == "*" || pattern == domain {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasSuffix(domain, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether node may use the domains of ds.
+func (ds *domainSet) allows(node *tailcfg.Node) bool {
+	if len(ds.allowedTags) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(node.Tags, func(tag string) bool {
+		return slices.Contains(ds.allowedTags, tag)
+	})
+}
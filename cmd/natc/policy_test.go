@@ -0,0 +1,233 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/cmd/natc/ippool"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/must"
+)
+
+func TestParsePolicy(t *testing.T) {
+	reserved, _, _ := calculateAddresses([]netip.Prefix{netip.MustParsePrefix("100.64.1.0/24")})
+
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			in: `{
+				// Comments are allowed.
+				"DomainSets": [
+					{
+						"Name": "corp",
+						"Domains": ["*.corp.example.com", "Wiki.Example.com."],
+						"V4Prefixes": ["100.64.2.0/24"],
+						"AllowedTags": ["tag:eng"],
+					},
+					{
+						"Name": "rest",
+						"Domains": ["*"],
+						"V4Prefixes": ["100.64.3.0/24", "100.64.4.0/25"],
+					},
+				],
+			}`,
+		},
+		{
+			name:    "bad_name",
+			in:      `{"DomainSets": [{"Name": "Corp", "Domains": ["a.com"], "V4Prefixes": ["100.64.2.0/24"]}]}`,
+			wantErr: "invalid domain set name",
+		},
+		{
+			name: "duplicate_name",
+			in: `{"DomainSets": [
+				{"Name": "a", "Domains": ["a.com"], "V4Prefixes": ["100.64.2.0/24"]},
+				{"Name": "a", "Domains": ["b.com"], "V4Prefixes": ["100.64.3.0/24"]},
+			]}`,
+			wantErr: "duplicate domain set",
+		},
+		{
+			name:    "no_prefixes",
+			in:      `{"DomainSets": [{"Name": "a", "Domains": ["a.com"]}]}`,
+			wantErr: "no V4Prefixes",
+		},
+		{
+			name:    "overlaps_v4_pfx",
+			in:      `{"DomainSets": [{"Name": "a", "Domains": ["a.com"], "V4Prefixes": ["100.64.1.128/25"]}]}`,
+			wantErr: "overlaps",
+		},
+		{
+			name: "overlaps_other_set",
+			in: `{"DomainSets": [
+				{"Name": "a", "Domains": ["a.com"], "V4Prefixes": ["100.64.2.0/24"]},
+				{"Name": "b", "Domains": ["b.com"], "V4Prefixes": ["100.64.0.0/16"]},
+			]}`,
+			wantErr: "overlaps",
+		},
+		{
+			name:    "unmasked_prefix",
+			in:      `{"DomainSets": [{"Name": "a", "Domains": ["a.com"], "V4Prefixes": ["100.64.2.1/24"]}]}`,
+			wantErr: "not a masked IPv4 prefix",
+		},
+		{
+			name:    "bad_tag",
+			in:      `{"DomainSets": [{"Name": "a", "Domains": ["a.com"], "V4Prefixes": ["100.64.2.0/24"], "AllowedTags": ["eng"]}]}`,
+			wantErr: "tag",
+		},
+		{
+			name:    "bad_domain",
+			in:      `{"DomainSets": [{"Name": "a", "Domains": ["a..com"], "V4Prefixes": ["100.64.2.0/24"]}]}`,
+			wantErr: "domain set \"a\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets, err := parsePolicy([]byte(tt.in), reserved)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parsePolicy error = %v; want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePolicy: %v", err)
+			}
+			if len(sets) != 2 {
+				t.Fatalf("got %d domain sets; want 2", len(sets))
+			}
+			if got, want := strings.Join(sets[0].domains, ","), "*.corp.example.com,wiki.example.com"; got != want {
+				t.Errorf("domains = %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDomainSetMatches(t *testing.T) {
+	ds := &domainSet{domains: []string{"*.corp.example.com", "wiki.example.com"}}
+	for domain, want := range map[string]bool{
+		"wiki.example.com":         true,
+		"a.corp.example.com":       true,
+		"a.b.corp.example.com":     true,
+		"corp.example.com":         false,
+		"notcorp.example.com":      false,
+		"wiki.example.com.evil.io": false,
+		"example.com":              false,
+	} {
+		if got := ds.matches(domain); got != want {
+			t.Errorf("matches(%q) = %v; want %v", domain, got, want)
+		}
+	}
+
+	all := &domainSet{domains: []string{"*"}}
+	if !all.matches("anything.example") {
+		t.Errorf("* does not match all domains")
+	}
+}
+
+func TestDomainSetAllows(t *testing.T) {
+	open := &domainSet{}
+	eng := &domainSet{allowedTags: []string{"tag:eng", "tag:ops"}}
+
+	untagged := &tailcfg.Node{}
+	engNode := &tailcfg.Node{Tags: []string{"tag:web", "tag:ops"}}
+	if !open.allows(untagged) {
+		t.Errorf("set without AllowedTags does not allow untagged node")
+	}
+	if eng.allows(untagged) {
+		t.Errorf("set with AllowedTags allows untagged node")
+	}
+	if !eng.allows(engNode) {
+		t.Errorf("set does not allow node with one of its tags")
+	}
+}
+
+func TestDNSResponsePolicy(t *testing.T) {
+	routes, dnsAddr, _ := calculateAddresses([]netip.Prefix{netip.MustParsePrefix("100.64.1.0/24")})
+	sets := must.Get(parsePolicy([]byte(`{"DomainSets": [{
+		"Name": "corp",
+		"Domains": ["*.corp.example.com"],
+		"V4Prefixes": ["100.64.2.0/24"],
+		"AllowedTags": ["tag:eng"],
+	}]}`), routes))
+	sets[0].ipPool = &ippool.SingleMachineIPPool{IPSet: sets[0].prefixes}
+
+	c := connector{
+		resolver: &resolver{
+			resolves: map[string][]netip.Addr{
+				"app.corp.example.com.": {netip.MustParseAddr("10.0.0.1")},
+				"example.com.":          {netip.MustParseAddr("8.8.8.8")},
+			},
+		},
+		whois: &whois{
+			peers: map[string]*apitype.WhoIsResponse{
+				"100.64.254.1": {Node: &tailcfg.Node{ID: 1, Tags: []string{"tag:eng"}}},
+				"100.64.254.2": {Node: &tailcfg.Node{ID: 2}},
+			},
+		},
+		routes:     routes,
+		v6ULA:      ula(1),
+		dnsAddr:    dnsAddr,
+		domainSets: sets,
+	}
+
+	query := func(remote, name string) dnsmessage.Message {
+		t.Helper()
+		var rpc recordingPacketConn
+		rb := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1})
+		must.Do(rb.StartQuestions())
+		rb.Question(dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		})
+		c.handleDNS(&rpc, must.Get(rb.Finish()), must.Get(net.ResolveUDPAddr("udp", remote+":53")))
+		if len(rpc.writes) != 1 {
+			t.Fatalf("got %d responses; want 1", len(rpc.writes))
+		}
+		var msg dnsmessage.Message
+		must.Do(msg.Unpack(rpc.writes[0]))
+		return msg
+	}
+	answerAddr := func(msg dnsmessage.Message) netip.Addr {
+		t.Helper()
+		if len(msg.Answers) != 1 {
+			t.Fatalf("got %d answers; want 1", len(msg.Answers))
+		}
+		return netip.AddrFrom4(msg.Answers[0].Body.(*dnsmessage.AResource).A)
+	}
+
+	// An allowed peer gets an address from the domain set's prefix, which
+	// maps back to the domain.
+	got := answerAddr(query("100.64.254.1", "app.corp.example.com."))
+	if !sets[0].prefixes.Contains(got) {
+		t.Errorf("allocated %v; want an address in %v", got, sets[0].prefixes.Prefixes())
+	}
+	pool, ds := c.ipPoolForAddr(got)
+	if ds != sets[0] {
+		t.Fatalf("ipPoolForAddr(%v) returned domain set %v; want %q", got, ds, sets[0].name)
+	}
+	if domain, _ := pool.DomainForIP(1, got, time.Now()); domain != "app.corp.example.com" {
+		t.Errorf("DomainForIP(%v) = %q; want app.corp.example.com", got, domain)
+	}
+
+	// Other peers get NXDOMAIN.
+	if msg := query("100.64.254.2", "app.corp.example.com."); msg.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("disallowed peer got RCode %v; want NXDOMAIN", msg.RCode)
+	}
+
+	// Domains outside of all sets resolve to their real addresses.
+	if got, want := answerAddr(query("100.64.254.2", "example.com.")), netip.MustParseAddr("8.8.8.8"); got != want {
+		t.Errorf("unmatched domain resolved to %v; want %v", got, want)
+	}
+}
@@ -37,6 +37,9 @@
 	upstreamAddr = flag.String("upstream-addr", "", "Address of the upstream Postgres server, in host:port format")
 	upstreamCA   = flag.String("upstream-ca-file", "", "File containing the PEM-encoded CA certificate for the upstream server")
 	tailscaleDir = flag.String("state-dir", "", "Directory in which to store the Tailscale auth state")
+	enforceRoles = flag.Bool("enforce-roles", false, "only allow clients to connect as the Postgres roles granted to them by the tailscale.com/cap/pgproxy capability or --roles-file")
+	rolesFile    = flag.String("roles-file", "", "JSON file mapping tailnet users and tags to the Postgres roles they may connect as; implies --enforce-roles")
+	auditFile    = flag.String("audit-log", "", "File to append a JSON line to for each query clients run, with their Tailscale identity")
 )
 
 func main() {
@@ -72,6 +75,22 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *rolesFile != "" {
+		p.roles, err = loadRolePolicy(*rolesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if *enforceRoles {
+		p.roles = new(rolePolicy)
+	}
+	if *auditFile != "" {
+		f, err := os.OpenFile(*auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		p.audit = &auditLog{w: f}
+	}
 	expvar.Publish("pgproxy", p.Expvar())
 
 	if *debugPort != 0 {
@@ -107,6 +126,13 @@ type proxy struct {
 	downstreamCert   []tls.Certificate
 	client           *local.Client
 
+	// roles, if non-nil, restricts clients to the Postgres roles it and
+	// their peer capabilities allow.
+	roles *rolePolicy
+
+	// audit, if non-nil, is where the queries of clients are logged.
+	audit *auditLog
+
 	activeSessions  expvar.Int
 	startedSessions expvar.Int
 	errors          metrics.LabelMap
@@ -186,10 +212,6 @@ func (p *proxy) Serve(ln net.Listener) error {
 	// that they want to do a TLS handshake. Servers should respond with
 	// the single byte "S" before starting a normal TLS handshake.
 	sslStart = [8]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}
-	// plaintextStart is the magic bytes that postgres clients use to
-	// indicate that they're starting a plaintext authentication
-	// handshake.
-	plaintextStart = [8]byte{0, 0, 0, 86, 0, 3, 0, 0}
 )
 
 // serve proxies the postgres client on c to the proxy's upstream,
@@ -239,16 +261,46 @@ func (p *proxy) serve(sessionID int64, c net.Conn) error {
 		p.errors.Add("network-error", 1)
 		return fmt.Errorf("initial magic read: %v", err)
 	}
-	var clientIsTLS bool
-	switch {
-	case buf == sslStart:
-		clientIsTLS = true
-	case buf == plaintextStart:
-		clientIsTLS = false
-	default:
+
+	// Accept the client conn and set it up the way the client wants.
+	clientConn := c
+	if buf == sslStart {
+		io.WriteString(c, "S") // yeah, we're good to speak TLS
+		s := tls.Server(c, &tls.Config{
+			ServerName:   p.upstreamHost,
+			Certificates: p.downstreamCert,
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err = s.HandshakeContext(ctx); err != nil {
+			p.errors.Add("client-tls", 1)
+			return fmt.Errorf("client TLS handshake: %v", err)
+		}
+		clientConn = s
+		if _, err := io.ReadFull(clientConn, buf[:]); err != nil {
+			p.errors.Add("network-error", 1)
+			return fmt.Errorf("startup message read: %v", err)
+		}
+	}
+	startup, err := readStartupMessage(clientConn, buf)
+	if err != nil {
 		p.errors.Add("client-bad-protocol", 1)
-		return fmt.Errorf("unrecognized initial packet = % 02x", buf)
+		return fmt.Errorf("reading startup message: %v", err)
+	}
+	role, database := startup.params["user"], startup.params["database"]
+	if p.roles != nil {
+		roles, err := p.roles.allowedRoles(whois)
+		if err != nil {
+			p.errors.Add("bad-capability", 1)
+			return err
+		}
+		if !roleAllowed(roles, role) {
+			p.errors.Add("unauthorized-role", 1)
+			// 28000 is invalid_authorization_specification.
+			writeErrorResponse(clientConn, "28000", fmt.Sprintf("pgproxy: %s is not allowed to connect as role %q", user, role))
+			return fmt.Errorf("user %s not allowed to connect as role %q (allowed: %q)", user, role, roles)
+		}
 	}
+	log.Printf("%d: connecting as role %q to database %q", sessionID, role, database)
 
 	// Dial & verify upstream connection.
 	var d net.Dialer
@@ -282,33 +334,27 @@ func (p *proxy) serve(sessionID int64, c net.Conn) error {
 		return fmt.Errorf("upstream TLS handshake: %v", err)
 	}
 
-	// Accept the client conn and set it up the way the client wants.
-	var clientConn net.Conn
-	if clientIsTLS {
-		io.WriteString(c, "S") // yeah, we're good to speak TLS
-		s := tls.Server(c, &tls.Config{
-			ServerName:   p.upstreamHost,
-			Certificates: p.downstreamCert,
-			MinVersion:   tls.VersionTLS12,
-		})
-		if err = uptc.HandshakeContext(ctx); err != nil {
-			p.errors.Add("client-tls", 1)
-			return fmt.Errorf("client TLS handshake: %v", err)
-		}
-		clientConn = s
-	} else {
-		// Repeat the header we read earlier up to the server.
-		if _, err := uptc.Write(plaintextStart[:]); err != nil {
-			p.errors.Add("network-error", 1)
-			return fmt.Errorf("sending initial client bytes to upstream: %v", err)
-		}
-		clientConn = c
+	// Repeat the startup message we read earlier up to the server.
+	if _, err := uptc.Write(startup.raw); err != nil {
+		p.errors.Add("network-error", 1)
+		return fmt.Errorf("sending startup message to upstream: %v", err)
 	}
 
 	// Finally, proxy the client to the upstream.
 	errc := make(chan error, 1)
 	go func() {
-		_, err := io.Copy(uptc, clientConn)
+		var err error
+		if p.audit != nil {
+			err = p.audit.copyAudited(uptc, clientConn, auditEntry{
+				Session:  sessionID,
+				User:     user,
+				Machine:  machine,
+				Role:     role,
+				Database: database,
+			})
+		} else {
+			_, err = io.Copy(uptc, clientConn)
+		}
 		errc <- err
 	}()
 	go func() {
@@ -0,0 +1,165 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// pgMessage returns a postgres message of type typ with the given
+// NUL-terminated fields as its body.
+func pgMessage(typ byte, fields ...string) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = append(b, f...)
+		b = append(b, 0)
+	}
+	hdr := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(b)+4))
+	return append(hdr, b...)
+}
+
+func TestReadStartupMessage(t *testing.T) {
+	startup := func(version uint32, params ...string) []byte {
+		b := binary.BigEndian.AppendUint32(make([]byte, 4), version)
+		for _, p := range params {
+			b = append(append(b, p...), 0)
+		}
+		b = append(b, 0)
+		binary.BigEndian.PutUint32(b, uint32(len(b)))
+		return b
+	}
+	read := func(b []byte) (*startupMessage, error) {
+		return readStartupMessage(bytes.NewReader(b[8:]), [8]byte(b[:8]))
+	}
+
+	b := startup(protocolVersion3, "user", "alice", "database", "prod", "application_name", "psql")
+	m, err := read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.raw, b) {
+		t.Errorf("raw = %q; want %q", m.raw, b)
+	}
+	if m.params["user"] != "alice" || m.params["database"] != "prod" || m.params["application_name"] != "psql" {
+		t.Errorf("params = %v", m.params)
+	}
+
+	for name, b := range map[string][]byte{
+		"no user":     startup(protocolVersion3, "database", "prod"),
+		"version 2":   startup(2<<16, "user", "alice"),
+		"gssencrypt":  startup(80877104),
+		"truncated":   startup(protocolVersion3, "user", "alice")[:12],
+		"too long":    startup(protocolVersion3, "user", strings.Repeat("a", maxStartupMessageLen)),
+		"length zero": append([]byte{0, 0, 0, 0}, startup(protocolVersion3, "user", "alice")[4:]...),
+	} {
+		if _, err := read(b); err == nil {
+			t.Errorf("%s: readStartupMessage succeeded; want error", name)
+		}
+	}
+}
+
+func TestAllowedRoles(t *testing.T) {
+	rp := &rolePolicy{
+		Users: map[string][]string{"alice@example.com": {"alice", "readonly"}},
+		Tags:  map[string][]string{"tag:ci": {"migrations"}},
+	}
+	alice := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+		CapMap: tailcfg.PeerCapMap{
+			pgproxyCapability: {`{"roles":["readonly","reporting"]}`},
+		},
+	}
+	ci := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "ci.example.ts.net.", Tags: []string{"tag:ci"}},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+	for _, tt := range []struct {
+		rp   *rolePolicy
+		who  *apitype.WhoIsResponse
+		want []string
+	}{
+		{rp, alice, []string{"alice", "readonly", "reporting"}},
+		{nil, alice, []string{"readonly", "reporting"}},
+		{rp, ci, []string{"migrations"}},
+		{nil, ci, nil},
+	} {
+		got, err := tt.rp.allowedRoles(tt.who)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("allowedRoles(%s) = %q; want %q", tt.who.Node.Name, got, tt.want)
+		}
+	}
+
+	if !roleAllowed([]string{"a", "b"}, "b") || roleAllowed([]string{"a"}, "b") || !roleAllowed([]string{"*"}, "b") {
+		t.Error("roleAllowed is wrong")
+	}
+}
+
+func TestCopyAudited(t *testing.T) {
+	var in []byte
+	in = append(in, pgMessage('Q', "SELECT 1")...)
+	in = append(in, pgMessage('P', "stmt1", "SELECT * FROM t WHERE id = $1", "\x00\x00")...)
+	in = append(in, pgMessage('B', "", "stmt1")...)
+	in = append(in, pgMessage('S')...)
+	in = append(in, pgMessage('X')...)
+
+	var out, logged bytes.Buffer
+	a := &auditLog{w: &logged}
+	if err := a.copyAudited(&out, bytes.NewReader(in), auditEntry{Session: 42, User: "alice@example.com", Role: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), in) {
+		t.Errorf("copied %q; want %q", out.Bytes(), in)
+	}
+
+	dec := json.NewDecoder(&logged)
+	var got []auditEntry
+	for dec.More() {
+		var e auditEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("logged %d entries; want 2", len(got))
+	}
+	for i, want := range []struct{ protocol, query string }{
+		{"simple", "SELECT 1"},
+		{"extended", "SELECT * FROM t WHERE id = $1"},
+	} {
+		e := got[i]
+		if e.Protocol != want.protocol || e.Query != want.query || e.Session != 42 || e.User != "alice@example.com" || e.Role != "alice" {
+			t.Errorf("entry %d = %+v; want %s query %q of session 42", i, e, want.protocol, want.query)
+		}
+	}
+
+	// Messages with invalid lengths end the session.
+	if err := a.copyAudited(&out, bytes.NewReader([]byte{'Q', 0, 0, 0, 1}), auditEntry{}); err == nil {
+		t.Error("copyAudited of invalid message succeeded; want error")
+	}
+}
+
+func TestWriteErrorResponse(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeErrorResponse(&b, "28000", "denied"); err != nil {
+		t.Fatal(err)
+	}
+	want := pgMessage('E', "SFATAL", "VFATAL", "C28000", "Mdenied", "")
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("got %q; want %q", b.Bytes(), want)
+	}
+}
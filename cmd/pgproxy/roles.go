@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// pgproxyCapability is the peer capability granting tailnet clients the
+// Postgres roles they may connect as. Its values are capRules.
+const pgproxyCapability tailcfg.PeerCapability = "tailscale.com/cap/pgproxy"
+
+// capRule is a value of pgproxyCapability.
+type capRule struct {
+	// Roles are the Postgres roles the client may connect as. "*" allows
+	// all roles.
+	Roles []string `json:"roles"`
+}
+
+// rolePolicy maps tailnet users and tags to the Postgres roles they may
+// connect as, in addition to those granted by pgproxyCapability.
+type rolePolicy struct {
+	// Users maps the login names of users to the roles their (untagged)
+	// nodes may connect as.
+	Users map[string][]string `json:"users,omitempty"`
+
+	// Tags maps ACL tags to the roles nodes with the tag may connect as.
+	Tags map[string][]string `json:"tags,omitempty"`
+}
+
+// loadRolePolicy reads the rolePolicy in the JSON file at path.
+func loadRolePolicy(path string) (*rolePolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rp := new(rolePolicy)
+	if err := json.Unmarshal(b, rp); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return rp, nil
+}
+
+// allowedRoles returns the Postgres roles the node and user who may connect
+// as, under rp and the peer capabilities granted to who. rp may be nil.
+func (rp *rolePolicy) allowedRoles(who *apitype.WhoIsResponse) ([]string, error) {
+	var roles []string
+	if rp != nil && who.Node != nil {
+		if who.Node.IsTagged() {
+			for _, tag := range who.Node.Tags {
+				roles = append(roles, rp.Tags[tag]...)
+			}
+		} else if who.UserProfile != nil {
+			roles = append(roles, rp.Users[who.UserProfile.LoginName]...)
+		}
+	}
+	rules, err := tailcfg.UnmarshalCapJSON[capRule](who.CapMap, pgproxyCapability)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s capability: %w", pgproxyCapability, err)
+	}
+	for _, r := range rules {
+		roles = append(roles, r.Roles...)
+	}
+	slices.Sort(roles)
+	return slices.Compact(roles), nil
+}
+
+// roleAllowed reports whether role is one of roles, or roles allow all.
+func roleAllowed(roles []string, role string) bool {
+	return slices.Contains(roles, role) || slices.Contains(roles, "*")
+}
@@ -0,0 +1,199 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// protocolVersion3 is the protocol version code in the startup
+	// message of postgres clients speaking protocol 3.0, the only one we
+	// support.
+	protocolVersion3 = 3 << 16
+
+	// maxStartupMessageLen is the maximum length of a startup message,
+	// as enforced by postgres itself.
+	maxStartupMessageLen = 10000
+
+	// maxAuditedMessageLen is the maximum length of a query message whose
+	// query is logged. Longer ones are logged truncated.
+	maxAuditedMessageLen = 1 << 20
+)
+
+// startupMessage is a postgres client's StartupMessage.
+type startupMessage struct {
+	raw    []byte            // the whole message, including its length
+	params map[string]string // "user", "database", and others
+}
+
+// readStartupMessage reads the rest of a startup message from r, given its
+// first 8 bytes, hdr: its length and protocol version.
+func readStartupMessage(r io.Reader, hdr [8]byte) (*startupMessage, error) {
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if v := binary.BigEndian.Uint32(hdr[4:]); v != protocolVersion3 {
+		return nil, fmt.Errorf("unsupported protocol version %d.%d", v>>16, v&0xffff)
+	}
+	if n < 8 || n > maxStartupMessageLen {
+		return nil, fmt.Errorf("invalid startup message length %d", n)
+	}
+	raw := make([]byte, n)
+	copy(raw, hdr[:])
+	if _, err := io.ReadFull(r, raw[8:]); err != nil {
+		return nil, err
+	}
+
+	// The parameters are pairs of NUL-terminated names and values,
+	// terminated by an empty name.
+	m := &startupMessage{raw: raw, params: map[string]string{}}
+	fields := bytes.Split(raw[8:], []byte{0})
+	for i := 0; i+1 < len(fields) && len(fields[i]) > 0; i += 2 {
+		m.params[string(fields[i])] = string(fields[i+1])
+	}
+	if m.params["user"] == "" {
+		return nil, errors.New("startup message has no user")
+	}
+	return m, nil
+}
+
+// writeErrorResponse writes a postgres ErrorResponse message with severity
+// FATAL, the SQLSTATE code and the message msg to w.
+func writeErrorResponse(w io.Writer, code, msg string) error {
+	var body bytes.Buffer
+	for _, f := range []struct {
+		typ byte
+		val string
+	}{
+		{'S', "FATAL"},
+		{'V', "FATAL"},
+		{'C', code},
+		{'M', msg},
+	} {
+		body.WriteByte(f.typ)
+		body.WriteString(f.val)
+		body.WriteByte(0)
+	}
+	body.WriteByte(0)
+
+	var hdr [5]byte
+	hdr[0] = 'E'
+	binary.BigEndian.PutUint32(hdr[1:], uint32(body.Len()+4))
+	_, err := w.Write(append(hdr[:], body.Bytes()...))
+	return err
+}
+
+// auditLog is a log of the queries clients run through the proxy, as JSON
+// auditEntry lines.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// auditEntry is an entry of an auditLog.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Session  int64     `json:"session"`
+	User     string    `json:"user"` // login name of the client, or its tags
+	Machine  string    `json:"machine"`
+	Role     string    `json:"role"`
+	Database string    `json:"database,omitempty"`
+	Protocol string    `json:"protocol"` // "simple" or "extended"
+	Query    string    `json:"query"`
+}
+
+func (a *auditLog) log(e *auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := json.NewEncoder(a.w).Encode(e); err != nil {
+		log.Printf("%d: writing audit log: %v", e.Session, err)
+	}
+}
+
+// copyAudited copies the postgres client messages in src to dst, logging
+// the queries of Query (simple protocol) and Parse (extended protocol)
+// messages to a with the session details in tmpl.
+func (a *auditLog) copyAudited(dst io.Writer, src io.Reader, tmpl auditEntry) error {
+	br := bufio.NewReader(src)
+	bw := bufio.NewWriter(dst)
+	var hdr [5]byte
+	for {
+		// Send what we have before waiting for more.
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if _, err := bw.Write(hdr[:]); err != nil {
+			return err
+		}
+		n := int64(binary.BigEndian.Uint32(hdr[1:])) - 4
+		if n < 0 {
+			return fmt.Errorf("invalid length of %q message", hdr[0])
+		}
+		var protocol string
+		switch hdr[0] {
+		case 'Q':
+			protocol = "simple"
+		case 'P':
+			protocol = "extended"
+		default:
+			if _, err := io.CopyN(bw, br, n); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Log the query, and forward the message, even if it is too
+		// long to log in full.
+		var body bytes.Buffer
+		if _, err := io.CopyN(io.MultiWriter(bw, &limitedBuffer{&body, maxAuditedMessageLen}), br, n); err != nil {
+			return err
+		}
+		b := body.Bytes()
+		if protocol == "extended" {
+			// A Parse message is the prepared statement's name, then
+			// its query.
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[i+1:]
+			}
+		}
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		e := tmpl
+		e.Time = time.Now()
+		e.Protocol = protocol
+		e.Query = string(b)
+		a.log(&e)
+	}
+}
+
+// limitedBuffer is an io.Writer to a bytes.Buffer that discards what is
+// written past max bytes.
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
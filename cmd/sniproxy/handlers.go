@@ -5,7 +5,6 @@
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"math/rand/v2"
 	"net"
@@ -27,6 +26,9 @@ type tcpRoundRobinHandler struct {
 
 	// ReachableIPs enumerates the IP addresses this handler is reachable on.
 	ReachableIPs []netip.Addr
+
+	// Policy decides which connections are forwarded, and logs them.
+	Policy *connPolicy
 }
 
 // ReachableOn returns the IP addresses this handler is reachable on.
@@ -49,17 +51,19 @@ func (h *tcpRoundRobinHandler) Handle(c net.Conn) {
 	}
 
 	dest := h.To[rand.IntN(len(h.To))]
-	dial := &tcpproxy.DialProxy{
-		Addr:        fmt.Sprintf("%s:%s", dest, port),
-		DialContext: h.DialContext,
+	target := h.Policy.target(c, "tcp", dest, port, dest, h.DialContext)
+	if target == nil {
+		h.Policy.deny(c, "tcp", dest, port)
+		return
 	}
 
-	p.AddRoute(addrPortStr, dial)
+	p.AddRoute(addrPortStr, target)
 	p.Start()
 }
 
 type tcpSNIHandler struct {
 	// Allowlist enumerates the FQDNs which may be proxied via SNI. An
+	// entry starting with "*." permits all subdomains of the rest. An
 	// empty slice means all domains are permitted.
 	Allowlist []string
 
@@ -68,6 +72,9 @@ type tcpSNIHandler struct {
 
 	// ReachableIPs enumerates the IP addresses this handler is reachable on.
 	ReachableIPs []netip.Addr
+
+	// Policy decides which connections are proxied, and logs them.
+	Policy *connPolicy
 }
 
 // ReachableOn returns the IP addresses this handler is reachable on.
@@ -89,17 +96,23 @@ func (h *tcpSNIHandler) Handle(c net.Conn) {
 		return netutil.NewOneConnListener(c, nil), nil
 	}
 	p.AddSNIRouteFunc(addrPortStr, func(ctx context.Context, sniName string) (t tcpproxy.Target, ok bool) {
+		label := "*"
 		if len(h.Allowlist) > 0 {
-			// TODO(tom): handle subdomains
-			if slices.Index(h.Allowlist, sniName) < 0 {
+			i := slices.IndexFunc(h.Allowlist, func(d string) bool {
+				return domainMatches(d, sniName)
+			})
+			if i < 0 {
+				h.Policy.deny(c, "sni", sniName, port)
 				return nil, false
 			}
+			label = h.Allowlist[i]
 		}
-
-		return &tcpproxy.DialProxy{
-			Addr:        net.JoinHostPort(sniName, port),
-			DialContext: h.DialContext,
-		}, true
+		target := h.Policy.target(c, "sni", sniName, port, label, h.DialContext)
+		if target == nil {
+			h.Policy.deny(c, "sni", sniName, port)
+			return nil, false
+		}
+		return target, true
 	})
 	p.Start()
 }
@@ -7,13 +7,17 @@
 	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"io"
 	"net"
 	"net/netip"
+	"slices"
 	"strings"
 	"testing"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
 )
 
 func echoConnOnce(conn net.Conn) {
@@ -157,3 +161,140 @@ func TestTCPSNIHandler(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 }
+
+// chanWriter is an io.Writer sending each write on a channel.
+type chanWriter chan []byte
+
+func (w chanWriter) Write(b []byte) (int, error) {
+	w <- bytes.Clone(b)
+	return len(b), nil
+}
+
+func TestTCPSNIHandlerPolicy(t *testing.T) {
+	logs := make(chanWriter, 1)
+	var who *apitype.WhoIsResponse
+	h := tcpSNIHandler{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, s := memnet.NewConn("outbound", 1024)
+			go func() {
+				// Echo the ClientHello, which is larger than what
+				// echoConnOnce reads.
+				defer s.Close()
+				b := make([]byte, len(fakeSNIHeader()))
+				if _, err := io.ReadFull(s, b); err == nil {
+					s.Write(b)
+				}
+			}()
+			return c, nil
+		},
+		Policy: &connPolicy{
+			Rules: []accessRule{{Domain: "*.tailscale.com", Users: []string{"alice@example.com"}}},
+			WhoIs: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+				if remoteAddr != "100.64.1.2:1234" {
+					t.Errorf("WhoIs(%q); want 100.64.1.2:1234", remoteAddr)
+				}
+				return who, nil
+			},
+			AccessLog: logs,
+		},
+	}
+	connect := func() (entry accessLogEntry, echoed bool) {
+		t.Helper()
+		cSock, sSock := memnet.NewTCPConn(netip.MustParseAddrPort("100.64.1.2:1234"), netip.MustParseAddrPort("10.64.1.2:443"), 4096)
+		h.Handle(sSock)
+		hello := fakeSNIHeader()
+		if _, err := cSock.Write(hello); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(hello))
+		_, err := io.ReadFull(cSock, got)
+		cSock.Close()
+		if err := json.Unmarshal(<-logs, &entry); err != nil {
+			t.Fatal(err)
+		}
+		return entry, err == nil
+	}
+
+	before := getMetrics().bytesSent.Get("*.tailscale.com").Value()
+	who = &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+	e, echoed := connect()
+	if !echoed {
+		t.Error("allowed connection was not proxied")
+	}
+	if !e.Allowed || e.User != "alice@example.com" || e.Dest != "pkgs.tailscale.com:443" || e.Handler != "sni" {
+		t.Errorf("access log entry = %+v; want allowed sni connection of alice to pkgs.tailscale.com:443", e)
+	}
+	hello := int64(len(fakeSNIHeader()))
+	if e.BytesSent != hello || e.BytesReceived != hello {
+		t.Errorf("bytes sent, received = %d, %d; want %d, %d", e.BytesSent, e.BytesReceived, hello, hello)
+	}
+	if got := getMetrics().bytesSent.Get("*.tailscale.com").Value() - before; got != hello {
+		t.Errorf("bytes sent metric of the rule increased by %d; want %d", got, hello)
+	}
+	if got := getMetrics().bytesSent.Get("pkgs.tailscale.com").Value(); got != 0 {
+		t.Errorf("bytes sent metric labeled by the SNI name = %d; want 0", got)
+	}
+
+	who = &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "ci.example.ts.net.", Tags: []string{"tag:ci"}},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+	e, echoed = connect()
+	if echoed {
+		t.Error("denied connection was proxied")
+	}
+	if e.Allowed || e.User != "" || !slices.Equal(e.Tags, []string{"tag:ci"}) || e.BytesSent != 0 {
+		t.Errorf("access log entry = %+v; want denied connection of tag:ci", e)
+	}
+}
+
+func TestTCPRoundRobinHandlerPolicy(t *testing.T) {
+	var who *apitype.WhoIsResponse
+	h := tcpRoundRobinHandler{
+		To: []string{"10.1.2.3"},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, s := memnet.NewConn("outbound", 1024)
+			go echoConnOnce(s)
+			return c, nil
+		},
+		Policy: &connPolicy{
+			Rules: []accessRule{
+				{Domain: "*.example.com", Tags: []string{"*"}},
+				{Domain: "10.1.0.0/16", Users: []string{"alice@example.com"}},
+			},
+			WhoIs: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+				return who, nil
+			},
+		},
+	}
+	connect := func() bool {
+		t.Helper()
+		cSock, sSock := memnet.NewTCPConn(netip.MustParseAddrPort("100.64.1.2:1234"), netip.MustParseAddrPort("10.64.1.2:22"), 1024)
+		defer cSock.Close()
+		h.Handle(sSock)
+		if _, err := io.WriteString(cSock, "hello"); err != nil {
+			return false
+		}
+		got := make([]byte, len("hello"))
+		_, err := io.ReadFull(cSock, got)
+		return err == nil
+	}
+
+	who = &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+	if !connect() {
+		t.Error("connection allowed by an address rule was not forwarded")
+	}
+	who = &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "ci.example.ts.net.", Tags: []string{"tag:ci"}},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+	if connect() {
+		t.Error("connection denied by an address rule was forwarded")
+	}
+}
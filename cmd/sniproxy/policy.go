@@ -0,0 +1,223 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inetaf/tcpproxy"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/netx"
+)
+
+// connPolicy decides which connections the handlers of a Server proxy, and
+// logs them. A nil *connPolicy allows all connections and logs none.
+type connPolicy struct {
+	// Rules, if non-empty, are the rules connections are allowed by.
+	Rules []accessRule
+
+	// WhoIs, if non-nil, looks up the tailnet identity of the source of
+	// connections, by remote address.
+	WhoIs func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+
+	// AccessLog, if non-nil, is where a JSON accessLogEntry is written
+	// for each connection, once it closes or is denied.
+	AccessLog io.Writer
+
+	mu sync.Mutex // guards writes to AccessLog
+}
+
+// accessLogEntry is an entry of the access log of a connPolicy.
+type accessLogEntry struct {
+	Time    time.Time `json:"time"` // when the connection was accepted
+	Src     string    `json:"src"`  // tailnet address of the source
+	Node    string    `json:"node,omitempty"`
+	User    string    `json:"user,omitempty"` // login name of the node's owner, if not tagged
+	Tags    []string  `json:"tags,omitempty"`
+	Handler string    `json:"handler"` // "sni" or "tcp"
+	Dest    string    `json:"dest"`    // host:port
+	Allowed bool      `json:"allowed"`
+
+	// The fields below are only set for allowed connections.
+	BytesSent     int64  `json:"bytes_sent,omitempty"`     // from the source to the destination
+	BytesReceived int64  `json:"bytes_received,omitempty"` // from the destination to the source
+	DurationMS    int64  `json:"duration_ms,omitempty"`
+	Error         string `json:"error,omitempty"` // of dialing the destination
+}
+
+// whoIs returns the identity of the source of c, or nil if unknown.
+func (p *connPolicy) whoIs(c net.Conn) *apitype.WhoIsResponse {
+	if p.WhoIs == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	who, err := p.WhoIs(ctx, c.RemoteAddr().String())
+	if err != nil {
+		log.Printf("whois %v: %v", c.RemoteAddr(), err)
+		return nil
+	}
+	return who
+}
+
+// newEntry returns the access log entry of connection c, of the handler
+// kind handler, to host:port.
+func (p *connPolicy) newEntry(c net.Conn, handler, host, port string, who *apitype.WhoIsResponse) *accessLogEntry {
+	e := &accessLogEntry{
+		Time:    time.Now(),
+		Src:     c.RemoteAddr().String(),
+		Handler: handler,
+		Dest:    net.JoinHostPort(host, port),
+	}
+	if who != nil && who.Node != nil {
+		e.Node = who.Node.Name
+		e.Tags = who.Node.Tags
+		if !who.Node.IsTagged() && who.UserProfile != nil {
+			e.User = who.UserProfile.LoginName
+		}
+	}
+	return e
+}
+
+func (p *connPolicy) log(e *accessLogEntry) {
+	if p == nil || p.AccessLog == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := json.NewEncoder(p.AccessLog).Encode(e); err != nil {
+		log.Printf("writing access log: %v", err)
+	}
+}
+
+// deny records that connection c, of the handler kind handler, to
+// host:port was denied, and closes it.
+func (p *connPolicy) deny(c net.Conn, handler, host, port string) {
+	getMetrics().deniedConns.Add(1)
+	if p != nil && p.AccessLog != nil {
+		p.log(p.newEntry(c, handler, host, port, p.whoIs(c)))
+	}
+	c.Close()
+}
+
+// target returns the target proxying connection c, of the handler kind
+// handler, to host:port with dial, or nil if p denies the connection.
+// A nil target must be handled by calling deny.
+//
+// The bytes proxied are counted in the metrics labeled by the domain of the
+// rule allowing the connection, or by label if p has no rules. Neither
+// comes from the client, which chooses the host of SNI connections.
+func (p *connPolicy) target(c net.Conn, handler, host, port, label string, dial netx.DialFunc) tcpproxy.Target {
+	t := &accountedTarget{label: label}
+	t.dp = tcpproxy.DialProxy{
+		Addr: net.JoinHostPort(host, port),
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, err := dial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return &countingConn{Conn: c, t: t}, nil
+		},
+		OnDialError: func(src net.Conn, err error) {
+			log.Printf("dialing %s: %v", t.dp.Addr, err)
+			t.dialErr = err
+			src.Close()
+		},
+	}
+	if p == nil {
+		return t
+	}
+	var who *apitype.WhoIsResponse
+	if len(p.Rules) > 0 || p.AccessLog != nil {
+		who = p.whoIs(c)
+	}
+	if len(p.Rules) > 0 {
+		r := matchRule(p.Rules, host)
+		if r == nil || !r.allows(who) {
+			return nil
+		}
+		t.label = r.Domain
+	}
+	if p.AccessLog != nil {
+		t.entry = p.newEntry(c, handler, host, port, who)
+		t.entry.Allowed = true
+		t.policy = p
+	}
+	return t
+}
+
+// accountedTarget is a tcpproxy.Target that counts the bytes it proxies to
+// and from a destination, and logs the connection once it closes.
+type accountedTarget struct {
+	label string // of the metrics of the destination
+	dp    tcpproxy.DialProxy
+
+	policy *connPolicy     // or nil to not log the connection
+	entry  *accessLogEntry // non-nil if policy is
+
+	sent, received atomic.Int64
+	dialErr        error // set by dp.OnDialError
+}
+
+// HandleConn implements tcpproxy.Target.
+func (t *accountedTarget) HandleConn(src net.Conn) {
+	t.dp.HandleConn(src)
+
+	m := getMetrics()
+	m.bytesSent.Add(t.label, t.sent.Load())
+	m.bytesReceived.Add(t.label, t.received.Load())
+	if t.entry == nil {
+		return
+	}
+	t.entry.BytesSent = t.sent.Load()
+	t.entry.BytesReceived = t.received.Load()
+	t.entry.DurationMS = time.Since(t.entry.Time).Milliseconds()
+	if t.dialErr != nil {
+		t.entry.Error = t.dialErr.Error()
+	}
+	t.policy.log(t.entry)
+}
+
+// countingConn is a connection to the destination of an accountedTarget,
+// counting the bytes written to and read from it.
+type countingConn struct {
+	net.Conn
+	t *accountedTarget
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.t.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.t.sent.Add(int64(n))
+	return n, err
+}
+
+// CloseRead and CloseWrite let tcpproxy half-close the underlying
+// connection, if it supports it.
+
+func (c *countingConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return nil
+}
+
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// accessRule allows connections to the domains it matches from the tailnet
+// nodes and users it lists.
+type accessRule struct {
+	// Domain is the domain name the rule applies to. A leading "*."
+	// matches any subdomain of the rest, and "*" matches all domains.
+	// It may instead be an IP address or CIDR prefix, matching the
+	// connections forwarded to IP addresses by TCP (DNAT) handlers.
+	// The rule's Domain labels the metrics of the connections it allows.
+	Domain string `json:"domain"`
+
+	// Tags are the ACL tags of the tagged nodes allowed to connect, and
+	// Users the login names of the users whose (untagged) nodes are. "*"
+	// in either allows all tagged nodes or all users, respectively.
+	Tags  []string `json:"tags,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+// rulesConfig is the format of the file named by --rules.
+type rulesConfig struct {
+	Rules []accessRule `json:"rules"`
+}
+
+// loadRules reads the access rules in the rulesConfig file at path.
+func loadRules(path string) ([]accessRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg rulesConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, r := range cfg.Rules {
+		if err := r.check(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", path, i, err)
+		}
+	}
+	return cfg.Rules, nil
+}
+
+// check returns an error if r is not a valid rule.
+func (r *accessRule) check() error {
+	d := r.Domain
+	if d == "" {
+		return errors.New("missing domain")
+	}
+	if d != "*" && strings.Contains(strings.TrimPrefix(d, "*."), "*") {
+		return fmt.Errorf("domain %q: wildcard must be a leading \"*.\"", d)
+	}
+	for _, tag := range r.Tags {
+		if tag != "*" && !strings.HasPrefix(tag, "tag:") {
+			return fmt.Errorf("domain %q: tag %q must start with \"tag:\"", d, tag)
+		}
+	}
+	return nil
+}
+
+// domainMatches reports whether the domain name host matches pattern, which
+// is a domain name, "*.", followed by one matching any of its subdomains, or
+// "*" matching all names.
+func domainMatches(pattern, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if pattern == "*" {
+		return true
+	}
+	if parent, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+parent)
+	}
+	return host == pattern
+}
+
+// hostMatches reports whether the destination host, a domain name or IP
+// address, matches pattern, which is either a pattern for domainMatches or
+// an IP address or CIDR prefix matching the IP addresses within it.
+func hostMatches(pattern, host string) bool {
+	pfx, err := netip.ParsePrefix(pattern)
+	if err != nil {
+		addr, err := netip.ParseAddr(pattern)
+		if err != nil {
+			return domainMatches(pattern, host)
+		}
+		pfx = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && pfx.Contains(addr.Unmap())
+}
+
+// allows reports whether r allows connections from the node and user who.
+// A nil who is a source whose identity is unknown, which no rule allows.
+func (r *accessRule) allows(who *apitype.WhoIsResponse) bool {
+	if who == nil || who.Node == nil {
+		return false
+	}
+	if who.Node.IsTagged() {
+		for _, tag := range who.Node.Tags {
+			if slices.Contains(r.Tags, tag) {
+				return true
+			}
+		}
+		return slices.Contains(r.Tags, "*")
+	}
+	if who.UserProfile == nil {
+		return false
+	}
+	return slices.Contains(r.Users, "*") || slices.Contains(r.Users, who.UserProfile.LoginName)
+}
+
+// matchRule returns the first of rules whose domain matches host, or nil if
+// none does.
+func matchRule(rules []accessRule, host string) *accessRule {
+	for i, r := range rules {
+		if hostMatches(r.Domain, host) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// allowedBy reports whether who may connect to host under rules: whether the
+// first rule whose domain matches host allows it. Connections to hosts that
+// no rule matches are denied, unless there are no rules at all.
+func allowedBy(rules []accessRule, host string, who *apitype.WhoIsResponse) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	r := matchRule(rules, host)
+	return r != nil && r.allows(who)
+}
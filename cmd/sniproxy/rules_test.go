@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func TestDomainMatches(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*", "anything.org", true},
+	}
+	for _, tt := range tests {
+		if got := domainMatches(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("domainMatches(%q, %q) = %v; want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"*.example.com", "www.example.com", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "11.1.2.3", false},
+		{"10.1.2.3", "10.1.2.3", true},
+		{"10.1.2.3", "10.1.2.4", false},
+		{"10.0.0.0/8", "::ffff:10.1.2.3", true},
+		{"fd7a:115c:a1e0::/48", "fd7a:115c:a1e0::1", true},
+		{"10.0.0.0/8", "example.com", false},
+		{"*", "10.1.2.3", true},
+	}
+	for _, tt := range tests {
+		if got := hostMatches(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("hostMatches(%q, %q) = %v; want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedBy(t *testing.T) {
+	alice := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+	server := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "ci.example.ts.net.", Tags: []string{"tag:ci"}},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+	rules := []accessRule{
+		{Domain: "internal.example.com", Users: []string{"alice@example.com"}},
+		{Domain: "*.github.com", Tags: []string{"tag:ci"}, Users: []string{"*"}},
+		{Domain: "blocked.org"},
+		{Domain: "*", Tags: []string{"*"}},
+	}
+	tests := []struct {
+		host string
+		who  *apitype.WhoIsResponse
+		want bool
+	}{
+		{"internal.example.com", alice, true},
+		{"internal.example.com", server, false},
+		{"internal.example.com", nil, false},
+		{"api.github.com", alice, true},
+		{"api.github.com", server, true},
+		{"blocked.org", alice, false},
+		{"blocked.org", server, false},
+		{"other.org", alice, false},
+		{"other.org", server, true},
+	}
+	for _, tt := range tests {
+		if got := allowedBy(rules, tt.host, tt.who); got != tt.want {
+			t.Errorf("allowedBy(%q, %v) = %v; want %v", tt.host, tt.who, got, tt.want)
+		}
+	}
+	if !allowedBy(nil, "other.org", nil) {
+		t.Error("allowedBy with no rules = false; want true")
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	for _, tt := range []struct {
+		config  string
+		wantErr bool
+	}{
+		{`{"rules":[{"domain":"*.example.com","tags":["tag:ci"],"users":["*"]},{"domain":"*"}]}`, false},
+		{`{"rules":[{"tags":["tag:ci"]}]}`, true},
+		{`{"rules":[{"domain":"www.*.com"}]}`, true},
+		{`{"rules":[{"domain":"example.com","tags":["ci"]}]}`, true},
+		{`{"rules":`, true},
+	} {
+		if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadRules(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("loadRules(%s) error = %v; want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
@@ -32,6 +32,11 @@ type target struct {
 
 // Server implements an App Connector as expressed in sniproxy.
 type Server struct {
+	// Policy, if non-nil, decides which connections the handlers of
+	// the server proxy, and logs them. It must be set before Configure
+	// is first called.
+	Policy *connPolicy
+
 	mu         sync.RWMutex // mu guards following fields
 	connectors map[appctype.ConfigID]connector
 }
@@ -42,10 +47,20 @@ type appcMetrics struct {
 	tcpConns       expvar.Int
 	sniConns       expvar.Int
 	unhandledConns expvar.Int
+	deniedConns    expvar.Int
+
+	// bytesSent and bytesReceived are the bytes proxied to and from the
+	// destinations, by the domain of the access rule allowing them, or the
+	// allowlist entry or forwarding destination if there are no rules.
+	bytesSent     metrics.LabelMap
+	bytesReceived metrics.LabelMap
 }
 
 var getMetrics = sync.OnceValue[*appcMetrics](func() *appcMetrics {
-	m := appcMetrics{}
+	m := appcMetrics{
+		bytesSent:     metrics.LabelMap{Label: "destination"},
+		bytesReceived: metrics.LabelMap{Label: "destination"},
+	}
 
 	stats := new(metrics.Set)
 	stats.Set("tls_sessions", &m.sniConns)
@@ -56,6 +71,10 @@ type appcMetrics struct {
 	clientmetric.NewCounterFunc("sniproxy_dns_responses", m.dnsResponses.Value)
 	stats.Set("dns_failed", &m.dnsFailures)
 	clientmetric.NewCounterFunc("sniproxy_dns_failed", m.dnsFailures.Value)
+	stats.Set("denied_sessions", &m.deniedConns)
+	clientmetric.NewCounterFunc("sniproxy_denied_sessions", m.deniedConns.Value)
+	stats.Set("counter_bytes_sent", &m.bytesSent)
+	stats.Set("counter_bytes_received", &m.bytesReceived)
 	expvar.Publish("sniproxy", stats)
 
 	return &m
@@ -65,7 +84,7 @@ type appcMetrics struct {
 func (s *Server) Configure(cfg *appctype.AppConnectorConfig) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.connectors = makeConnectorsFromConfig(cfg)
+	s.connectors = makeConnectorsFromConfig(cfg, s.Policy)
 	log.Printf("installed app connector config: %+v", s.connectors)
 }
 
@@ -265,7 +284,7 @@ type handler interface {
 	ReachableOn() []netip.Addr
 }
 
-func installDNATHandler(d *appctype.DNATConfig, out *connector) {
+func installDNATHandler(d *appctype.DNATConfig, policy *connPolicy, out *connector) {
 	// These handlers don't actually do DNAT, they just
 	// proxy the data over the connection.
 	var dialer net.Dialer
@@ -274,6 +293,7 @@ func installDNATHandler(d *appctype.DNATConfig, out *connector) {
 		To:           d.To,
 		DialContext:  dialer.DialContext,
 		ReachableIPs: d.Addrs,
+		Policy:       policy,
 	}
 
 	for _, addr := range d.Addrs {
@@ -288,13 +308,14 @@ func installDNATHandler(d *appctype.DNATConfig, out *connector) {
 	}
 }
 
-func installSNIHandler(c *appctype.SNIProxyConfig, out *connector) {
+func installSNIHandler(c *appctype.SNIProxyConfig, policy *connPolicy, out *connector) {
 	var dialer net.Dialer
 	dialer.Timeout = 5 * time.Second
 	h := tcpSNIHandler{
 		Allowlist:    c.AllowedDomains,
 		DialContext:  dialer.DialContext,
 		ReachableIPs: c.Addrs,
+		Policy:       policy,
 	}
 
 	for _, addr := range c.Addrs {
@@ -309,17 +330,17 @@ func installSNIHandler(c *appctype.SNIProxyConfig, out *connector) {
 	}
 }
 
-func makeConnectorsFromConfig(cfg *appctype.AppConnectorConfig) map[appctype.ConfigID]connector {
+func makeConnectorsFromConfig(cfg *appctype.AppConnectorConfig, policy *connPolicy) map[appctype.ConfigID]connector {
 	var connectors map[appctype.ConfigID]connector
 
 	for cID, d := range cfg.DNAT {
 		c := connectors[cID]
-		installDNATHandler(&d, &c)
+		installDNATHandler(&d, policy, &c)
 		mak.Set(&connectors, cID, c)
 	}
 	for cID, d := range cfg.SNIProxy {
 		c := connectors[cID]
-		installSNIHandler(&d, &c)
+		installSNIHandler(&d, policy, &c)
 		mak.Set(&connectors, cID, c)
 	}
 
@@ -80,7 +80,7 @@ func TestMakeConnectorsFromConfig(t *testing.T) {
 
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
-			connectors := makeConnectorsFromConfig(tc.input)
+			connectors := makeConnectorsFromConfig(tc.input, nil)
 
 			if diff := cmp.Diff(connectors, tc.want,
 				cmpopts.IgnoreFields(tcpRoundRobinHandler{}, "DialContext"),
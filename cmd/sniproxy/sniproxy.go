@@ -77,22 +77,48 @@ func main() {
 		promoteHTTPS = fs.Bool("promote-https", true, "promote HTTP to HTTPS")
 		debugPort    = fs.Int("debug-port", 8893, "Listening port for debug/metrics endpoint")
 		hostname     = fs.String("hostname", "", "Hostname to register the service under")
+		rules        = fs.String("rules", "", "path to a JSON file of rules allowing connections to domains from tailnet tags and users; if empty, all connections are allowed")
+		accessLog    = fs.String("access-log", "", "path of a file to append a JSON line to for each connection, or \"-\" for stdout; if empty, connections are not logged")
 	)
 	err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("TS_APPC"))
 	if err != nil {
 		log.Fatal("ff.Parse")
 	}
 
+	var policy *connPolicy
+	if *rules != "" || *accessLog != "" {
+		policy = new(connPolicy)
+	}
+	if *rules != "" {
+		policy.Rules, err = loadRules(*rules)
+		if err != nil {
+			log.Fatalf("loading rules: %v", err)
+		}
+	}
+	switch *accessLog {
+	case "":
+	case "-":
+		policy.AccessLog = os.Stdout
+	default:
+		f, err := os.OpenFile(*accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatalf("opening access log: %v", err)
+		}
+		defer f.Close()
+		policy.AccessLog = f
+	}
+
 	var ts tsnet.Server
 	defer ts.Close()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	run(ctx, &ts, *wgPort, *hostname, *promoteHTTPS, *debugPort, *ports, *forwards)
+	run(ctx, &ts, *wgPort, *hostname, *promoteHTTPS, *debugPort, *ports, *forwards, policy)
 }
 
 // run actually runs the sniproxy. Its separate from main() to assist in testing.
-func run(ctx context.Context, ts *tsnet.Server, wgPort int, hostname string, promoteHTTPS bool, debugPort int, ports, forwards string) {
+// policy, if non-nil, decides which connections are proxied, and logs them.
+func run(ctx context.Context, ts *tsnet.Server, wgPort int, hostname string, promoteHTTPS bool, debugPort int, ports, forwards string, policy *connPolicy) {
 	// Wire up Tailscale node + app connector server
 	hostinfo.SetApp("sniproxy")
 	var s sniproxy
@@ -106,6 +132,10 @@ func run(ctx context.Context, ts *tsnet.Server, wgPort int, hostname string, pro
 		log.Fatalf("LocalClient() failed: %v", err)
 	}
 	s.lc = lc
+	if policy != nil {
+		policy.WhoIs = lc.WhoIs
+		s.srv.Policy = policy
+	}
 	s.ts.RegisterFallbackTCPHandler(s.srv.HandleTCPFlow)
 
 	// Start special-purpose listeners: dns, http promotion, debug server
@@ -195,10 +195,17 @@ type boolFlag interface {
 
 // noDupFlagify modifies c recursively to make all the
 // flag values be wrappers that permit setting the value
-// at most once.
+// at most once, except for values with an IsRepeatable
+// method reporting true.
 func noDupFlagify(c *ffcli.Command) {
+	type repeatableFlag interface {
+		IsRepeatable() bool
+	}
 	if c.FlagSet != nil {
 		c.FlagSet.VisitAll(func(f *flag.Flag) {
+			if rf, ok := f.Value.(repeatableFlag); ok && rf.IsRepeatable() {
+				return
+			}
 			f.Value = &onceFlagValue{Value: f.Value}
 		})
 	}
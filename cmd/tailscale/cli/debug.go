@@ -14,6 +14,8 @@
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -22,8 +24,10 @@
 	"os"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -322,6 +326,35 @@ func debugCmd() *ffcli.Command {
 					return fs
 				})(),
 			},
+			{
+				Name:       "filter",
+				ShortUsage: "tailscale debug filter [--conntrack | --src=<ip> --port=<port> [--dst=<ip>] [--proto=<proto>]]",
+				Exec:       runDebugFilter,
+				ShortHelp:  "Print packet filter rule hit counts, or explain the verdict on a connection",
+				LongHelp: strings.TrimSpace(`
+Without flags, 'tailscale debug filter' prints how many incoming connections
+each rule of the packet filter accepted, and how many packets were dropped
+and why, since the packet filter last changed.
+
+With --src and --port, it reports whether the packet filter accepts a new
+incoming connection from --src to --dst (by default, this node) and --port,
+and the rule that accepts it.
+
+With --conntrack, it prints the packet filter's connection tracking table:
+the UDP and SCTP flows and ICMP Echo requests this node initiated, whose
+return traffic is let in until they are idle for longer than their timeout
+or are evicted to make room for newer flows.
+`),
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("filter")
+					fs.StringVar(&debugFilterArgs.src, "src", "", "source IP of the connection to explain")
+					fs.StringVar(&debugFilterArgs.dst, "dst", "", "destination IP of the connection to explain (default: this node's Tailscale IP)")
+					fs.UintVar(&debugFilterArgs.port, "port", 0, "destination port of the connection to explain")
+					fs.StringVar(&debugFilterArgs.proto, "proto", "tcp", `protocol of the connection to explain ("tcp", "udp", "icmp", etc.)`)
+					fs.BoolVar(&debugFilterArgs.conntrack, "conntrack", false, "print the connection tracking table")
+					return fs
+				})(),
+			},
 			{
 				Name:       "peer-endpoint-changes",
 				ShortUsage: "tailscale debug peer-endpoint-changes <hostname-or-IP>",
@@ -1175,6 +1208,104 @@ func debugPortmap(ctx context.Context, args []string) error {
 	return err
 }
 
+var debugFilterArgs struct {
+	src       string
+	dst       string
+	port      uint
+	proto     string
+	conntrack bool
+}
+
+func runDebugFilter(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	if debugFilterArgs.conntrack {
+		if debugFilterArgs.src != "" {
+			return errors.New("--conntrack and --src are mutually exclusive")
+		}
+		return printFilterConnTrack(ctx)
+	}
+	if debugFilterArgs.src == "" {
+		if debugFilterArgs.dst != "" || debugFilterArgs.port != 0 {
+			return errors.New("--dst and --port require --src")
+		}
+		st, err := localClient.DebugFilterStats(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "RULE\tHITS\tMATCH\n")
+		for _, r := range st.Rules {
+			fmt.Fprintf(w, "%d\t%d\t%s\n", r.Index, r.Hits, r.Rule)
+		}
+		w.Flush()
+		if len(st.Drops) > 0 {
+			printf("\nDropped packets:\n")
+			for _, why := range slices.Sorted(maps.Keys(st.Drops)) {
+				printf("  %d\t%s\n", st.Drops[why], why)
+			}
+		}
+		return nil
+	}
+
+	src, err := netip.ParseAddr(debugFilterArgs.src)
+	if err != nil {
+		return fmt.Errorf("invalid --src: %w", err)
+	}
+	var dst netip.Addr
+	if debugFilterArgs.dst != "" {
+		if dst, err = netip.ParseAddr(debugFilterArgs.dst); err != nil {
+			return fmt.Errorf("invalid --dst: %w", err)
+		}
+	}
+	if debugFilterArgs.port > math.MaxUint16 {
+		return fmt.Errorf("invalid --port %d", debugFilterArgs.port)
+	}
+	res, err := localClient.DebugFilterExplain(ctx, src, dst, uint16(debugFilterArgs.port), debugFilterArgs.proto)
+	if err != nil {
+		return err
+	}
+	printf("Verdict: %s\n", res.Verdict)
+	printf("Reason:  %s\n", res.Reason)
+	if res.Index < 0 {
+		return nil
+	}
+	printf("Rule:    #%d %s\n", res.Index, res.Rule)
+	if res.FilterRule != nil {
+		j, err := json.MarshalIndent(res.FilterRule, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("From control rule:\n%s\n", j)
+	}
+	return nil
+}
+
+func printFilterConnTrack(ctx context.Context) error {
+	ct, err := localClient.DebugFilterConnTrack(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "PROTO\tSRC\tDST\tIDLE\tTIMEOUT\n")
+	var icmpFlows int
+	for _, f := range ct.Flows {
+		if f.Proto == "icmp" || f.Proto == "ipv6-icmp" {
+			icmpFlows++
+		}
+		timeout := "-"
+		if f.Timeout > 0 {
+			timeout = f.Timeout.String()
+		}
+		fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%s\n", f.Proto, f.Src, f.Dst, f.Idle.Round(time.Second), timeout)
+	}
+	w.Flush()
+	printf("\n%d/%d UDP and SCTP entries, %d/%d ICMP Echo entries; %d evicted while active, %d expired\n",
+		len(ct.Flows)-icmpFlows, ct.MaxEntries, icmpFlows, ct.MaxICMPEntries, ct.Evicted, ct.Expired)
+	return nil
+}
+
 func runPeerEndpointChanges(ctx context.Context, args []string) error {
 	st, err := localClient.Status(ctx)
 	if err != nil {
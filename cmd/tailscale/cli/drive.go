@@ -5,8 +5,11 @@
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"math"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -14,7 +17,7 @@
 )
 
 const (
-	driveShareUsage   = "tailscale drive share <name> <path>"
+	driveShareUsage   = "tailscale drive share [--quota=<size>] [--symlinks=allow|within-share|deny] <name> <path>"
 	driveRenameUsage  = "tailscale drive rename <oldname> <newname>"
 	driveUnshareUsage = "tailscale drive unshare <name>"
 	driveListUsage    = "tailscale drive list"
@@ -37,6 +40,12 @@
 			ShortUsage: driveShareUsage,
 			Exec:       runDriveShare,
 			ShortHelp:  "[ALPHA] Create or modify a share",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("share")
+				fs.StringVar(&driveShareArgs.quota, "quota", "", "maximum total size of the files in the share, in bytes or with a K, M, G or T suffix; writes from other machines beyond it are refused")
+				fs.StringVar(&driveShareArgs.symlinks, "symlinks", "", `whether other machines may follow symbolic links in the share: "allow", "within-share" for those that stay within it, or "deny" (default "allow")`)
+				return fs
+			})(),
 		},
 		{
 			Name:       "rename",
@@ -59,6 +68,11 @@
 	},
 }
 
+var driveShareArgs struct {
+	quota    string
+	symlinks string
+}
+
 // runDriveShare is the entry point for the "tailscale drive share" command.
 func runDriveShare(ctx context.Context, args []string) error {
 	if len(args) != 2 {
@@ -72,9 +86,23 @@ func runDriveShare(ctx context.Context, args []string) error {
 		return err
 	}
 
+	var quota int64
+	if driveShareArgs.quota != "" {
+		quota, err = parseSize(driveShareArgs.quota)
+		if err != nil {
+			return fmt.Errorf("invalid --quota: %w", err)
+		}
+	}
+	symlinks := drive.SymlinkPolicy(driveShareArgs.symlinks)
+	if !symlinks.Valid() {
+		return fmt.Errorf("invalid --symlinks %q; must be allow, within-share or deny", driveShareArgs.symlinks)
+	}
+
 	err = localClient.DriveShareSet(ctx, &drive.Share{
-		Name: name,
-		Path: absolutePath,
+		Name:     name,
+		Path:     absolutePath,
+		Quota:    quota,
+		Symlinks: symlinks,
 	})
 	if err == nil {
 		fmt.Printf("Sharing %q as %q\n", path, name)
@@ -146,6 +174,21 @@ func runDriveList(ctx context.Context, args []string) error {
 	return nil
 }
 
+// parseSize parses a size in bytes, optionally with a K, M, G or T suffix
+// for KiB, MiB, GiB or TiB.
+func parseSize(s string) (int64, error) {
+	digits, shift := s, 0
+	if i := strings.IndexAny(s, "KMGTkmgt"); i >= 0 && i == len(s)-1 {
+		shift = 10 * (strings.IndexByte("KMGT", strings.ToUpper(s[i:])[0]) + 1)
+		digits = s[:i]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("%q is not a valid size", s)
+	}
+	return n << shift, nil
+}
+
 func buildShareLongHelp() string {
 	longHelpAs := ""
 	if drive.AllowShareAs() {
@@ -217,6 +260,16 @@ func buildShareLongHelp() string {
 	  }
 	}]
 
+You can limit the total size of the files in a share, and control whether other machines may follow symbolic links in it. For example, to share build artifacts in at most 10 GiB, without following symbolic links that lead outside of the share:
+
+  $ tailscale drive share --quota=10G --symlinks=within-share artifacts /srv/artifacts
+
+Other machines can watch a directory in a share for changes by sending a GET request for it with the query parameter "changes", for example:
+
+  http://100.100.100.100:8080/mydomain.com/mylaptop/artifacts?changes=
+
+The response is a JSON object with a "cursor". Passing it back as the value of "changes" waits for, and returns, the changes since then.
+
 You can rename shares, for example you could rename the above share by running:
 
   $ tailscale drive rename docs newdocs
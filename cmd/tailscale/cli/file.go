@@ -8,10 +8,13 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -77,14 +80,16 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 		fs.StringVar(&cpArgs.name, "name", "", "alternate filename to use, especially useful when <file> is \"-\" (stdin)")
 		fs.BoolVar(&cpArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&cpArgs.targets, "targets", false, "list possible file cp targets")
+		fs.BoolVar(&cpArgs.recursive, "r", false, "copy directories, with the regular files they contain, recursively")
 		return fs
 	})(),
 }
 
 var cpArgs struct {
-	name    string
-	verbose bool
-	targets bool
+	name      string
+	verbose   bool
+	targets   bool
+	recursive bool
 }
 
 func runCp(ctx context.Context, args []string) error {
@@ -159,7 +164,13 @@ func runCp(ctx context.Context, args []string) error {
 				return err
 			}
 			if fi.IsDir() {
-				return errors.New("directories not supported")
+				if !cpArgs.recursive {
+					return errors.New("directories not supported without -r")
+				}
+				if err := pushDir(ctx, stableID, fileArg, name); err != nil {
+					return err
+				}
+				continue
 			}
 			contentLength = fi.Size()
 			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
@@ -196,6 +207,112 @@ func runCp(ctx context.Context, args []string) error {
 	return nil
 }
 
+// pushDir sends the directory dir, with the regular files it contains,
+// recursively, to stableID as a directory named name, or by its base name
+// if empty.
+func pushDir(ctx context.Context, stableID tailcfg.StableNodeID, dir, name string) error {
+	if name == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		name = filepath.Base(abs)
+	}
+	if cpArgs.verbose {
+		log.Printf("hashing files in %q ...", dir)
+	}
+	manifest, err := dirManifest(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	if len(manifest) == 0 {
+		return fmt.Errorf("%s: no files to send", dir)
+	}
+	var size int64
+	for _, f := range manifest {
+		size += f.Size
+	}
+	if cpArgs.verbose {
+		log.Printf("sending %q (%d files) to %v ...", name, len(manifest), stableID)
+	}
+
+	fsys := &countingFS{FS: os.DirFS(dir)}
+	var group syncs.WaitGroup
+	ctxProgress, cancelProgress := context.WithCancel(ctx)
+	defer cancelProgress()
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		group.Go(func() { progressPrinter(ctxProgress, name+"/", fsys.n.Load, size) })
+	}
+
+	err = localClient.PushDir(ctx, stableID, name, fsys, manifest)
+	cancelProgress()
+	group.Wait() // wait for progress printer to stop before reporting the error
+	if err != nil {
+		return err
+	}
+	if cpArgs.verbose {
+		log.Printf("sent %q", name)
+	}
+	return nil
+}
+
+// dirManifest returns the manifest of the regular files in fsys, which
+// skips symlinks and other special files.
+func dirManifest(fsys fs.FS) ([]apitype.FileManifestEntry, error) {
+	var manifest []apitype.FileManifestEntry
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			fmt.Fprintf(Stderr, "# skipping %s: not a regular file\n", p)
+			return nil
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, apitype.FileManifestEntry{
+			Path:   p,
+			Size:   n,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	})
+	return manifest, err
+}
+
+// countingFS is an fs.FS counting the bytes read from the files it opens.
+type countingFS struct {
+	fs.FS
+	n atomic.Int64
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	f, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingFile{File: f, n: &c.n}, nil
+}
+
+type countingFile struct {
+	fs.File
+	n *atomic.Int64
+}
+
+func (f *countingFile) Read(buf []byte) (int, error) {
+	n, err := f.File.Read(buf)
+	f.n.Add(int64(n))
+	return n, err
+}
+
 func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
@@ -521,12 +638,25 @@ func openFileOrSubstitute(dir, base string, action onConflict) (*os.File, error)
 }
 
 func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targetFile string, size int64, err error) {
+	// Files of received directories are named by their slash-separated
+	// paths in them, and written with the same layout.
+	name := filepath.FromSlash(wf.Name)
+	if !filepath.IsLocal(name) {
+		return "", 0, fmt.Errorf("invalid inbox file name %q", wf.Name)
+	}
 	rc, size, err := localClient.GetWaitingFile(ctx, wf.Name)
 	if err != nil {
 		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
 	}
 	defer rc.Close()
-	f, err := openFileOrSubstitute(dir, wf.Name, getArgs.conflict)
+	if sub := filepath.Dir(name); sub != "." {
+		dir = filepath.Join(dir, sub)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", 0, err
+		}
+		name = filepath.Base(name)
+	}
+	f, err := openFileOrSubstitute(dir, name, getArgs.conflict)
 	if err != nil {
 		return "", 0, err
 	}
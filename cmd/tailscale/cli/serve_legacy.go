@@ -4,6 +4,7 @@
 package cli
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -18,9 +19,11 @@
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
@@ -141,6 +144,7 @@ type localServeClient interface {
 	QueryFeature(ctx context.Context, feature string) (*tailcfg.QueryFeatureResponse, error)
 	WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (*tailscale.IPNBusWatcher, error)
 	IncrementCounter(ctx context.Context, name string, delta int) error
+	ServeBackendStatus(context.Context) (map[string][]ipn.ServeBackendStatus, error)
 }
 
 // serveEnv is the environment the serve command runs within. All I/O should be
@@ -163,6 +167,22 @@ type serveEnv struct {
 	subcmd           serveMode // subcommand
 	yes              bool      // update without prompt
 
+	// v2 web handler flags
+	status             int         // status code of text and redirect responses
+	rewritePath        string      // path prefix replacing the mount point for proxies
+	setRequestHeaders  headerFlag  // headers to set on proxied requests
+	setResponseHeaders headerFlag  // headers to set on responses
+	identityHeaders    bool        // set identity headers on non-proxy responses
+	allowCaps          stringsFlag // peer capabilities allowed to access the mount point
+	allowUsers         stringsFlag // user logins allowed to access the mount point
+	allowTags          stringsFlag // tags allowed to access the mount point
+
+	// v2 load balancing flags
+	backends            stringsFlag   // additional backends of the target
+	lbPolicy            string        // load balancing policy
+	healthCheck         string        // "tcp" or an HTTP path
+	healthCheckInterval time.Duration // time between health checks
+
 	lc localServeClient // localClient interface, specific to serve
 
 	// optional stuff for tests:
@@ -623,14 +643,21 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
+	var backendStatus map[string][]ipn.ServeBackendStatus
+	if sc.HasLoadBalancer() {
+		backendStatus, err = e.lc.ServeBackendStatus(ctx)
+		if err != nil {
+			return err
+		}
+	}
 	if sc.IsTCPForwardingAny() {
-		if err := printTCPStatusTree(ctx, sc, st); err != nil {
+		if err := printTCPStatusTree(ctx, sc, st, backendStatus); err != nil {
 			return err
 		}
 		printf("\n")
 	}
 	for hp := range sc.Web {
-		err := e.printWebStatusTree(sc, hp)
+		err := e.printWebStatusTree(sc, hp, backendStatus)
 		if err != nil {
 			return err
 		}
@@ -640,7 +667,7 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 	return nil
 }
 
-func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.Status) error {
+func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.Status, backendStatus map[string][]ipn.ServeBackendStatus) error {
 	dnsName := strings.TrimSuffix(st.Self.DNSName, ".")
 	for p, h := range sc.TCP {
 		if h.TCPForward == "" {
@@ -661,11 +688,50 @@ func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.S
 			printf("|-- tcp://%s\n", ipp)
 		}
 		printf("|--> tcp://%s\n", h.TCPForward)
+		if h.LoadBalancer != nil {
+			printBackendStatus("    ", h.LoadBalancer, h.View().ForwardBackends(), backendStatus)
+		}
 	}
 	return nil
 }
 
-func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) error {
+// printBackendStatus prints the backends of the load balancer lb, with
+// their state from backendStatus, each line starting with indent.
+func printBackendStatus(indent string, lb *ipn.LoadBalancer, backends []string, backendStatus map[string][]ipn.ServeBackendStatus) {
+	policy := cmp.Or(lb.Policy, ipn.LBRoundRobin)
+	printf("%s|-- load balanced (%s)\n", indent, policy)
+	statuses := backendStatus[strings.Join(backends, ",")]
+	for _, b := range backends {
+		state := "unknown"
+		if i := slices.IndexFunc(statuses, func(st ipn.ServeBackendStatus) bool { return st.Backend == b }); i >= 0 {
+			st := statuses[i]
+			switch {
+			case st.Healthy:
+				state = fmt.Sprintf("healthy, %d active", st.Active)
+			case st.LastError != "":
+				state = fmt.Sprintf("unhealthy: %s", st.LastError)
+			default:
+				state = "unhealthy"
+			}
+		}
+		printf("%s|   |--> %s (%s)\n", indent, b, state)
+	}
+}
+
+// printAccess prints the peers allowed by a.
+func printAccess(indent string, a *ipn.ServeAccess) {
+	var allowed []string
+	for _, c := range a.Caps {
+		allowed = append(allowed, "cap "+string(c))
+	}
+	for _, login := range a.Logins {
+		allowed = append(allowed, "user "+login)
+	}
+	allowed = append(allowed, a.Tags...)
+	printf("%s|-- allowed: %s\n", indent, strings.Join(allowed, ", "))
+}
+
+func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort, backendStatus map[string][]ipn.ServeBackendStatus) error {
 	// No-op if no serve config
 	if sc == nil {
 		return nil
@@ -704,6 +770,10 @@ func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) erro
 			return "proxy", h.Proxy
 		case h.Text != "":
 			return "text", "\"" + elipticallyTruncate(h.Text, 20) + "\""
+		case h.Redirect != "":
+			return "redirect", h.Redirect
+		case h.Status != 0:
+			return "status", strconv.Itoa(h.Status)
 		}
 		return "", ""
 	}
@@ -718,6 +788,12 @@ func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) erro
 		h := sc.Web[hp].Handlers[m]
 		t, d := srvTypeAndDesc(h)
 		printf("%s %s%s %-5s %s\n", "|--", m, strings.Repeat(" ", maxLen-len(m)), t, d)
+		if h.Access != nil {
+			printAccess("    ", h.Access)
+		}
+		if h.LoadBalancer != nil {
+			printBackendStatus("    ", h.LoadBalancer, h.View().ProxyBackends(), backendStatus)
+		}
 	}
 
 	return nil
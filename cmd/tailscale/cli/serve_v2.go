@@ -11,13 +11,16 @@
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -44,6 +47,8 @@ type commandInfo struct {
 <target> can be a file, directory, text, or most commonly the location to a service running on the
 local machine. The location to the location service can be expressed as a port number (e.g., 3000),
 a partial URL (e.g., localhost:3000), or a full URL including a path (e.g., http://localhost:3000/foo).
+It can also be "text:<text>" to serve fixed text, or "redirect:<url>" to redirect requests to <url>,
+where ${HOST} and ${REQUEST_URI} are replaced by the request's host and its path and query.
 
 EXAMPLES
   - Expose an HTTP server running at 127.0.0.1:3000 in the foreground:
@@ -55,6 +60,16 @@ type commandInfo struct {
   - Expose an HTTPS server with invalid or self-signed certificates at https://localhost:8443
     $ tailscale %[1]s https+insecure://localhost:8443
 
+  - Expose an API at 127.0.0.1:8080/v2 under /api, telling it which environment it runs in:
+    $ tailscale %[1]s --bg --set-path=/api --rewrite-path=/v2 --set-header="X-Env: prod" 8080
+
+  - Expose an admin UI at 127.0.0.1:9090 under /admin, only to peers granted a capability by your
+    tailnet policy file:
+    $ tailscale %[1]s --bg --set-path=/admin --allow-cap=example.com/cap/admin 9090
+
+  - Permanently redirect HTTP requests to HTTPS:
+    $ tailscale %[1]s --bg --http=80 --status=301 'redirect:https://${HOST}${REQUEST_URI}'
+
 For more examples and use cases visit our docs site https://tailscale.com/kb/1247/funnel-serve-use-cases
 `)
 
@@ -128,6 +143,18 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			fs.UintVar(&e.tcp, "tcp", 0, "Expose a TCP forwarder to forward raw TCP packets at the specified port")
 			fs.UintVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", 0, "Expose a TCP forwarder to forward TLS-terminated TCP packets at the specified port")
 			fs.BoolVar(&e.yes, "yes", false, "Update without interactive prompts (default false)")
+			fs.IntVar(&e.status, "status", 0, "HTTP status code of text and redirect responses (default 200 for text, 302 for redirects)")
+			fs.StringVar(&e.rewritePath, "rewrite-path", "", "Replaces the mount point with the specified path in requests to the underlying service (default strips the mount point)")
+			fs.Var(&e.setRequestHeaders, "set-header", `Sets a header on requests to the underlying service, as "Name: value"; may be repeated`)
+			fs.Var(&e.setResponseHeaders, "set-response-header", `Sets a header on responses, as "Name: value"; may be repeated`)
+			fs.BoolVar(&e.identityHeaders, "identity-headers", false, "Sets the Tailscale-User-* headers identifying the requesting user on file, text and redirect responses (default false)")
+			fs.Var(&e.allowCaps, "allow-cap", "Restricts the mount point to peers granted the specified capability on this node; may be repeated")
+			fs.Var(&e.allowUsers, "allow-user", "Restricts the mount point to the untagged nodes of the specified user login; may be repeated")
+			fs.Var(&e.allowTags, "allow-tag", "Restricts the mount point to nodes with the specified tag; may be repeated")
+			fs.Var(&e.backends, "backend", "Load balances between the target and the specified additional backend; may be repeated")
+			fs.StringVar(&e.lbPolicy, "lb-policy", "", `Load balancing policy, "round-robin" or "least-conn" (default "round-robin")`)
+			fs.StringVar(&e.healthCheck, "health-check", "", `Checks the health of load balanced backends, by connecting to them ("tcp") or requesting the specified HTTP path`)
+			fs.DurationVar(&e.healthCheckInterval, "health-check-interval", 0, "Time between health checks of each backend (default 10s)")
 		}),
 		UsageFunc: usageFuncNoDefaultValues,
 		Subcommands: []*ffcli.Command{
@@ -379,6 +406,9 @@ func (e *serveEnv) setServe(sc *ipn.ServeConfig, st *ipnstate.Status, dnsName st
 		if e.setPath != "" {
 			return fmt.Errorf("cannot mount a path for TCP serve")
 		}
+		if e.hasWebHandlerFlags() {
+			return fmt.Errorf("cannot use HTTP handler flags for TCP serve")
+		}
 
 		err := e.applyTCPServe(sc, dnsName, srvType, srvPort, target)
 		if err != nil {
@@ -435,6 +465,10 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 			return "proxy", h.Proxy
 		case h.Text != "":
 			return "text", "\"" + elipticallyTruncate(h.Text, 20) + "\""
+		case h.Redirect != "":
+			return "redirect", h.Redirect
+		case h.Status != 0:
+			return "status", strconv.Itoa(h.Status)
 		}
 		return "", ""
 	}
@@ -493,6 +527,12 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 			return errors.New("unable to serve; text cannot be an empty string")
 		}
 		h.Text = text
+	case strings.HasPrefix(target, "redirect:"):
+		redirect := strings.TrimPrefix(target, "redirect:")
+		if redirect == "" {
+			return errors.New("unable to serve; redirect URL cannot be an empty string")
+		}
+		h.Redirect = redirect
 	case filepath.IsAbs(target):
 		if version.IsMacAppStore() || version.IsMacSys() {
 			// The Tailscale network extension cannot serve arbitrary paths on macOS due to sandbox restrictions (2024-03-26)
@@ -520,6 +560,35 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 		h.Proxy = t
 	}
 
+	lb, err := e.loadBalancer(func(backend string) (string, error) {
+		return ipn.ExpandProxyTargetValue(backend, []string{"http", "https", "https+insecure"}, "http")
+	})
+	if err != nil {
+		return err
+	}
+	if lb != nil && h.Proxy == "" {
+		return errors.New("cannot load balance; target is not a proxy")
+	}
+	h.LoadBalancer = lb
+
+	h.Status = e.status
+	h.RewritePath = e.rewritePath
+	h.SetRequestHeaders = e.setRequestHeaders
+	h.SetResponseHeaders = e.setResponseHeaders
+	h.IdentityHeaders = e.identityHeaders
+	if len(e.allowCaps)+len(e.allowUsers)+len(e.allowTags) > 0 {
+		h.Access = &ipn.ServeAccess{
+			Logins: e.allowUsers,
+			Tags:   e.allowTags,
+		}
+		for _, c := range e.allowCaps {
+			h.Access.Caps = append(h.Access.Caps, tailcfg.PeerCapability(c))
+		}
+	}
+	if err := h.CheckValid(); err != nil {
+		return err
+	}
+
 	// TODO: validation needs to check nested foreground configs
 	if sc.IsTCPForwardingOnPort(srvPort) {
 		return errors.New("cannot serve web; already serving TCP")
@@ -556,7 +625,29 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 		return fmt.Errorf("cannot serve TCP; already serving web on %d", srcPort)
 	}
 
+	lb, err := e.loadBalancer(func(backend string) (string, error) {
+		u, err := ipn.ExpandProxyTargetValue(backend, []string{"tcp"}, "tcp")
+		if err != nil {
+			return "", err
+		}
+		bu, err := url.Parse(u)
+		if err != nil {
+			return "", fmt.Errorf("invalid TCP backend %q: %v", backend, err)
+		}
+		return bu.Host, nil
+	})
+	if err != nil {
+		return err
+	}
+
 	sc.SetTCPForwarding(srcPort, dstURL.Host, terminateTLS, dnsName)
+	if lb != nil {
+		h := sc.TCP[srcPort]
+		h.LoadBalancer = lb
+		if err := h.CheckValid(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -801,6 +892,93 @@ func cleanURLPath(urlPath string) (string, error) {
 	return "", fmt.Errorf("invalid mount point %q", urlPath)
 }
 
+// hasWebHandlerFlags reports whether any of the flags that configure HTTP
+// handlers beyond their target are set.
+func (e *serveEnv) hasWebHandlerFlags() bool {
+	return e.status != 0 ||
+		e.rewritePath != "" ||
+		len(e.setRequestHeaders) > 0 ||
+		len(e.setResponseHeaders) > 0 ||
+		e.identityHeaders ||
+		len(e.allowCaps) > 0 ||
+		len(e.allowUsers) > 0 ||
+		len(e.allowTags) > 0
+}
+
+// loadBalancer returns the load balancer configured by the --backend,
+// --lb-policy and --health-check flags, or nil if no --backend is set.
+// expand converts each backend to the form used by the handler.
+func (e *serveEnv) loadBalancer(expand func(string) (string, error)) (*ipn.LoadBalancer, error) {
+	if len(e.backends) == 0 {
+		if e.lbPolicy != "" || e.healthCheck != "" || e.healthCheckInterval != 0 {
+			return nil, errors.New("load balancing flags require --backend")
+		}
+		return nil, nil
+	}
+	lb := &ipn.LoadBalancer{Policy: ipn.LBPolicy(e.lbPolicy)}
+	for _, b := range e.backends {
+		t, err := expand(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend %q: %w", b, err)
+		}
+		lb.Backends = append(lb.Backends, t)
+	}
+	switch {
+	case e.healthCheck == "tcp":
+		lb.HealthCheck = &ipn.HealthCheck{}
+	case strings.HasPrefix(e.healthCheck, "/"):
+		lb.HealthCheck = &ipn.HealthCheck{Path: e.healthCheck}
+	case e.healthCheck != "":
+		return nil, fmt.Errorf(`invalid --health-check %q; must be "tcp" or an HTTP path`, e.healthCheck)
+	case e.healthCheckInterval != 0:
+		return nil, errors.New("--health-check-interval requires --health-check")
+	}
+	if lb.HealthCheck != nil {
+		lb.HealthCheck.Interval.Duration = e.healthCheckInterval
+	}
+	return lb, nil
+}
+
+// stringsFlag is a flag.Value for flags that may be repeated, collecting
+// each value.
+type stringsFlag []string
+
+func (f stringsFlag) String() string { return strings.Join(f, ",") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// IsRepeatable tells noDupFlagify that the flag may be set more than once.
+func (f *stringsFlag) IsRepeatable() bool { return true }
+
+// headerFlag is a flag.Value for the --set-header and --set-response-header
+// flags. Unlike other flags, they may be repeated, each time with a header
+// of the form "Name: value".
+type headerFlag map[string]string
+
+func (f headerFlag) String() string {
+	var hs []string
+	for _, k := range slices.Sorted(maps.Keys(f)) {
+		hs = append(hs, k+": "+f[k])
+	}
+	return strings.Join(hs, ", ")
+}
+
+func (f *headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return fmt.Errorf("header %q is not of the form \"Name: value\"", s)
+	}
+	mak.Set((*map[string]string)(f), http.CanonicalHeaderKey(k), strings.TrimSpace(v))
+	return nil
+}
+
+// IsRepeatable tells noDupFlagify that the flag may be set more than once.
+func (f *headerFlag) IsRepeatable() bool { return true }
+
 func (s serveType) String() string {
 	switch s {
 	case serveTypeHTTP:
@@ -14,11 +14,14 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 )
 
 func TestServeDevConfigMutations(t *testing.T) {
@@ -791,6 +794,185 @@ type group struct {
 				},
 			},
 		},
+		{
+			name: "web_handler_flags",
+			steps: []step{
+				{
+					command: cmd("serve --bg --set-path=/api --rewrite-path=/v2 --set-header=X-Env:prod --set-header=x-team:web --set-response-header=Cache-Control:no-store 3000"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/api": {
+									Proxy:              "http://127.0.0.1:3000",
+									RewritePath:        "/v2",
+									SetRequestHeaders:  map[string]string{"X-Env": "prod", "X-Team": "web"},
+									SetResponseHeaders: map[string]string{"Cache-Control": "no-store"},
+								},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --http=80 --status=308 redirect:https://${HOST}${REQUEST_URI}"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}, 80: {HTTP: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/api": {
+									Proxy:              "http://127.0.0.1:3000",
+									RewritePath:        "/v2",
+									SetRequestHeaders:  map[string]string{"X-Env": "prod", "X-Team": "web"},
+									SetResponseHeaders: map[string]string{"Cache-Control": "no-store"},
+								},
+							}},
+							"foo.test.ts.net:80": {Handlers: map[string]*ipn.HTTPHandler{
+								"/": {Redirect: "https://${HOST}${REQUEST_URI}", Status: 308},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --set-path=/teapot --status=418 --identity-headers text:hi"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}, 80: {HTTP: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/api": {
+									Proxy:              "http://127.0.0.1:3000",
+									RewritePath:        "/v2",
+									SetRequestHeaders:  map[string]string{"X-Env": "prod", "X-Team": "web"},
+									SetResponseHeaders: map[string]string{"Cache-Control": "no-store"},
+								},
+								"/teapot": {Text: "hi", Status: 418, IdentityHeaders: true},
+							}},
+							"foo.test.ts.net:80": {Handlers: map[string]*ipn.HTTPHandler{
+								"/": {Redirect: "https://${HOST}${REQUEST_URI}", Status: 308},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --set-path=/bad --status=404 3000"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --bg --set-path=/bad --rewrite-path=/v2 text:hi"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --bg --set-path=/bad --set-header=X-Forwarded-For:1.2.3.4 3000"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --bg --tcp=5432 --set-header=X-Env:prod 5432"),
+					wantErr: anyErr(),
+				},
+			},
+		},
+		{
+			name: "access_control",
+			steps: []step{
+				{
+					command: cmd("serve --bg --set-path=/admin --allow-cap=example.com/cap/admin --allow-user=alice@example.com --allow-tag=tag:ops --allow-tag=tag:eng 3000"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/admin": {
+									Proxy: "http://127.0.0.1:3000",
+									Access: &ipn.ServeAccess{
+										Caps:   []tailcfg.PeerCapability{"example.com/cap/admin"},
+										Logins: []string{"alice@example.com"},
+										Tags:   []string{"tag:ops", "tag:eng"},
+									},
+								},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --set-path=/bad --allow-tag=ops 3000"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --bg --tcp=5432 --allow-tag=tag:ops 5432"),
+					wantErr: anyErr(),
+				},
+			},
+		},
+		{
+			name: "load_balancing",
+			steps: []step{
+				{
+					command: cmd("serve --bg --backend=3001 --backend=https+insecure://localhost:3002 --lb-policy=least-conn --health-check=/healthz --health-check-interval=30s 3000"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/": {
+									Proxy: "http://127.0.0.1:3000",
+									LoadBalancer: &ipn.LoadBalancer{
+										Backends: []string{"http://127.0.0.1:3001", "https+insecure://localhost:3002"},
+										Policy:   ipn.LBLeastConn,
+										HealthCheck: &ipn.HealthCheck{
+											Path:     "/healthz",
+											Interval: tstime.GoDuration{Duration: 30 * time.Second},
+										},
+									},
+								},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --tcp=5432 --backend=5433 --health-check=tcp 5432"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{
+							443: {HTTPS: true},
+							5432: {
+								TCPForward: "127.0.0.1:5432",
+								LoadBalancer: &ipn.LoadBalancer{
+									Backends:    []string{"127.0.0.1:5433"},
+									HealthCheck: &ipn.HealthCheck{},
+								},
+							},
+						},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/": {
+									Proxy: "http://127.0.0.1:3000",
+									LoadBalancer: &ipn.LoadBalancer{
+										Backends: []string{"http://127.0.0.1:3001", "https+insecure://localhost:3002"},
+										Policy:   ipn.LBLeastConn,
+										HealthCheck: &ipn.HealthCheck{
+											Path:     "/healthz",
+											Interval: tstime.GoDuration{Duration: 30 * time.Second},
+										},
+									},
+								},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --tcp=6000 --backend=6001 --health-check=/healthz 6000"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --bg --set-path=/lb --lb-policy=least-conn 3000"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --bg --set-path=/lb --backend=3001 --lb-policy=random 3000"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --bg --set-path=/lb --backend=3001 text:hi"),
+					wantErr: anyErr(),
+				},
+			},
+		},
 		{
 			name: "forground_with_bg_conflict",
 			steps: []step{
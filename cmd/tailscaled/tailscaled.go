@@ -12,6 +12,7 @@
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"expvar"
 	"flag"
@@ -33,6 +34,7 @@
 	"tailscale.com/client/local"
 	"tailscale.com/cmd/tailscaled/childproc"
 	"tailscale.com/control/controlclient"
+	"tailscale.com/drive"
 	"tailscale.com/drive/driveimpl"
 	"tailscale.com/envknob"
 	_ "tailscale.com/feature/condregister"
@@ -710,6 +712,35 @@ func handleSubnetsInNetstack() bool {
 
 var tstunNew = tstun.New
 
+// driveCacheConfigPath is the path of the JSON file configuring the caching
+// of files on remote Taildrive shares, if any. See drive.CacheConfig.
+var driveCacheConfigPath = envknob.RegisterString("TS_DRIVE_CACHE_CONFIG")
+
+// newDriveForLocal returns the Taildrive file system for local clients,
+// caching remote files as configured by the TS_DRIVE_CACHE_CONFIG file.
+func newDriveForLocal(logf logger.Logf) *driveimpl.FileSystemForLocal {
+	fs := driveimpl.NewFileSystemForLocal(logf)
+	path := driveCacheConfigPath()
+	if path == "" {
+		return fs
+	}
+	cfg, err := drive.LoadCacheConfig(path)
+	if err != nil {
+		logf("taildrive: not caching remote files: %v", err)
+		return fs
+	}
+	if cfg.Dir == "" {
+		varRoot := ipnServerOpts().VarRoot
+		if varRoot == "" {
+			logf("taildrive: not caching remote files: no cache directory configured and no state directory")
+			return fs
+		}
+		cfg.Dir = filepath.Join(varRoot, "drive-cache")
+	}
+	fs.SetCacheConfig(cfg)
+	return fs
+}
+
 func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack bool, err error) {
 	conf := wgengine.Config{
 		ListenPort:    args.port,
@@ -720,7 +751,7 @@ func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack boo
 		SetSubsystem:  sys.Set,
 		ControlKnobs:  sys.ControlKnobs(),
 		EventBus:      sys.Bus.Get(),
-		DriveForLocal: driveimpl.NewFileSystemForLocal(logf),
+		DriveForLocal: newDriveForLocal(logf),
 	}
 
 	sys.HealthTracker().SetMetricsRegistry(sys.UserMetricsRegistry())
@@ -927,18 +958,24 @@ func serveDrive(args []string) error {
 	if len(args) == 0 {
 		return errors.New("missing shares")
 	}
-	if len(args)%2 != 0 {
-		return errors.New("need <sharename> <path> pairs")
+	var shares []*drive.Share
+	if sharesJSON, ok := strings.CutPrefix(args[0], driveimpl.ServeDriveSharesFlag); ok && len(args) == 1 {
+		if err := json.Unmarshal([]byte(sharesJSON), &shares); err != nil {
+			return fmt.Errorf("invalid shares: %w", err)
+		}
+	} else {
+		if len(args)%2 != 0 {
+			return errors.New("need <sharename> <path> pairs")
+		}
+		for i := 0; i < len(args); i += 2 {
+			shares = append(shares, &drive.Share{Name: args[i], Path: args[i+1]})
+		}
 	}
 	s, err := driveimpl.NewFileServer()
 	if err != nil {
 		return fmt.Errorf("unable to start Taildrive file server: %v", err)
 	}
-	shares := make(map[string]string)
-	for i := 0; i < len(args); i += 2 {
-		shares[args[i]] = args[i+1]
-	}
-	s.SetShares(shares)
+	s.SetDriveShares(shares)
 	fmt.Printf("%v\n", s.Addr())
 	return s.Serve()
 }
@@ -0,0 +1,190 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+)
+
+// Sources of the value of a claimMapping.
+const (
+	claimFromLogin       = "login"        // the user's login name
+	claimFromDisplayName = "display-name" // the user's display name
+	claimFromNode        = "node"         // the node's name
+	claimFromTags        = "tags"         // the node's ACL tags
+	claimFromCap         = "cap"          // a field of the node's peer capabilities
+)
+
+// claimMapping is an operator-defined claim, whose value is taken from the
+// node or user being authenticated.
+type claimMapping struct {
+	// Claim is the name of the claim. It must not be one of
+	// openIDSupportedClaims.
+	Claim string `json:"claim"`
+
+	// From is the source of the claim's value, one of the claimFrom
+	// constants.
+	From string `json:"from"`
+
+	// Capability and Field select the value of a "cap" mapping: Field of
+	// each value of Capability in the node's peer capability map, merged
+	// across grants. Capability defaults to tailscale.com/cap/tsidp. As
+	// for capRule, values with a "clients" field are only used for the
+	// relying parties it lists.
+	Capability tailcfg.PeerCapability `json:"capability,omitempty"`
+	Field      string                 `json:"field,omitempty"`
+
+	// Clients, if non-empty, are the client IDs of the relying parties the
+	// claim is issued to. Otherwise it is issued to all of them.
+	Clients []string `json:"clients,omitempty"`
+
+	// IncludeInUserInfo is whether the claim is returned by the userinfo
+	// endpoint, in addition to the ID token.
+	IncludeInUserInfo bool `json:"includeInUserInfo,omitempty"`
+}
+
+// claimsConfig is the format of the file named by --claims-config.
+type claimsConfig struct {
+	Claims []claimMapping `json:"claims"`
+}
+
+// loadClaimMappings reads the claim mappings in the claimsConfig file at path.
+func loadClaimMappings(path string) ([]claimMapping, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg claimsConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, m := range cfg.Claims {
+		if err := m.check(); err != nil {
+			return nil, fmt.Errorf("%s: claim %d: %w", path, i, err)
+		}
+	}
+	return cfg.Claims, nil
+}
+
+// check returns an error if m is not a valid mapping.
+func (m *claimMapping) check() error {
+	if m.Claim == "" {
+		return errors.New("missing claim name")
+	}
+	if views.SliceContains(openIDSupportedClaims, m.Claim) {
+		return fmt.Errorf("claim %q is reserved", m.Claim)
+	}
+	switch m.From {
+	case claimFromLogin, claimFromDisplayName, claimFromNode, claimFromTags:
+	case claimFromCap:
+		if m.Field == "" {
+			return fmt.Errorf("claim %q: missing capability field", m.Claim)
+		}
+	default:
+		return fmt.Errorf("claim %q: unknown source %q", m.Claim, m.From)
+	}
+	return nil
+}
+
+// forClient reports whether a claim or rule limited to the client IDs in
+// clients is issued to the relying party with ID clientID.
+func forClient(clients []string, clientID string) bool {
+	return len(clients) == 0 || slices.Contains(clients, clientID)
+}
+
+// value returns the value of m for the node and user who, issued to the
+// relying party with ID clientID, or nil if it has none.
+func (m *claimMapping) value(who *apitype.WhoIsResponse, clientID string) (any, error) {
+	switch m.From {
+	case claimFromLogin:
+		return who.UserProfile.LoginName, nil
+	case claimFromDisplayName:
+		return who.UserProfile.DisplayName, nil
+	case claimFromNode:
+		return who.Node.Name, nil
+	case claimFromTags:
+		if len(who.Node.Tags) == 0 {
+			return nil, nil
+		}
+		return who.Node.Tags, nil
+	case claimFromCap:
+		capability := m.Capability
+		if capability == "" {
+			capability = tailcfg.PeerCapabilityTsIDP
+		}
+		vals, err := tailcfg.UnmarshalCapJSON[map[string]json.RawMessage](who.CapMap, capability)
+		if err != nil {
+			return nil, fmt.Errorf("claim %q: %w", m.Claim, err)
+		}
+		var merged []any
+		for _, v := range vals {
+			var clients []string
+			if raw, ok := v["clients"]; ok {
+				if err := json.Unmarshal(raw, &clients); err != nil {
+					return nil, fmt.Errorf("claim %q: clients: %w", m.Claim, err)
+				}
+			}
+			if !forClient(clients, clientID) {
+				continue
+			}
+			if raw, ok := v[m.Field]; ok {
+				var fv any
+				if err := json.Unmarshal(raw, &fv); err != nil {
+					return nil, fmt.Errorf("claim %q: %w", m.Claim, err)
+				}
+				merged = append(merged, fv)
+			}
+		}
+		switch len(merged) {
+		case 0:
+			return nil, nil
+		case 1:
+			return merged[0], nil
+		}
+		return merged, nil
+	}
+	return nil, fmt.Errorf("claim %q: unknown source %q", m.Claim, m.From)
+}
+
+// claimRules returns the rules whose extra claims are issued to the relying
+// party of ar: those of the tsidp peer capabilities granted to the node
+// being authenticated, and one per operator-defined claim mapping.
+func (s *idpServer) claimRules(ar *authRequest) ([]capRule, error) {
+	who := ar.remoteUser
+	grants, err := tailcfg.UnmarshalCapJSON[capRule](who.CapMap, tailcfg.PeerCapabilityTsIDP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal capability: %w", err)
+	}
+	var rules []capRule
+	for _, r := range grants {
+		if forClient(r.Clients, ar.clientID) {
+			rules = append(rules, r)
+		}
+	}
+	for _, m := range s.claimMappings {
+		if !forClient(m.Clients, ar.clientID) {
+			continue
+		}
+		v, err := m.value(who, ar.clientID)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil || v == "" {
+			continue
+		}
+		rules = append(rules, capRule{
+			IncludeInUserInfo: m.IncludeInUserInfo,
+			ExtraClaims:       map[string]any{m.Claim: v},
+		})
+	}
+	return rules, nil
+}
@@ -23,12 +23,14 @@
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"net/http"
 	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -67,6 +69,11 @@ type ctxConn struct{
 	flagFunnel             = flag.Bool("funnel", false, "use Tailscale Funnel to make tsidp available on the public internet")
 	flagHostname           = flag.String("hostname", "idp", "tsnet hostname to use instead of idp")
 	flagDir                = flag.String("dir", "", "tsnet state directory; a default one will be created if not provided")
+
+	flagTokenLifetime        = flag.Duration("token-lifetime", 5*time.Minute, "lifetime of ID and access tokens")
+	flagRefreshTokenLifetime = flag.Duration("refresh-token-lifetime", 7*24*time.Hour, "lifetime of refresh tokens, renewed each time one is used; 0 disables refresh tokens")
+	flagKeyRotationInterval  = flag.Duration("key-rotation-interval", 0, "how often to replace the token signing key, keeping the previous one in the JWKS; 0 never replaces it")
+	flagClaimsConfig         = flag.String("claims-config", "", "path to a JSON file of claims to add to ID tokens and userinfo, mapped from the user, node and peer capabilities")
 )
 
 func main() {
@@ -152,9 +159,18 @@ func main() {
 	}
 
 	srv := &idpServer{
-		lc:          lc,
-		funnel:      *flagFunnel,
-		localTSMode: *flagUseLocalTailscaled,
+		lc:                   lc,
+		funnel:               *flagFunnel,
+		localTSMode:          *flagUseLocalTailscaled,
+		tokenLifetime:        *flagTokenLifetime,
+		refreshTokenLifetime: *flagRefreshTokenLifetime,
+		keyRotationInterval:  *flagKeyRotationInterval,
+	}
+	if *flagClaimsConfig != "" {
+		srv.claimMappings, err = loadClaimMappings(*flagClaimsConfig)
+		if err != nil {
+			log.Fatalf("could not load claims config: %v", err)
+		}
 	}
 	if *flagPort != 443 {
 		srv.serverURL = fmt.Sprintf("https://%s:%d", strings.TrimSuffix(st.Self.DNSName, "."), *flagPort)
@@ -283,16 +299,28 @@ type idpServer struct {
 	funnel      bool
 	localTSMode bool
 
-	lazyMux        lazy.SyncValue[*http.ServeMux]
-	lazySigningKey lazy.SyncValue[*signingKey]
-	lazySigner     lazy.SyncValue[jose.Signer]
+	tokenLifetime        time.Duration // of ID and access tokens; 5 minutes if zero
+	refreshTokenLifetime time.Duration // or zero to not issue refresh tokens
+	keyRotationInterval  time.Duration // or zero to never rotate the signing key
+
+	claimMappings []claimMapping // from --claims-config
+
+	lazyMux lazy.SyncValue[*http.ServeMux]
+
+	keysMu sync.Mutex     // guards keys
+	keys   *signingKeySet // nil until first used
 
 	mu            sync.Mutex               // guards the fields below
 	code          map[string]*authRequest  // keyed by random hex
 	accessToken   map[string]*authRequest  // keyed by random hex
+	refreshToken  map[string]*authRequest  // keyed by random hex
 	funnelClients map[string]*funnelClient // keyed by client ID
+	lastSweep     time.Time                // of expired tokens
 }
 
+// tokenSweepInterval is how often issueTokens removes expired tokens.
+const tokenSweepInterval = time.Minute
+
 type authRequest struct {
 	// localRP is true if the request is from a relying party running on the
 	// same machine as the idp server. It is mutually exclusive with rpNodeID
@@ -321,9 +349,17 @@ type authRequest struct {
 	// remoteUser is the user who is being authenticated.
 	remoteUser *apitype.WhoIsResponse
 
+	// grant identifies the exchange of an authorization code for tokens.
+	// The tokens issued by refreshing them share it, so that revoking a
+	// refresh token revokes all of them.
+	grant string
+
+	// grantValidTill is the time until which the tokens of the grant can
+	// be refreshed. It is set when the authorization code is exchanged,
+	// and refreshing doesn't extend it.
+	grantValidTill time.Time
+
 	// validTill is the time until which the token is valid.
-	// As of 2023-11-14, it is 5 minutes.
-	// TODO: add routine to delete expired tokens.
 	validTill time.Time
 }
 
@@ -451,6 +487,7 @@ func (s *idpServer) newMux() *http.ServeMux {
 	mux.HandleFunc("/authorize/", s.authorize)
 	mux.HandleFunc("/userinfo", s.serveUserInfo)
 	mux.HandleFunc("/token", s.serveToken)
+	mux.HandleFunc("/revoke", s.serveRevoke)
 	mux.HandleFunc("/clients/", s.serveClients)
 	mux.HandleFunc("/", s.handleUI)
 	return mux
@@ -501,9 +538,9 @@ func (s *idpServer) serveUserInfo(w http.ResponseWriter, r *http.Request) {
 	// TODO(maisem): not sure if this is the right thing to do
 	ui.UserName, _, _ = strings.Cut(ar.remoteUser.UserProfile.LoginName, "@")
 
-	rules, err := tailcfg.UnmarshalCapJSON[capRule](ar.remoteUser.CapMap, tailcfg.PeerCapabilityTsIDP)
+	rules, err := s.claimRules(ar)
 	if err != nil {
-		http.Error(w, "tsidp: failed to unmarshal capability: %v", http.StatusBadRequest)
+		http.Error(w, "tsidp: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -539,6 +576,10 @@ type userInfo struct {
 type capRule struct {
 	IncludeInUserInfo bool           `json:"includeInUserInfo"`
 	ExtraClaims       map[string]any `json:"extraClaims,omitempty"` // list of features peer is allowed to edit
+
+	// Clients, if non-empty, are the client IDs of the relying parties
+	// ExtraClaims are issued to. Otherwise they are issued to all of them.
+	Clients []string `json:"clients,omitempty"`
 }
 
 // flattenExtraClaims merges all ExtraClaims from a slice of capRule into a single map.
@@ -675,10 +716,17 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if r.FormValue("grant_type") != "authorization_code" {
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		s.serveAuthorizationCodeGrant(w, r)
+	case "refresh_token":
+		s.serveRefreshTokenGrant(w, r)
+	default:
 		http.Error(w, "tsidp: grant_type not supported", http.StatusBadRequest)
-		return
 	}
+}
+
+func (s *idpServer) serveAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
 	if code == "" {
 		http.Error(w, "tsidp: code is required", http.StatusBadRequest)
@@ -703,6 +751,86 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
 		return
 	}
+	ar.grant = rands.HexString(16)
+	s.issueTokens(w, ar)
+}
+
+func (s *idpServer) serveRefreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	rt := r.FormValue("refresh_token")
+	if rt == "" {
+		http.Error(w, "tsidp: refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	ar, ok := s.refreshToken[rt]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "tsidp: invalid refresh_token", http.StatusBadRequest)
+		return
+	}
+	if err := ar.allowRelyingParty(r, s.lc); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Refresh tokens are single use: a new one is issued along with the
+	// new ID token.
+	s.mu.Lock()
+	_, ok = s.refreshToken[rt]
+	delete(s.refreshToken, rt)
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "tsidp: invalid refresh_token", http.StatusBadRequest)
+		return
+	}
+	if ar.validTill.Before(time.Now()) {
+		http.Error(w, "tsidp: refresh_token expired", http.StatusBadRequest)
+		return
+	}
+
+	// Look the node up again, both to make sure it is still in the
+	// tailnet and to pick up changes to its user's claims.
+	who, err := s.lc.WhoIsNodeKey(r.Context(), ar.remoteUser.Node.Key)
+	if errors.Is(err, local.ErrPeerNotFound) || (err == nil && who.Node.ID != ar.remoteUser.Node.ID) {
+		http.Error(w, "tsidp: node no longer in tailnet", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nar := *ar
+	nar.remoteUser = who
+	nar.nonce = ""
+	s.issueTokens(w, &nar)
+}
+
+// tokenLifetimes returns how long the ID and access tokens, and the refresh
+// token, issued for ar are valid. A zero refresh lifetime means no refresh
+// token is issued.
+func (s *idpServer) tokenLifetimes(ar *authRequest) (token, refresh time.Duration) {
+	token, refresh = s.tokenLifetime, s.refreshTokenLifetime
+	if token == 0 {
+		token = 5 * time.Minute
+	}
+	if ar.funnelRP != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if v := ar.funnelRP.TokenLifetime; v > 0 {
+			token = time.Duration(v) * time.Second
+		}
+		if v := ar.funnelRP.RefreshTokenLifetime; v > 0 {
+			refresh = time.Duration(v) * time.Second
+		}
+	}
+	return token, refresh
+}
+
+// issueTokens writes a token response for ar, whose relying party has been
+// authenticated.
+func (s *idpServer) issueTokens(w http.ResponseWriter, ar *authRequest) {
 	signer, err := s.oidcSigner()
 	if err != nil {
 		log.Printf("Error getting signer: %v", err)
@@ -721,11 +849,12 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	now := time.Now()
+	lifetime, refreshLifetime := s.tokenLifetimes(ar)
 	_, tcd, _ := strings.Cut(n.Name(), ".")
 	tsClaims := tailscaleClaims{
 		Claims: jwt.Claims{
 			Audience:  jwt.Audience{ar.clientID},
-			Expiry:    jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			Expiry:    jwt.NewNumericDate(now.Add(lifetime)),
 			ID:        jti,
 			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    s.serverURL,
@@ -746,9 +875,9 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		tsClaims.Issuer = s.loopbackURL
 	}
 
-	rules, err := tailcfg.UnmarshalCapJSON[capRule](who.CapMap, tailcfg.PeerCapabilityTsIDP)
+	rules, err := s.claimRules(ar)
 	if err != nil {
-		log.Printf("tsidp: failed to unmarshal capability: %v", err)
+		log.Printf("tsidp: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -769,22 +898,86 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	at := rands.HexString(32)
+	var rt string
 	s.mu.Lock()
-	ar.validTill = now.Add(5 * time.Minute)
+	s.sweepExpiredLocked(now)
+	ar.validTill = now.Add(lifetime)
 	mak.Set(&s.accessToken, at, ar)
+	if refreshLifetime > 0 && ar.grantValidTill.IsZero() {
+		ar.grantValidTill = now.Add(refreshLifetime)
+	}
+	if now.Before(ar.grantValidTill) {
+		rt = rands.HexString(32)
+		rar := *ar
+		rar.validTill = ar.grantValidTill
+		mak.Set(&s.refreshToken, rt, &rar)
+	}
 	s.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(oidcTokenResponse{
-		AccessToken: at,
-		TokenType:   "Bearer",
-		ExpiresIn:   5 * 60,
-		IDToken:     token,
+		AccessToken:  at,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(lifetime / time.Second),
+		IDToken:      token,
+		RefreshToken: rt,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// sweepExpiredLocked removes the expired access and refresh tokens, if it
+// hasn't in the last tokenSweepInterval. s.mu must be held.
+func (s *idpServer) sweepExpiredLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < tokenSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	isExpired := func(_ string, v *authRequest) bool { return v.validTill.Before(now) }
+	maps.DeleteFunc(s.accessToken, isExpired)
+	maps.DeleteFunc(s.refreshToken, isExpired)
+}
+
+// serveRevoke revokes an access or refresh token, as described in RFC 7009.
+// Revoking a refresh token also revokes the tokens issued along with it, and
+// by refreshing it.
+func (s *idpServer) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tk := r.FormValue("token")
+	if tk == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	ar, isRefresh := s.refreshToken[tk]
+	if !isRefresh {
+		ar = s.accessToken[tk]
+	}
+	s.mu.Unlock()
+	if ar == nil {
+		// Unknown tokens, such as ones that already expired or were
+		// revoked, are not an error.
+		return
+	}
+	if err := ar.allowRelyingParty(r, s.lc); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !isRefresh {
+		delete(s.accessToken, tk)
+		return
+	}
+	isGrant := func(_ string, v *authRequest) bool { return v.grant == ar.grant }
+	maps.DeleteFunc(s.accessToken, isGrant)
+	maps.DeleteFunc(s.refreshToken, isGrant)
+}
+
 type oidcTokenResponse struct {
 	IDToken      string `json:"id_token"`
 	TokenType    string `json:"token_type"`
@@ -799,44 +992,38 @@ type oidcTokenResponse struct {
 )
 
 func (s *idpServer) oidcSigner() (jose.Signer, error) {
-	return s.lazySigner.GetErr(func() (jose.Signer, error) {
-		sk, err := s.oidcPrivateKey()
-		if err != nil {
-			return nil, err
-		}
-		return jose.NewSigner(jose.SigningKey{
-			Algorithm: jose.RS256,
-			Key:       sk.k,
-		}, &jose.SignerOptions{EmbedJWK: false, ExtraHeaders: map[jose.HeaderKey]any{
-			jose.HeaderType: "JWT",
-			"kid":           fmt.Sprint(sk.kid),
-		}})
-	})
+	keys, err := s.signingKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys[0].signer()
 }
 
-func (s *idpServer) oidcPrivateKey() (*signingKey, error) {
-	return s.lazySigningKey.GetErr(func() (*signingKey, error) {
-		var sk signingKey
-		b, err := os.ReadFile("oidc-key.json")
-		if err == nil {
-			if err := sk.UnmarshalJSON(b); err == nil {
-				return &sk, nil
-			} else {
-				log.Printf("Error unmarshaling key: %v", err)
-			}
-		}
-		id, k := mustGenRSAKey(2048)
-		sk.k = k
-		sk.kid = id
-		b, err = sk.MarshalJSON()
+// signingKeys returns the keys to publish in the JWKS, the first of which
+// signs new tokens. It loads them on first use, and rotates them once
+// s.keyRotationInterval has passed since the current key was created.
+func (s *idpServer) signingKeys() ([]*signingKey, error) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if s.keys == nil {
+		ks, err := loadSigningKeys(oidcKeyFile)
 		if err != nil {
-			log.Fatalf("Error marshaling key: %v", err)
+			return nil, err
 		}
-		if err := os.WriteFile("oidc-key.json", b, 0600); err != nil {
-			log.Fatalf("Error writing key: %v", err)
+		s.keys = ks
+	}
+	if s.keyRotationInterval > 0 && time.Since(s.keys.Keys[0].created) >= s.keyRotationInterval {
+		ks := s.keys.rotated(time.Now())
+		if err := ks.save(); err != nil {
+			// Keep signing with the current key rather than one that
+			// would be lost on restart.
+			log.Printf("Error writing rotated keys: %v", err)
+		} else {
+			log.Printf("Rotated signing key %d to %d", s.keys.Keys[0].kid, ks.Keys[0].kid)
+			s.keys = ks
 		}
-		return &sk, nil
-	})
+	}
+	return s.keys.Keys, nil
 }
 
 func (s *idpServer) serveJWKS(w http.ResponseWriter, r *http.Request) {
@@ -845,25 +1032,24 @@ func (s *idpServer) serveJWKS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	sk, err := s.oidcPrivateKey()
+	keys, err := s.signingKeys()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	// TODO(maisem): maybe only marshal this once and reuse?
-	// TODO(maisem): implement key rotation.
+	var jwks jose.JSONWebKeySet
+	for _, sk := range keys {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+			Key:       sk.k.Public(),
+			Algorithm: string(jose.RS256),
+			Use:       "sig",
+			KeyID:     fmt.Sprint(sk.kid),
+		})
+	}
 	je := json.NewEncoder(w)
 	je.SetIndent("", "  ")
-	if err := je.Encode(jose.JSONWebKeySet{
-		Keys: []jose.JSONWebKey{
-			{
-				Key:       sk.k.Public(),
-				Algorithm: string(jose.RS256),
-				Use:       "sig",
-				KeyID:     fmt.Sprint(sk.kid),
-			},
-		},
-	}); err != nil {
+	if err := je.Encode(jwks); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
@@ -876,9 +1062,11 @@ type openIDProviderMetadata struct {
 	AuthorizationEndpoint            string              `json:"authorization_endpoint,omitempty"`
 	TokenEndpoint                    string              `json:"token_endpoint,omitempty"`
 	UserInfoEndpoint                 string              `json:"userinfo_endpoint,omitempty"`
+	RevocationEndpoint               string              `json:"revocation_endpoint,omitempty"`
 	JWKS_URI                         string              `json:"jwks_uri"`
 	ScopesSupported                  views.Slice[string] `json:"scopes_supported"`
 	ResponseTypesSupported           views.Slice[string] `json:"response_types_supported"`
+	GrantTypesSupported              views.Slice[string] `json:"grant_types_supported"`
 	SubjectTypesSupported            views.Slice[string] `json:"subject_types_supported"`
 	ClaimsSupported                  views.Slice[string] `json:"claims_supported"`
 	IDTokenSigningAlgValuesSupported views.Slice[string] `json:"id_token_signing_alg_values_supported"`
@@ -920,6 +1108,8 @@ type tailscaleClaims struct {
 	// We only support getting the id_token.
 	openIDSupportedReponseTypes = views.SliceOf([]string{"id_token", "code"})
 
+	openIDSupportedGrantTypes = views.SliceOf([]string{"authorization_code", "refresh_token"})
+
 	// The type of the "sub" field in the JWT, which means it is globally unique identifier.
 	// The other option is "pairwise", which means the identifier is different per receiving 3p.
 	openIDSupportedSubjectTypes = views.SliceOf([]string{"public"})
@@ -975,8 +1165,10 @@ func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
 		JWKS_URI:                         rpEndpoint + oidcJWKSPath,
 		UserInfoEndpoint:                 rpEndpoint + "/userinfo",
 		TokenEndpoint:                    rpEndpoint + "/token",
+		RevocationEndpoint:               rpEndpoint + "/revoke",
 		ScopesSupported:                  openIDSupportedScopes,
 		ResponseTypesSupported:           openIDSupportedReponseTypes,
+		GrantTypesSupported:              openIDSupportedGrantTypes,
 		SubjectTypesSupported:            openIDSupportedSubjectTypes,
 		ClaimsSupported:                  openIDSupportedClaims,
 		IDTokenSigningAlgValuesSupported: openIDSupportedSigningAlgos,
@@ -992,6 +1184,19 @@ type funnelClient struct {
 	Secret      string `json:"client_secret,omitempty"`
 	Name        string `json:"name,omitempty"`
 	RedirectURI string `json:"redirect_uri"`
+
+	// TokenLifetime and RefreshTokenLifetime, in seconds, override
+	// --token-lifetime and --refresh-token-lifetime for the client if
+	// non-zero.
+	TokenLifetime        int64 `json:"token_lifetime,omitempty"`
+	RefreshTokenLifetime int64 `json:"refresh_token_lifetime,omitempty"`
+}
+
+// redacted returns a copy of c without its secret.
+func (c *funnelClient) redacted() *funnelClient {
+	rc := *c
+	rc.Secret = ""
+	return &rc
 }
 
 // /clients is a privileged endpoint that allows the visitor to create new
@@ -1026,12 +1231,10 @@ func (s *idpServer) serveClients(w http.ResponseWriter, r *http.Request) {
 	case "DELETE":
 		s.serveDeleteClient(w, r, path)
 	case "GET":
-		json.NewEncoder(w).Encode(&funnelClient{
-			ID:          c.ID,
-			Name:        c.Name,
-			Secret:      "",
-			RedirectURI: c.RedirectURI,
-		})
+		s.mu.Lock()
+		rc := c.redacted()
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(rc)
 	default:
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -1047,13 +1250,25 @@ func (s *idpServer) serveNewClient(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: must provide redirect_uri", http.StatusBadRequest)
 		return
 	}
+	tokenLifetime, err := parseLifetime(r.FormValue("token_lifetime"))
+	if err != nil {
+		http.Error(w, "tsidp: invalid token_lifetime: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	refreshTokenLifetime, err := parseLifetime(r.FormValue("refresh_token_lifetime"))
+	if err != nil {
+		http.Error(w, "tsidp: invalid refresh_token_lifetime: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	clientID := rands.HexString(32)
 	clientSecret := rands.HexString(64)
 	newClient := funnelClient{
-		ID:          clientID,
-		Secret:      clientSecret,
-		Name:        r.FormValue("name"),
-		RedirectURI: redirectURI,
+		ID:                   clientID,
+		Secret:               clientSecret,
+		Name:                 r.FormValue("name"),
+		RedirectURI:          redirectURI,
+		TokenLifetime:        tokenLifetime,
+		RefreshTokenLifetime: refreshTokenLifetime,
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1075,14 +1290,9 @@ func (s *idpServer) serveGetClientsList(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	s.mu.Lock()
-	redactedClients := make([]funnelClient, 0, len(s.funnelClients))
+	redactedClients := make([]*funnelClient, 0, len(s.funnelClients))
 	for _, c := range s.funnelClients {
-		redactedClients = append(redactedClients, funnelClient{
-			ID:          c.ID,
-			Name:        c.Name,
-			Secret:      "",
-			RedirectURI: c.RedirectURI,
-		})
+		redactedClients = append(redactedClients, c.redacted())
 	}
 	s.mu.Unlock()
 	json.NewEncoder(w).Encode(redactedClients)
@@ -1113,6 +1323,12 @@ func (s *idpServer) serveDeleteClient(w http.ResponseWriter, r *http.Request, cl
 		s.funnelClients[clientID] = deleted
 		return
 	}
+	// Revoke everything issued to the client, whose requests would
+	// otherwise still be authenticated by its deleted secret.
+	isClient := func(_ string, v *authRequest) bool { return v.funnelRP == deleted }
+	maps.DeleteFunc(s.code, isClient)
+	maps.DeleteFunc(s.accessToken, isClient)
+	maps.DeleteFunc(s.refreshToken, isClient)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -1155,16 +1371,57 @@ func readUint64(r io.Reader) (uint64, error) {
 	}
 }
 
+// parseLifetime parses a token lifetime override from a form value, such as
+// "10m", into seconds. An empty value is zero, which means the default.
+func parseLifetime(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("%q is less than a second", v)
+	}
+	return int64(d / time.Second), nil
+}
+
+// formatLifetime formats a token lifetime override in seconds, as parsed by
+// parseLifetime.
+func formatLifetime(secs int64) string {
+	if secs == 0 {
+		return ""
+	}
+	return (time.Duration(secs) * time.Second).String()
+}
+
 // rsaPrivateKeyJSONWrapper is the the JSON serialization
 // format used by RSAPrivateKey.
 type rsaPrivateKeyJSONWrapper struct {
-	Key string
-	ID  uint64
+	Key     string
+	ID      uint64
+	Created time.Time `json:",omitzero"`
 }
 
 type signingKey struct {
-	k   *rsa.PrivateKey
-	kid uint64
+	k       *rsa.PrivateKey
+	kid     uint64
+	created time.Time // zero for keys written by older versions
+
+	lazySigner lazy.SyncValue[jose.Signer]
+}
+
+func (sk *signingKey) signer() (jose.Signer, error) {
+	return sk.lazySigner.GetErr(func() (jose.Signer, error) {
+		return jose.NewSigner(jose.SigningKey{
+			Algorithm: jose.RS256,
+			Key:       sk.k,
+		}, &jose.SignerOptions{EmbedJWK: false, ExtraHeaders: map[jose.HeaderKey]any{
+			jose.HeaderType: "JWT",
+			"kid":           fmt.Sprint(sk.kid),
+		}})
+	})
 }
 
 func (sk *signingKey) MarshalJSON() ([]byte, error) {
@@ -1174,8 +1431,9 @@ func (sk *signingKey) MarshalJSON() ([]byte, error) {
 	}
 	bts := pem.EncodeToMemory(&b)
 	return json.Marshal(rsaPrivateKeyJSONWrapper{
-		Key: base64.URLEncoding.EncodeToString(bts),
-		ID:  sk.kid,
+		Key:     base64.URLEncoding.EncodeToString(bts),
+		ID:      sk.kid,
+		Created: sk.created,
 	})
 }
 
@@ -1192,15 +1450,100 @@ func (sk *signingKey) UnmarshalJSON(b []byte) error {
 		return err
 	}
 	blk, _ := pem.Decode(b64dec)
+	if blk == nil {
+		return errors.New("invalid PEM key")
+	}
 	k, err := x509.ParsePKCS1PrivateKey(blk.Bytes)
 	if err != nil {
 		return err
 	}
 	sk.k = k
 	sk.kid = wrapper.ID
+	sk.created = wrapper.Created
+	return nil
+}
+
+// oidcKeyFile is the file where the keys tsidp signs tokens with are
+// persisted.
+const oidcKeyFile = "oidc-key.json"
+
+// maxSigningKeys is the number of keys in a signingKeySet: the current key,
+// and the one it replaced.
+const maxSigningKeys = 2
+
+// signingKeySet is the set of keys tsidp signs tokens with.
+type signingKeySet struct {
+	// Keys are the keys published in the JWKS, newest first. Tokens are
+	// signed with the first one. The others were replaced by rotation,
+	// and are kept so that the tokens they signed can still be verified.
+	Keys []*signingKey
+
+	path string // where to save the set, or empty to not
+}
+
+// loadSigningKeys reads the signing keys from path, generating and saving a
+// key if there is none.
+func loadSigningKeys(path string) (*signingKeySet, error) {
+	ks := &signingKeySet{path: path}
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(b, ks); err != nil {
+			log.Printf("Error unmarshaling keys: %v", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(ks.Keys) == 0 {
+		ks = ks.rotated(time.Now())
+		if err := ks.save(); err != nil {
+			return nil, fmt.Errorf("writing keys: %w", err)
+		}
+	}
+	return ks, nil
+}
+
+func (ks *signingKeySet) UnmarshalJSON(b []byte) error {
+	var keys struct {
+		Keys []*signingKey
+	}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return err
+	}
+	if len(keys.Keys) == 0 {
+		// Older versions wrote a single key.
+		var sk signingKey
+		if err := sk.UnmarshalJSON(b); err != nil {
+			return err
+		}
+		keys.Keys = append(keys.Keys, &sk)
+	}
+	ks.Keys = slices.DeleteFunc(keys.Keys, func(sk *signingKey) bool { return sk.k == nil })
 	return nil
 }
 
+// rotated returns a copy of ks with a new key created at now, replacing its
+// current one.
+func (ks *signingKeySet) rotated(now time.Time) *signingKeySet {
+	kid, k := mustGenRSAKey(2048)
+	keys := append([]*signingKey{{k: k, kid: kid, created: now}}, ks.Keys...)
+	return &signingKeySet{
+		Keys: keys[:min(len(keys), maxSigningKeys)],
+		path: ks.path,
+	}
+}
+
+// save writes ks to its path, if any.
+func (ks *signingKeySet) save() error {
+	if ks.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(struct{ Keys []*signingKey }{ks.Keys})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ks.path, b, 0600)
+}
+
 // parseID takes a string input and returns a typed IntID T and true, or a zero
 // value and false if the input is unhandled syntax or out of a valid range.
 func parseID[T ~int64](input string) (_ T, ok bool) {
@@ -14,18 +14,26 @@
 	"net/http/httptest"
 	"net/netip"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"go4.org/mem"
 	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
+	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/memnet"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 	"tailscale.com/types/views"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/rands"
 )
 
 // normalizeMap recursively sorts []any values in a map[string]any
@@ -66,14 +74,11 @@ func mustMarshalJSON(t *testing.T, v any) tailcfg.RawMessage {
 
 var privateKey *rsa.PrivateKey = nil
 
-func oidcTestingSigner(t *testing.T) jose.Signer {
+// oidcTestingKeys returns an unsaved signing key set of the key returned by
+// mustGeneratePrivateKey.
+func oidcTestingKeys(t *testing.T) *signingKeySet {
 	t.Helper()
-	privKey := mustGeneratePrivateKey(t)
-	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: privKey}, nil)
-	if err != nil {
-		t.Fatalf("failed to create signer: %v", err)
-	}
-	return sig
+	return &signingKeySet{Keys: []*signingKey{{k: mustGeneratePrivateKey(t), kid: 1, created: time.Now()}}}
 }
 
 func oidcTestingPublicKey(t *testing.T) *rsa.PublicKey {
@@ -585,8 +590,8 @@ func TestServeToken(t *testing.T) {
 					},
 				},
 			}
-			// Inject a working signer
-			s.lazySigner.Set(oidcTestingSigner(t))
+			// Inject a working signing key
+			s.keys = oidcTestingKeys(t)
 
 			form := url.Values{}
 			form.Set("grant_type", tt.grantType)
@@ -825,3 +830,371 @@ func TestExtraUserInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestSigningKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oidc-key.json")
+
+	// Keys written by older versions of tsidp are read as a set of one key.
+	old := &signingKey{k: mustGeneratePrivateKey(t), kid: 1}
+	b, err := old.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := loadSigningKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Keys) != 1 || ks.Keys[0].kid != 1 {
+		t.Fatalf("loaded keys %+v; want key 1", ks.Keys)
+	}
+
+	s := &idpServer{keys: ks, keyRotationInterval: time.Hour}
+	jwks := func() []string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		s.serveJWKS(rr, httptest.NewRequest("GET", oidcJWKSPath, nil))
+		var set jose.JSONWebKeySet
+		if err := json.Unmarshal(rr.Body.Bytes(), &set); err != nil {
+			t.Fatalf("unmarshaling JWKS: %v", err)
+		}
+		var kids []string
+		for _, k := range set.Keys {
+			kids = append(kids, k.KeyID)
+		}
+		return kids
+	}
+
+	// The key of unknown age is rotated, and both keys are published.
+	kids := jwks()
+	if len(kids) != 2 || kids[1] != "1" {
+		t.Fatalf("JWKS key IDs = %v; want new key and 1", kids)
+	}
+	current := s.keys.Keys[0]
+	if got := jwks(); !slices.Equal(got, kids) {
+		t.Errorf("JWKS key IDs = %v; want %v before rotation is due", got, kids)
+	}
+
+	// The rotated set was saved.
+	ks, err = loadSigningKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Keys) != 2 || ks.Keys[0].kid != current.kid || !ks.Keys[0].created.Equal(current.created) {
+		t.Errorf("saved keys %+v; want %d first", ks.Keys, current.kid)
+	}
+
+	// Once due, the current key is rotated and the oldest dropped.
+	current.created = time.Now().Add(-2 * time.Hour)
+	if got := jwks(); len(got) != 2 || got[1] != kids[0] {
+		t.Errorf("JWKS key IDs after rotation = %v; want new key and %s", got, kids[0])
+	}
+}
+
+// fakeWhoIsNodeKey serves a LocalAPI that answers WhoIs requests by node key
+// from peers, and returns a client for it.
+func fakeWhoIsNodeKey(t *testing.T, peers map[key.NodePublic]*apitype.WhoIsResponse) *local.Client {
+	ln := memnet.Listen("local-tailscaled.sock:80")
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, err := key.ParseNodePublicUntyped(mem.S(strings.TrimPrefix(r.FormValue("addr"), "nodekey:")))
+		who, ok := peers[k]
+		if r.URL.Path != "/localapi/v0/whois" || err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(who)
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return &local.Client{Dial: ln.Dial}
+}
+
+func TestRefreshToken(t *testing.T) {
+	nodeKey := key.NewNode().Public()
+	who := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ID:   123,
+			Name: "test-node.test.ts.net.",
+			User: 456,
+			Key:  nodeKey,
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+	peers := map[key.NodePublic]*apitype.WhoIsResponse{nodeKey: who}
+
+	s := &idpServer{
+		lc:                   fakeWhoIsNodeKey(t, peers),
+		keys:                 oidcTestingKeys(t),
+		tokenLifetime:        10 * time.Minute,
+		refreshTokenLifetime: time.Hour,
+	}
+	newCode := func() string {
+		code := rands.HexString(8)
+		s.mu.Lock()
+		mak.Set(&s.code, code, &authRequest{
+			clientID:    "client-id",
+			nonce:       "nonce123",
+			redirectURI: "https://rp.example.com/callback",
+			remoteUser:  who,
+			localRP:     true,
+		})
+		s.mu.Unlock()
+		return code
+	}
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.RemoteAddr = "127.0.0.1:12345"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+	token := func(form url.Values) (resp oidcTokenResponse, ok bool) {
+		t.Helper()
+		rr := post("/token", form)
+		if rr.Code != http.StatusOK {
+			return resp, false
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp, true
+	}
+	exchange := func() oidcTokenResponse {
+		t.Helper()
+		resp, ok := token(url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {newCode()},
+			"redirect_uri": {"https://rp.example.com/callback"},
+		})
+		if !ok {
+			t.Fatal("authorization_code grant failed")
+		}
+		return resp
+	}
+	refresh := func(rt string) (oidcTokenResponse, bool) {
+		t.Helper()
+		return token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt}})
+	}
+
+	refreshValidTill := func(rt string) time.Time {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.refreshToken[rt].validTill
+	}
+
+	first := exchange()
+	if first.RefreshToken == "" || first.ExpiresIn != 600 {
+		t.Fatalf("token response = %+v; want refresh token and expires_in 600", first)
+	}
+	firstTill := refreshValidTill(first.RefreshToken)
+	second, ok := refresh(first.RefreshToken)
+	if !ok {
+		t.Fatal("refresh_token grant failed")
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken || second.IDToken == "" {
+		t.Fatalf("refreshed token response = %+v; want new ID and refresh tokens", second)
+	}
+	// Refreshing doesn't extend the lifetime of the grant.
+	if got := refreshValidTill(second.RefreshToken); !got.Equal(firstTill) {
+		t.Errorf("refreshed refresh token valid till %v; want %v", got, firstTill)
+	}
+	tok, err := jwt.ParseSigned(second.IDToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]any
+	if err := tok.Claims(oidcTestingPublicKey(t), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "userid:1c8" || claims["nonce"] != nil {
+		t.Errorf("refreshed ID token claims = %v; want sub of user 456 and no nonce", claims)
+	}
+
+	// Refresh tokens are single use.
+	if _, ok := refresh(first.RefreshToken); ok {
+		t.Error("reused refresh token was accepted")
+	}
+
+	// Revoking a refresh token revokes the access tokens of its grant,
+	// but not of others.
+	other := exchange()
+	if rr := post("/revoke", url.Values{"token": {second.RefreshToken}}); rr.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d; want 200", rr.Code)
+	}
+	if _, ok := refresh(second.RefreshToken); ok {
+		t.Error("revoked refresh token was accepted")
+	}
+	s.mu.Lock()
+	_, firstOK := s.accessToken[first.AccessToken]
+	_, secondOK := s.accessToken[second.AccessToken]
+	_, otherOK := s.accessToken[other.AccessToken]
+	s.mu.Unlock()
+	if firstOK || secondOK || !otherOK {
+		t.Errorf("access tokens valid after revocation = %v, %v, %v; want false, false, true", firstOK, secondOK, otherOK)
+	}
+	if rr := post("/revoke", url.Values{"token": {"unknown"}}); rr.Code != http.StatusOK {
+		t.Errorf("revoke of unknown token status = %d; want 200", rr.Code)
+	}
+
+	// Refresh tokens stop working once the node leaves the tailnet.
+	delete(peers, nodeKey)
+	if _, ok := refresh(other.RefreshToken); ok {
+		t.Error("refresh token of removed node was accepted")
+	}
+
+	// Refresh tokens can be disabled.
+	s.refreshTokenLifetime = 0
+	if resp := exchange(); resp.RefreshToken != "" {
+		t.Errorf("got refresh token %q with refresh tokens disabled", resp.RefreshToken)
+	}
+
+	// Expired tokens are removed when tokens are next issued.
+	s.mu.Lock()
+	for _, ar := range s.accessToken {
+		ar.validTill = time.Now().Add(-time.Second)
+	}
+	s.lastSweep = time.Time{}
+	s.mu.Unlock()
+	exchange()
+	s.mu.Lock()
+	n := len(s.accessToken)
+	s.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d access tokens after sweep; want 1", n)
+	}
+}
+
+func TestDeleteClientRevokesTokens(t *testing.T) {
+	t.Chdir(t.TempDir()) // for the funnel clients file
+	client := &funnelClient{ID: "client-id", Secret: "secret"}
+	other := &funnelClient{ID: "other-id", Secret: "secret"}
+	s := &idpServer{funnelClients: map[string]*funnelClient{client.ID: client, other.ID: other}}
+	s.code = map[string]*authRequest{"code": {funnelRP: client}}
+	s.accessToken = map[string]*authRequest{"at": {funnelRP: client}, "other-at": {funnelRP: other}}
+	s.refreshToken = map[string]*authRequest{"rt": {funnelRP: client}, "other-rt": {funnelRP: other}}
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest("DELETE", "/clients/"+client.ID, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d; want %d", rr.Code, http.StatusNoContent)
+	}
+	if len(s.code) != 0 || len(s.accessToken) != 1 || len(s.refreshToken) != 1 || s.accessToken["other-at"] == nil {
+		t.Errorf("after delete: codes %v, access tokens %v, refresh tokens %v; want only other client's tokens",
+			s.code, s.accessToken, s.refreshToken)
+	}
+}
+
+func TestTokenLifetimes(t *testing.T) {
+	s := &idpServer{refreshTokenLifetime: time.Hour}
+	client := &funnelClient{TokenLifetime: 60}
+	for _, tt := range []struct {
+		ar                     *authRequest
+		wantToken, wantRefresh time.Duration
+	}{
+		{&authRequest{localRP: true}, 5 * time.Minute, time.Hour},
+		{&authRequest{funnelRP: client}, time.Minute, time.Hour},
+		{&authRequest{funnelRP: &funnelClient{RefreshTokenLifetime: 60}}, 5 * time.Minute, time.Minute},
+	} {
+		if token, refresh := s.tokenLifetimes(tt.ar); token != tt.wantToken || refresh != tt.wantRefresh {
+			t.Errorf("tokenLifetimes(%+v) = %v, %v; want %v, %v", tt.ar, token, refresh, tt.wantToken, tt.wantRefresh)
+		}
+	}
+
+	for in, want := range map[string]int64{"": 0, "90s": 90, "1h": 3600} {
+		if got, err := parseLifetime(in); err != nil || got != want {
+			t.Errorf("parseLifetime(%q) = %v, %v; want %v", in, got, err, want)
+		}
+		if got := formatLifetime(want); in != "" && got != (time.Duration(want)*time.Second).String() {
+			t.Errorf("formatLifetime(%d) = %q", want, got)
+		}
+	}
+	for _, in := range []string{"5", "-1m", "1ms"} {
+		if _, err := parseLifetime(in); err == nil {
+			t.Errorf("parseLifetime(%q) succeeded; want error", in)
+		}
+	}
+}
+
+func TestClaimRules(t *testing.T) {
+	who := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			Name: "test-node.test.ts.net.",
+			Tags: []string{"tag:server"},
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com", DisplayName: "Alice"},
+		CapMap: tailcfg.PeerCapMap{
+			tailcfg.PeerCapabilityTsIDP: {
+				`{"extraClaims":{"groups":["eng"]},"roles":"viewer"}`,
+				`{"extraClaims":{"groups":["grafana-admins"]},"clients":["grafana"],"roles":["editor","admin"]}`,
+			},
+			"example.com/cap/app": {`{"team":"infra"}`},
+		},
+	}
+	s := &idpServer{claimMappings: []claimMapping{
+		{Claim: "login", From: claimFromLogin},
+		{Claim: "node_tags", From: claimFromTags, Clients: []string{"grafana"}},
+		{Claim: "roles", From: claimFromCap, Field: "roles"},
+		{Claim: "team", From: claimFromCap, Capability: "example.com/cap/app", Field: "team"},
+		{Claim: "missing", From: claimFromCap, Field: "missing"},
+	}}
+	claims := func(clientID string) map[string]any {
+		t.Helper()
+		rules, err := s.claimRules(&authRequest{clientID: clientID, remoteUser: who})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := flattenExtraClaims(rules)
+		for _, v := range got {
+			if vs, ok := v.([]any); ok {
+				slices.SortFunc(vs, func(a, b any) int { return strings.Compare(a.(string), b.(string)) })
+			}
+		}
+		return got
+	}
+
+	if got, want := claims("other"), map[string]any{
+		"groups": []any{"eng"},
+		"login":  "alice@example.com",
+		"roles":  "viewer",
+		"team":   "infra",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("claims for other client = %v; want %v", got, want)
+	}
+	if got, want := claims("grafana"), map[string]any{
+		"groups":    []any{"eng", "grafana-admins"},
+		"login":     "alice@example.com",
+		"node_tags": []any{"tag:server"},
+		"roles":     []any{"admin", "editor", "viewer"},
+		"team":      "infra",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("claims for grafana = %v; want %v", got, want)
+	}
+}
+
+func TestLoadClaimMappings(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		config  string
+		wantErr bool
+	}{
+		{`{"claims":[{"claim":"groups","from":"cap","field":"groups"},{"claim":"login","from":"login","clients":["a"]}]}`, false},
+		{`{"claims":[{"claim":"email","from":"login"}]}`, true},
+		{`{"claims":[{"claim":"groups","from":"cap"}]}`, true},
+		{`{"claims":[{"claim":"groups","from":"groups"}]}`, true},
+		{`{"claims":[{"from":"login"}]}`, true},
+		{`{"claims":`, true},
+	} {
+		path := filepath.Join(dir, "claims.json")
+		if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadClaimMappings(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("loadClaimMappings(%s) error = %v; want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
@@ -107,23 +107,32 @@ func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 		redirectURI := strings.TrimSpace(r.FormValue("redirect_uri"))
 
 		baseData := clientDisplayData{
-			IsNew:       true,
-			Name:        name,
-			RedirectURI: redirectURI,
+			IsNew:                true,
+			Name:                 name,
+			RedirectURI:          redirectURI,
+			TokenLifetime:        strings.TrimSpace(r.FormValue("token_lifetime")),
+			RefreshTokenLifetime: strings.TrimSpace(r.FormValue("refresh_token_lifetime")),
 		}
 
 		if errMsg := validateRedirectURI(redirectURI); errMsg != "" {
 			s.renderFormError(w, baseData, errMsg)
 			return
 		}
+		tokenLifetime, refreshTokenLifetime, errMsg := validateLifetimes(baseData)
+		if errMsg != "" {
+			s.renderFormError(w, baseData, errMsg)
+			return
+		}
 
 		clientID := rands.HexString(32)
 		clientSecret := rands.HexString(64)
 		newClient := funnelClient{
-			ID:          clientID,
-			Secret:      clientSecret,
-			Name:        name,
-			RedirectURI: redirectURI,
+			ID:                   clientID,
+			Secret:               clientSecret,
+			Name:                 name,
+			RedirectURI:          redirectURI,
+			TokenLifetime:        tokenLifetime,
+			RefreshTokenLifetime: refreshTokenLifetime,
 		}
 
 		s.mu.Lock()
@@ -141,11 +150,13 @@ func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 		}
 
 		successData := clientDisplayData{
-			ID:          clientID,
-			Name:        name,
-			RedirectURI: redirectURI,
-			Secret:      clientSecret,
-			IsNew:       true,
+			ID:                   clientID,
+			Name:                 name,
+			RedirectURI:          redirectURI,
+			TokenLifetime:        baseData.TokenLifetime,
+			RefreshTokenLifetime: baseData.RefreshTokenLifetime,
+			Secret:               clientSecret,
+			IsNew:                true,
 		}
 		s.renderFormSuccess(w, successData, "Client created successfully! Save the client secret - it won't be shown again.")
 		return
@@ -231,15 +242,24 @@ func (s *idpServer) handleEditClient(w http.ResponseWriter, r *http.Request) {
 		name := strings.TrimSpace(r.FormValue("name"))
 		redirectURI := strings.TrimSpace(r.FormValue("redirect_uri"))
 		baseData := createEditBaseData(client, name, redirectURI)
+		baseData.TokenLifetime = strings.TrimSpace(r.FormValue("token_lifetime"))
+		baseData.RefreshTokenLifetime = strings.TrimSpace(r.FormValue("refresh_token_lifetime"))
 
 		if errMsg := validateRedirectURI(redirectURI); errMsg != "" {
 			s.renderFormError(w, baseData, errMsg)
 			return
 		}
+		tokenLifetime, refreshTokenLifetime, errMsg := validateLifetimes(baseData)
+		if errMsg != "" {
+			s.renderFormError(w, baseData, errMsg)
+			return
+		}
 
 		s.mu.Lock()
 		s.funnelClients[clientID].Name = name
 		s.funnelClients[clientID].RedirectURI = redirectURI
+		s.funnelClients[clientID].TokenLifetime = tokenLifetime
+		s.funnelClients[clientID].RefreshTokenLifetime = refreshTokenLifetime
 		err := s.storeFunnelClientsLocked()
 		s.mu.Unlock()
 
@@ -257,15 +277,17 @@ func (s *idpServer) handleEditClient(w http.ResponseWriter, r *http.Request) {
 }
 
 type clientDisplayData struct {
-	ID          string
-	Name        string
-	RedirectURI string
-	Secret      string
-	HasSecret   bool
-	IsNew       bool
-	IsEdit      bool
-	Success     string
-	Error       string
+	ID                   string
+	Name                 string
+	RedirectURI          string
+	TokenLifetime        string
+	RefreshTokenLifetime string
+	Secret               string
+	HasSecret            bool
+	IsNew                bool
+	IsEdit               bool
+	Success              string
+	Error                string
 }
 
 func (s *idpServer) renderClientForm(w http.ResponseWriter, data clientDisplayData) error {
@@ -295,12 +317,28 @@ func (s *idpServer) renderFormSuccess(w http.ResponseWriter, data clientDisplayD
 
 func createEditBaseData(client *funnelClient, name, redirectURI string) clientDisplayData {
 	return clientDisplayData{
-		ID:          client.ID,
-		Name:        name,
-		RedirectURI: redirectURI,
-		HasSecret:   client.Secret != "",
-		IsEdit:      true,
+		ID:                   client.ID,
+		Name:                 name,
+		RedirectURI:          redirectURI,
+		TokenLifetime:        formatLifetime(client.TokenLifetime),
+		RefreshTokenLifetime: formatLifetime(client.RefreshTokenLifetime),
+		HasSecret:            client.Secret != "",
+		IsEdit:               true,
+	}
+}
+
+// validateLifetimes parses the token lifetimes of data, returning an error
+// message if they are invalid.
+func validateLifetimes(data clientDisplayData) (tokenLifetime, refreshTokenLifetime int64, errMsg string) {
+	tokenLifetime, err := parseLifetime(data.TokenLifetime)
+	if err != nil {
+		return 0, 0, "Invalid token lifetime: " + err.Error()
+	}
+	refreshTokenLifetime, err = parseLifetime(data.RefreshTokenLifetime)
+	if err != nil {
+		return 0, 0, "Invalid refresh token lifetime: " + err.Error()
 	}
+	return tokenLifetime, refreshTokenLifetime, ""
 }
 
 func validateRedirectURI(redirectURI string) string {
@@ -0,0 +1,188 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// Protocols of a listener.
+const (
+	protoTCP   = "tcp"   // TCP, forwarded as is
+	protoTLS   = "tls"   // TLS with the node's certificate, forwarded as TCP
+	protoHTTP  = "http"  // HTTP, reverse proxied
+	protoHTTPS = "https" // HTTPS with the node's certificate, reverse proxied
+)
+
+// config is the format of the file named by --config.
+type config struct {
+	Listeners []*listenerConfig `json:"listeners"`
+}
+
+// listenerConfig is a port tsproxy listens on, and the upstream it proxies
+// the connections it accepts to.
+type listenerConfig struct {
+	// Port is the port to listen on.
+	Port uint16 `json:"port"`
+
+	// Protocol is one of "tcp", "tls", "http" or "https". For "tls" and
+	// "https", TLS is terminated with the node's certificate for its
+	// MagicDNS name, so HTTPS must be enabled in the tailnet.
+	Protocol string `json:"protocol"`
+
+	// Upstream is where connections are proxied to: a host:port for
+	// "tcp" and "tls", or an http or https URL for "http" and "https".
+	Upstream string `json:"upstream"`
+
+	// UpstreamTLS is whether "tcp" and "tls" listeners connect to
+	// Upstream with TLS. The upstream's certificate is verified against
+	// the system roots, or those in UpstreamCAFile if set.
+	UpstreamTLS    bool   `json:"upstream_tls,omitempty"`
+	UpstreamCAFile string `json:"upstream_ca_file,omitempty"`
+
+	// Allow are the tailnet clients allowed to connect.
+	Allow allowConfig `json:"allow,omitzero"`
+
+	upstreamURL    *url.URL    // for "http" and "https"
+	upstreamTLSCfg *tls.Config // if UpstreamTLS
+}
+
+// allowConfig lists the tailnet clients allowed to connect to a listener.
+// A client is allowed if it matches any of the lists, or, if all are empty,
+// if it is any tailnet peer.
+type allowConfig struct {
+	// Users are the login names of users whose (untagged) nodes are
+	// allowed.
+	Users []string `json:"users,omitempty"`
+
+	// Tags are the ACL tags of the tagged nodes that are allowed.
+	Tags []string `json:"tags,omitempty"`
+
+	// Caps are peer capabilities, granted to this node in the tailnet
+	// policy file, of which the clients granted any are allowed.
+	Caps []tailcfg.PeerCapability `json:"caps,omitempty"`
+}
+
+// loadConfig reads and checks the config file at path.
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := new(config)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(c.Listeners) == 0 {
+		return nil, fmt.Errorf("%s: no listeners", path)
+	}
+	ports := make(map[uint16]bool)
+	for _, l := range c.Listeners {
+		if ports[l.Port] {
+			return nil, fmt.Errorf("%s: port %d: listened on more than once", path, l.Port)
+		}
+		ports[l.Port] = true
+		if err := l.init(); err != nil {
+			return nil, fmt.Errorf("%s: port %d: %w", path, l.Port, err)
+		}
+	}
+	return c, nil
+}
+
+// init checks l, and sets its unexported fields.
+func (l *listenerConfig) init() error {
+	if l.Port == 0 {
+		return errors.New("missing port")
+	}
+	if l.Upstream == "" {
+		return errors.New("missing upstream")
+	}
+	switch l.Protocol {
+	case protoTCP, protoTLS:
+		if _, _, err := net.SplitHostPort(l.Upstream); err != nil {
+			return fmt.Errorf("upstream: %w", err)
+		}
+	case protoHTTP, protoHTTPS:
+		u, err := url.Parse(l.Upstream)
+		if err != nil {
+			return fmt.Errorf("upstream: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("upstream %q: must be an http or https URL", l.Upstream)
+		}
+		if l.UpstreamTLS || l.UpstreamCAFile != "" {
+			return errors.New("upstream_tls and upstream_ca_file are for tcp and tls listeners; use an https upstream")
+		}
+		l.upstreamURL = u
+	default:
+		return fmt.Errorf("unknown protocol %q", l.Protocol)
+	}
+	for _, tag := range l.Allow.Tags {
+		if !strings.HasPrefix(tag, "tag:") {
+			return fmt.Errorf("tag %q must start with \"tag:\"", tag)
+		}
+	}
+
+	if l.UpstreamCAFile != "" && !l.UpstreamTLS {
+		return errors.New("upstream_ca_file requires upstream_tls")
+	}
+	if l.UpstreamTLS {
+		host, _, _ := net.SplitHostPort(l.Upstream)
+		l.upstreamTLSCfg = &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		}
+		if l.UpstreamCAFile != "" {
+			bs, err := os.ReadFile(l.UpstreamCAFile)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(bs) {
+				return fmt.Errorf("invalid CA cert in %q", l.UpstreamCAFile)
+			}
+			l.upstreamTLSCfg.RootCAs = pool
+		}
+	}
+	return nil
+}
+
+// allows reports whether a allows the client who.
+func (a *allowConfig) allows(who *apitype.WhoIsResponse) bool {
+	if who.Node == nil {
+		return false
+	}
+	if len(a.Users) == 0 && len(a.Tags) == 0 && len(a.Caps) == 0 {
+		return true
+	}
+	if who.Node.IsTagged() {
+		for _, tag := range who.Node.Tags {
+			if slices.Contains(a.Tags, tag) {
+				return true
+			}
+		}
+	} else if who.UserProfile != nil && slices.ContainsFunc(a.Users, func(u string) bool {
+		return strings.EqualFold(u, who.UserProfile.LoginName)
+	}) {
+		return true
+	}
+	for _, c := range a.Caps {
+		if who.CapMap.HasCapability(c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tsproxy server is an identity-aware proxy for TCP, TLS and HTTP
+// services. It joins the tailnet with tsnet, and proxies the connections it
+// accepts on each of its configured ports to an upstream, allowing only the
+// tailnet users, tags and holders of peer capabilities configured for the
+// port. HTTP requests are sent upstream with Tailscale-User-* headers
+// identifying the user who made them.
+//
+// Set the TS_AUTHKEY environment variable to have this server automatically
+// join your tailnet, or look for the logged auth link on first start.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tsnet"
+	"tailscale.com/util/ctxkey"
+)
+
+var (
+	hostname     = flag.String("hostname", "", "Tailscale hostname to serve on")
+	configPath   = flag.String("config", "", "JSON file of the ports to listen on and the upstreams to proxy them to")
+	tailscaleDir = flag.String("state-dir", "", "Directory in which to store the Tailscale auth state")
+	loginServer  = flag.String("login-server", "", "URL to alternative control server. If empty, the default Tailscale control is used.")
+)
+
+func main() {
+	flag.Parse()
+	if *hostname == "" {
+		log.Fatal("missing --hostname")
+	}
+	if *configPath == "" {
+		log.Fatal("missing --config")
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts := &tsnet.Server{
+		Dir:        *tailscaleDir,
+		Hostname:   *hostname,
+		ControlURL: *loginServer,
+	}
+	defer ts.Close()
+	lc, err := ts.LocalClient()
+	if err != nil {
+		log.Fatalf("getting tsnet API client: %v", err)
+	}
+
+	p := &proxy{whoIs: lc.WhoIs}
+	errc := make(chan error, len(cfg.Listeners))
+	for _, l := range cfg.Listeners {
+		ln, err := ts.Listen("tcp", fmt.Sprintf(":%d", l.Port))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if l.Protocol == protoTLS || l.Protocol == protoHTTPS {
+			ln = tls.NewListener(ln, &tls.Config{
+				GetCertificate: lc.GetCertificate,
+			})
+		}
+		log.Printf("proxying %s on port %d to %s", l.Protocol, l.Port, l.Upstream)
+		go func() {
+			errc <- fmt.Errorf("port %d: %w", l.Port, p.serve(ln, l))
+		}()
+	}
+	log.Fatal(<-errc)
+}
+
+// proxy proxies the connections accepted by listeners to their upstreams.
+type proxy struct {
+	// whoIs looks up the tailnet identity of a client by its remote
+	// address.
+	whoIs func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+
+	// dialer dials upstreams.
+	dialer net.Dialer
+}
+
+// serve accepts connections on ln and proxies them as configured by l.
+func (p *proxy) serve(ln net.Listener, l *listenerConfig) error {
+	if l.Protocol == protoHTTP || l.Protocol == protoHTTPS {
+		return http.Serve(ln, p.httpHandler(l))
+	}
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := p.serveTCP(c, l); err != nil {
+				log.Printf("port %d: %s: %v", l.Port, c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// authorize returns the identity of the client at remoteAddr, or an error
+// if it is not allowed to connect to l.
+func (p *proxy) authorize(ctx context.Context, l *listenerConfig, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	who, err := p.whoIs(ctx, remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("getting client identity: %w", err)
+	}
+	if !l.Allow.allows(who) {
+		return nil, fmt.Errorf("%s is not allowed", identity(who))
+	}
+	return who, nil
+}
+
+// identity returns a description of the client who for logs: its login
+// name, or its tags if tagged, and its node name.
+func identity(who *apitype.WhoIsResponse) string {
+	if who.Node == nil {
+		return "unknown client"
+	}
+	user := "unknown user"
+	if who.Node.IsTagged() {
+		user = strings.Join(who.Node.Tags, ",")
+	} else if who.UserProfile != nil {
+		user = who.UserProfile.LoginName
+	}
+	return fmt.Sprintf("%s (machine %s)", user, strings.TrimSuffix(who.Node.Name, "."))
+}
+
+// serveTCP proxies the client connection c to the upstream of l.
+func (p *proxy) serveTCP(c net.Conn, l *listenerConfig) error {
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	who, err := p.authorize(ctx, l, c.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+
+	upc, err := p.dialer.DialContext(ctx, "tcp", l.Upstream)
+	if err != nil {
+		return fmt.Errorf("upstream dial: %w", err)
+	}
+	defer upc.Close()
+	if l.upstreamTLSCfg != nil {
+		tc := tls.Client(upc, l.upstreamTLSCfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("upstream TLS handshake: %w", err)
+		}
+		upc = tc
+	}
+
+	log.Printf("port %d: session start, from %s, %s", l.Port, c.RemoteAddr(), identity(who))
+	start := time.Now()
+	defer func() {
+		log.Printf("port %d: session end, from %s, lasted %s", l.Port, c.RemoteAddr(), time.Since(start).Round(time.Millisecond))
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(upc, c)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(c, upc)
+		errc <- err
+	}()
+	if err := <-errc; err != nil {
+		return fmt.Errorf("session terminated with error: %w", err)
+	}
+	return nil
+}
+
+// whoKey is the context key of the identity of the client of an HTTP request.
+var whoKey = ctxkey.New[*apitype.WhoIsResponse]("tsproxy.who", nil)
+
+// httpHandler returns the handler reverse proxying the HTTP requests of the
+// clients of l to its upstream.
+func (p *proxy) httpHandler(l *listenerConfig) http.Handler {
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(l.upstreamURL)
+			r.SetXForwarded()
+			setIdentityHeaders(r.Out.Header, whoKey.Value(r.In.Context()))
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, err := p.authorize(r.Context(), l, r.RemoteAddr)
+		if err != nil {
+			log.Printf("port %d: %s: %v", l.Port, r.RemoteAddr, err)
+			http.Error(w, "tsproxy: access denied", http.StatusForbidden)
+			return
+		}
+		rp.ServeHTTP(w, r.WithContext(whoKey.WithValue(r.Context(), who)))
+	})
+}
+
+// setIdentityHeaders replaces the Tailscale-User-* headers in h with ones
+// identifying the user of the client who, like those of Tailscale Serve.
+// They are not set for tagged nodes.
+func setIdentityHeaders(h http.Header, who *apitype.WhoIsResponse) {
+	// Clear any incoming values squatting in the headers.
+	h.Del("Tailscale-User-Login")
+	h.Del("Tailscale-User-Name")
+	h.Del("Tailscale-User-Profile-Pic")
+
+	if who == nil || who.Node == nil || who.Node.IsTagged() || who.UserProfile == nil {
+		return
+	}
+	h.Set("Tailscale-User-Login", encHeaderValue(who.UserProfile.LoginName))
+	h.Set("Tailscale-User-Name", encHeaderValue(who.UserProfile.DisplayName))
+	h.Set("Tailscale-User-Profile-Pic", who.UserProfile.ProfilePicURL)
+}
+
+// encHeaderValue encodes v as Tailscale Serve does for its identity headers:
+// with RFC 2047 Q-encoding if it is not ASCII.
+func encHeaderValue(v string) string {
+	if !utf8.ValidString(v) {
+		return ""
+	}
+	return mime.QEncoding.Encode("utf-8", v)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+var (
+	alice = &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com", DisplayName: "Alice Ñ"},
+	}
+	bob = &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "desktop.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "bob@example.com"},
+		CapMap:      tailcfg.PeerCapMap{"example.com/cap/redis": nil},
+	}
+	ci = &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "ci.example.ts.net.", Tags: []string{"tag:ci"}},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+)
+
+// fakeWhoIs returns a whoIs func for a proxy that identifies every client
+// as the current value of who, or fails if it is nil.
+func fakeWhoIs(who *atomic.Pointer[apitype.WhoIsResponse]) func(context.Context, string) (*apitype.WhoIsResponse, error) {
+	return func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		w := who.Load()
+		if w == nil {
+			return nil, errors.New("not a peer")
+		}
+		return w, nil
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	for _, tt := range []struct {
+		config  string
+		wantErr bool
+	}{
+		{`{"listeners":[
+			{"port":6379,"protocol":"tcp","upstream":"redis:6379","allow":{"caps":["example.com/cap/redis"]}},
+			{"port":443,"protocol":"https","upstream":"http://localhost:8080","allow":{"users":["alice@example.com"],"tags":["tag:ci"]}},
+			{"port":3306,"protocol":"tls","upstream":"mysql:3306","upstream_tls":true}
+		]}`, false},
+		{`{"listeners":[]}`, true},
+		{`{"listeners":[{"port":80,"protocol":"http","upstream":"localhost:8080"}]}`, true},
+		{`{"listeners":[{"port":80,"protocol":"udp","upstream":"localhost:53"}]}`, true},
+		{`{"listeners":[{"port":22,"protocol":"tcp","upstream":"localhost"}]}`, true},
+		{`{"listeners":[{"protocol":"tcp","upstream":"localhost:22"}]}`, true},
+		{`{"listeners":[{"port":22,"protocol":"tcp","upstream":"localhost:22","allow":{"tags":["ci"]}}]}`, true},
+		{`{"listeners":[{"port":22,"protocol":"tcp","upstream":"localhost:22","upstream_ca_file":"ca.pem"}]}`, true},
+		{`{"listeners":[{"port":22,"protocol":"tcp","upstream":"a:22"},{"port":22,"protocol":"tcp","upstream":"b:22"}]}`, true},
+	} {
+		if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("loadConfig(%s) error = %v; want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	a := allowConfig{
+		Users: []string{"Alice@example.com"},
+		Tags:  []string{"tag:ci"},
+		Caps:  []tailcfg.PeerCapability{"example.com/cap/redis"},
+	}
+	for _, who := range []*apitype.WhoIsResponse{alice, bob, ci} {
+		if !a.allows(who) {
+			t.Errorf("allows(%s) = false; want true", identity(who))
+		}
+		if !(&allowConfig{}).allows(who) {
+			t.Errorf("empty allows(%s) = false; want true", identity(who))
+		}
+	}
+	if (&allowConfig{Users: []string{"bob@example.com"}}).allows(alice) {
+		t.Error("allows alice with only bob allowed")
+	}
+	if (&allowConfig{Users: []string{"tagged-devices"}}).allows(ci) {
+		t.Error("allows tagged node by its user")
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	var gotHeader http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Clone()
+		io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	l := &listenerConfig{
+		Port:     80,
+		Protocol: protoHTTP,
+		Upstream: upstream.URL,
+		Allow:    allowConfig{Users: []string{"alice@example.com"}, Tags: []string{"tag:ci"}},
+	}
+	if err := l.init(); err != nil {
+		t.Fatal(err)
+	}
+	var who atomic.Pointer[apitype.WhoIsResponse]
+	h := (&proxy{whoIs: fakeWhoIs(&who)}).httpHandler(l)
+	get := func() *httptest.ResponseRecorder {
+		gotHeader = nil
+		req := httptest.NewRequest("GET", "/path", nil)
+		req.Header.Set("Tailscale-User-Login", "mallory@example.com")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	who.Store(alice)
+	rec := get()
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello from /path" {
+		t.Fatalf("alice got %d %q; want 200", rec.Code, rec.Body.String())
+	}
+	if got := gotHeader.Get("Tailscale-User-Login"); got != "alice@example.com" {
+		t.Errorf("Tailscale-User-Login = %q; want alice@example.com", got)
+	}
+	if got, want := gotHeader.Get("Tailscale-User-Name"), "=?utf-8?q?Alice_=C3=91?="; got != want {
+		t.Errorf("Tailscale-User-Name = %q; want %q", got, want)
+	}
+	if gotHeader.Get("X-Forwarded-For") == "" {
+		t.Error("X-Forwarded-For not set")
+	}
+
+	who.Store(ci)
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Fatalf("tag:ci got %d; want 200", rec.Code)
+	}
+	if got := gotHeader.Get("Tailscale-User-Login"); got != "" {
+		t.Errorf("Tailscale-User-Login for tagged node = %q; want it removed", got)
+	}
+
+	for _, w := range []*apitype.WhoIsResponse{bob, nil} {
+		who.Store(w)
+		if rec := get(); rec.Code != http.StatusForbidden || gotHeader != nil {
+			t.Errorf("got %d; want 403 and no upstream request", rec.Code)
+		}
+	}
+}
+
+func TestServeTCP(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			c, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	l := &listenerConfig{
+		Port:     6379,
+		Protocol: protoTCP,
+		Upstream: upstream.Addr().String(),
+		Allow:    allowConfig{Caps: []tailcfg.PeerCapability{"example.com/cap/redis"}},
+	}
+	if err := l.init(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var who atomic.Pointer[apitype.WhoIsResponse]
+	go (&proxy{whoIs: fakeWhoIs(&who)}).serve(ln, l)
+
+	echo := func() (string, error) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err := io.WriteString(c, "PING"); err != nil {
+			return "", err
+		}
+		b := make([]byte, 4)
+		_, err = io.ReadFull(c, b)
+		return string(b), err
+	}
+
+	who.Store(bob)
+	if got, err := echo(); err != nil || got != "PING" {
+		t.Errorf("bob got %q, %v; want PING", got, err)
+	}
+	who.Store(alice)
+	if got, err := echo(); err == nil {
+		t.Errorf("alice got %q; want connection closed", got)
+	}
+}
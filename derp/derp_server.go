@@ -168,6 +168,8 @@ type Server struct {
 	multiForwarderDeleted      expvar.Int
 	removePktForwardOther      expvar.Int
 	sclientWriteTimeouts       expvar.Int
+	packetsThrottled           expvar.Int       // packets delayed by a client's bandwidth limit
+	bytesThrottled             expvar.Int       // bytes of packets delayed by a client's bandwidth limit
 	avgQueueDuration           *uint64          // In milliseconds; accessed atomically
 	tcpRtt                     metrics.LabelMap // histogram
 	meshUpdateBatchSize        *metrics.Histogram
@@ -179,8 +181,10 @@ type Server struct {
 	// running tailscaled's client's LocalAPI.
 	verifyClientsLocalTailscaled bool
 
-	verifyClientsURL         string
-	verifyClientsURLFailOpen bool
+	// verifyClientsURL and verifyClientsURLFailOpen may be changed while
+	// serving, via SetVerifyClientURL and SetVerifyClientURLFailOpen.
+	verifyClientsURL         syncs.AtomicValue[string]
+	verifyClientsURLFailOpen atomic.Bool
 
 	mu       sync.Mutex
 	closed   bool
@@ -202,6 +206,11 @@ type Server struct {
 	// maps from netip.AddrPort to a client's public key
 	keyOfAddr map[netip.AddrPort]key.NodePublic
 
+	// bwLimits are the per-client bandwidth limits; see SetBandwidthLimits.
+	bwLimits BandwidthLimits
+	// fairQueuing mirrors bwLimits.FairQueuing, for reading without mu.
+	fairQueuing atomic.Bool
+
 	// Sets the client send queue depth for the server.
 	perClientSendQueueDepth int
 
@@ -443,6 +452,7 @@ func genDroppedCounters() {
 		dropReasonQueueTail,
 		dropReasonWriteError,
 		dropReasonDupClient,
+		dropReasonFairQueue,
 	}
 
 	for _, dr := range dropReasons {
@@ -483,14 +493,18 @@ func (s *Server) SetVerifyClient(v bool) {
 // SetVerifyClientURL sets the admission controller URL to use for verifying clients.
 // If empty, all clients are accepted (unless restricted by SetVerifyClient checking
 // against tailscaled).
+//
+// It may be called at any time; it affects subsequently connecting clients.
 func (s *Server) SetVerifyClientURL(v string) {
-	s.verifyClientsURL = v
+	s.verifyClientsURL.Store(v)
 }
 
 // SetVerifyClientURLFailOpen sets whether to allow clients to connect if the
 // admission controller URL is unreachable.
+//
+// It may be called at any time; it affects subsequently connecting clients.
 func (s *Server) SetVerifyClientURLFailOpen(v bool) {
-	s.verifyClientsURLFailOpen = v
+	s.verifyClientsURLFailOpen.Store(v)
 }
 
 // SetTailscaledSocketPath sets the unix socket path to use to talk to
@@ -713,6 +727,7 @@ func (s *Server) registerClient(c *sclient) {
 		s.clientsMesh[c.key] = nil // just for varz of total users in cluster
 	}
 	s.keyOfAddr[c.remoteIPPort] = c.key
+	s.setClientBandwidthLimitLocked(c)
 	s.curClients.Add(1)
 	if c.isNotIdealConn {
 		s.curClientsNotIdeal.Add(1)
@@ -1186,6 +1201,9 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %v: recvPacket: %v", c.key, err)
 	}
+	if !c.waitForBandwidth(len(contents)) {
+		return nil
+	}
 
 	var fwd PacketForwarder
 	var dstLen int
@@ -1254,6 +1272,7 @@ type dropReasonKindLabels struct {
 	dropReasonQueueTail        dropReason = "queue_tail"          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError       dropReason = "write_error"         // OS write() failed
 	dropReasonDupClient        dropReason = "dup_client"          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonFairQueue        dropReason = "fair_queue"          // destination queue is full and the source has more than its share of it
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1290,6 +1309,8 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 	sendQueue := dst.sendQueue
 	if disco.LooksLikeDiscoWrapper(p.bs) {
 		sendQueue = dst.discoSendQueue
+	} else if s.fairQueuing.Load() {
+		p.fairQueued = true
 	}
 	for attempt := 0; attempt < 3; attempt++ {
 		select {
@@ -1299,15 +1320,29 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 			return nil
 		default:
 		}
+		if p.fairQueued {
+			// Count the packet before it becomes visible to
+			// dst's sendLoop, which uncounts it.
+			dst.fq.add(p.src)
+		}
 		select {
 		case sendQueue <- p:
 			dst.debugLogf("sendPkt attempt %d enqueued", attempt)
 			return nil
 		default:
 		}
+		if p.fairQueued {
+			dst.fq.remove(p.src)
+			if dst.fq.overShare(p.src, cap(sendQueue)) {
+				s.recordDrop(p.bs, c.key, dstKey, dropReasonFairQueue)
+				dst.debugLogf("sendPkt attempt %d dropped, source over its fair share", attempt)
+				return nil
+			}
+		}
 
 		select {
 		case pkt := <-sendQueue:
+			dst.dequeued(pkt)
 			s.recordDrop(pkt.bs, c.key, dstKey, dropReasonQueueHead)
 			c.recordQueueTime(pkt.enqueuedAt)
 		default:
@@ -1397,7 +1432,7 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 	}
 
 	// admission controller-based verification:
-	if s.verifyClientsURL != "" {
+	if verifyURL := s.verifyClientsURL.Load(); verifyURL != "" {
 		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
@@ -1408,13 +1443,13 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 		if err != nil {
 			return err
 		}
-		req, err := http.NewRequestWithContext(ctx, "POST", s.verifyClientsURL, bytes.NewReader(jreq))
+		req, err := http.NewRequestWithContext(ctx, "POST", verifyURL, bytes.NewReader(jreq))
 		if err != nil {
 			return err
 		}
 		res, err := http.DefaultClient.Do(req)
 		if err != nil {
-			if s.verifyClientsURLFailOpen {
+			if s.verifyClientsURLFailOpen.Load() {
 				s.logf("admission controller unreachable; allowing client %v", clientKey)
 				return nil
 			}
@@ -1665,6 +1700,14 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// bwLim is the client's bandwidth limit, replaced by
+	// Server.SetBandwidthLimits.
+	bwLim bandwidthLimiter
+
+	// fq counts the packets in sendQueue per source, when fair queuing
+	// is enabled.
+	fq fairQueue
 }
 
 func (c *sclient) presentFlags() PeerPresentFlags {
@@ -1705,6 +1748,10 @@ type pkt struct {
 
 	// src is the who's the sender of the packet.
 	src key.NodePublic
+
+	// fairQueued is whether the packet is counted in its destination's
+	// fairQueue, and must be uncounted when dequeued.
+	fairQueued bool
 }
 
 // peerGoneMsg is a request to write a peerGone frame to an sclient
@@ -1775,6 +1822,7 @@ func (c *sclient) onSendLoopDone() {
 	for {
 		select {
 		case pkt := <-c.sendQueue:
+			c.dequeued(pkt)
 			c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
 		case pkt := <-c.discoSendQueue:
 			c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
@@ -1785,6 +1833,13 @@ func (c *sclient) onSendLoopDone() {
 
 }
 
+// dequeued is called when p is removed from c.sendQueue.
+func (c *sclient) dequeued(p pkt) {
+	if p.fairQueued {
+		c.fq.remove(p.src)
+	}
+}
+
 func (c *sclient) sendLoop(ctx context.Context) error {
 	defer c.onSendLoopDone()
 
@@ -1811,6 +1866,7 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 			werr = c.sendMeshUpdates()
 			continue
 		case msg := <-c.sendQueue:
+			c.dequeued(msg)
 			werr = c.sendPacket(msg.src, msg.bs)
 			c.recordQueueTime(msg.enqueuedAt)
 			continue
@@ -1845,6 +1901,7 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		case <-c.meshUpdate:
 			werr = c.sendMeshUpdates()
 		case msg := <-c.sendQueue:
+			c.dequeued(msg)
 			werr = c.sendPacket(msg.src, msg.bs)
 			c.recordQueueTime(msg.enqueuedAt)
 		case msg := <-c.discoSendQueue:
@@ -2220,6 +2277,8 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("multiforwarder_deleted", &s.multiForwarderDeleted)
 	m.Set("packet_forwarder_delete_other_value", &s.removePktForwardOther)
 	m.Set("sclient_write_timeouts", &s.sclientWriteTimeouts)
+	m.Set("packets_throttled", &s.packetsThrottled)
+	m.Set("bytes_throttled", &s.bytesThrottled)
 	m.Set("average_queue_duration_ms", expvar.Func(func() any {
 		return math.Float64frombits(atomic.LoadUint64(s.avgQueueDuration))
 	}))
@@ -0,0 +1,183 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/types/key"
+)
+
+// BandwidthLimit is a token bucket limit on the rate at which a client may
+// send packets through a [Server].
+type BandwidthLimit struct {
+	// BytesPerSecond is the sustained rate at which the client may send
+	// packet payload bytes. Zero means no limit.
+	BytesPerSecond int64 `json:",omitempty"`
+
+	// Burst is the size of the token bucket, in bytes. Zero means one
+	// second's worth of BytesPerSecond. Values smaller than MaxPacketSize are
+	// raised to MaxPacketSize, so that any packet can eventually be sent.
+	Burst int64 `json:",omitempty"`
+}
+
+// IsZero reports whether l is the zero value, meaning no limit.
+func (l BandwidthLimit) IsZero() bool {
+	return l.BytesPerSecond == 0
+}
+
+// burst returns the effective token bucket size of l.
+func (l BandwidthLimit) burst() int {
+	b := l.Burst
+	if b == 0 {
+		b = l.BytesPerSecond
+	}
+	return int(max(b, MaxPacketSize))
+}
+
+// newLimiter returns a new rate limiter enforcing l, or nil if l has no
+// limit.
+func (l BandwidthLimit) newLimiter() *rate.Limiter {
+	if l.IsZero() {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(l.BytesPerSecond), l.burst())
+}
+
+// BandwidthLimits configures the per-client bandwidth shaping of a
+// [Server].
+//
+// Clients that exceed their limit are not disconnected and their packets
+// are not dropped; instead, the server stops reading from them until they
+// are back within their limit, pushing back on the sender via TCP flow
+// control. Mesh peers are never limited.
+type BandwidthLimits struct {
+	// Default is the limit of clients without an entry in PerNode.
+	Default BandwidthLimit
+
+	// PerNode overrides Default for specific node keys. An entry with a
+	// zero BytesPerSecond exempts that node from limiting.
+	PerNode map[key.NodePublic]BandwidthLimit `json:",omitempty"`
+
+	// FairQueuing, if true, makes a client whose send queue is full drop
+	// the incoming packet when its source already occupies more than an
+	// equal share of the queue, rather than evicting the oldest queued
+	// packet, which is likely to be from a lighter sender.
+	FairQueuing bool `json:",omitempty"`
+}
+
+// limitFor returns the limit that applies to k.
+func (l *BandwidthLimits) limitFor(k key.NodePublic) BandwidthLimit {
+	if nl, ok := l.PerNode[k]; ok {
+		return nl
+	}
+	return l.Default
+}
+
+// SetBandwidthLimits sets the per-client bandwidth limits of the server.
+//
+// It may be called at any time. New limits apply immediately to connected
+// clients, with full token buckets.
+func (s *Server) SetBandwidthLimits(l BandwidthLimits) {
+	l.PerNode = maps.Clone(l.PerNode)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bwLimits = l
+	s.fairQueuing.Store(l.FairQueuing)
+	for _, cs := range s.clients {
+		cs.ForeachClient(s.setClientBandwidthLimitLocked)
+	}
+}
+
+// setClientBandwidthLimitLocked updates c's token bucket from s.bwLimits.
+//
+// s.mu must be held.
+func (s *Server) setClientBandwidthLimitLocked(c *sclient) {
+	if c.canMesh {
+		return
+	}
+	c.bwLim.set(s.bwLimits.limitFor(c.key))
+}
+
+// bandwidthLimiter is a client's token bucket, safe for concurrent
+// replacement. The zero value is unlimited.
+type bandwidthLimiter struct {
+	lim atomic.Pointer[rate.Limiter] // nil if unlimited
+}
+
+func (b *bandwidthLimiter) set(l BandwidthLimit) {
+	b.lim.Store(l.newLimiter())
+}
+
+// waitForBandwidth blocks until c's bandwidth limit, if any, permits it to
+// send n more bytes. It reports false if c's connection closed while
+// waiting.
+func (c *sclient) waitForBandwidth(n int) bool {
+	lim := c.bwLim.lim.Load()
+	if lim == nil {
+		return true
+	}
+	now := c.s.clock.Now()
+	r := lim.ReserveN(now, n)
+	if !r.OK() {
+		// Unreachable: bursts are at least MaxPacketSize.
+		return true
+	}
+	d := r.DelayFrom(now)
+	if d <= 0 {
+		return true
+	}
+	c.s.packetsThrottled.Add(1)
+	c.s.bytesThrottled.Add(int64(n))
+	t, tc := c.s.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-tc:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// fairQueue tracks how many packets each source has in a client's send
+// queue, for [BandwidthLimits.FairQueuing].
+type fairQueue struct {
+	mu     sync.Mutex
+	queued map[key.NodePublic]int
+}
+
+// overShare reports whether src has more than an equal share of a queue of
+// the given depth, among the sources currently in it.
+func (q *fairQueue) overShare(src key.NodePublic, depth int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := q.queued[src]
+	if n == 0 {
+		return false
+	}
+	return n > depth/len(q.queued)
+}
+
+func (q *fairQueue) add(src key.NodePublic) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued == nil {
+		q.queued = make(map[key.NodePublic]int)
+	}
+	q.queued[src]++
+}
+
+func (q *fairQueue) remove(src key.NodePublic) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued[src] <= 1 {
+		delete(q.queued, src)
+	} else {
+		q.queued[src]--
+	}
+}
@@ -1759,3 +1759,154 @@ func TestIsMeshPeer(t *testing.T) {
 		})
 	}
 }
+
+func TestBandwidthLimitBurst(t *testing.T) {
+	tests := []struct {
+		lim  BandwidthLimit
+		want int
+	}{
+		{BandwidthLimit{BytesPerSecond: 1 << 20}, 1 << 20},
+		{BandwidthLimit{BytesPerSecond: 1 << 10}, MaxPacketSize},
+		{BandwidthLimit{BytesPerSecond: 1 << 20, Burst: 4 << 20}, 4 << 20},
+		{BandwidthLimit{BytesPerSecond: 1 << 20, Burst: 1}, MaxPacketSize},
+	}
+	for _, tt := range tests {
+		if got := tt.lim.burst(); got != tt.want {
+			t.Errorf("%+v.burst() = %v; want %v", tt.lim, got, tt.want)
+		}
+	}
+}
+
+func TestSetBandwidthLimits(t *testing.T) {
+	s := NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+
+	regular := &sclient{key: pubAll(1)}
+	exempt := &sclient{key: pubAll(2)}
+	mesh := &sclient{key: pubAll(3), canMesh: true}
+	for _, c := range []*sclient{regular, exempt, mesh} {
+		cs := &clientSet{}
+		cs.activeClient.Store(c)
+		s.clients[c.key] = cs
+	}
+
+	s.SetBandwidthLimits(BandwidthLimits{
+		Default: BandwidthLimit{BytesPerSecond: 1 << 20},
+		PerNode: map[key.NodePublic]BandwidthLimit{
+			exempt.key: {},
+		},
+	})
+	if regular.bwLim.lim.Load() == nil {
+		t.Errorf("regular client is not limited")
+	}
+	if exempt.bwLim.lim.Load() != nil {
+		t.Errorf("exempt client is limited")
+	}
+	if mesh.bwLim.lim.Load() != nil {
+		t.Errorf("mesh peer is limited")
+	}
+
+	s.SetBandwidthLimits(BandwidthLimits{})
+	if regular.bwLim.lim.Load() != nil {
+		t.Errorf("regular client is still limited after removing limits")
+	}
+}
+
+func TestWaitForBandwidth(t *testing.T) {
+	s := NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+
+	done := make(chan struct{})
+	c := &sclient{s: s, key: pubAll(1), done: done}
+	c.bwLim.set(BandwidthLimit{BytesPerSecond: 10 * MaxPacketSize, Burst: MaxPacketSize})
+
+	// The first packet fits in the burst.
+	if !c.waitForBandwidth(MaxPacketSize) {
+		t.Fatal("waitForBandwidth failed")
+	}
+	if got := s.packetsThrottled.Value(); got != 0 {
+		t.Fatalf("packetsThrottled = %d; want 0", got)
+	}
+
+	// The second one has to wait for about a tenth of a second.
+	start := time.Now()
+	if !c.waitForBandwidth(MaxPacketSize) {
+		t.Fatal("waitForBandwidth failed")
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("waitForBandwidth returned after %v; want it to wait", d)
+	}
+	if got := s.packetsThrottled.Value(); got != 1 {
+		t.Errorf("packetsThrottled = %d; want 1", got)
+	}
+	if got := s.bytesThrottled.Value(); got != MaxPacketSize {
+		t.Errorf("bytesThrottled = %d; want %d", got, MaxPacketSize)
+	}
+
+	// Waiting is abandoned once the client is gone.
+	c.bwLim.set(BandwidthLimit{BytesPerSecond: 1})
+	c.waitForBandwidth(MaxPacketSize)
+	close(done)
+	if c.waitForBandwidth(MaxPacketSize) {
+		t.Error("waitForBandwidth succeeded for closed client")
+	}
+}
+
+func TestSendPktFairQueuing(t *testing.T) {
+	s := NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	s.SetBandwidthLimits(BandwidthLimits{FairQueuing: true})
+
+	const depth = 4
+	dst := &sclient{
+		s:              s,
+		key:            pubAll(1),
+		logf:           logger.Discard,
+		done:           make(chan struct{}),
+		sendQueue:      make(chan pkt, depth),
+		discoSendQueue: make(chan pkt, depth),
+	}
+	heavy := &sclient{s: s, key: pubAll(2), logf: logger.Discard}
+	light := &sclient{s: s, key: pubAll(3), logf: logger.Discard}
+	send := func(c *sclient) {
+		t.Helper()
+		if err := c.sendPkt(dst, pkt{bs: []byte("data"), src: c.key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fairQueueDrops := packetsDropped.Get(dropReasonKindLabels{
+		Reason: string(dropReasonFairQueue),
+		Kind:   string(packetKindOther),
+	}).(*expvar.Int)
+	drops0 := fairQueueDrops.Value()
+
+	// A single source may use the whole queue, with head drops as usual.
+	for range depth + 1 {
+		send(heavy)
+	}
+	if got := fairQueueDrops.Value() - drops0; got != 0 {
+		t.Fatalf("fair queue drops = %d; want 0", got)
+	}
+
+	// A light source evicts the heavy source's oldest packet.
+	send(light)
+	// Then the heavy source, now over its half share, is dropped.
+	send(heavy)
+	if got := fairQueueDrops.Value() - drops0; got != 1 {
+		t.Fatalf("fair queue drops = %d; want 1", got)
+	}
+
+	var got []key.NodePublic
+	for range depth {
+		p := <-dst.sendQueue
+		dst.dequeued(p)
+		got = append(got, p.src)
+	}
+	want := []key.NodePublic{heavy.key, heavy.key, heavy.key, light.key}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queued sources = %v; want %v", got, want)
+	}
+	if n := len(dst.fq.queued); n != 0 {
+		t.Errorf("fair queue still tracks %d sources after draining", n)
+	}
+}
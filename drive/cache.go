@@ -0,0 +1,103 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package drive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultCacheMetadataTTL is how long a FileSystemForLocal that caches a
+// remote trusts the metadata of its files, unless configured otherwise.
+const DefaultCacheMetadataTTL = 10 * time.Second
+
+// CacheConfig configures the caching, on local disk, of the contents of the
+// files on remotes and of the WebDAV PROPFIND results listing them, so that
+// repeated reads are served locally. It is read from a JSON file, with the
+// fields as its keys.
+//
+// A cached file is served once it's validated against the remote, either
+// by its ETag or modification time in metadata fetched no longer than
+// MetadataTTL ago, or by asking the remote whether it changed, which
+// transfers it again only if it did.
+type CacheConfig struct {
+	// Dir is the directory to cache in. Each remote is cached in a
+	// subdirectory of its own. If empty, tailscaled caches in its state
+	// directory.
+	Dir string `json:",omitempty"`
+
+	// Default is the configuration of the remotes not in Remotes.
+	Default RemoteCacheConfig
+
+	// Remotes are the configurations of particular remotes, by name.
+	Remotes map[string]RemoteCacheConfig `json:",omitempty"`
+}
+
+// RemoteCacheConfig configures the caching of a remote.
+type RemoteCacheConfig struct {
+	// MaxSize is the maximum total size of the files cached for the
+	// remote, in bytes. The least recently used are evicted to stay
+	// within it. Zero disables caching.
+	MaxSize int64 `json:",omitempty"`
+
+	// MetadataTTL is how long the PROPFIND results of the remote are
+	// trusted, in the format of [time.ParseDuration]. The default is
+	// DefaultCacheMetadataTTL.
+	MetadataTTL string `json:",omitempty"`
+}
+
+// LoadCacheConfig reads and checks the cache configuration file at path.
+func LoadCacheConfig(path string) (*CacheConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := new(CacheConfig)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := c.Default.check(); err != nil {
+		return nil, fmt.Errorf("%s: default: %w", path, err)
+	}
+	for name, rc := range c.Remotes {
+		if err := rc.check(); err != nil {
+			return nil, fmt.Errorf("%s: remote %q: %w", path, name, err)
+		}
+	}
+	return c, nil
+}
+
+func (rc RemoteCacheConfig) check() error {
+	if rc.MaxSize < 0 {
+		return fmt.Errorf("negative MaxSize")
+	}
+	if rc.MetadataTTL != "" {
+		if d, err := time.ParseDuration(rc.MetadataTTL); err != nil || d < 0 {
+			return fmt.Errorf("invalid MetadataTTL %q", rc.MetadataTTL)
+		}
+	}
+	return nil
+}
+
+// ForRemote returns the maximum size of the cache of the named remote, zero
+// if it isn't cached, and how long the metadata of its files is trusted.
+func (c *CacheConfig) ForRemote(name string) (maxSize int64, metadataTTL time.Duration) {
+	if c == nil {
+		return 0, 0
+	}
+	rc, ok := c.Remotes[name]
+	if !ok {
+		rc = c.Default
+	}
+	metadataTTL = DefaultCacheMetadataTTL
+	if d, err := time.ParseDuration(rc.MetadataTTL); err == nil {
+		metadataTTL = d
+	}
+	return rc.MaxSize, metadataTTL
+}
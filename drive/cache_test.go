@@ -0,0 +1,65 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package drive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadCacheConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"empty", `{}`, false},
+		{"full", `{"Dir": "/var/cache/drive", "Default": {"MaxSize": 100}, "Remotes": {"nas": {"MaxSize": 1000, "MetadataTTL": "1h"}}}`, false},
+		{"unknown-field", `{"MaxSize": 100}`, true},
+		{"negative-size", `{"Default": {"MaxSize": -1}}`, true},
+		{"bad-ttl", `{"Remotes": {"nas": {"MetadataTTL": "forever"}}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "cache.json")
+			if err := os.WriteFile(p, []byte(tt.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadCacheConfig(p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v; want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCacheConfigForRemote(t *testing.T) {
+	c := &CacheConfig{
+		Default: RemoteCacheConfig{MaxSize: 100},
+		Remotes: map[string]RemoteCacheConfig{
+			"nas":    {MaxSize: 1000, MetadataTTL: "1h"},
+			"laptop": {},
+		},
+	}
+	tests := []struct {
+		remote      string
+		wantMaxSize int64
+		wantTTL     time.Duration
+	}{
+		{"nas", 1000, time.Hour},
+		{"laptop", 0, DefaultCacheMetadataTTL},
+		{"other", 100, DefaultCacheMetadataTTL},
+	}
+	for _, tt := range tests {
+		maxSize, ttl := c.ForRemote(tt.remote)
+		if maxSize != tt.wantMaxSize || ttl != tt.wantTTL {
+			t.Errorf("ForRemote(%q) = %d, %v; want %d, %v", tt.remote, maxSize, ttl, tt.wantMaxSize, tt.wantTTL)
+		}
+	}
+	if maxSize, _ := (*CacheConfig)(nil).ForRemote("nas"); maxSize != 0 {
+		t.Errorf("nil config caches %d bytes", maxSize)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package drive
+
+import "time"
+
+// ChangesParam is the query parameter of a GET request for a directory in a
+// share that asks for the changes to the files in it, rather than for the
+// directory itself. Its value is the Cursor of the last ChangeFeed received,
+// or empty to get a cursor to start from.
+//
+// If there are no changes since the cursor, the request waits for some, for
+// up to the number of seconds in the ChangesWaitParam query parameter, or
+// MaxChangesWait, before returning an empty ChangeFeed.
+//
+// Changes are found by periodically scanning the share, so they are reported
+// with a delay of up to a few seconds, and changes that are undone in between
+// scans are not reported.
+const ChangesParam = "changes"
+
+// ChangesWaitParam is the query parameter of a request for changes that
+// limits how long it waits, in seconds.
+const ChangesWaitParam = "wait"
+
+// MaxChangesWait is the longest a request for changes waits.
+const MaxChangesWait = 5 * time.Minute
+
+// ChangeFeed is the JSON response to a request for changes.
+type ChangeFeed struct {
+	// Cursor is the position in the feed after Changes, to request the
+	// next changes from.
+	Cursor string `json:"cursor"`
+
+	// Reset is whether changes since the requested cursor are no longer
+	// known, as it is too old or from before the share was reconfigured or
+	// its node restarted. The client should list the directory again.
+	Reset bool `json:"reset,omitempty"`
+
+	// Changes are the changes since the requested cursor, oldest first.
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// ChangeOp is the kind of a Change.
+type ChangeOp string
+
+const (
+	ChangeCreate ChangeOp = "create"
+	ChangeModify ChangeOp = "modify"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// Change is a change to a file or directory, as reported in a ChangeFeed.
+type Change struct {
+	Op ChangeOp `json:"op"`
+
+	// Path is the slash-separated path of the file or directory, relative
+	// to the directory whose changes were requested.
+	Path string `json:"path"`
+
+	IsDir bool `json:"isDir,omitempty"`
+
+	// Size and ModTime are those of the file after the change, unless
+	// it was deleted.
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"modTime,omitzero"`
+}
@@ -23,6 +23,8 @@ func (src *Share) Clone() *Share {
 	Path         string
 	As           string
 	BookmarkData []byte
+	Quota        int64
+	Symlinks     SymlinkPolicy
 }{})
 
 // Clone duplicates src into dst and reports whether it succeeded.
@@ -65,6 +65,8 @@ func (v ShareView) As() string   { return v.ж.As }
 func (v ShareView) BookmarkData() views.ByteSlice[[]byte] {
 	return views.ByteSliceOf(v.ж.BookmarkData)
 }
+func (v ShareView) Quota() int64            { return v.ж.Quota }
+func (v ShareView) Symlinks() SymlinkPolicy { return v.ж.Symlinks }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ShareViewNeedsRegeneration = Share(struct {
@@ -72,4 +74,6 @@ func (v ShareView) BookmarkData() views.ByteSlice[[]byte] {
 	Path         string
 	As           string
 	BookmarkData []byte
+	Quota        int64
+	Symlinks     SymlinkPolicy
 }{})
@@ -0,0 +1,337 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/drive"
+	"tailscale.com/drive/driveimpl/shared"
+)
+
+const (
+	// changeScanInterval is how often a share is scanned for changes
+	// while clients are waiting for some.
+	changeScanInterval = 2 * time.Second
+
+	// maxLoggedChanges is how many changes to a share are remembered for
+	// clients to catch up on.
+	maxLoggedChanges = 10_000
+
+	// pokeDelay is how long after a write through WebDAV the share is
+	// scanned for changes, if clients are waiting for some.
+	pokeDelay = 250 * time.Millisecond
+)
+
+// changeWatcher serves the drive.ChangeFeed of a share, finding changes to
+// its files by scanning it on demand.
+type changeWatcher struct {
+	root   string
+	follow func(p string) bool // reports whether to follow the symbolic link at p
+	epoch  string              // random; identifies the watcher in cursors
+
+	scanMu sync.Mutex // held while scanning
+
+	// mu guards the below values.
+	mu       sync.Mutex
+	snapshot map[string]fileState // by slash-separated path; nil until scanned
+	lastScan time.Time
+	seq      uint64         // of the latest change
+	log      []loggedChange // the latest changes, at most maxLoggedChanges
+	changed  chan struct{}  // closed when changes are logged
+	waiters  int            // clients waiting for changes
+	pokeScan *time.Timer    // pending scan after a write, or nil
+}
+
+// fileState is the state of a file or directory in a changeWatcher's
+// snapshot.
+type fileState struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+type loggedChange struct {
+	seq uint64
+	drive.Change
+}
+
+// newChangeWatcher returns a changeWatcher of the share at root, following
+// the symbolic links in it for which follow returns true.
+func newChangeWatcher(root string, follow func(p string) bool) *changeWatcher {
+	epoch := make([]byte, 8)
+	rand.Read(epoch)
+	return &changeWatcher{
+		root:    root,
+		follow:  follow,
+		epoch:   hex.EncodeToString(epoch),
+		changed: make(chan struct{}),
+	}
+}
+
+// scan scans the share, unless it was scanned less than maxAge ago, and
+// logs any changes since the last scan.
+func (w *changeWatcher) scan(maxAge time.Duration) error {
+	w.scanMu.Lock()
+	defer w.scanMu.Unlock()
+
+	w.mu.Lock()
+	fresh := w.snapshot != nil && time.Since(w.lastScan) < maxAge
+	w.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	now := time.Now()
+	snapshot := make(map[string]fileState)
+	realRoot, err := filepath.EvalSymlinks(w.root)
+	if err != nil {
+		realRoot = w.root
+	}
+	if err := w.walk(snapshot, w.root, "", []string{realRoot}); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	old := w.snapshot
+	w.snapshot = snapshot
+	w.lastScan = now
+	if old == nil {
+		return nil // nothing to compare to
+	}
+	var changes []drive.Change
+	for p, st := range snapshot {
+		was, ok := old[p]
+		switch {
+		case !ok:
+			changes = append(changes, st.change(drive.ChangeCreate, p))
+		case was.isDir != st.isDir:
+			changes = append(changes, was.change(drive.ChangeDelete, p), st.change(drive.ChangeCreate, p))
+		case !st.isDir && (was.size != st.size || !was.modTime.Equal(st.modTime)):
+			changes = append(changes, st.change(drive.ChangeModify, p))
+		}
+	}
+	for p, was := range old {
+		if _, ok := snapshot[p]; !ok {
+			changes = append(changes, was.change(drive.ChangeDelete, p))
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(changes, func(a, b drive.Change) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	for _, c := range changes {
+		w.seq++
+		w.log = append(w.log, loggedChange{w.seq, c})
+	}
+	if n := len(w.log) - maxLoggedChanges; n > 0 {
+		w.log = slices.Delete(w.log, 0, n)
+	}
+	close(w.changed)
+	w.changed = make(chan struct{})
+	return nil
+}
+
+// walk adds the files and directories in dir, whose slash-separated path
+// relative to the share is rel, and those under them, to snapshot. Symbolic
+// links are followed if w.follow allows them. ancestors are the paths, with
+// any symbolic links resolved, of dir and the directories walked to reach
+// it, so that links to them aren't followed in circles.
+func (w *changeWatcher) walk(snapshot map[string]fileState, dir, rel string, ancestors []string) error {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // removed while walking
+		}
+		return err
+	}
+	for _, de := range des {
+		p := filepath.Join(dir, de.Name())
+		var fi fs.FileInfo
+		if de.Type()&fs.ModeSymlink != 0 {
+			if !w.follow(p) {
+				continue
+			}
+			fi, err = os.Stat(p)
+		} else {
+			fi, err = de.Info()
+		}
+		if err != nil || !(fi.IsDir() || fi.Mode().IsRegular()) {
+			continue
+		}
+		r := path.Join(rel, de.Name())
+		snapshot[r] = fileState{
+			isDir:   fi.IsDir(),
+			size:    fi.Size(),
+			modTime: fi.ModTime(),
+		}
+		if !fi.IsDir() {
+			continue
+		}
+		resolved := filepath.Join(ancestors[len(ancestors)-1], de.Name())
+		if de.Type()&fs.ModeSymlink != 0 {
+			resolved, err = filepath.EvalSymlinks(p)
+			if err != nil || slices.ContainsFunc(ancestors, func(a string) bool { return isWithin(a, resolved) }) {
+				continue // listed, but not walked in circles
+			}
+		}
+		if err := w.walk(snapshot, p, r, append(ancestors, resolved)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isWithin reports whether the path p is dir or under it.
+func isWithin(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+func (st fileState) change(op drive.ChangeOp, p string) drive.Change {
+	c := drive.Change{Op: op, Path: p, IsDir: st.isDir}
+	if op != drive.ChangeDelete {
+		c.ModTime = st.modTime
+		if !st.isDir {
+			c.Size = st.size
+		}
+	}
+	return c
+}
+
+// poke has the watcher scan the share soon, if clients are waiting for
+// changes, as it was just written to. The writes within pokeDelay of one
+// another lead to a single scan.
+func (w *changeWatcher) poke() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiters == 0 || w.pokeScan != nil {
+		return
+	}
+	w.pokeScan = time.AfterFunc(pokeDelay, func() {
+		w.mu.Lock()
+		w.pokeScan = nil
+		w.mu.Unlock()
+		w.scan(0)
+	})
+}
+
+// cursorLocked returns the cursor of the latest change. w.mu must be held.
+func (w *changeWatcher) cursorLocked() string {
+	return fmt.Sprintf("%s-%d", w.epoch, w.seq)
+}
+
+// changesSince returns the feed of the changes within dir, a slash-separated
+// path relative to the share or empty, since cursor, and the channel closed
+// when more changes are logged.
+func (w *changeWatcher) changesSince(dir, cursor string) (drive.ChangeFeed, <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	feed := drive.ChangeFeed{Cursor: w.cursorLocked()}
+	if cursor == "" {
+		return feed, w.changed
+	}
+	epoch, seqStr, _ := strings.Cut(cursor, "-")
+	since, err := strconv.ParseUint(seqStr, 10, 64)
+	oldest := w.seq - uint64(len(w.log)) // seq before that of the first logged change
+	if epoch != w.epoch || err != nil || since > w.seq || since < oldest {
+		feed.Reset = true
+		return feed, w.changed
+	}
+	for _, c := range w.log[since-oldest:] {
+		if dir == "" {
+			feed.Changes = append(feed.Changes, c.Change)
+		} else if rel, ok := strings.CutPrefix(c.Path, dir+"/"); ok {
+			c.Path = rel
+			feed.Changes = append(feed.Changes, c.Change)
+		} else if c.Path == dir && c.Op == drive.ChangeDelete {
+			// The directory itself is gone.
+			feed.Reset = true
+		}
+	}
+	return feed, w.changed
+}
+
+// serveHTTP serves a request for the changes within the directory at
+// r.URL.Path, as seen through fsys.
+func (w *changeWatcher) serveHTTP(rw http.ResponseWriter, r *http.Request, fsys webdav.FileSystem) {
+	fi, err := fsys.Stat(r.Context(), r.URL.Path)
+	if err != nil {
+		http.Error(rw, "not found", http.StatusNotFound)
+		return
+	}
+	if !fi.IsDir() {
+		http.Error(rw, "changes are only available for directories", http.StatusBadRequest)
+		return
+	}
+	dir := strings.TrimPrefix(shared.Normalize(r.URL.Path), "/")
+
+	q := r.URL.Query()
+	wait := drive.MaxChangesWait
+	if s := q.Get(drive.ChangesWaitParam); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs < 0 {
+			http.Error(rw, "invalid wait", http.StatusBadRequest)
+			return
+		}
+		wait = min(time.Duration(secs)*time.Second, drive.MaxChangesWait)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(changeScanInterval)
+	defer ticker.Stop()
+
+	w.mu.Lock()
+	w.waiters++
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.waiters--
+		w.mu.Unlock()
+	}()
+
+	cursor := q.Get(drive.ChangesParam)
+	for {
+		if err := w.scan(changeScanInterval / 2); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		feed, changed := w.changesSince(dir, cursor)
+		if len(feed.Changes) > 0 || feed.Reset || cursor == "" {
+			writeChangeFeed(rw, feed)
+			return
+		}
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-timer.C:
+			writeChangeFeed(rw, feed)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeChangeFeed(w http.ResponseWriter, feed drive.ChangeFeed) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
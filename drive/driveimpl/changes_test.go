@@ -0,0 +1,227 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/drive"
+	"tailscale.com/tstest"
+)
+
+func TestChangeFeed(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "builds", "old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "builds", "old", "a.tgz"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	serve := serveShare(t, &drive.Share{Name: "share", Path: dir})
+
+	changes := func(path, cursor string) drive.ChangeFeed {
+		t.Helper()
+		rr := serve("GET", path+"?changes="+cursor+"&wait=0", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d: %s", path, rr.Code, rr.Body)
+		}
+		var feed drive.ChangeFeed
+		if err := json.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+			t.Fatal(err)
+		}
+		for i := range feed.Changes {
+			feed.Changes[i].ModTime = time.Time{} // not compared
+		}
+		return feed
+	}
+	paths := func(feed drive.ChangeFeed) []string {
+		var ret []string
+		for _, c := range feed.Changes {
+			ret = append(ret, string(c.Op)+" "+c.Path)
+		}
+		return ret
+	}
+
+	start := changes("/builds", "")
+	if start.Cursor == "" || start.Reset || len(start.Changes) > 0 {
+		t.Fatalf("initial feed = %+v", start)
+	}
+	if got := changes("/builds", start.Cursor); len(got.Changes) > 0 || got.Cursor != start.Cursor {
+		t.Errorf("feed without changes = %+v", got)
+	}
+
+	// Make some changes on disk, and one through WebDAV.
+	if err := os.Mkdir(filepath.Join(dir, "builds", "new"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "builds", "new", "b.tgz"), []byte("bb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "builds", "old")); err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve("PUT", "/elsewhere.txt", strings.NewReader("x")); rr.Code != http.StatusCreated {
+		t.Fatalf("PUT: %d", rr.Code)
+	}
+	time.Sleep(changeScanInterval) // let the scan after the PUT become stale
+
+	got := changes("/builds", start.Cursor)
+	want := []string{
+		"create new",
+		"create new/b.tgz",
+		"delete old",
+		"delete old/a.tgz",
+	}
+	if diff := cmp.Diff(want, paths(got)); diff != "" {
+		t.Errorf("changes in /builds (-want +got):\n%s", diff)
+	}
+	if c := got.Changes[1]; c.Size != 2 || c.IsDir {
+		t.Errorf("change = %+v; want a file of size 2", c)
+	}
+
+	all := changes("/", start.Cursor)
+	want = []string{
+		"create builds/new",
+		"create builds/new/b.tgz",
+		"delete builds/old",
+		"delete builds/old/a.tgz",
+		"create elsewhere.txt",
+	}
+	if diff := cmp.Diff(want, paths(all)); diff != "" {
+		t.Errorf("changes in / (-want +got):\n%s", diff)
+	}
+	if next := changes("/", all.Cursor); len(next.Changes) > 0 || next.Reset {
+		t.Errorf("changes after the latest = %+v", next)
+	}
+
+	if got := changes("/builds", "bogus-1"); !got.Reset {
+		t.Errorf("feed for an unknown cursor = %+v; want reset", got)
+	}
+	if rr := serve("GET", "/nonexistent?changes=", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("changes of a nonexistent directory: %d; want 404", rr.Code)
+	}
+}
+
+func TestChangeFeedWaits(t *testing.T) {
+	dir := t.TempDir()
+	serve := serveShare(t, &drive.Share{Name: "share", Path: dir})
+	rr := serve("GET", "/?changes=", nil)
+	var start drive.ChangeFeed
+	if err := json.Unmarshal(rr.Body.Bytes(), &start); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan drive.ChangeFeed)
+	go func() {
+		rr := serve("GET", "/?changes="+start.Cursor+"&wait=30", nil)
+		var feed drive.ChangeFeed
+		json.Unmarshal(rr.Body.Bytes(), &feed)
+		done <- feed
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case feed := <-done:
+		if len(feed.Changes) != 1 || feed.Changes[0].Path != "new.txt" {
+			t.Errorf("feed = %+v; want the creation of new.txt", feed)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("timed out waiting for changes")
+	}
+}
+
+func TestChangeWatcherSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	outside := t.TempDir()
+	dir := t.TempDir()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644))
+	must(os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	must(os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("file"), 0o644))
+	must(os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "inside")))
+	must(os.Symlink(outside, filepath.Join(dir, "outside")))
+	must(os.Symlink(dir, filepath.Join(dir, "sub", "loop")))
+
+	tests := []struct {
+		policy drive.SymlinkPolicy
+		want   []string
+	}{
+		{"", []string{"inside", "inside/file", "inside/loop", "outside", "outside/secret", "sub", "sub/file", "sub/loop"}},
+		{drive.SymlinksWithinShare, []string{"inside", "inside/file", "inside/loop", "sub", "sub/file", "sub/loop"}},
+		{drive.SymlinksDeny, []string{"sub", "sub/file"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			w := newShareHandler(&drive.Share{Name: "share", Path: dir, Symlinks: tt.policy}).changes
+			if err := w.scan(0); err != nil {
+				t.Fatal(err)
+			}
+			got := slices.Sorted(maps.Keys(w.snapshot))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("scanned paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChangeWatcherPoke(t *testing.T) {
+	dir := t.TempDir()
+	w := newChangeWatcher(dir, func(string) bool { return false })
+	if err := w.scan(0); err != nil {
+		t.Fatal(err)
+	}
+	scanned := func() time.Time {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.lastScan
+	}
+	first := scanned()
+
+	w.poke()
+	time.Sleep(2 * pokeDelay)
+	if !scanned().Equal(first) {
+		t.Errorf("poke without waiting clients scanned the share")
+	}
+
+	w.mu.Lock()
+	w.waiters++
+	w.mu.Unlock()
+	for range 10 {
+		w.poke()
+	}
+	w.mu.Lock()
+	pending := w.pokeScan
+	w.mu.Unlock()
+	if pending == nil {
+		t.Fatal("poke with a waiting client didn't schedule a scan")
+	}
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if scanned().Equal(first) {
+			return errors.New("not scanned yet")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
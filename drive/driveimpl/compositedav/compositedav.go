@@ -36,6 +36,10 @@ type Child struct {
 	// with this Child's WebDAV service.
 	Transport http.RoundTripper
 
+	// Cache (if specified) caches the files on this Child and their
+	// metadata on local disk.
+	Cache *DiskCache
+
 	rp       *httputil.ReverseProxy
 	initOnce sync.Once
 }
@@ -170,6 +174,10 @@ func (h *Handler) delegate(mpl int, pathComponents []string, w http.ResponseWrit
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	name := shared.Normalize(r.URL.Path)
+	if cacheInvalidatingMethods[r.Method] {
+		child.Cache.invalidateMetadata()
+	}
 
 	baseURL, err := child.BaseURL()
 	if err != nil {
@@ -184,11 +192,27 @@ func (h *Handler) delegate(mpl int, pathComponents []string, w http.ResponseWrit
 		return
 	}
 	u.Path = path.Join(u.Path, shared.Join(pathComponents[1:]...))
+	u.RawQuery = r.URL.RawQuery // e.g. drive.ChangesParam
 	r.URL = u
 	r.Host = u.Host
+	if child.Cache != nil && r.Method == "GET" && u.RawQuery == "" {
+		child.Cache.serveGET(w, r, name, h.fileStat(child, name), child.rp.ServeHTTP)
+		return
+	}
 	child.rp.ServeHTTP(w, r)
 }
 
+// fileStat returns the metadata of the named file on child from a PROPFIND
+// result in the StatCache or child's DiskCache, or nil if neither has it.
+func (h *Handler) fileStat(child *Child, name string) *fileStat {
+	if ce := h.StatCache.get(name, 0); ce != nil && ce.Status == http.StatusMultiStatus {
+		if st := parseFileStat(ce.Raw, name, 0); st != nil {
+			return st
+		}
+	}
+	return child.Cache.stat(name)
+}
+
 // SetChildren replaces the entire existing set of children with the given
 // ones. If staticRoot is given, the children will appear with a subfolder
 // bearing named <staticRoot>.
@@ -0,0 +1,601 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package compositedav
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/drive/driveimpl/shared"
+)
+
+// DiskCache is an optional cache, on local disk, of the contents of the
+// files on a Child and of the PROPFIND results listing them. Over links with
+// high latency, it lets repeated reads be served without waiting for the
+// Child.
+//
+// File contents are cached along with the ETag and Last-Modified time the
+// Child served them with. A cached file is served as is if PROPFIND results
+// no older than MetadataTTL, from this cache or from the StatCache, show the
+// same ETag (or, lacking one, modification time). Otherwise, the Child is
+// asked for the file only if it doesn't match, and the cached contents are
+// served if it does.
+//
+// Each file or PROPFIND result is cached as one file in Dir, its body
+// followed by a JSON diskHeader and the length of that header. The least
+// recently used are removed to keep the total size of the files within
+// MaxSize.
+type DiskCache struct {
+	// Dir is the directory to cache in. It is created if needed.
+	Dir string
+
+	// MaxSize is the maximum total size, in bytes, of the cached files.
+	MaxSize int64
+
+	// MetadataTTL is how long cached PROPFIND results are served.
+	MetadataTTL time.Duration
+
+	// mu guards the below values.
+	mu      sync.Mutex
+	entries map[string]*diskEntry // by file name in Dir; nil until loaded
+	size    int64                 // of entries
+}
+
+type diskEntry struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// diskHeader describes a file in a DiskCache.
+type diskHeader struct {
+	Path    string    // normalized path of the file or PROPFIND
+	Depth   int       `json:",omitempty"` // of a PROPFIND
+	Status  int       `json:",omitempty"` // of a PROPFIND
+	Fetched time.Time // when fetched from the Child
+	Size    int64     // of the body
+
+	// Response headers for file contents.
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	ContentType  string `json:",omitempty"`
+}
+
+// trailerLen is the length of the big-endian length of the JSON diskHeader
+// at the end of a cache file.
+const trailerLen = 4
+
+// contentsKey returns the name of the file caching the contents of the file
+// at the normalized path name.
+func contentsKey(name string) string {
+	return fmt.Sprintf("c-%x", sha256.Sum256([]byte(name)))
+}
+
+// propfindKey returns the name of the file caching the PROPFIND result of
+// the normalized path name at depth.
+func propfindKey(name string, depth int) string {
+	return fmt.Sprintf("p%d-%x", depth, sha256.Sum256([]byte(name)))
+}
+
+// loadLocked indexes the files in c.Dir, if it hasn't yet. c.mu must be
+// held.
+func (c *DiskCache) loadLocked() {
+	if c.entries != nil {
+		return
+	}
+	c.entries = make(map[string]*diskEntry)
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		log.Printf("diskcache: %v", err)
+		return
+	}
+	des, err := os.ReadDir(c.Dir)
+	if err != nil {
+		log.Printf("diskcache: %v", err)
+		return
+	}
+	for _, de := range des {
+		p := filepath.Join(c.Dir, de.Name())
+		if strings.HasSuffix(de.Name(), ".tmp") {
+			os.Remove(p) // left behind by a crash
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		c.entries[de.Name()] = &diskEntry{size: fi.Size(), lastUsed: fi.ModTime()}
+		c.size += fi.Size()
+	}
+	c.evictLocked()
+}
+
+// evictLocked removes the least recently used files until the rest fit in
+// c.MaxSize. c.mu must be held.
+func (c *DiskCache) evictLocked() {
+	if c.size <= c.MaxSize {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return c.entries[a].lastUsed.Compare(c.entries[b].lastUsed)
+	})
+	for _, key := range keys {
+		if c.size <= c.MaxSize {
+			break
+		}
+		c.removeLocked(key)
+	}
+}
+
+// removeLocked removes the file key. c.mu must be held.
+func (c *DiskCache) removeLocked(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	os.Remove(filepath.Join(c.Dir, key))
+	delete(c.entries, key)
+	c.size -= e.size
+}
+
+func (c *DiskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+}
+
+// invalidateMetadata removes all cached PROPFIND results, as the Child was
+// written to.
+func (c *DiskCache) invalidateMetadata() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadLocked()
+	for key := range c.entries {
+		if strings.HasPrefix(key, "p") {
+			c.removeLocked(key)
+		}
+	}
+}
+
+// cachedFile is an open file of a DiskCache.
+type cachedFile struct {
+	diskHeader
+	f    *os.File
+	body *io.SectionReader
+}
+
+func (cf *cachedFile) Close() error {
+	return cf.f.Close()
+}
+
+// open opens the file key, or returns nil if it isn't cached.
+func (c *DiskCache) open(key string) *cachedFile {
+	c.mu.Lock()
+	c.loadLocked()
+	e, ok := c.entries[key]
+	if ok {
+		e.lastUsed = time.Now()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(c.Dir, key))
+	if err != nil {
+		c.remove(key)
+		return nil
+	}
+	cf, err := readCachedFile(f)
+	if err != nil {
+		log.Printf("diskcache: %s: %v", key, err)
+		f.Close()
+		c.remove(key)
+		return nil
+	}
+	return cf
+}
+
+func readCachedFile(f *os.File) (*cachedFile, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var trailer [trailerLen]byte
+	if _, err := f.ReadAt(trailer[:], fi.Size()-trailerLen); err != nil {
+		return nil, err
+	}
+	hlen := int64(binary.BigEndian.Uint32(trailer[:]))
+	hstart := fi.Size() - trailerLen - hlen
+	if hstart < 0 {
+		return nil, errors.New("truncated")
+	}
+	cf := &cachedFile{f: f}
+	if err := json.NewDecoder(io.NewSectionReader(f, hstart, hlen)).Decode(&cf.diskHeader); err != nil {
+		return nil, err
+	}
+	if cf.Size != hstart {
+		return nil, errors.New("truncated")
+	}
+	cf.body = io.NewSectionReader(f, 0, cf.Size)
+	return cf, nil
+}
+
+// cacheWriter writes a file to a DiskCache.
+type cacheWriter struct {
+	c   *DiskCache
+	key string
+	f   *os.File // nil once committed or aborted
+	n   int64
+}
+
+// create starts writing the file key, or returns nil if it can't be.
+func (c *DiskCache) create(key string) *cacheWriter {
+	c.mu.Lock()
+	c.loadLocked()
+	c.mu.Unlock()
+	f, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		log.Printf("diskcache: %v", err)
+		return nil
+	}
+	return &cacheWriter{c: c, key: key, f: f}
+}
+
+// Write writes the body of the file. Bodies larger than the whole cache
+// abort the write.
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		return len(p), nil
+	}
+	if w.n+int64(len(p)) > w.c.MaxSize {
+		w.abort()
+		return len(p), nil
+	}
+	n, err := w.f.Write(p)
+	w.n += int64(n)
+	if err != nil {
+		w.abort()
+	}
+	return len(p), nil
+}
+
+// commit finishes writing the file with the given header, making it
+// available.
+func (w *cacheWriter) commit(h diskHeader) {
+	if w.f == nil {
+		return
+	}
+	h.Size = w.n
+	hb, err := json.Marshal(h)
+	if err != nil {
+		w.abort()
+		return
+	}
+	hb = binary.BigEndian.AppendUint32(hb, uint32(len(hb)))
+	if w.n+int64(len(hb)) > w.c.MaxSize {
+		w.abort()
+		return
+	}
+	if _, err := w.f.Write(hb); err != nil {
+		w.abort()
+		return
+	}
+	tmp := w.f.Name()
+	if err := w.f.Close(); err != nil {
+		w.abort()
+		return
+	}
+	w.f = nil
+
+	c := w.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp, filepath.Join(c.Dir, w.key)); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if e, ok := c.entries[w.key]; ok {
+		c.size -= e.size
+	}
+	size := w.n + int64(len(hb))
+	c.entries[w.key] = &diskEntry{size: size, lastUsed: time.Now()}
+	c.size += size
+	c.evictLocked()
+}
+
+// abort abandons writing the file, if it wasn't committed.
+func (w *cacheWriter) abort() {
+	if w.f == nil {
+		return
+	}
+	w.f.Close()
+	os.Remove(w.f.Name())
+	w.f = nil
+}
+
+// getOr returns the cached PROPFIND result for the named file at the given
+// depth, if it was fetched no longer than c.MetadataTTL ago. Otherwise, it
+// returns the status and value of fetch, caching them if they are a
+// MultiStatus or NotFound.
+func (c *DiskCache) getOr(name string, depth int, fetch func() (int, []byte)) (int, []byte) {
+	name = shared.Normalize(name)
+	if depth > 1 {
+		return fetch()
+	}
+	key := propfindKey(name, depth)
+	if cf := c.open(key); cf != nil {
+		defer cf.Close()
+		if time.Since(cf.Fetched) < c.MetadataTTL {
+			if raw, err := io.ReadAll(cf.body); err == nil {
+				return cf.Status, raw
+			}
+		}
+	}
+
+	now := time.Now()
+	status, raw := fetch()
+	if status == http.StatusMultiStatus || status == http.StatusNotFound {
+		if w := c.create(key); w != nil {
+			w.Write(raw)
+			w.commit(diskHeader{Path: name, Depth: depth, Status: status, Fetched: now})
+		}
+	}
+	return status, raw
+}
+
+// fileStat is the metadata of a file from a PROPFIND result.
+type fileStat struct {
+	etag         string
+	lastModified string
+}
+
+// stat returns the metadata of the named file from a cached PROPFIND result
+// no older than c.MetadataTTL, or nil if there's none.
+func (c *DiskCache) stat(name string) *fileStat {
+	if c == nil {
+		return nil
+	}
+	name = shared.Normalize(name)
+	for depth, p := range []string{name, shared.Parent(name)} {
+		cf := c.open(propfindKey(p, depth))
+		if cf == nil {
+			continue
+		}
+		defer cf.Close()
+		if cf.Status != http.StatusMultiStatus || time.Since(cf.Fetched) >= c.MetadataTTL {
+			continue
+		}
+		raw, err := io.ReadAll(cf.body)
+		if err != nil {
+			continue
+		}
+		if st := parseFileStat(raw, name, depth); st != nil {
+			return st
+		}
+	}
+	return nil
+}
+
+// parseFileStat returns the metadata of the named file from a PROPFIND
+// MultiStatus at depth 0 of the file, or at depth 1 of its parent, or nil if
+// it's not there.
+func parseFileStat(raw []byte, name string, depth int) *fileStat {
+	var ms multiStatus
+	if err := xml.Unmarshal(raw, &ms); err != nil {
+		return nil
+	}
+	for i, resp := range ms.Responses {
+		if depth > 0 {
+			// Rewritten hrefs are only escaped past the Child's own
+			// path, so match the file by its base name.
+			if i == 0 {
+				continue // the parent itself
+			}
+			base := path.Base(strings.TrimSuffix(resp.Href, "/"))
+			if unescaped, err := url.PathUnescape(base); err == nil {
+				base = unescaped
+			}
+			if base != path.Base(name) {
+				continue
+			}
+		}
+		st := new(fileStat)
+		for _, ps := range resp.PropStats {
+			var v struct {
+				ETag         string `xml:"prop>getetag"`
+				LastModified string `xml:"prop>getlastmodified"`
+				Status       string `xml:"status"`
+			}
+			if err := xml.Unmarshal([]byte(`<propstat xmlns:D="DAV:">`+string(ps.InnerXML)+`</propstat>`), &v); err != nil {
+				continue
+			}
+			if !strings.Contains(v.Status, " 200 ") {
+				continue
+			}
+			st.etag = cmp.Or(st.etag, v.ETag)
+			st.lastModified = cmp.Or(st.lastModified, v.LastModified)
+		}
+		if *st == (fileStat{}) {
+			return nil
+		}
+		return st
+	}
+	return nil
+}
+
+// matches reports whether the cached contents are those of a file with the
+// metadata st.
+func (cf *cachedFile) matches(st *fileStat) bool {
+	if st.etag != "" || cf.ETag != "" {
+		return st.etag == cf.ETag
+	}
+	return st.lastModified != "" && st.lastModified == cf.LastModified
+}
+
+// serve serves the cached contents of a file for the request r.
+func (cf *cachedFile) serve(w http.ResponseWriter, r *http.Request) {
+	if cf.ETag != "" {
+		w.Header().Set("ETag", cf.ETag)
+	}
+	if cf.ContentType != "" {
+		w.Header().Set("Content-Type", cf.ContentType)
+	}
+	modTime, _ := http.ParseTime(cf.LastModified)
+	http.ServeContent(w, r, path.Base(cf.Path), modTime, cf.body)
+}
+
+// conditionalHeaders are the request headers that make a GET conditional.
+var conditionalHeaders = []string{
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// serveGET serves a GET request for the file at the normalized path name,
+// from the cache if its contents match st (which may be nil) or the file
+// on the Child, and otherwise by delegating the request to the Child with
+// next, caching the response.
+func (c *DiskCache) serveGET(w http.ResponseWriter, r *http.Request, name string, st *fileStat, next func(http.ResponseWriter, *http.Request)) {
+	key := contentsKey(name)
+	cf := c.open(key)
+	if cf != nil {
+		defer cf.Close()
+		if st != nil && cf.matches(st) {
+			cf.serve(w, r)
+			return
+		}
+	}
+
+	req := r
+	if cf != nil {
+		// Ask whether the cached contents are current, rather than
+		// whatever the client asked.
+		req = r.Clone(r.Context())
+		for _, h := range conditionalHeaders {
+			req.Header.Del(h)
+		}
+		if cf.ETag != "" {
+			req.Header.Set("If-None-Match", cf.ETag)
+		} else {
+			req.Header.Set("If-Modified-Since", cf.LastModified)
+		}
+	} else if r.Header.Get("Range") != "" {
+		// Only whole files are cached.
+		next(w, r)
+		return
+	}
+
+	cw := &cachingResponseWriter{ResponseWriter: w, c: c, key: key, validating: cf != nil}
+	defer cw.abort()
+	next(cw, req)
+
+	switch {
+	case cw.notModified:
+		cf.serve(w, r)
+	case cw.cw != nil:
+		if want, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && want == cw.cw.n {
+			cw.cw.commit(diskHeader{
+				Path:         name,
+				Fetched:      cw.fetched,
+				ETag:         w.Header().Get("ETag"),
+				LastModified: w.Header().Get("Last-Modified"),
+				ContentType:  w.Header().Get("Content-Type"),
+			})
+		}
+	case cf != nil && (cw.status == http.StatusOK || cw.status == http.StatusPartialContent || cw.status == http.StatusNotFound):
+		// The cached contents are stale.
+		c.remove(key)
+	}
+}
+
+// cachingResponseWriter is the http.ResponseWriter for a GET request
+// delegated to a Child by DiskCache.serveGET. It caches complete responses
+// with validators, and swallows the Not Modified response to a request
+// validating the cached contents.
+type cachingResponseWriter struct {
+	http.ResponseWriter
+	c          *DiskCache
+	key        string
+	validating bool // the request asks whether the cached contents are current
+
+	status      int // or zero if the header wasn't written
+	notModified bool
+	fetched     time.Time
+	cw          *cacheWriter // of the response body, if caching it
+}
+
+func (w *cachingResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	h := w.Header()
+	switch {
+	case status == http.StatusNotModified && w.validating:
+		w.notModified = true
+		return
+	case status == http.StatusOK && h.Get("Content-Encoding") == "" && (h.Get("ETag") != "" || h.Get("Last-Modified") != ""):
+		if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && n <= w.c.MaxSize {
+			w.fetched = time.Now()
+			w.cw = w.c.create(w.key)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cachingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(p), nil
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if w.cw != nil {
+		if err != nil {
+			w.cw.abort()
+		} else {
+			w.cw.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (w *cachingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.notModified {
+		f.Flush()
+	}
+}
+
+// abort abandons caching the response, if it wasn't committed.
+func (w *cachingResponseWriter) abort() {
+	if w.cw != nil {
+		w.cw.abort()
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package compositedav
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/drive/driveimpl/dirfs"
+)
+
+// cacheTest is a Handler with a single Child, "remote", that serves the
+// files in a directory and is cached on disk.
+type cacheTest struct {
+	t      *testing.T
+	dir    string // served by the Child
+	remote *httptest.Server
+
+	mu       sync.Mutex
+	requests []string // "METHOD status" of the requests to the Child
+}
+
+func newCacheTest(t *testing.T) *cacheTest {
+	ct := &cacheTest{t: t, dir: t.TempDir()}
+	wh := &webdav.Handler{
+		FileSystem: webdav.Dir(ct.dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	ct.remote = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		wh.ServeHTTP(rec, r)
+		ct.mu.Lock()
+		ct.requests = append(ct.requests, r.Method+" "+http.StatusText(rec.Code))
+		ct.mu.Unlock()
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	t.Cleanup(ct.remote.Close)
+	return ct
+}
+
+// handler returns a new Handler for the Child, cached with c.
+func (ct *cacheTest) handler(c *DiskCache) *Handler {
+	h := &Handler{}
+	h.SetChildren("", &Child{
+		Child:   &dirfs.Child{Name: "remote"},
+		BaseURL: func() (string, error) { return ct.remote.URL, nil },
+		Cache:   c,
+	})
+	ct.t.Cleanup(h.Close)
+	return h
+}
+
+// do makes a request to h and returns the status and body of the response,
+// and the requests it made to the Child.
+func (ct *cacheTest) do(h *Handler, method, path string, header http.Header, body string) (int, string, []string) {
+	ct.t.Helper()
+	ct.mu.Lock()
+	ct.requests = nil
+	ct.mu.Unlock()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return rr.Code, rr.Body.String(), ct.requests
+}
+
+func (ct *cacheTest) writeFile(name, contents string, modTime time.Time) {
+	ct.t.Helper()
+	p := filepath.Join(ct.dir, name)
+	if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+		ct.t.Fatal(err)
+	}
+	if err := os.Chtimes(p, modTime, modTime); err != nil {
+		ct.t.Fatal(err)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	ct := newCacheTest(t)
+	modTime := time.Now().Add(-time.Hour)
+	ct.writeFile("file.txt", "hello, world", modTime)
+	cacheDir := t.TempDir()
+	h := ct.handler(&DiskCache{Dir: cacheDir, MaxSize: 10000, MetadataTTL: time.Hour})
+
+	type want struct {
+		status   int
+		body     string
+		requests string // to the Child
+	}
+	check := func(label, method, path string, header http.Header, w want) {
+		t.Helper()
+		status, body, requests := ct.do(h, method, path, header, "")
+		if status != w.status || (w.body != "" && body != w.body) || strings.Join(requests, ", ") != w.requests {
+			t.Errorf("%s: got %d %q, requests [%s]; want %d %q, requests [%s]",
+				label, status, body, strings.Join(requests, ", "), w.status, w.body, w.requests)
+		}
+	}
+
+	check("first read", "GET", "/remote/file.txt", nil, want{200, "hello, world", "GET OK"})
+	check("validated read", "GET", "/remote/file.txt", nil, want{200, "hello, world", "GET Not Modified"})
+	check("ranged read", "GET", "/remote/file.txt", http.Header{"Range": {"bytes=7-"}}, want{206, "world", "GET Not Modified"})
+	check("listing", "PROPFIND", "/remote/", http.Header{"Depth": {"1"}}, want{207, "", "PROPFIND Multi-Status"})
+	check("cached listing", "PROPFIND", "/remote/", http.Header{"Depth": {"1"}}, want{207, "", ""})
+	check("read after listing", "GET", "/remote/file.txt", nil, want{200, "hello, world", ""})
+
+	// Changes behind the cache's back aren't seen while the metadata is
+	// trusted, but writing through it invalidates the metadata.
+	ct.writeFile("file.txt", "hello, tailnet", modTime.Add(time.Minute))
+	check("stale read", "GET", "/remote/file.txt", nil, want{200, "hello, world", ""})
+	check("write", "PUT", "/remote/other.txt", nil, want{201, "", "PUT Created"})
+	check("read after write", "GET", "/remote/file.txt", nil, want{200, "hello, tailnet", "GET OK"})
+	check("read of new contents", "GET", "/remote/file.txt", nil, want{200, "hello, tailnet", "GET Not Modified"})
+	check("missing", "GET", "/remote/missing.txt", nil, want{404, "", "GET Not Found"})
+
+	// The cache outlives the Handler.
+	h = ct.handler(&DiskCache{Dir: cacheDir, MaxSize: 10000, MetadataTTL: time.Hour})
+	check("listing after restart", "PROPFIND", "/remote/", http.Header{"Depth": {"1"}}, want{207, "", "PROPFIND Multi-Status"})
+	check("read after restart", "GET", "/remote/file.txt", nil, want{200, "hello, tailnet", ""})
+}
+
+func TestDiskCacheMaxSize(t *testing.T) {
+	ct := newCacheTest(t)
+	modTime := time.Now().Add(-time.Hour)
+	ct.writeFile("a", strings.Repeat("a", 300), modTime)
+	ct.writeFile("b", strings.Repeat("b", 300), modTime)
+	ct.writeFile("big", strings.Repeat("c", 1000), modTime)
+	c := &DiskCache{Dir: t.TempDir(), MaxSize: 1000}
+	h := ct.handler(c)
+
+	cached := func(name string) bool {
+		t.Helper()
+		cf := c.open(contentsKey("/remote/" + name))
+		if cf == nil {
+			return false
+		}
+		defer cf.Close()
+		b, err := io.ReadAll(cf.body)
+		return err == nil && len(b) > 0
+	}
+	for _, name := range []string{"a", "b", "a", "big"} {
+		if status, _, _ := ct.do(h, "GET", "/remote/"+name, nil, ""); status != http.StatusOK {
+			t.Fatalf("GET %s: %d", name, status)
+		}
+	}
+	if cached("big") {
+		t.Errorf("file larger than the cache was cached")
+	}
+	if !cached("a") || !cached("b") {
+		t.Errorf("files within the cache's size weren't cached")
+	}
+
+	// b was used last, by the check above, so a is evicted to make room.
+	ct.writeFile("c", strings.Repeat("c", 300), modTime)
+	ct.do(h, "GET", "/remote/c", nil, "")
+	if hasA, hasB, hasC := cached("a"), cached("b"), cached("c"); hasA || !hasB || !hasC {
+		t.Errorf("cached a: %v, b: %v, c: %v; want a evicted", hasA, hasB, hasC)
+	}
+	if c.size > c.MaxSize {
+		t.Errorf("cache size %d exceeds %d", c.size, c.MaxSize)
+	}
+}
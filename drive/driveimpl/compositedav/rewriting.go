@@ -24,9 +24,19 @@ func (h *Handler) handlePROPFIND(w http.ResponseWriter, r *http.Request, pathCom
 		// Delegate to a Child.
 		depth := getDepth(r)
 
-		status, result := h.StatCache.getOr(r.URL.Path, depth, func() (int, []byte) {
+		fetch := func() (int, []byte) {
 			return h.delegateRewriting(w, r, pathComponents, mpl)
-		})
+		}
+		if len(pathComponents) >= mpl {
+			if child := h.GetChild(pathComponents[mpl-1]); child != nil && child.Cache != nil {
+				fetch = func() (int, []byte) {
+					return child.Cache.getOr(r.URL.Path, depth, func() (int, []byte) {
+						return h.delegateRewriting(w, r, pathComponents, mpl)
+					})
+				}
+			}
+		}
+		status, result := h.StatCache.getOr(r.URL.Path, depth, fetch)
 
 		respondRewritten(w, status, result)
 		return
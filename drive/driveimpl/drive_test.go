@@ -4,6 +4,7 @@
 package driveimpl
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
@@ -227,6 +228,82 @@ func TestSecretTokenAuth(t *testing.T) {
 	}
 }
 
+func TestChangesThroughRemote(t *testing.T) {
+	s := newSystem(t)
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionReadOnly)
+
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	u := fmt.Sprintf("http://%s/%s/%s/%s",
+		s.local.l.Addr(),
+		url.PathEscape(domain),
+		url.PathEscape(remote1),
+		url.PathEscape(share11))
+	changes := func(cursor string) drive.ChangeFeed {
+		t.Helper()
+		resp, err := client.Get(u + "?" + url.Values{drive.ChangesParam: {cursor}, drive.ChangesWaitParam: {"0"}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var feed drive.ChangeFeed
+		if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+			t.Fatalf("decoding changes (status %d): %v", resp.StatusCode, err)
+		}
+		return feed
+	}
+
+	start := changes("")
+	s.write(remote1, share11, file111, "hello world")
+	time.Sleep(changeScanInterval)
+	feed := changes(start.Cursor)
+	if len(feed.Changes) != 1 || feed.Changes[0].Path != file111 || feed.Changes[0].Op != drive.ChangeCreate {
+		t.Errorf("changes = %+v; want the creation of %s", feed, file111)
+	}
+}
+
+func TestCachedReads(t *testing.T) {
+	s := newSystem(t)
+	s.local.fs.SetCacheConfig(&drive.CacheConfig{
+		Dir:     t.TempDir(),
+		Default: drive.RemoteCacheConfig{MaxSize: 1 << 20, MetadataTTL: "1h"},
+	})
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionReadWrite)
+	s.write(remote1, share11, file111, "hello world")
+
+	// List the share and read the file, which caches both.
+	if _, err := s.client.ReadDir(pathTo(remote1, share11, "")); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.readViaWebDAV(remote1, share11, file111); got != "hello world" {
+		t.Fatalf("read %q", got)
+	}
+
+	// The file is now read without waiting for the remote.
+	s.freezeRemote(remote1)
+	got := s.readViaWebDAV(remote1, share11, file111)
+	s.unfreezeRemote(remote1)
+	if got != "hello world" {
+		t.Errorf("cached read %q", got)
+	}
+
+	// Changes made on the remote aren't seen while its metadata is
+	// trusted, until something is written through the cache.
+	s.write(remote1, share11, file111, "hello tailnet")
+	if got := s.readViaWebDAV(remote1, share11, file111); got != "hello world" {
+		t.Errorf("read of changed file %q; want the cached contents", got)
+	}
+	s.writeFile("write", remote1, share11, file112, "other", true)
+	if got := s.readViaWebDAV(remote1, share11, file111); got != "hello tailnet" {
+		t.Errorf("read after write %q", got)
+	}
+}
+
 func TestLOCK(t *testing.T) {
 	s := newSystem(t)
 
@@ -13,6 +13,7 @@
 	"sync"
 
 	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/drive"
 	"tailscale.com/drive/driveimpl/shared"
 )
 
@@ -22,7 +23,7 @@
 type FileServer struct {
 	l             net.Listener
 	secretToken   string
-	shareHandlers map[string]http.Handler
+	shareHandlers map[string]*shareHandler
 	sharesMu      sync.RWMutex
 }
 
@@ -57,7 +58,7 @@ func NewFileServer() (*FileServer, error) {
 	return &FileServer{
 		l:             l,
 		secretToken:   secretToken,
-		shareHandlers: make(map[string]http.Handler),
+		shareHandlers: make(map[string]*shareHandler),
 	}, nil
 }
 
@@ -95,16 +96,19 @@ func (s *FileServer) UnlockShares() {
 // ClearSharesLocked clears the map of shares, assuming that LockShares() has
 // been called first.
 func (s *FileServer) ClearSharesLocked() {
-	s.shareHandlers = make(map[string]http.Handler)
+	s.shareHandlers = make(map[string]*shareHandler)
 }
 
 // AddShareLocked adds a share to the map of shares, assuming that LockShares()
 // has been called first.
 func (s *FileServer) AddShareLocked(share, path string) {
-	s.shareHandlers[share] = &webdav.Handler{
-		FileSystem: &birthTimingFS{webdav.Dir(path)},
-		LockSystem: webdav.NewMemLS(),
-	}
+	s.AddDriveShareLocked(&drive.Share{Name: share, Path: path})
+}
+
+// AddDriveShareLocked is like AddShareLocked, but also applies the share's
+// quota and symlink policy.
+func (s *FileServer) AddDriveShareLocked(share *drive.Share) {
+	s.shareHandlers[share.Name] = newShareHandler(share)
 }
 
 // SetShares sets the full map of shares to the new value, mapping name->path.
@@ -117,6 +121,69 @@ func (s *FileServer) SetShares(shares map[string]string) {
 	}
 }
 
+// SetDriveShares is like SetShares, but also applies the shares' quotas and
+// symlink policies.
+func (s *FileServer) SetDriveShares(shares []*drive.Share) {
+	s.LockShares()
+	defer s.UnlockShares()
+	s.ClearSharesLocked()
+	for _, share := range shares {
+		s.AddDriveShareLocked(share)
+	}
+}
+
+// shareHandler serves the WebDAV content of a share, and its
+// drive.ChangeFeed.
+type shareHandler struct {
+	webdav  *webdav.Handler
+	quota   *quotaFS // or nil if the share has no quota
+	changes *changeWatcher
+}
+
+func newShareHandler(share *drive.Share) *shareHandler {
+	var fs webdav.FileSystem = webdav.Dir(share.Path)
+	followLink := func(string) bool { return true }
+	if share.Symlinks == drive.SymlinksWithinShare || share.Symlinks == drive.SymlinksDeny {
+		sfs := newSymlinkFS(fs, share.Path, share.Symlinks)
+		fs = sfs
+		followLink = sfs.allowedLink
+	}
+	h := &shareHandler{changes: newChangeWatcher(share.Path, followLink)}
+	if share.Quota > 0 {
+		h.quota = &quotaFS{FileSystem: fs, root: share.Path, quota: share.Quota}
+		fs = h.quota
+	}
+	h.webdav = &webdav.Handler{
+		FileSystem: &birthTimingFS{fs},
+		LockSystem: webdav.NewMemLS(),
+	}
+	return h
+}
+
+func (h *shareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && r.URL.Query().Has(drive.ChangesParam) {
+		h.changes.serveHTTP(w, r, h.webdav.FileSystem)
+		return
+	}
+	if r.Method == "PUT" && h.quota != nil && r.ContentLength > 0 {
+		// Refuse uploads known to be too big before writing anything.
+		// Others fail once they exceed the quota.
+		fits, err := h.quota.fits(r.Context(), r.URL.Path, r.ContentLength)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !fits {
+			http.Error(w, errQuotaExceeded.Error(), http.StatusInsufficientStorage)
+			return
+		}
+	}
+	h.webdav.ServeHTTP(w, r)
+	if writeMethods[r.Method] {
+		h.changes.poke()
+	}
+}
+
 // ServeHTTP implements the http.Handler interface. This requires a secret
 // token in the path in order to prevent Mark-of-the-Web (MOTW) bypass attacks
 // of the below sort:
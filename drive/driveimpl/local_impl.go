@@ -5,9 +5,13 @@
 package driveimpl
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"tailscale.com/drive"
@@ -52,6 +56,11 @@ type FileSystemForLocal struct {
 	logf     logger.Logf
 	h        *compositedav.Handler
 	listener *connListener
+
+	// cacheMu guards the below values.
+	cacheMu     sync.Mutex
+	cacheConfig *drive.CacheConfig
+	caches      map[string]*compositedav.DiskCache // by remote domain and name
 }
 
 func (s *FileSystemForLocal) startServing() {
@@ -83,12 +92,48 @@ func (s *FileSystemForLocal) SetRemotes(domain string, remotes []*drive.Remote,
 			},
 			BaseURL:   func() (string, error) { return remote.URL, nil },
 			Transport: transport,
+			Cache:     s.cacheFor(domain, remote.Name),
 		})
 	}
 
 	s.h.SetChildren(domain, children...)
 }
 
+// SetCacheConfig configures caching the files on remotes on local disk. It
+// applies to the remotes set by subsequent calls to SetRemotes.
+func (s *FileSystemForLocal) SetCacheConfig(cfg *drive.CacheConfig) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cacheConfig = cfg
+	s.caches = nil
+}
+
+// cacheFor returns the cache for the named remote on the given tailnet
+// domain, or nil if it isn't cached. The same remote keeps the same cache
+// across calls to SetRemotes.
+func (s *FileSystemForLocal) cacheFor(domain, name string) *compositedav.DiskCache {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	maxSize, metadataTTL := s.cacheConfig.ForRemote(name)
+	if maxSize <= 0 {
+		return nil
+	}
+	id := domain + "/" + name
+	if c, ok := s.caches[id]; ok {
+		return c
+	}
+	c := &compositedav.DiskCache{
+		Dir:         filepath.Join(s.cacheConfig.Dir, fmt.Sprintf("%x", sha256.Sum256([]byte(id)))[:16]),
+		MaxSize:     maxSize,
+		MetadataTTL: metadataTTL,
+	}
+	if s.caches == nil {
+		s.caches = make(map[string]*compositedav.DiskCache)
+	}
+	s.caches[id] = c
+	return c
+}
+
 // Close() stops serving the WebDAV content
 func (s *FileSystemForLocal) Close() error {
 	err := s.listener.Close()
@@ -0,0 +1,191 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/drive/driveimpl/shared"
+)
+
+var errQuotaExceeded = errors.New("share quota exceeded")
+
+// usageRecountInterval is how often a quotaFS recounts the size of the files
+// in its share, to account for changes made other than through it. In
+// between, the writes and removals made through it are added up.
+const usageRecountInterval = time.Minute
+
+// quotaFS extends a webdav.FileSystem to refuse writes that would make the
+// files under root exceed quota bytes in total.
+type quotaFS struct {
+	webdav.FileSystem
+	root  string
+	quota int64
+
+	// mu guards the below values.
+	mu      sync.Mutex
+	used    int64     // as of counted, plus the changes made since
+	changed int64     // bytes written less those removed, in total
+	counted time.Time // when used was last counted, or zero
+}
+
+// usage returns the total size of the regular files under root, as last
+// counted, at most usageRecountInterval ago, plus the changes made through
+// q since.
+func (q *quotaFS) usage() (int64, error) {
+	q.mu.Lock()
+	now := time.Now()
+	if !q.counted.IsZero() && now.Sub(q.counted) < usageRecountInterval {
+		defer q.mu.Unlock()
+		return q.used, nil
+	}
+	q.counted = now // so that concurrent writes don't also count
+	changed := q.changed
+	q.mu.Unlock()
+
+	n, err := countUsage(q.root)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		q.counted = time.Time{}
+		return 0, err
+	}
+	// Writes made while counting may be counted twice, which errs on the
+	// side of the quota.
+	q.used = n + q.changed - changed
+	return q.used, nil
+}
+
+// add records n bytes written, or removed if negative, under root.
+func (q *quotaFS) add(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used += n
+	q.changed += n
+}
+
+// reserve records n bytes about to be written under root, and reports
+// whether they fit within the quota. If not, nothing is recorded.
+func (q *quotaFS) reserve(n int64) (bool, error) {
+	if _, err := q.usage(); err != nil {
+		return false, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used+n > q.quota {
+		return false, nil
+	}
+	q.used += n
+	q.changed += n
+	return true, nil
+}
+
+// countUsage returns the total size of the regular files under p.
+func countUsage(p string) (n int64, err error) {
+	err = filepath.WalkDir(p, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if !de.Type().IsRegular() {
+			return nil
+		}
+		if fi, err := de.Info(); err == nil {
+			n += fi.Size()
+		}
+		return nil
+	})
+	return n, err
+}
+
+// fits reports whether replacing the file at name, if any, with one of size
+// bytes would keep the share within its quota.
+func (q *quotaFS) fits(ctx context.Context, name string, size int64) (bool, error) {
+	used, err := q.usage()
+	if err != nil {
+		return false, err
+	}
+	if fi, err := q.FileSystem.Stat(ctx, name); err == nil && fi.Mode().IsRegular() {
+		used -= fi.Size()
+	}
+	return used+size <= q.quota, nil
+}
+
+func (q *quotaFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return q.FileSystem.OpenFile(ctx, name, flag, perm)
+	}
+	var truncated int64
+	if flag&os.O_TRUNC != 0 {
+		if fi, err := q.FileSystem.Stat(ctx, name); err == nil && fi.Mode().IsRegular() {
+			truncated = fi.Size()
+		}
+	}
+	f, err := q.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	q.add(-truncated)
+	return &quotaFile{File: f, ctx: ctx, fs: q, name: name}, nil
+}
+
+func (q *quotaFS) RemoveAll(ctx context.Context, name string) error {
+	// The files are counted before being removed, so the count may be off
+	// if they change meanwhile, until the next recount.
+	n, _ := countUsage(filepath.Join(q.root, filepath.FromSlash(shared.Normalize(name))))
+	if err := q.FileSystem.RemoveAll(ctx, name); err != nil {
+		return err
+	}
+	q.add(-n)
+	return nil
+}
+
+// quotaFile is a file of a quotaFS opened for writing. If a write would
+// exceed the quota, it fails with errQuotaExceeded, and the file is removed
+// once closed.
+type quotaFile struct {
+	webdav.File
+	ctx      context.Context
+	fs       *quotaFS
+	name     string
+	exceeded bool
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	if f.exceeded {
+		return 0, errQuotaExceeded
+	}
+	ok, err := f.fs.reserve(int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		f.exceeded = true
+		return 0, errQuotaExceeded
+	}
+	n, err := f.File.Write(p)
+	f.fs.add(int64(n - len(p)))
+	return n, err
+}
+
+func (f *quotaFile) Close() error {
+	err := f.File.Close()
+	if f.exceeded {
+		if rmErr := f.fs.RemoveAll(f.ctx, f.name); rmErr != nil {
+			return rmErr
+		}
+		return errQuotaExceeded
+	}
+	return err
+}
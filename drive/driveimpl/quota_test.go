@@ -0,0 +1,102 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/drive"
+)
+
+// serveShare returns a function that serves requests for the paths in the
+// share as a FileServer would.
+func serveShare(t *testing.T, share *drive.Share) func(method, path string, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	s, err := NewFileServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.SetDriveShares([]*drive.Share{share})
+	return func(method, path string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/"+s.secretToken+"/"+share.Name+path, body)
+		if body == nil {
+			req.ContentLength = 0
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+}
+
+// unknownLength hides the length of a reader from http.NewRequest.
+type unknownLength struct{ io.Reader }
+
+func TestQuota(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "existing"), []byte(strings.Repeat("x", 40)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	serve := serveShare(t, &drive.Share{Name: "share", Path: dir, Quota: 100})
+
+	tests := []struct {
+		name       string
+		path       string
+		body       io.Reader
+		wantStatus int
+		wantSize   int // or -1 if the file must not exist
+	}{
+		{"fits", "/a", strings.NewReader(strings.Repeat("a", 50)), http.StatusCreated, 50},
+		{"too-big", "/b", strings.NewReader(strings.Repeat("b", 20)), http.StatusInsufficientStorage, -1},
+		{"too-big-unknown-length", "/b", unknownLength{strings.NewReader(strings.Repeat("b", 20))}, http.StatusMethodNotAllowed, -1},
+		{"replace-fits", "/a", strings.NewReader(strings.Repeat("a", 60)), http.StatusCreated, 60},
+		{"replace-unknown-length-fits", "/a", unknownLength{strings.NewReader(strings.Repeat("a", 55))}, http.StatusCreated, 55},
+		{"fill", "/c", strings.NewReader(strings.Repeat("c", 5)), http.StatusCreated, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve("PUT", tt.path, tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d; body: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			fi, err := os.Stat(filepath.Join(dir, tt.path))
+			switch {
+			case tt.wantSize < 0 && err == nil:
+				t.Errorf("%s exists", tt.path)
+			case tt.wantSize >= 0 && (err != nil || fi.Size() != int64(tt.wantSize)):
+				t.Errorf("%s: %v, %v; want size %d", tt.path, fi, err, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestQuotaRemove(t *testing.T) {
+	dir := t.TempDir()
+	serve := serveShare(t, &drive.Share{Name: "share", Path: dir, Quota: 100})
+
+	put := func(path string, size, wantStatus int) {
+		t.Helper()
+		if rr := serve("PUT", path, strings.NewReader(strings.Repeat("x", size))); rr.Code != wantStatus {
+			t.Errorf("PUT %s: %d; want %d", path, rr.Code, wantStatus)
+		}
+	}
+	if rr := serve("MKCOL", "/dir", nil); rr.Code != http.StatusCreated {
+		t.Fatalf("MKCOL: %d", rr.Code)
+	}
+	put("/dir/a", 40, http.StatusCreated)
+	put("/dir/b", 40, http.StatusCreated)
+	put("/c", 40, http.StatusInsufficientStorage)
+	put("/c", 20, http.StatusCreated)
+	if rr := serve("DELETE", "/dir", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d", rr.Code)
+	}
+	put("/d", 80, http.StatusCreated)
+	put("/e", 1, http.StatusInsufficientStorage)
+}
@@ -7,6 +7,7 @@
 	"bufio"
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
@@ -328,9 +329,9 @@ func (s *userServer) runLoop() {
 // userServers anyway.
 func (s *userServer) run() error {
 	// set up the command
-	args := []string{"serve-taildrive"}
-	for _, s := range s.shares {
-		args = append(args, s.Name, s.Path)
+	args, err := serveDriveArgs(s.shares)
+	if err != nil {
+		return err
 	}
 	var cmd *exec.Cmd
 	if su := s.canSU(); su != "" {
@@ -397,6 +398,29 @@ func (s *userServer) run() error {
 	return cmd.Wait()
 }
 
+// ServeDriveSharesFlag is the flag of tailscaled serve-taildrive that
+// passes it its shares as a JSON array of drive.Share, instead of as name
+// and path pairs.
+const ServeDriveSharesFlag = "--shares="
+
+// serveDriveArgs returns the arguments of tailscaled to serve shares.
+func serveDriveArgs(shares []*drive.Share) ([]string, error) {
+	opts := make([]*drive.Share, 0, len(shares))
+	for _, share := range shares {
+		opts = append(opts, &drive.Share{
+			Name:     share.Name,
+			Path:     share.Path,
+			Quota:    share.Quota,
+			Symlinks: share.Symlinks,
+		})
+	}
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"serve-taildrive", ServeDriveSharesFlag + string(b)}, nil
+}
+
 var writeMethods = map[string]bool{
 	"PUT":       true,
 	"POST":      true,
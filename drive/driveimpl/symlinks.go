@@ -0,0 +1,140 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/drive"
+	"tailscale.com/drive/driveimpl/shared"
+)
+
+// symlinkFS extends a webdav.FileSystem serving the directory root to apply
+// the drive.SymlinksWithinShare or drive.SymlinksDeny policy: paths through
+// symbolic links the policy doesn't allow are not found, and the links are
+// not listed in their directories.
+type symlinkFS struct {
+	webdav.FileSystem
+	root     string
+	realRoot string // root with any symbolic links resolved
+	policy   drive.SymlinkPolicy
+}
+
+func newSymlinkFS(fs webdav.FileSystem, root string, policy drive.SymlinkPolicy) *symlinkFS {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+	return &symlinkFS{
+		FileSystem: fs,
+		root:       root,
+		realRoot:   realRoot,
+		policy:     policy,
+	}
+}
+
+// allowedLink reports whether the policy allows following the symbolic link
+// at p.
+func (s *symlinkFS) allowedLink(p string) bool {
+	if s.policy != drive.SymlinksWithinShare {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		// Dangling links aren't followed, lest creating a file through
+		// one creates it outside of the share.
+		return false
+	}
+	rel, err := filepath.Rel(s.realRoot, target)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+// check returns os.ErrNotExist if the path name goes through a symbolic
+// link the policy doesn't allow.
+func (s *symlinkFS) check(name string) error {
+	p := s.root
+	for _, elem := range shared.CleanAndSplit(name) {
+		if elem == "" {
+			continue
+		}
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			// Nothing (yet) to follow. Let the operation itself fail, or
+			// create the file.
+			return nil
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 && !s.allowedLink(p) {
+			return os.ErrNotExist
+		}
+	}
+	return nil
+}
+
+func (s *symlinkFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := s.check(name); err != nil {
+		return err
+	}
+	return s.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (s *symlinkFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if err := s.check(name); err != nil {
+		return nil, err
+	}
+	f, err := s.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &symlinkFile{File: f, fs: s, dir: filepath.Join(s.root, filepath.FromSlash(shared.Normalize(name)))}, nil
+}
+
+func (s *symlinkFS) RemoveAll(ctx context.Context, name string) error {
+	if err := s.check(name); err != nil {
+		return err
+	}
+	return s.FileSystem.RemoveAll(ctx, name)
+}
+
+func (s *symlinkFS) Rename(ctx context.Context, oldName, newName string) error {
+	if err := s.check(oldName); err != nil {
+		return err
+	}
+	if err := s.check(newName); err != nil {
+		return err
+	}
+	return s.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (s *symlinkFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := s.check(name); err != nil {
+		return nil, err
+	}
+	return s.FileSystem.Stat(ctx, name)
+}
+
+// symlinkFile is a file of a symlinkFS, at dir if it is a directory.
+type symlinkFile struct {
+	webdav.File
+	fs  *symlinkFS
+	dir string
+}
+
+func (f *symlinkFile) Readdir(count int) ([]fs.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+	allowed := fis[:0]
+	for _, fi := range fis {
+		if fi.Mode()&fs.ModeSymlink == 0 || f.fs.allowedLink(filepath.Join(f.dir, fi.Name())) {
+			allowed = append(allowed, fi)
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"tailscale.com/drive"
+)
+
+func TestSymlinkPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	must(os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("file"), 0o644))
+	must(os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "inside")))
+	must(os.Symlink(outside, filepath.Join(dir, "outside")))
+	must(os.Symlink(filepath.Join(outside, "nonexistent"), filepath.Join(dir, "dangling")))
+
+	tests := []struct {
+		policy      drive.SymlinkPolicy
+		wantInside  bool
+		wantOutside bool
+	}{
+		{"", true, true},
+		{drive.SymlinksAllow, true, true},
+		{drive.SymlinksWithinShare, true, false},
+		{drive.SymlinksDeny, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			serve := serveShare(t, &drive.Share{Name: "share", Path: dir, Symlinks: tt.policy})
+			check := func(path string, want bool) {
+				t.Helper()
+				rr := serve("GET", path, nil)
+				if got := rr.Code == http.StatusOK; got != want {
+					t.Errorf("GET %s: %d; want success %v", path, rr.Code, want)
+				}
+			}
+			check("/sub/file", true)
+			check("/inside/file", tt.wantInside)
+			check("/outside/secret", tt.wantOutside)
+
+			rr := serve("PROPFIND", "/", nil)
+			listing := rr.Body.String()
+			if got := strings.Contains(listing, "/inside"); got != tt.wantInside {
+				t.Errorf("listing has inside: %v; want %v", got, tt.wantInside)
+			}
+			if got := strings.Contains(listing, "/outside"); got != tt.wantOutside {
+				t.Errorf("listing has outside: %v; want %v", got, tt.wantOutside)
+			}
+
+			// Writing through a dangling link would create a file outside
+			// of the share.
+			serve("PUT", "/dangling", strings.NewReader("x"))
+			_, err := os.Stat(filepath.Join(outside, "nonexistent"))
+			if created := err == nil; created != (tt.policy == "" || tt.policy == drive.SymlinksAllow) {
+				t.Errorf("file created through dangling link: %v", created)
+			}
+			os.Remove(filepath.Join(outside, "nonexistent"))
+		})
+	}
+}
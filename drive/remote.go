@@ -19,6 +19,8 @@
 	DisallowShareAs     = false
 	ErrDriveNotEnabled  = errors.New("Taildrive not enabled")
 	ErrInvalidShareName = errors.New("Share names may only contain the letters a-z, underscore _, parentheses (), or spaces")
+	ErrInvalidSymlinks  = errors.New("Symlink policy must be one of allow, within-share or deny")
+	ErrInvalidQuota     = errors.New("Share quota must not be negative")
 )
 
 var (
@@ -51,6 +53,40 @@ type Share struct {
 	// hold on to a security-scoped bookmark. That bookmark is stored here. See
 	// https://developer.apple.com/documentation/security/app_sandbox/accessing_files_from_the_macos_app_sandbox#4144043
 	BookmarkData []byte `json:"bookmarkData,omitempty"`
+
+	// Quota, if positive, is the approximate maximum total size in bytes of
+	// the files in the shared directory. Writes from remote nodes that would
+	// exceed it are refused. Files written locally are counted, but not
+	// limited.
+	Quota int64 `json:"quota,omitempty"`
+
+	// Symlinks controls whether remote nodes may follow symbolic links
+	// within the shared directory. The empty value means SymlinksAllow.
+	Symlinks SymlinkPolicy `json:"symlinks,omitempty"`
+}
+
+// SymlinkPolicy controls how a Share treats symbolic links.
+type SymlinkPolicy string
+
+const (
+	// SymlinksAllow follows symbolic links wherever they point.
+	SymlinksAllow SymlinkPolicy = "allow"
+
+	// SymlinksWithinShare follows symbolic links that resolve to a path
+	// within the shared directory, and hides the others.
+	SymlinksWithinShare SymlinkPolicy = "within-share"
+
+	// SymlinksDeny hides all symbolic links.
+	SymlinksDeny SymlinkPolicy = "deny"
+)
+
+// Valid reports whether p is a known policy, or empty.
+func (p SymlinkPolicy) Valid() bool {
+	switch p {
+	case "", SymlinksAllow, SymlinksWithinShare, SymlinksDeny:
+		return true
+	}
+	return false
 }
 
 func ShareViewsEqual(a, b ShareView) bool {
@@ -60,7 +96,8 @@ func ShareViewsEqual(a, b ShareView) bool {
 	if !a.Valid() || !b.Valid() {
 		return false
 	}
-	return a.Name() == b.Name() && a.Path() == b.Path() && a.As() == b.As() && a.BookmarkData().Equal(b.ж.BookmarkData)
+	return a.Name() == b.Name() && a.Path() == b.Path() && a.As() == b.As() && a.BookmarkData().Equal(b.ж.BookmarkData) &&
+		a.Quota() == b.Quota() && a.Symlinks() == b.Symlinks()
 }
 
 func SharesEqual(a, b *Share) bool {
@@ -70,7 +107,8 @@ func SharesEqual(a, b *Share) bool {
 	if a == nil || b == nil {
 		return false
 	}
-	return a.Name == b.Name && a.Path == b.Path && a.As == b.As && bytes.Equal(a.BookmarkData, b.BookmarkData)
+	return a.Name == b.Name && a.Path == b.Path && a.As == b.As && bytes.Equal(a.BookmarkData, b.BookmarkData) &&
+		a.Quota == b.Quota && a.Symlinks == b.Symlinks
 }
 
 func CompareShares(a, b *Share) int {
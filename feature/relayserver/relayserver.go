@@ -39,14 +39,36 @@ func init() {
 // newExtension is an [ipnext.NewExtensionFn] that creates a new relay server
 // extension. It is registered with [ipnext.RegisterExtension] if the package is
 // imported.
-func newExtension(logf logger.Logf, _ ipnext.SafeBackend) (ipnext.Extension, error) {
-	return &extension{logf: logger.WithPrefix(logf, featureName+": ")}, nil
+func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, error) {
+	return &extension{
+		logf:    logger.WithPrefix(logf, featureName+": "),
+		metrics: udprelay.NewMetrics(sb.Sys().UserMetricsRegistry()),
+	}, nil
+}
+
+// Resource limits of the relay server, for nodes that share their host with
+// other workloads. Zero or unset means no limit. See [udprelay.Limits].
+var (
+	maxEndpoints        = envknob.RegisterInt("TS_RELAY_SERVER_MAX_ENDPOINTS")
+	maxEndpointsPerNode = envknob.RegisterInt("TS_RELAY_SERVER_MAX_ENDPOINTS_PER_NODE")
+	packetsPerSecond    = envknob.RegisterInt("TS_RELAY_SERVER_PACKETS_PER_SECOND")
+	bytesPerSecond      = envknob.RegisterInt("TS_RELAY_SERVER_BYTES_PER_SECOND")
+)
+
+func limitsFromEnv() udprelay.Limits {
+	return udprelay.Limits{
+		MaxEndpoints:        maxEndpoints(),
+		MaxEndpointsPerNode: maxEndpointsPerNode(),
+		PacketsPerSecond:    packetsPerSecond(),
+		BytesPerSecond:      bytesPerSecond(),
+	}
 }
 
 // extension is an [ipnext.Extension] managing the relay server on platforms
 // that import this package.
 type extension struct {
-	logf logger.Logf
+	logf    logger.Logf
+	metrics *udprelay.Metrics // shared by successive servers; nil in tests
 
 	mu                     sync.Mutex // guards the following fields
 	shutdown               bool
@@ -134,11 +156,12 @@ func (e *extension) relayServerOrInit() (relayServer, error) {
 	if !envknob.UseWIPCode() {
 		return nil, errors.New("TAILSCALE_USE_WIP_CODE envvar is not set")
 	}
-	var err error
-	e.server, _, err = udprelay.NewServer(e.logf, *e.port, nil)
+	s, _, err := udprelay.NewServer(e.logf, *e.port, nil, e.metrics)
 	if err != nil {
 		return nil, err
 	}
+	s.SetLimits(limitsFromEnv())
+	e.server = s
 	return e.server, nil
 }
 
@@ -183,6 +206,10 @@ func handlePeerAPIRelayAllocateEndpoint(h ipnlocal.PeerAPIHandler, w http.Respon
 		return
 	}
 	ep, err := rs.AllocateEndpoint(allocateEndpointReq.DiscoKeys[0], allocateEndpointReq.DiscoKeys[1])
+	if errors.Is(err, udprelay.ErrQuotaExceeded) {
+		httpErrAndLog(err.Error(), http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		httpErrAndLog(err.Error(), http.StatusInternalServerError)
 		return
@@ -31,6 +31,10 @@ type fileDeleter struct {
 	dir   string
 	event func(string) // called for certain events; for testing only
 
+	// forgetDir is called with the base name of each partial directory
+	// deleted, to forget its abandoned directory transfer.
+	forgetDir func(string)
+
 	mu     sync.Mutex
 	queue  list.List
 	byName map[string]*list.Element
@@ -52,6 +56,7 @@ func (d *fileDeleter) Init(m *manager, eventHook func(string)) {
 	d.clock = m.opts.Clock
 	d.dir = m.opts.Dir
 	d.event = eventHook
+	d.forgetDir = m.forgetIncomingDir
 
 	d.byName = make(map[string]*list.Element)
 	d.emptySignal = make(chan struct{})
@@ -75,6 +80,15 @@ func (d *fileDeleter) Init(m *manager, eventHook func(string)) {
 			switch {
 			case d.shutdownCtx.Err() != nil:
 				return false // terminate early
+			case de.IsDir() && strings.HasSuffix(de.Name(), partialSuffix):
+				// Only enqueue a directory transfer for deletion
+				// if it hasn't been restarted.
+				if key, ok := partialDirKey(de.Name()); ok {
+					if _, ok := m.incomingDirs.Load(key); ok {
+						break
+					}
+				}
+				d.Insert(de.Name())
 			case !de.Type().IsRegular():
 				return true
 			case strings.HasSuffix(de.Name(), partialSuffix):
@@ -155,11 +169,18 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 					continue
 				}
 			}
-			if err := os.Remove(filepath.Join(d.dir, file.name)); err != nil && !os.IsNotExist(err) {
+			remove := os.Remove
+			if strings.HasSuffix(file.name, partialSuffix) {
+				remove = os.RemoveAll // may be the directory of a directory transfer
+			}
+			if err := remove(filepath.Join(d.dir, file.name)); err != nil && !os.IsNotExist(err) {
 				d.logf("could not delete: %v", redactError(err))
 				failed = append(failed, elem)
 				continue
 			}
+			if strings.HasSuffix(file.name, partialSuffix) {
+				d.forgetDir(file.name)
+			}
 			d.queue.Remove(elem)
 			delete(d.byName, file.name)
 			d.event("deleted " + file.name)
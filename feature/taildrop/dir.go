@@ -0,0 +1,351 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/version/distro"
+)
+
+// maxManifestFiles is the maximum number of files in a directory transfer.
+const maxManifestFiles = 100_000
+
+// A directory transfer sends a directory tree as a whole. The sender first
+// sends a manifest of the files in the tree, with their sizes and SHA-256
+// checksums, with [manager.StartDir]. It then sends each file with
+// [manager.PutDirFile], resuming partial files like [manager.PutFile] does,
+// and finally calls [manager.FinishDir] to check the tree against the
+// manifest and move it into place.
+//
+// Until then, the files are received in a partial directory named like a
+// partial file, next to where the directory will be, so that an unfinished
+// transfer is neither listed by WaitingFiles nor mistaken for a finished
+// one, and is eventually deleted if abandoned.
+
+// manifestFile is a file listed in the manifest of a directory transfer.
+type manifestFile struct {
+	size int64
+	sum  [sha256.Size]byte
+}
+
+// incomingDir is a directory transfer started with [manager.StartDir].
+type incomingDir struct {
+	partialPath string                  // directory the files are received in
+	files       map[string]manifestFile // by slash-separated relative path
+}
+
+// StartDir starts, or restarts to resume, the transfer from id of the
+// directory with the base name root, whose files are listed in manifest.
+//
+// It returns the paths of the files in manifest that an earlier attempt
+// already received intact, which need not be sent again.
+func (m *manager) StartDir(id clientID, root string, manifest []apitype.FileManifestEntry) (have []string, err error) {
+	switch {
+	case m == nil || m.opts.Dir == "":
+		return nil, ErrNoTaildrop
+	case !envknob.CanTaildrop():
+		return nil, ErrNoTaildrop
+	case distro.Get() == distro.Unraid && !m.opts.DirectFileMode:
+		return nil, ErrNotAccessible
+	case m.opts.Mode != PutModeDirect:
+		return nil, ErrDirNotSupported
+	}
+	dstPath, err := joinDir(m.opts.Dir, root)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest) == 0 || len(manifest) > maxManifestFiles {
+		return nil, ErrInvalidManifest
+	}
+	d := &incomingDir{
+		partialPath: dstPath + id.partialSuffix(),
+		files:       make(map[string]manifestFile, len(manifest)),
+	}
+	for _, f := range manifest {
+		if _, err := joinRelPath(d.partialPath, f.Path); err != nil {
+			return nil, err
+		}
+		sum, err := hex.DecodeString(f.SHA256)
+		if err != nil || len(sum) != sha256.Size || f.Size < 0 {
+			return nil, ErrInvalidManifest
+		}
+		if _, dup := d.files[f.Path]; dup {
+			return nil, ErrInvalidManifest
+		}
+		d.files[f.Path] = manifestFile{f.Size, [sha256.Size]byte(sum)}
+	}
+	// A file can't also be the directory of others.
+	for p := range d.files {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if _, ok := d.files[dir]; ok {
+				return nil, ErrInvalidManifest
+			}
+		}
+	}
+
+	m.deleter.Remove(filepath.Base(d.partialPath))
+	if err := os.MkdirAll(d.partialPath, 0o755); err != nil {
+		return nil, m.redactAndLogError("Mkdir", err)
+	}
+	m.deleter.Insert(filepath.Base(d.partialPath)) // until a file is put, the transfer may be abandoned
+	m.incomingDirs.Store(incomingFileKey{id, root}, d)
+
+	for _, f := range manifest {
+		if d.received(f.Path) {
+			have = append(have, f.Path)
+		}
+	}
+	return have, nil
+}
+
+// partialDirKey returns the key in [manager.incomingDirs] of the directory
+// transfer received in the partial directory with the base name.
+func partialDirKey(baseName string) (key incomingFileKey, ok bool) {
+	nameID, ok := strings.CutSuffix(baseName, partialSuffix)
+	i := strings.LastIndexByte(nameID, '.')
+	if !ok || i <= 0 {
+		return key, false
+	}
+	return incomingFileKey{clientID(nameID[i+len("."):]), nameID[:i]}, true
+}
+
+// forgetIncomingDir forgets the abandoned directory transfer whose partial
+// directory, with the base name, was deleted, along with its manifest.
+func (m *manager) forgetIncomingDir(baseName string) {
+	key, ok := partialDirKey(baseName)
+	if !ok {
+		return
+	}
+	if d, ok := m.incomingDirs.Load(key); ok && filepath.Base(d.partialPath) == baseName {
+		m.incomingDirs.Delete(key)
+	}
+}
+
+// received reports whether the file at relPath of d has been received
+// intact.
+func (d *incomingDir) received(relPath string) bool {
+	want, ok := d.files[relPath]
+	if !ok {
+		return false
+	}
+	p, err := joinRelPath(d.partialPath, relPath)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Lstat(p)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() != want.size {
+		return false
+	}
+	sum, err := sha256File(p)
+	return err == nil && sum == want.sum
+}
+
+// PutDirFile stores the file at the slash-separated path relPath of the
+// directory transfer root from id, started with [manager.StartDir].
+// The other arguments, the result, and resumption of partial files are as
+// for [manager.PutFile]. Once received, the file is checked against the
+// manifest, and discarded with [ErrChecksumMismatch] if it doesn't match.
+func (m *manager) PutDirFile(id clientID, root, relPath string, r io.Reader, offset, length int64) (int64, error) {
+	if m == nil || m.opts.Dir == "" {
+		return 0, ErrNoTaildrop
+	}
+	d, ok := m.incomingDirs.Load(incomingFileKey{id, root})
+	if !ok {
+		return 0, ErrNoManifest
+	}
+	want, ok := d.files[relPath]
+	if !ok {
+		return 0, ErrInvalidFileName
+	}
+	dstPath, err := joinRelPath(d.partialPath, relPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return 0, m.redactAndLogError("Mkdir", err)
+	}
+	return m.putFile(id, path.Join(root, relPath), dstPath, r, offset, length, d, want)
+}
+
+// finalizeDirFile checks the file received at partialPath against want,
+// its entry in the manifest, and renames it to dstPath, replacing any
+// earlier copy. The partial file is removed if it doesn't match.
+func (m *manager) finalizeDirFile(partialPath, dstPath string, fileLength int64, want manifestFile) error {
+	if fileLength == want.size {
+		sum, err := sha256File(partialPath)
+		if err != nil {
+			return err
+		}
+		if sum == want.sum {
+			return os.Rename(partialPath, dstPath)
+		}
+	}
+	if err := os.Remove(partialPath); err != nil {
+		return err
+	}
+	return ErrChecksumMismatch
+}
+
+// FinishDir finishes the directory transfer root from id. It checks that
+// every file in the manifest was received intact, removes any other files,
+// and moves the directory into place. It returns the base name the
+// directory was given, which differs from root if that was taken.
+func (m *manager) FinishDir(id clientID, root string) (string, error) {
+	if m == nil || m.opts.Dir == "" {
+		return "", ErrNoTaildrop
+	}
+	key := incomingFileKey{id, root}
+	d, ok := m.incomingDirs.Load(key)
+	if !ok {
+		return "", ErrNoManifest
+	}
+	m.deleter.Remove(filepath.Base(d.partialPath))
+
+	var missing int
+	for p := range d.files {
+		if !d.received(p) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		m.deleter.Insert(filepath.Base(d.partialPath))
+		m.opts.Logf("put dir: %d of %d files missing or corrupt", missing, len(d.files))
+		return "", fmt.Errorf("%w: %d of %d files missing or corrupt", ErrIncompleteDir, missing, len(d.files))
+	}
+
+	// Remove what isn't in the manifest, such as files of an earlier
+	// attempt that are no longer being sent, and leftover partial files.
+	err := filepath.WalkDir(d.partialPath, func(p string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(d.partialPath, p)
+		if err != nil {
+			return err
+		}
+		if _, ok := d.files[filepath.ToSlash(rel)]; !ok {
+			return os.Remove(p)
+		}
+		return nil
+	})
+	if err != nil {
+		m.deleter.Insert(filepath.Base(d.partialPath))
+		return "", m.redactAndLogError("Walk", err)
+	}
+
+	m.renameMu.Lock()
+	defer m.renameMu.Unlock()
+	name := root
+	const maxRetries = 10
+	for range maxRetries {
+		dstPath := filepath.Join(m.opts.Dir, name)
+		if _, err := os.Lstat(dstPath); os.IsNotExist(err) {
+			if err := os.Rename(d.partialPath, dstPath); err != nil {
+				m.deleter.Insert(filepath.Base(d.partialPath))
+				return "", m.redactAndLogError("Rename", err)
+			}
+			m.incomingDirs.Delete(key)
+			m.totalReceived.Add(1)
+			m.opts.SendFileNotify()
+			return name, nil
+		}
+		name = nextFilename(name)
+	}
+	m.deleter.Insert(filepath.Base(d.partialPath))
+	return "", fmt.Errorf("too many retries trying to rename a partial directory %q", redactString(root))
+}
+
+// joinRelPath is like joinDir, for the slash-separated path relPath of a
+// file in a directory transfer, each element of which must be a valid base
+// name.
+func joinRelPath(dir, relPath string) (string, error) {
+	if relPath == "" {
+		return "", ErrInvalidFileName
+	}
+	fullPath := dir
+	for elem := range strings.SplitSeq(relPath, "/") {
+		var err error
+		if fullPath, err = joinDir(fullPath, elem); err != nil {
+			return "", err
+		}
+	}
+	return fullPath, nil
+}
+
+// escapeRelPath escapes each element of the slash-separated path relPath
+// for use in a URL path.
+func escapeRelPath(relPath string) string {
+	elems := strings.Split(relPath, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
+
+// unescapeRelPath is the inverse of escapeRelPath. It returns
+// [ErrInvalidFileName] if an element is empty or contains an escaped slash.
+func unescapeRelPath(escaped string) (string, error) {
+	elems := strings.Split(escaped, "/")
+	for i, elem := range elems {
+		var err error
+		if elems[i], err = url.PathUnescape(elem); err != nil || elems[i] == "" || strings.Contains(elems[i], "/") {
+			return "", ErrInvalidFileName
+		}
+	}
+	return strings.Join(elems, "/"), nil
+}
+
+// waitingDirFiles returns the files waiting in the received directory
+// dirName in [Handler.Dir], named by their slash-separated paths relative
+// to [Handler.Dir].
+func (m *manager) waitingDirFiles(dirName string) (ret []apitype.WaitingFile, err error) {
+	err = filepath.WalkDir(filepath.Join(m.opts.Dir, dirName), func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if isPartialOrDeleted(de.Name()) || !de.Type().IsRegular() {
+			return nil
+		}
+		if _, err := os.Stat(p + deletedSuffix); !os.IsNotExist(err) {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(m.opts.Dir, p)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, apitype.WaitingFile{
+			Name: filepath.ToSlash(rel),
+			Size: fi.Size(),
+		})
+		return nil
+	})
+	return ret, err
+}
+
+// removeEmptyDirs removes the directories of the file at the
+// slash-separated path relPath in [Handler.Dir] that are empty, from the
+// innermost outwards.
+func (m *manager) removeEmptyDirs(relPath string) {
+	for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(filepath.Join(m.opts.Dir, filepath.FromSlash(dir))) != nil {
+			return // not empty, or already gone
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/util/must"
+)
+
+func manifestOf(files map[string]string) []apitype.FileManifestEntry {
+	var manifest []apitype.FileManifestEntry
+	for p, contents := range files {
+		sum := sha256.Sum256([]byte(contents))
+		manifest = append(manifest, apitype.FileManifestEntry{
+			Path:   p,
+			Size:   int64(len(contents)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	return manifest
+}
+
+func TestJoinRelPath(t *testing.T) {
+	for _, tt := range []struct {
+		relPath string
+		want    string // or empty if invalid
+	}{
+		{"a", "a"},
+		{"a/b/c.txt", filepath.Join("a", "b", "c.txt")},
+		{"", ""},
+		{"/a", ""},
+		{"a/", ""},
+		{"a//b", ""},
+		{"a/./b", ""},
+		{"a/../b", ""},
+		{"../a", ""},
+		{"a/b.partial", ""},
+		{"a/b\\c", ""},
+		{"a/ b", ""},
+	} {
+		got, err := joinRelPath("root", tt.relPath)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("joinRelPath(%q) = %q; want error", tt.relPath, got)
+			}
+			continue
+		}
+		if want := filepath.Join("root", tt.want); err != nil || got != want {
+			t.Errorf("joinRelPath(%q) = %q, %v; want %q", tt.relPath, got, err, want)
+		}
+	}
+}
+
+func TestDirTransfer(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, Dir: dir}.New()
+	defer m.Shutdown()
+
+	files := map[string]string{
+		"a.txt":          "alpha",
+		"sub/b.txt":      "bravo",
+		"sub/deep/c.txt": strings.Repeat("charlie", 1000),
+	}
+	manifest := manifestOf(files)
+	const id = clientID("n123")
+
+	if _, err := m.PutDirFile(id, "tree", "a.txt", strings.NewReader("alpha"), 0, 5); !errors.Is(err, ErrNoManifest) {
+		t.Fatalf("PutDirFile before StartDir: %v; want %v", err, ErrNoManifest)
+	}
+	have := must.Get(m.StartDir(id, "tree", manifest))
+	if len(have) != 0 {
+		t.Errorf("StartDir have = %q; want none", have)
+	}
+
+	// A file is read no further than its size in the manifest.
+	if _, err := m.PutDirFile(id, "tree", "a.txt", strings.NewReader("alpha-bravo"), 0, -1); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("PutDirFile of file larger than in the manifest: %v; want %v", err, ErrFileTooLarge)
+	}
+	// A file not matching the manifest is discarded.
+	if _, err := m.PutDirFile(id, "tree", "a.txt", strings.NewReader("ALPHA"), 0, 5); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("PutDirFile of corrupt file: %v; want %v", err, ErrChecksumMismatch)
+	}
+	if _, err := m.PutDirFile(id, "tree", "other.txt", strings.NewReader("x"), 0, 1); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("PutDirFile of file not in manifest: %v; want %v", err, ErrInvalidFileName)
+	}
+	must.Get(m.PutDirFile(id, "tree", "a.txt", strings.NewReader("alpha"), 0, 5))
+
+	// Interrupt the transfer of c.txt, and resume it.
+	c := files["sub/deep/c.txt"]
+	r := io.MultiReader(strings.NewReader(c[:3000]), iotest.ErrReader(io.ErrClosedPipe))
+	if _, err := m.PutDirFile(id, "tree", "sub/deep/c.txt", r, 0, int64(len(c))); err == nil {
+		t.Fatal("interrupted PutDirFile succeeded")
+	}
+	if _, err := m.FinishDir(id, "tree"); !errors.Is(err, ErrIncompleteDir) {
+		t.Fatalf("FinishDir of incomplete transfer: %v; want %v", err, ErrIncompleteDir)
+	}
+
+	have = must.Get(m.StartDir(id, "tree", manifest))
+	if want := []string{"a.txt"}; !cmp.Equal(have, want) {
+		t.Errorf("restarted StartDir have = %q; want %q", have, want)
+	}
+	next, close := must.Get2(m.HashPartialDirFile(id, "tree", "sub/deep/c.txt"))
+	offset, rest := must.Get2(resumeReader(strings.NewReader(c), next))
+	must.Do(close())
+	if offset == 0 {
+		t.Error("partial file was not resumed")
+	}
+	must.Get(m.PutDirFile(id, "tree", "sub/deep/c.txt", rest, offset, int64(len(c))-offset))
+	must.Get(m.PutDirFile(id, "tree", "sub/b.txt", strings.NewReader("bravo"), 0, 5))
+
+	if name := must.Get(m.FinishDir(id, "tree")); name != "tree" {
+		t.Errorf("FinishDir = %q; want %q", name, "tree")
+	}
+	for p, want := range files {
+		got := must.Get(os.ReadFile(filepath.Join(dir, "tree", filepath.FromSlash(p))))
+		if string(got) != want {
+			t.Errorf("%s: got %q; want %q", p, got, want)
+		}
+	}
+	if _, err := m.FinishDir(id, "tree"); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("FinishDir twice: %v; want %v", err, ErrNoManifest)
+	}
+
+	// Another transfer of the same name doesn't replace the first.
+	must.Get(m.StartDir(id, "tree", manifestOf(map[string]string{"d.txt": "delta"})))
+	must.Get(m.PutDirFile(id, "tree", "d.txt", strings.NewReader("delta"), 0, 5))
+	if name := must.Get(m.FinishDir(id, "tree")); name != "tree (1)" {
+		t.Errorf("FinishDir = %q; want %q", name, "tree (1)")
+	}
+
+	if !m.HasFilesWaiting() {
+		t.Error("HasFilesWaiting = false; want true")
+	}
+	wfs := must.Get(m.WaitingFiles())
+	want := []apitype.WaitingFile{
+		{Name: "tree (1)/d.txt", Size: 5},
+		{Name: "tree/a.txt", Size: 5},
+		{Name: "tree/sub/b.txt", Size: 5},
+		{Name: "tree/sub/deep/c.txt", Size: int64(len(c))},
+	}
+	if diff := cmp.Diff(wfs, want); diff != "" {
+		t.Fatalf("WaitingFiles mismatch (-got +want):\n%s", diff)
+	}
+	rc, size := must.Get2(m.OpenFile("tree/sub/deep/c.txt"))
+	got := must.Get(io.ReadAll(rc))
+	rc.Close()
+	if size != int64(len(c)) || string(got) != c {
+		t.Errorf("OpenFile returned %d bytes of size %d; want %d", len(got), size, len(c))
+	}
+	for _, wf := range wfs {
+		must.Do(m.DeleteFile(wf.Name))
+	}
+	if des := must.Get(os.ReadDir(dir)); len(des) != 0 {
+		t.Errorf("after deleting all waiting files, %d entries remain in %s", len(des), dir)
+	}
+}
+
+func TestAbandonedDirForgotten(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, Clock: tstime.DefaultClock{Clock: clock}, Dir: dir}.New()
+	defer m.Shutdown()
+
+	const id = clientID("n123")
+	must.Get(m.StartDir(id, "tree", manifestOf(map[string]string{"a.txt": "alpha"})))
+	err := tstest.WaitFor(10*time.Second, func() error {
+		clock.Advance(deleteDelay) // in case the deleter's timer wasn't yet started
+		if _, ok := m.incomingDirs.Load(incomingFileKey{id, "tree"}); ok {
+			return errors.New("abandoned directory transfer not forgotten")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tree"+id.partialSuffix())); !os.IsNotExist(err) {
+		t.Errorf("partial directory not deleted: %v", err)
+	}
+}
+
+func TestStartDirInvalidManifest(t *testing.T) {
+	m := managerOptions{Logf: t.Logf, Dir: t.TempDir()}.New()
+	defer m.Shutdown()
+
+	valid := manifestOf(map[string]string{"a": "alpha"})[0]
+	for name, manifest := range map[string][]apitype.FileManifestEntry{
+		"empty":       nil,
+		"traversal":   {{Path: "../a", SHA256: valid.SHA256}},
+		"absolute":    {{Path: "/etc/passwd", SHA256: valid.SHA256}},
+		"bad_sum":     {{Path: "a", SHA256: "abcd"}},
+		"negative":    {{Path: "a", Size: -1, SHA256: valid.SHA256}},
+		"duplicate":   {valid, valid},
+		"file_parent": {valid, {Path: "a/b", SHA256: valid.SHA256}},
+	} {
+		if _, err := m.StartDir("", "tree", manifest); err == nil {
+			t.Errorf("%s: StartDir succeeded; want error", name)
+		}
+	}
+	if _, err := m.StartDir("", "..", []apitype.FileManifestEntry{valid}); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("StartDir of %q: %v; want %v", "..", err, ErrInvalidFileName)
+	}
+}
+
+func TestHandlePeerPutDir(t *testing.T) {
+	dir := t.TempDir()
+	var logBuf tstest.MemLogger
+	ext := &fakeExtension{
+		logf:           logBuf.Logf,
+		capFileSharing: true,
+		clock:          &tstest.Clock{},
+		taildrop:       managerOptions{Logf: logBuf.Logf, Dir: dir}.New(),
+	}
+	defer ext.taildrop.Shutdown()
+	ph := &peerAPIHandler{
+		isSelf:   true,
+		selfNode: (&tailcfg.Node{}).View(),
+		peerNode: (&tailcfg.Node{ComputedName: "some-peer-name"}).View(),
+	}
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handlePeerPutDirWithBackend(ph, ext, rr, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return rr
+	}
+
+	files := map[string]string{
+		"Foo Bar.txt":        "foo",
+		"sub dir/Straße.txt": "bar",
+	}
+	manifestJSON := must.Get(json.Marshal(manifestOf(files)))
+	if rr := do("PUT", "/v0/put-dir/"+hexAll("My Files"), manifestJSON); rr.Code != http.StatusOK {
+		t.Fatalf("manifest PUT: %v %s", rr.Code, rr.Body)
+	}
+	if rr := do("PUT", "/v0/put-dir/"+hexAll("My Files")+"/sub%20dir/Stra%C3%9Fe.txt", []byte("bar")); rr.Code != http.StatusOK {
+		t.Fatalf("PUT: %v %s", rr.Code, rr.Body)
+	}
+	for _, tt := range []struct {
+		method, path string
+		body         string
+		wantCode     int
+	}{
+		{"PUT", "/v0/put-dir/" + hexAll("My Files") + "/" + hexAll("sub dir/x"), "x", http.StatusBadRequest},
+		{"PUT", "/v0/put-dir/" + hexAll("My Files") + "/sub%20dir/..", "x", http.StatusBadRequest},
+		{"PUT", "/v0/put-dir/" + hexAll("My Files") + "/Foo%20Bar.txt", "bad", http.StatusUnprocessableEntity},
+		{"PUT", "/v0/put-dir/other/x", "x", http.StatusConflict},
+		{"PUT", "/v0/put-dir/" + hexAll(".."), string(manifestJSON), http.StatusBadRequest},
+		{"PUT", "/v0/put-dir/" + hexAll("My Files") + "/", "x", http.StatusBadRequest},
+		{"DELETE", "/v0/put-dir/" + hexAll("My Files"), "", http.StatusMethodNotAllowed},
+		{"POST", "/v0/put-dir/" + hexAll("My Files"), "", http.StatusUnprocessableEntity}, // Foo Bar.txt is missing
+	} {
+		if rr := do(tt.method, tt.path, []byte(tt.body)); rr.Code != tt.wantCode {
+			t.Errorf("%s %s: %v %s; want %v", tt.method, tt.path, rr.Code, rr.Body, tt.wantCode)
+		}
+	}
+
+	rr := do("PUT", "/v0/put-dir/"+hexAll("My Files"), manifestJSON)
+	var res putDirResponse
+	must.Do(json.Unmarshal(rr.Body.Bytes(), &res))
+	if want := []string{"sub dir/Straße.txt"}; !cmp.Equal(res.Have, want) {
+		t.Errorf("restarted manifest PUT have = %q; want %q", res.Have, want)
+	}
+	do("PUT", "/v0/put-dir/"+hexAll("My Files")+"/Foo%20Bar.txt", []byte("foo"))
+	rr = do("POST", "/v0/put-dir/"+hexAll("My Files"), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST: %v %s", rr.Code, rr.Body)
+	}
+	must.Do(json.Unmarshal(rr.Body.Bytes(), &res))
+	if res.Name != "My Files" {
+		t.Errorf("received as %q; want %q", res.Name, "My Files")
+	}
+	for p, want := range files {
+		got := must.Get(os.ReadFile(filepath.Join(dir, "My Files", filepath.FromSlash(p))))
+		if string(got) != want {
+			t.Errorf("%s: got %q; want %q", p, got, want)
+		}
+	}
+}
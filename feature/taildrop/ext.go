@@ -49,6 +49,14 @@ func newExtension(logf logger.Logf, b ipnext.SafeBackend) (ipnext.Extension, err
 		logf:       logger.WithPrefix(logf, "taildrop: "),
 	}
 	e.setPlatformDefaultDirectFileRoot()
+	if path := receivePolicyPath(); path != "" {
+		p, err := loadReceivePolicy(path)
+		if err != nil {
+			e.logf("receive policy: %v; rejecting all files", err)
+			p = rejectAllPolicy
+		}
+		e.policy = p
+	}
 	return e, nil
 }
 
@@ -77,6 +85,10 @@ type Extension struct {
 	// This is currently being used for Android to use the Storage Access Framework.
 	FileOps FileOps
 
+	// policy, if non-nil, limits the files that are received.
+	// It is read at startup from TS_TAILDROP_RECEIVE_POLICY.
+	policy *receivePolicy
+
 	nodeBackendForTest ipnext.NodeBackend // if non-nil, pretend we're this node state for tests
 
 	mu             sync.Mutex // Lock order: lb.mu > e.mu
@@ -194,6 +206,7 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 		FileOps:        e.FileOps,
 		Mode:           mode,
 		SendFileNotify: e.sendFileNotify,
+		Policy:         e.policy,
 	}.New())
 }
 
@@ -349,6 +362,14 @@ func (e *Extension) nodeBackend() ipnext.NodeBackend {
 	return e.host.NodeBackend()
 }
 
+func (e *Extension) userLogin(uid tailcfg.UserID) string {
+	u, ok := e.nodeBackend().UserByID(uid)
+	if !ok {
+		return ""
+	}
+	return u.LoginName()
+}
+
 // FileTargets lists nodes that the current node can send files to.
 func (e *Extension) FileTargets() ([]*apitype.FileTarget, error) {
 	var ret []*apitype.FileTarget
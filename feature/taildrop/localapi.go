@@ -15,6 +15,7 @@
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -29,16 +30,19 @@
 	"tailscale.com/util/mak"
 	"tailscale.com/util/progresstracking"
 	"tailscale.com/util/rands"
+	"tailscale.com/util/set"
 )
 
 func init() {
 	localapi.Register("file-put/", serveFilePut)
+	localapi.Register("file-put-dir/", serveFilePutDir)
 	localapi.Register("files/", serveFiles)
 	localapi.Register("file-targets", serveFileTargets)
 }
 
 var (
-	metricFilePutCalls = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutCalls    = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutDirCalls = clientmetric.NewCounter("localapi_file_put_dir")
 )
 
 // serveFilePut sends a file to another node.
@@ -86,12 +90,6 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fts, err := ext.FileTargets()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put/")
 	if !ok {
 		http.Error(w, "misconfigured", http.StatusInternalServerError)
@@ -109,46 +107,14 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 		peerIDStr = upath
 	}
 	peerID := tailcfg.StableNodeID(peerIDStr)
-
-	var ft *apitype.FileTarget
-	for _, x := range fts {
-		if x.Node.StableID == peerID {
-			ft = x
-			break
-		}
-	}
-	if ft == nil {
-		http.Error(w, "node not found", http.StatusNotFound)
-		return
-	}
-	dstURL, err := url.Parse(ft.PeerAPIURL)
-	if err != nil {
-		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+	dstURL, ok := fileTargetURL(ext, w, peerID)
+	if !ok {
 		return
 	}
 
-	// Periodically report progress of outgoing files.
-	outgoingFiles := make(map[string]*ipn.OutgoingFile)
-	t := time.NewTicker(1 * time.Second)
-	progressUpdates := make(chan ipn.OutgoingFile)
+	progressUpdates := trackOutgoingFiles(ext)
 	defer close(progressUpdates)
 
-	go func() {
-		defer t.Stop()
-		defer ext.updateOutgoingFiles(outgoingFiles)
-		for {
-			select {
-			case u, ok := <-progressUpdates:
-				if !ok {
-					return
-				}
-				outgoingFiles[u.ID] = &u
-			case <-t.C:
-				ext.updateOutgoingFiles(outgoingFiles)
-			}
-		}
-	}()
-
 	switch r.Method {
 	case "PUT":
 		file := ipn.OutgoingFile{
@@ -157,7 +123,7 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 			Name:         filenameEscaped,
 			DeclaredSize: r.ContentLength,
 		}
-		singleFilePut(h, r.Context(), progressUpdates, w, r.Body, dstURL, file)
+		singleFilePut(h, r.Context(), progressUpdates, w, r.Body, dstURL, "/v0/put/"+file.Name, file)
 	case "POST":
 		multiFilePost(h, progressUpdates, w, r, peerID, dstURL)
 	default:
@@ -215,7 +181,8 @@ func multiFilePost(h *localapi.Handler, progressUpdates chan (ipn.OutgoingFile),
 			continue
 		}
 
-		if !singleFilePut(h, r.Context(), progressUpdates, ww, part, dstURL, outgoingFilesByName[part.FileName()]) {
+		file := outgoingFilesByName[part.FileName()]
+		if !singleFilePut(h, r.Context(), progressUpdates, ww, part, dstURL, "/v0/put/"+file.Name, file) {
 			return
 		}
 
@@ -227,6 +194,184 @@ func multiFilePost(h *localapi.Handler, progressUpdates chan (ipn.OutgoingFile),
 	}
 }
 
+// serveFilePutDir sends a directory of files to another node, to be
+// received with its layout preserved.
+//
+// The request body is encoded as multipart/form-data. The first part must
+// be the manifest of the directory: an application/json file containing a
+// JSON array of [apitype.FileManifestEntry]. It is sent to the peer ahead
+// of the files, which follow in the remaining parts, in any order, each
+// with the form name of its path in the manifest. Files the peer already
+// received intact in an earlier attempt are skipped, and partial files are
+// resumed. Finally, the peer checks the files against the manifest.
+//
+// URL format:
+//
+//   - POST /localapi/v0/file-put-dir/:stableID/:escaped-dirname
+func serveFilePutDir(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	metricFilePutDirCalls.Add(1)
+
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST to put directory", http.StatusBadRequest)
+		return
+	}
+
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "misconfigured taildrop extension", http.StatusInternalServerError)
+		return
+	}
+
+	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	peerIDStr, rootEscaped, ok := strings.Cut(upath, "/")
+	if !ok || rootEscaped == "" || strings.Contains(rootEscaped, "/") {
+		http.Error(w, "bogus URL", http.StatusBadRequest)
+		return
+	}
+	peerID := tailcfg.StableNodeID(peerIDStr)
+	dstURL, ok := fileTargetURL(ext, w, peerID)
+	if !ok {
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid Content-Type for multipart POST: %s", err), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode multipart/form-data: %s", err), http.StatusBadRequest)
+		return
+	}
+	if part.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "first MIME part must be a JSON manifest", http.StatusBadRequest)
+		return
+	}
+	var manifest []apitype.FileManifestEntry
+	if err := json.NewDecoder(part).Decode(&manifest); err != nil {
+		http.Error(w, fmt.Sprintf("invalid manifest: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// Send the manifest ahead of the files.
+	dirPath := "/v0/put-dir/" + rootEscaped
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var started putDirResponse
+	if !peerDirRequest(h, r.Context(), w, "PUT", dstURL.String()+dirPath, manifestJSON, &started) {
+		return
+	}
+	have := set.SetOf(started.Have)
+
+	progressUpdates := trackOutgoingFiles(ext)
+	defer close(progressUpdates)
+
+	root, _ := url.PathUnescape(rootEscaped)
+	outgoingFiles := make(map[string]ipn.OutgoingFile, len(manifest))
+	for _, f := range manifest {
+		file := ipn.OutgoingFile{
+			ID:           rands.HexString(30),
+			PeerID:       peerID,
+			Name:         path.Join(root, f.Path),
+			DeclaredSize: f.Size,
+		}
+		if have.Contains(f.Path) {
+			file.Started = time.Now()
+			file.Sent = f.Size
+			file.Finished = true
+			file.Succeeded = true
+		}
+		outgoingFiles[f.Path] = file
+		progressUpdates <- file
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode multipart/form-data: %s", err), http.StatusBadRequest)
+			return
+		}
+		relPath := part.FormName()
+		file, ok := outgoingFiles[relPath]
+		if !ok {
+			http.Error(w, fmt.Sprintf("file %q is not in the manifest", relPath), http.StatusBadRequest)
+			return
+		}
+		if have.Contains(relPath) {
+			io.Copy(io.Discard, part)
+			continue
+		}
+		ww := &multiFilePostResponseWriter{}
+		if !singleFilePut(h, r.Context(), progressUpdates, ww, part, dstURL, dirPath+"/"+escapeRelPath(relPath), file) || ww.statusCode >= 400 {
+			h.Logf("error: singleFilePut: failed with status %d", ww.statusCode)
+			if err := ww.Flush(w); err != nil {
+				h.Logf("error: multiFilePostResponseWriter.Flush(): %s", err)
+			}
+			return
+		}
+	}
+
+	// Have the peer check the files against the manifest, and move the
+	// directory into place.
+	var finished putDirResponse
+	if !peerDirRequest(h, r.Context(), w, "POST", dstURL.String()+dirPath, nil, &finished) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(finished)
+}
+
+// peerDirRequest makes the request of a directory transfer with the method
+// and body to the PeerAPI URL peerURL, and decodes its JSON response into
+// res. If it fails, it writes an error to w and returns false.
+func peerDirRequest(h *localapi.Handler, ctx context.Context, w http.ResponseWriter, method, peerURL string, body []byte, res *putDirResponse) bool {
+	req, err := http.NewRequestWithContext(ctx, method, peerURL, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+		return false
+	}
+	client := &http.Client{
+		Transport: h.LocalBackend().Dialer().PeerAPITransport(),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return false
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			http.Error(w, fmt.Sprintf("invalid response from peer: %v", err), http.StatusBadGateway)
+			return false
+		}
+		return true
+	case http.StatusNotFound:
+		// An older peer, without directory transfers.
+		http.Error(w, ErrDirNotSupported.Error(), http.StatusNotImplemented)
+		return false
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		http.Error(w, strings.TrimSpace(string(msg)), resp.StatusCode)
+		return false
+	}
+}
+
 // multiFilePostResponseWriter is a buffering http.ResponseWriter that can be
 // reused across multiple singleFilePut calls and then flushed to the client
 // when all files have been PUT.
@@ -268,6 +413,61 @@ func (ww *multiFilePostResponseWriter) Flush(w http.ResponseWriter) error {
 	return nil
 }
 
+// fileTargetURL returns the PeerAPI URL of the file target peerID. If it is
+// not a file target, it writes an error to w and returns false.
+func fileTargetURL(ext *Extension, w http.ResponseWriter, peerID tailcfg.StableNodeID) (*url.URL, bool) {
+	fts, err := ext.FileTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	var ft *apitype.FileTarget
+	for _, x := range fts {
+		if x.Node.StableID == peerID {
+			ft = x
+			break
+		}
+	}
+	if ft == nil {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return nil, false
+	}
+	dstURL, err := url.Parse(ft.PeerAPIURL)
+	if err != nil {
+		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+		return nil, false
+	}
+	return dstURL, true
+}
+
+// trackOutgoingFiles returns a channel on which to send the progress of
+// outgoing files, which is periodically reported until it is closed.
+func trackOutgoingFiles(ext *Extension) chan ipn.OutgoingFile {
+	outgoingFiles := make(map[string]*ipn.OutgoingFile)
+	t := time.NewTicker(1 * time.Second)
+	progressUpdates := make(chan ipn.OutgoingFile)
+
+	go func() {
+		defer t.Stop()
+		defer ext.updateOutgoingFiles(outgoingFiles)
+		for {
+			select {
+			case u, ok := <-progressUpdates:
+				if !ok {
+					return
+				}
+				outgoingFiles[u.ID] = &u
+			case <-t.C:
+				ext.updateOutgoingFiles(outgoingFiles)
+			}
+		}
+	}()
+	return progressUpdates
+}
+
+// singleFilePut puts the file outgoingFile, whose contents are read from
+// body, at putPath of the PeerAPI at dstURL, resuming any partial file the
+// peer has.
 func singleFilePut(
 	h *localapi.Handler,
 	ctx context.Context,
@@ -275,6 +475,7 @@ func singleFilePut(
 	w http.ResponseWriter,
 	body io.Reader,
 	dstURL *url.URL,
+	putPath string,
 	outgoingFile ipn.OutgoingFile,
 ) bool {
 	outgoingFile.Started = time.Now()
@@ -299,7 +500,7 @@ func singleFilePut(
 		Transport: h.LocalBackend().Dialer().PeerAPITransport(),
 		Timeout:   10 * time.Second,
 	}
-	req, err := http.NewRequestWithContext(ctx, "GET", dstURL.String()+"/v0/put/"+outgoingFile.Name, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", dstURL.String()+putPath, nil)
 	if err != nil {
 		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
 		fail()
@@ -329,7 +530,7 @@ func singleFilePut(
 		resumeDuration = time.Since(resumeStart).Round(time.Millisecond)
 	}
 
-	outReq, err := http.NewRequestWithContext(ctx, "PUT", "http://peer/v0/put/"+outgoingFile.Name, remainingBody)
+	outReq, err := http.NewRequestWithContext(ctx, "PUT", "http://peer"+putPath, remainingBody)
 	if err != nil {
 		http.Error(w, "bogus outreq", http.StatusInternalServerError)
 		fail()
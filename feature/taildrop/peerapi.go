@@ -5,6 +5,7 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@
 	"strings"
 	"time"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -21,10 +23,12 @@
 
 func init() {
 	ipnlocal.RegisterPeerAPIHandler("/v0/put/", handlePeerPut)
+	ipnlocal.RegisterPeerAPIHandler("/v0/put-dir/", handlePeerPutDir)
 }
 
 var (
-	metricPutCalls = clientmetric.NewCounter("peerapi_put")
+	metricPutCalls    = clientmetric.NewCounter("peerapi_put")
+	metricPutDirCalls = clientmetric.NewCounter("peerapi_put_dir")
 )
 
 // canPutFile reports whether h can put a file ("Taildrop") to this node.
@@ -51,6 +55,29 @@ type extensionForPut interface {
 	manager() *manager
 	hasCapFileSharing() bool
 	Clock() tstime.Clock
+
+	// userLogin returns the login name of the user with the ID uid, or
+	// the empty string if unknown.
+	userLogin(uid tailcfg.UserID) string
+}
+
+// allowsSender reports whether the receive policy of m, if any, allows the
+// peer of h to send files.
+func allowsSender(h ipnlocal.PeerAPIHandler, ext extensionForPut, m *manager) bool {
+	p := m.opts.Policy
+	if p == nil {
+		return true
+	}
+	peer := h.Peer()
+	var login string
+	if !peer.IsTagged() {
+		login = ext.userLogin(peer.User())
+	}
+	if !p.allowsSender(peer, login) {
+		h.Logf("taildrop: receive policy rejected files from %v/%v", h.RemoteAddr().Addr(), peer.ComputedName())
+		return false
+	}
+	return true
 }
 
 func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
@@ -73,6 +100,10 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
 		return
 	}
+	if !allowsSender(h, ext, taildropMgr) {
+		http.Error(w, ErrRejected.Error(), http.StatusForbidden)
+		return
+	}
 	rawPath := r.URL.EscapedPath()
 	prefix, ok := strings.CutPrefix(rawPath, "/v0/put/")
 	if !ok {
@@ -108,53 +139,211 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 				return
 			}
 			defer close()
-			for {
-				switch cs, err := next(); {
-				case err == io.EOF:
-					return
-				case err != nil:
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					h.Logf("HashPartialFile.next error: %v", err)
-					return
-				default:
-					if err := enc.Encode(cs); err != nil {
-						http.Error(w, err.Error(), http.StatusInternalServerError)
-						h.Logf("json.Encoder.Encode error: %v", err)
-						return
-					}
-				}
-			}
+			writeBlockChecksums(h, w, next)
 		}
 	case "PUT":
 		t0 := ext.Clock().Now()
 		id := clientID(h.Peer().StableID())
 
-		var offset int64
-		if rangeHdr := r.Header.Get("Range"); rangeHdr != "" {
-			ranges, ok := httphdr.ParseRange(rangeHdr)
-			if !ok || len(ranges) != 1 || ranges[0].Length != 0 {
-				http.Error(w, "invalid Range header", http.StatusBadRequest)
-				return
-			}
-			offset = ranges[0].Start
+		offset, ok := putOffset(w, r)
+		if !ok {
+			return
+		}
+		if err := taildropMgr.checkPut(baseName, offset, r.ContentLength); err != nil {
+			http.Error(w, err.Error(), putErrorCode(err))
+			return
 		}
 		n, err := taildropMgr.PutFile(clientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength)
-		switch err {
-		case nil:
-			d := ext.Clock().Since(t0).Round(time.Second / 10)
-			h.Logf("got put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
-			io.WriteString(w, "{}\n")
-		case ErrNoTaildrop:
-			http.Error(w, err.Error(), http.StatusForbidden)
-		case ErrInvalidFileName:
+		if err != nil {
+			http.Error(w, err.Error(), putErrorCode(err))
+			return
+		}
+		d := ext.Clock().Since(t0).Round(time.Second / 10)
+		h.Logf("got put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
+		io.WriteString(w, "{}\n")
+	default:
+		http.Error(w, "expected method GET or PUT", http.StatusMethodNotAllowed)
+	}
+}
+
+// putDirResponse is the JSON response to the requests of a directory
+// transfer that start and finish it.
+type putDirResponse struct {
+	// Have are the paths of the files that were already received intact,
+	// in response to the manifest.
+	Have []string `json:"have,omitempty"`
+
+	// Name is the base name the directory was received as, once finished.
+	Name string `json:"name,omitempty"`
+}
+
+func handlePeerPutDir(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "miswired", http.StatusInternalServerError)
+		return
+	}
+	handlePeerPutDirWithBackend(h, ext, w, r)
+}
+
+// handlePeerPutDirWithBackend serves directory transfers:
+//
+//   - PUT /v0/put-dir/:escaped-dirname with a JSON array of
+//     [apitype.FileManifestEntry] starts (or resumes) the transfer.
+//   - GET and PUT /v0/put-dir/:escaped-dirname/:escaped-path get the block
+//     hashes of, and put, the files of the directory, like /v0/put/.
+//   - POST /v0/put-dir/:escaped-dirname checks the received files against
+//     the manifest and finishes the transfer.
+//
+// The :escaped-path is the slash-separated path of a file in the directory,
+// with each of its elements path-escaped.
+func handlePeerPutDirWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
+	taildropMgr := ext.manager()
+	if taildropMgr == nil {
+		h.Logf("taildrop: no taildrop manager")
+		http.Error(w, "failed to get taildrop manager", http.StatusInternalServerError)
+		return
+	}
+	if !canPutFile(h) || !ext.hasCapFileSharing() {
+		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
+		return
+	}
+	if !allowsSender(h, ext, taildropMgr) {
+		http.Error(w, ErrRejected.Error(), http.StatusForbidden)
+		return
+	}
+	suffix, ok := strings.CutPrefix(r.URL.EscapedPath(), "/v0/put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured internals", http.StatusForbidden)
+		return
+	}
+	rootEscaped, relEscaped, isFile := strings.Cut(suffix, "/")
+	root, err := url.PathUnescape(rootEscaped)
+	if err != nil || root == "" {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	var relPath string
+	if isFile {
+		if relPath, err = unescapeRelPath(relEscaped); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-		case ErrFileExists:
-			http.Error(w, err.Error(), http.StatusConflict)
-		default:
+			return
+		}
+	}
+	id := clientID(h.Peer().StableID())
+	enc := json.NewEncoder(w)
+
+	switch {
+	case !isFile && r.Method == "PUT":
+		metricPutDirCalls.Add(1)
+		var manifest []apitype.FileManifestEntry
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxManifestSize)).Decode(&manifest); err != nil {
+			http.Error(w, fmt.Sprintf("%v: %v", ErrInvalidManifest, err), http.StatusBadRequest)
+			return
+		}
+		if err := taildropMgr.checkManifest(manifest); err != nil {
+			http.Error(w, err.Error(), putErrorCode(err))
+			return
+		}
+		have, err := taildropMgr.StartDir(id, root, manifest)
+		if err != nil {
+			http.Error(w, err.Error(), putErrorCode(err))
+			return
+		}
+		enc.Encode(putDirResponse{Have: have})
+	case !isFile && r.Method == "POST":
+		name, err := taildropMgr.FinishDir(id, root)
+		if err != nil {
+			http.Error(w, err.Error(), putErrorCode(err))
+			return
+		}
+		h.Logf("got put of a directory from %v/%v", h.RemoteAddr().Addr(), h.Peer().ComputedName())
+		enc.Encode(putDirResponse{Name: name})
+	case isFile && r.Method == "GET":
+		next, close, err := taildropMgr.HashPartialDirFile(id, root, relPath)
+		if err != nil {
+			http.Error(w, err.Error(), putErrorCode(err))
+			return
+		}
+		defer close()
+		writeBlockChecksums(h, w, next)
+	case isFile && r.Method == "PUT":
+		metricPutCalls.Add(1)
+		offset, ok := putOffset(w, r)
+		if !ok {
+			return
+		}
+		// The manifest was checked against the receive policy, and
+		// PutDirFile checks the file against the manifest.
+		if _, err := taildropMgr.PutDirFile(id, root, relPath, r.Body, offset, r.ContentLength); err != nil {
+			http.Error(w, err.Error(), putErrorCode(err))
+			return
+		}
+		io.WriteString(w, "{}\n")
+	case isFile:
+		http.Error(w, "expected method GET or PUT", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "expected method PUT or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// maxManifestSize is the maximum size of the JSON manifest of a directory
+// transfer.
+const maxManifestSize = 64 << 20
+
+// writeBlockChecksums streams the block checksums returned by next to w.
+func writeBlockChecksums(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, next func() (blockChecksum, error)) {
+	enc := json.NewEncoder(w)
+	for {
+		switch cs, err := next(); {
+		case err == io.EOF:
+			return
+		case err != nil:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			h.Logf("HashPartialFile.next error: %v", err)
+			return
+		default:
+			if err := enc.Encode(cs); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				h.Logf("json.Encoder.Encode error: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// putOffset returns the offset to resume a put at, from the Range header
+// of r. If the header is invalid, it writes an error to w and returns
+// false.
+func putOffset(w http.ResponseWriter, r *http.Request) (offset int64, ok bool) {
+	if rangeHdr := r.Header.Get("Range"); rangeHdr != "" {
+		ranges, ok := httphdr.ParseRange(rangeHdr)
+		if !ok || len(ranges) != 1 || ranges[0].Length != 0 {
+			http.Error(w, "invalid Range header", http.StatusBadRequest)
+			return 0, false
 		}
+		offset = ranges[0].Start
+	}
+	return offset, true
+}
+
+// putErrorCode returns the HTTP status code of the error err of a put.
+func putErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrNoTaildrop), errors.Is(err, ErrRejected):
+		return http.StatusForbidden
+	case errors.Is(err, ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalidFileName), errors.Is(err, ErrInvalidManifest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrFileExists), errors.Is(err, ErrNoManifest):
+		return http.StatusConflict
+	case errors.Is(err, ErrChecksumMismatch), errors.Is(err, ErrIncompleteDir):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrDirNotSupported):
+		return http.StatusNotImplemented
 	default:
-		http.Error(w, "expected method GET or PUT", http.StatusMethodNotAllowed)
+		return http.StatusInternalServerError
 	}
 }
 
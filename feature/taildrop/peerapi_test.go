@@ -54,6 +54,7 @@ type fakeExtension struct {
 	capFileSharing bool
 	clock          tstime.Clock
 	taildrop       *manager
+	logins         map[tailcfg.UserID]string
 }
 
 func (lb *fakeExtension) manager() *manager {
@@ -63,6 +64,9 @@ func (lb *fakeExtension) Clock() tstime.Clock { return lb.clock }
 func (lb *fakeExtension) hasCapFileSharing() bool {
 	return lb.capFileSharing
 }
+func (lb *fakeExtension) userLogin(uid tailcfg.UserID) string {
+	return lb.logins[uid]
+}
 
 type peerAPITestEnv struct {
 	taildrop *manager
@@ -0,0 +1,422 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/tailcfg"
+)
+
+// receivePolicyPath is the path of the JSON file of the receive policy, if
+// any. See [receivePolicy] for its format.
+var receivePolicyPath = envknob.RegisterString("TS_TAILDROP_RECEIVE_POLICY")
+
+// defaultHookTimeout is how long the hook of a receive policy may run if
+// the policy doesn't say.
+const defaultHookTimeout = 5 * time.Minute
+
+// receivePolicy limits the files this node accepts with Taildrop, beyond
+// the tailnet policy's file sharing capabilities. It is read at startup
+// from the JSON file named by the TS_TAILDROP_RECEIVE_POLICY environment
+// variable, with the exported fields as its keys. A zero field doesn't
+// limit anything.
+//
+// The limits are enforced by the PeerAPI handlers for puts, before any
+// data is written where possible.
+type receivePolicy struct {
+	// Users are the login names of the users whose untagged nodes may
+	// send files, and Tags the ACL tags of the tagged nodes that may.
+	// If both are empty, any peer may send files.
+	Users []string `json:",omitempty"`
+	Tags  []string `json:",omitempty"`
+
+	// MaxFileSize is the maximum size of a received file, in bytes.
+	MaxFileSize int64 `json:",omitempty"`
+
+	// MaxPendingBytes is the maximum total size, in bytes, of the files
+	// in the Taildrop directory, including those being received. In
+	// direct file mode, that is every file in the directory.
+	MaxPendingBytes int64 `json:",omitempty"`
+
+	// Extensions are the file name extensions, such as ".pdf" or
+	// ".tar.gz", of the files that may be received, matched
+	// case-insensitively. For a directory, each file in it must match.
+	Extensions []string `json:",omitempty"`
+
+	// Hook is a command and its arguments, run on every received file
+	// before it is moved into place, with the path of the file appended
+	// as the last argument. The TS_TAILDROP_NAME environment variable is
+	// set to the name the file is being received as, a slash-separated
+	// path for the files of a directory, and TS_TAILDROP_SENDER to the
+	// stable ID of the node that sent it.
+	//
+	// If the command fails, or runs for longer than HookTimeout, the file
+	// is rejected, and moved to QuarantineDir or else deleted. The hook
+	// is not run for files received with Android's Storage Access
+	// Framework.
+	Hook []string `json:",omitempty"`
+
+	// HookTimeout is how long the hook may run, in the format of
+	// [time.ParseDuration]. The default is 5m.
+	HookTimeout string `json:",omitempty"`
+
+	// QuarantineDir is the directory files rejected by the hook are
+	// moved to. It must be on the same file system as the Taildrop
+	// directory.
+	QuarantineDir string `json:",omitempty"`
+
+	hookTimeout time.Duration
+	rejectAll   bool // the policy file couldn't be loaded
+}
+
+// rejectAllPolicy is the policy in effect if the policy file can't be
+// loaded, so that a mistake in it doesn't open up the node.
+var rejectAllPolicy = &receivePolicy{rejectAll: true}
+
+// loadReceivePolicy reads and checks the receive policy file at path.
+func loadReceivePolicy(path string) (*receivePolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := new(receivePolicy)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if p.MaxFileSize < 0 || p.MaxPendingBytes < 0 {
+		return nil, fmt.Errorf("%s: negative size limit", path)
+	}
+	for _, tag := range p.Tags {
+		if !strings.HasPrefix(tag, "tag:") {
+			return nil, fmt.Errorf("%s: tag %q must start with \"tag:\"", path, tag)
+		}
+	}
+	for _, ext := range p.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			return nil, fmt.Errorf("%s: extension %q must start with \".\"", path, ext)
+		}
+	}
+	p.hookTimeout = defaultHookTimeout
+	if p.HookTimeout != "" {
+		if p.hookTimeout, err = time.ParseDuration(p.HookTimeout); err != nil || p.hookTimeout <= 0 {
+			return nil, fmt.Errorf("%s: invalid hook timeout %q", path, p.HookTimeout)
+		}
+	}
+	if p.QuarantineDir != "" && len(p.Hook) == 0 {
+		return nil, fmt.Errorf("%s: a quarantine directory requires a hook", path)
+	}
+	if p.QuarantineDir != "" && !filepath.IsAbs(p.QuarantineDir) {
+		return nil, fmt.Errorf("%s: quarantine directory %q must be an absolute path", path, p.QuarantineDir)
+	}
+	return p, nil
+}
+
+// allowsSender reports whether p allows the peer, whose user has the login
+// name login if it is untagged, to send files.
+func (p *receivePolicy) allowsSender(peer tailcfg.NodeView, login string) bool {
+	switch {
+	case p == nil:
+		return true
+	case p.rejectAll:
+		return false
+	case len(p.Users) == 0 && len(p.Tags) == 0:
+		return true
+	case peer.IsTagged():
+		for _, tag := range peer.Tags().All() {
+			if slices.Contains(p.Tags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return login != "" && slices.ContainsFunc(p.Users, func(u string) bool {
+		return strings.EqualFold(u, login)
+	})
+}
+
+// allowsName reports whether p allows a file with the name, a base name or
+// the slash-separated path of a file in a directory, to be received.
+func (p *receivePolicy) allowsName(name string) bool {
+	if p == nil || len(p.Extensions) == 0 {
+		return true
+	}
+	base := strings.ToLower(path.Base(name))
+	return slices.ContainsFunc(p.Extensions, func(ext string) bool {
+		return strings.HasSuffix(base, strings.ToLower(ext)) && len(base) > len(ext)
+	})
+}
+
+// checkPut returns an error if the receive policy doesn't allow the file
+// with the name, a base name or the slash-separated path of a file in a
+// directory, to be put. The length is the length of the data to be put
+// at offset, or negative if unknown.
+func (m *manager) checkPut(name string, offset, length int64) error {
+	p := m.opts.Policy
+	switch {
+	case p == nil:
+		return nil
+	case p.rejectAll:
+		return ErrRejected
+	case !p.allowsName(name):
+		return fmt.Errorf("%w: file type not allowed", ErrRejected)
+	case p.MaxFileSize > 0 && length >= 0 && offset+length > p.MaxFileSize:
+		return ErrFileTooLarge
+	}
+	return m.checkPending(max(length, 0))
+}
+
+// checkManifest returns an error if the receive policy doesn't allow the
+// directory transfer of the files in manifest.
+func (m *manager) checkManifest(manifest []apitype.FileManifestEntry) error {
+	p := m.opts.Policy
+	if p == nil {
+		return nil
+	}
+	if p.rejectAll {
+		return ErrRejected
+	}
+	var total int64
+	for _, f := range manifest {
+		if !p.allowsName(f.Path) {
+			return fmt.Errorf("%w: file type not allowed", ErrRejected)
+		}
+		if p.MaxFileSize > 0 && f.Size > p.MaxFileSize {
+			return ErrFileTooLarge
+		}
+		total += f.Size
+	}
+	return m.checkPending(total)
+}
+
+// pendingRecountInterval is how often the bytes pending in [Handler.Dir]
+// are recounted, to account for files removed from it, including by the
+// user in direct file mode. In between, the bytes received are added up.
+const pendingRecountInterval = time.Minute
+
+// checkPending returns [ErrFileTooLarge] if receiving n more bytes would
+// exceed the receive policy's limit on pending bytes.
+func (m *manager) checkPending(n int64) error {
+	p := m.opts.Policy
+	if p == nil || p.MaxPendingBytes == 0 {
+		return nil
+	}
+	if n > p.MaxPendingBytes {
+		return ErrFileTooLarge
+	}
+	pending, err := m.pendingBytes()
+	if err != nil {
+		return m.redactAndLogError("Walk", err)
+	}
+	if pending+n > p.MaxPendingBytes {
+		m.opts.Logf("put rejected: %d bytes pending", pending)
+		return ErrFileTooLarge
+	}
+	return nil
+}
+
+// pendingBytes returns the total size of the files in [Handler.Dir], as last
+// counted by countPendingBytes, at most pendingRecountInterval ago, plus the
+// bytes received since.
+func (m *manager) pendingBytes() (int64, error) {
+	m.pendingMu.Lock()
+	now := m.opts.Clock.Now()
+	if !m.pendingCounted.IsZero() && now.Sub(m.pendingCounted) < pendingRecountInterval {
+		defer m.pendingMu.Unlock()
+		return m.pending, nil
+	}
+	m.pendingCounted = now // so that concurrent puts don't also count
+	received := m.bytesReceived
+	m.pendingMu.Unlock()
+
+	n, err := m.countPendingBytes()
+
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	if err != nil {
+		m.pendingCounted = time.Time{}
+		return 0, err
+	}
+	// Bytes received while counting may be counted twice, which errs on
+	// the side of the limit.
+	m.pending = n + m.bytesReceived - received
+	return m.pending, nil
+}
+
+// addPending adds n bytes received to the pending bytes, and reports
+// whether they are still within the receive policy's limit.
+func (m *manager) addPending(n int64) bool {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	m.pending += n
+	m.bytesReceived += n
+	return m.pending <= m.opts.Policy.MaxPendingBytes
+}
+
+// pendingBudget returns how many more bytes may be received within the
+// receive policy's limit on pending bytes.
+func (m *manager) pendingBudget() int64 {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	return m.opts.Policy.MaxPendingBytes - m.pending
+}
+
+// countPendingBytes returns the total size of the files in [Handler.Dir],
+// including partial files and those of directories, but not those marked
+// as deleted.
+func (m *manager) countPendingBytes() (n int64, err error) {
+	err = filepath.WalkDir(m.opts.Dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if !de.Type().IsRegular() || strings.HasSuffix(de.Name(), deletedSuffix) {
+			return nil
+		}
+		if _, err := os.Stat(p + deletedSuffix); err == nil {
+			return nil
+		}
+		if fi, err := de.Info(); err == nil {
+			n += fi.Size()
+		}
+		return nil
+	})
+	return n, err
+}
+
+// limitPut returns r, limited for a put starting at offset of a file whose
+// size is known to be size, or negative if unknown, to that size, the
+// receive policy's maximum file size, and the bytes that may still be
+// pending. Reading past the limit fails with [ErrFileTooLarge].
+func (m *manager) limitPut(r io.Reader, offset, size int64) io.Reader {
+	l := &limitedReader{r: r, n: math.MaxInt64}
+	if size >= 0 {
+		l.n = size - offset
+	}
+	if p := m.opts.Policy; p != nil {
+		if p.MaxFileSize > 0 {
+			l.n = min(l.n, p.MaxFileSize-offset)
+		}
+		if p.MaxPendingBytes > 0 {
+			l.pending = m
+		}
+	}
+	if l.n == math.MaxInt64 && l.pending == nil {
+		return r
+	}
+	return l
+}
+
+// limitedReader is like [io.LimitedReader], but fails with
+// [ErrFileTooLarge] rather than EOF once more than n bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64 // bytes left
+
+	// pending, if non-nil, is the manager to count the bytes read as
+	// pending with, also failing once they exceed its limit.
+	pending *manager
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	left := l.n
+	if l.pending != nil {
+		left = min(left, l.pending.pendingBudget())
+	}
+	if left < 0 {
+		return 0, ErrFileTooLarge
+	}
+	if int64(len(p))-1 > left {
+		p = p[:left+1] // one more, to tell a file at the limit from one over it
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.pending != nil && !l.pending.addPending(int64(n)) {
+		return n, ErrFileTooLarge
+	}
+	if l.n < 0 {
+		return n, ErrFileTooLarge
+	}
+	return n, err
+}
+
+// runHook runs the receive policy's hook, if any, on the file received
+// from id as name, at partialPath. If the hook fails, the file is moved to
+// the quarantine directory or removed, and runHook returns an error
+// wrapping [ErrRejected].
+func (m *manager) runHook(id clientID, name, partialPath string) error {
+	p := m.opts.Policy
+	if p == nil || len(p.Hook) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.Hook[0], append(p.Hook[1:], partialPath)...)
+	cmd.Env = append(os.Environ(),
+		"TS_TAILDROP_NAME="+name,
+		"TS_TAILDROP_SENDER="+string(id),
+	)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %v", p.hookTimeout)
+	}
+	m.opts.Logf("receive hook rejected %q: %v; output: %q", redactString(name), err, bytes.TrimSpace(out))
+
+	if p.QuarantineDir != "" {
+		if dst, err := m.quarantine(partialPath, path.Base(name)); err != nil {
+			m.opts.Logf("quarantine error: %v", redactError(err))
+		} else {
+			m.opts.Logf("quarantined %q as %q", redactString(name), redactString(dst))
+			return fmt.Errorf("%w: quarantined by receive hook", ErrRejected)
+		}
+	}
+	if err := os.Remove(partialPath); err != nil {
+		return m.redactAndLogError("Remove", err)
+	}
+	return fmt.Errorf("%w: by receive hook", ErrRejected)
+}
+
+// quarantine moves the file at partialPath to the receive policy's
+// quarantine directory as baseName, or a name derived from it if taken,
+// and returns its new path.
+func (m *manager) quarantine(partialPath, baseName string) (string, error) {
+	dir := m.opts.Policy.QuarantineDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	m.renameMu.Lock()
+	defer m.renameMu.Unlock()
+	const maxRetries = 10
+	for range maxRetries {
+		dst := filepath.Join(dir, baseName)
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			return dst, os.Rename(partialPath, dst)
+		}
+		baseName = nextFilename(baseName)
+	}
+	return "", errors.New("too many retries trying to quarantine a file")
+}
@@ -0,0 +1,368 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+)
+
+func TestLoadReceivePolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		json    string
+		wantErr string // or empty
+	}{
+		{name: "empty", json: `{}`},
+		{name: "full", json: `{"Users":["alice@example.com"],"Tags":["tag:ci"],"MaxFileSize":100,"MaxPendingBytes":1000,"Extensions":[".pdf"],"Hook":["scan"],"HookTimeout":"30s","QuarantineDir":"/var/quarantine"}`},
+		{name: "unknown-field", json: `{"MaxSize":1}`, wantErr: "unknown field"},
+		{name: "negative-size", json: `{"MaxFileSize":-1}`, wantErr: "negative size"},
+		{name: "bad-tag", json: `{"Tags":["ci"]}`, wantErr: "must start with \"tag:\""},
+		{name: "bad-extension", json: `{"Extensions":["pdf"]}`, wantErr: "must start with \".\""},
+		{name: "bad-timeout", json: `{"Hook":["scan"],"HookTimeout":"soon"}`, wantErr: "invalid hook timeout"},
+		{name: "quarantine-without-hook", json: `{"QuarantineDir":"/var/quarantine"}`, wantErr: "requires a hook"},
+		{name: "relative-quarantine", json: `{"Hook":["scan"],"QuarantineDir":"quarantine"}`, wantErr: "absolute path"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			p, err := loadReceivePolicy(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadReceivePolicy = %v; want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.hookTimeout <= 0 {
+				t.Errorf("hookTimeout = %v; want positive", p.hookTimeout)
+			}
+		})
+	}
+}
+
+func TestReceivePolicyAllows(t *testing.T) {
+	user := (&tailcfg.Node{User: 1}).View()
+	tagged := (&tailcfg.Node{Tags: []string{"tag:ci", "tag:prod"}}).View()
+
+	var nilPolicy *receivePolicy
+	if !nilPolicy.allowsSender(user, "") || !nilPolicy.allowsName("x.exe") {
+		t.Error("nil policy does not allow everything")
+	}
+	if rejectAllPolicy.allowsSender(user, "alice@example.com") {
+		t.Error("rejectAllPolicy allows a sender")
+	}
+
+	p := &receivePolicy{
+		Users:      []string{"alice@example.com"},
+		Tags:       []string{"tag:prod"},
+		Extensions: []string{".pdf", ".tar.gz"},
+	}
+	for _, tt := range []struct {
+		peer  tailcfg.NodeView
+		login string
+		want  bool
+	}{
+		{user, "alice@example.com", true},
+		{user, "Alice@Example.com", true},
+		{user, "bob@example.com", false},
+		{user, "", false},
+		{tagged, "", true},
+		{(&tailcfg.Node{Tags: []string{"tag:ci"}}).View(), "", false},
+	} {
+		if got := p.allowsSender(tt.peer, tt.login); got != tt.want {
+			t.Errorf("allowsSender(%v, %q) = %v; want %v", tt.peer.Tags(), tt.login, got, tt.want)
+		}
+	}
+	for name, want := range map[string]bool{
+		"report.pdf":         true,
+		"REPORT.PDF":         true,
+		"dir/sub/report.pdf": true,
+		"logs.tar.gz":        true,
+		"logs.gz":            false,
+		"report.pdf.exe":     false,
+		".pdf":               false,
+		"pdf":                false,
+	} {
+		if got := p.allowsName(name); got != want {
+			t.Errorf("allowsName(%q) = %v; want %v", name, got, want)
+		}
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	for _, tt := range []struct {
+		size, limit int
+		wantErr     bool
+	}{
+		{10, 10, false},
+		{10, 11, false},
+		{11, 10, true},
+		{0, 0, false},
+		{1, 0, true},
+	} {
+		var dst bytes.Buffer
+		_, err := dst.ReadFrom(&limitedReader{r: iotest.HalfReader(bytes.NewReader(make([]byte, tt.size))), n: int64(tt.limit)})
+		if gotErr := err == ErrFileTooLarge; gotErr != tt.wantErr || (err != nil && !gotErr) {
+			t.Errorf("reading %d bytes limited to %d: %v", tt.size, tt.limit, err)
+		}
+	}
+}
+
+func TestPendingBytes(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	dir := t.TempDir()
+	m := managerOptions{
+		Logf:   t.Logf,
+		Clock:  tstime.DefaultClock{Clock: clock},
+		Dir:    dir,
+		Policy: &receivePolicy{MaxPendingBytes: 10},
+	}.New()
+	defer m.Shutdown()
+	existing := filepath.Join(dir, "existing.txt")
+	if err := os.WriteFile(existing, []byte("world"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	checkPending := func(want int64) {
+		t.Helper()
+		if got, err := m.pendingBytes(); got != want || err != nil {
+			t.Errorf("pendingBytes = %d, %v; want %d", got, err, want)
+		}
+	}
+	checkPending(5)
+
+	// Bytes received are added up, and limited, without recounting.
+	os.Remove(existing)
+	var dst bytes.Buffer
+	if _, err := dst.ReadFrom(m.limitPut(strings.NewReader("hello"), 0, -1)); err != nil {
+		t.Fatal(err)
+	}
+	checkPending(10)
+	if _, err := dst.ReadFrom(m.limitPut(strings.NewReader("!"), 0, -1)); err != ErrFileTooLarge {
+		t.Errorf("reading past the pending limit: %v; want %v", err, ErrFileTooLarge)
+	}
+
+	// Removed files are accounted for once recounted.
+	clock.Advance(pendingRecountInterval)
+	checkPending(0)
+}
+
+func TestHandlePeerPutPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook uses sh")
+	}
+	const login = "alice@example.com"
+	// The hook rejects files containing "EICAR".
+	hook := []string{"sh", "-c", `if grep -q EICAR "$0"; then echo infected; exit 1; fi`}
+
+	put := func(name, body string, contentLength int64) *http.Request {
+		req := httptest.NewRequest("PUT", "http://100.100.100.101:123/v0/put/"+name, strings.NewReader(body))
+		req.ContentLength = contentLength
+		return req
+	}
+	tests := []struct {
+		name       string
+		policy     *receivePolicy
+		existing   string // contents of a file already waiting, if any
+		req        *http.Request
+		wantStatus int
+		wantFile   string // contents of the received file foo.txt, if any
+		wantQuar   string // contents of the quarantined file foo.txt, if any
+	}{
+		{
+			name:       "no-policy",
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusOK,
+			wantFile:   "hello",
+		},
+		{
+			name:       "reject-all",
+			policy:     rejectAllPolicy,
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "allowed-user",
+			policy:     &receivePolicy{Users: []string{login}},
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusOK,
+			wantFile:   "hello",
+		},
+		{
+			name:       "disallowed-user",
+			policy:     &receivePolicy{Users: []string{"bob@example.com"}},
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "disallowed-extension",
+			policy:     &receivePolicy{Extensions: []string{".pdf"}},
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "file-at-limit",
+			policy:     &receivePolicy{MaxFileSize: 5},
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusOK,
+			wantFile:   "hello",
+		},
+		{
+			name:       "file-too-large",
+			policy:     &receivePolicy{MaxFileSize: 4},
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "file-too-large-unknown-length",
+			policy:     &receivePolicy{MaxFileSize: 4},
+			req:        put("foo.txt", "hello", -1),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "pending-within-limit",
+			policy:     &receivePolicy{MaxPendingBytes: 10},
+			existing:   "world",
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusOK,
+			wantFile:   "hello",
+		},
+		{
+			name:       "too-many-pending",
+			policy:     &receivePolicy{MaxPendingBytes: 9},
+			existing:   "world",
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "too-many-pending-unknown-length",
+			policy:     &receivePolicy{MaxPendingBytes: 9},
+			existing:   "world",
+			req:        put("foo.txt", "hello", -1),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "hook-accepts",
+			policy:     &receivePolicy{Hook: hook, hookTimeout: defaultHookTimeout},
+			req:        put("foo.txt", "hello", 5),
+			wantStatus: http.StatusOK,
+			wantFile:   "hello",
+		},
+		{
+			name:       "hook-rejects",
+			policy:     &receivePolicy{Hook: hook, hookTimeout: defaultHookTimeout},
+			req:        put("foo.txt", "EICAR", 5),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "hook-quarantines",
+			policy:     &receivePolicy{Hook: hook, hookTimeout: defaultHookTimeout, QuarantineDir: "quarantine"},
+			req:        put("foo.txt", "EICAR", 5),
+			wantStatus: http.StatusForbidden,
+			wantQuar:   "EICAR",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.policy != nil && tt.policy.QuarantineDir != "" {
+				p := *tt.policy
+				p.QuarantineDir = filepath.Join(t.TempDir(), p.QuarantineDir)
+				tt.policy = &p
+			}
+			if tt.existing != "" {
+				if err := os.WriteFile(filepath.Join(dir, "existing.txt"), []byte(tt.existing), 0o666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := managerOptions{Logf: t.Logf, Dir: dir, Policy: tt.policy}.New()
+			defer m.Shutdown()
+			ext := &fakeExtension{
+				logf:           t.Logf,
+				capFileSharing: true,
+				clock:          &tstest.Clock{},
+				taildrop:       m,
+				logins:         map[tailcfg.UserID]string{1: login},
+			}
+			ph := &peerAPIHandler{
+				isSelf:   true,
+				selfNode: (&tailcfg.Node{User: 1}).View(),
+				peerNode: (&tailcfg.Node{User: 1}).View(),
+			}
+			rr := httptest.NewRecorder()
+			handlePeerPutWithBackend(ph, ext, rr, tt.req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d; body: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			got, err := os.ReadFile(filepath.Join(dir, "foo.txt"))
+			if tt.wantFile == "" {
+				if err == nil {
+					t.Errorf("foo.txt was received")
+				}
+			} else if string(got) != tt.wantFile {
+				t.Errorf("foo.txt = %q, %v; want %q", got, err, tt.wantFile)
+			}
+			wfs, err := m.WaitingFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, wf := range wfs {
+				if wf.Name == "foo.txt" && tt.wantFile == "" {
+					t.Errorf("foo.txt is waiting")
+				}
+			}
+			if tt.wantQuar != "" {
+				got, err := os.ReadFile(filepath.Join(tt.policy.QuarantineDir, "foo.txt"))
+				if err != nil || string(got) != tt.wantQuar {
+					t.Errorf("quarantined foo.txt = %q, %v; want %q", got, err, tt.wantQuar)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	m := managerOptions{
+		Logf: t.Logf,
+		Dir:  t.TempDir(),
+		Policy: &receivePolicy{
+			MaxFileSize:     10,
+			MaxPendingBytes: 15,
+			Extensions:      []string{".txt"},
+		},
+	}.New()
+	defer m.Shutdown()
+
+	for _, tt := range []struct {
+		files map[string]string
+		want  error
+	}{
+		{map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"}, nil},
+		{map[string]string{"a.txt": "alpha", "sub/b.exe": "bravo"}, ErrRejected},
+		{map[string]string{"a.txt": "alpha-bravo"}, ErrFileTooLarge},
+		{map[string]string{"a.txt": "alpha", "b.txt": "bravo", "c.txt": "charlie"}, ErrFileTooLarge},
+	} {
+		if err := m.checkManifest(manifestOf(tt.files)); !errors.Is(err, tt.want) {
+			t.Errorf("checkManifest(%v) = %v; want %v", tt.files, err, tt.want)
+		}
+	}
+}
@@ -76,14 +76,35 @@ func (m *manager) HashPartialFile(id clientID, baseName string) (next func() (bl
 	if m == nil || m.opts.Dir == "" {
 		return nil, nil, ErrNoTaildrop
 	}
-	noopNext := func() (blockChecksum, error) { return blockChecksum{}, io.EOF }
-	noopClose := func() error { return nil }
-
 	dstFile, err := joinDir(m.opts.Dir, baseName)
 	if err != nil {
 		return nil, nil, err
 	}
-	f, err := os.Open(dstFile + id.partialSuffix())
+	return hashPartialFile(dstFile + id.partialSuffix())
+}
+
+// HashPartialDirFile is like [manager.HashPartialFile], for the file at the
+// slash-separated path relPath of the directory transfer root.
+func (m *manager) HashPartialDirFile(id clientID, root, relPath string) (next func() (blockChecksum, error), close func() error, err error) {
+	if m == nil || m.opts.Dir == "" {
+		return nil, nil, ErrNoTaildrop
+	}
+	dstDir, err := joinDir(m.opts.Dir, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	dstFile, err := joinRelPath(dstDir+id.partialSuffix(), relPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hashPartialFile(dstFile + id.partialSuffix())
+}
+
+func hashPartialFile(partialPath string) (next func() (blockChecksum, error), close func() error, err error) {
+	noopNext := func() (blockChecksum, error) { return blockChecksum{}, io.EOF }
+	noopClose := func() error { return nil }
+
+	f, err := os.Open(partialPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return noopNext, noopClose, nil
@@ -38,6 +38,12 @@ func (m *manager) HasFilesWaiting() (has bool) {
 	// Check whether there is at least one one waiting file.
 	err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
 		name := de.Name()
+		if de.IsDir() && !isPartialOrDeleted(name) {
+			// A directory received by a directory transfer.
+			files, _ := m.waitingDirFiles(name)
+			has = len(files) > 0
+			return !has
+		}
 		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
 			return true
 		}
@@ -59,7 +65,8 @@ func (m *manager) HasFilesWaiting() (has bool) {
 }
 
 // WaitingFiles returns the list of files that have been sent by a
-// peer that are waiting in [Handler.Dir].
+// peer that are waiting in [Handler.Dir]. The files of directories sent
+// by directory transfers are named by their slash-separated paths.
 // This always returns nil when [Handler.DirectFileMode] is false.
 func (m *manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 	if m == nil || m.opts.Dir == "" {
@@ -68,8 +75,16 @@ func (m *manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 	if m.opts.DirectFileMode {
 		return nil, nil
 	}
+	var dirErr error
 	if err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
 		name := de.Name()
+		if de.IsDir() && !isPartialOrDeleted(name) {
+			// A directory received by a directory transfer.
+			var files []apitype.WaitingFile
+			files, dirErr = m.waitingDirFiles(name)
+			ret = append(ret, files...)
+			return dirErr == nil
+		}
 		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
 			return true
 		}
@@ -88,11 +103,17 @@ func (m *manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 	}); err != nil {
 		return nil, redactError(err)
 	}
+	if dirErr != nil {
+		return nil, redactError(dirErr)
+	}
 	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
 	return ret, nil
 }
 
 // DeleteFile deletes a file of the given baseName from [Handler.Dir].
+// The baseName may also be the slash-separated path of a file in a received
+// directory, as listed by WaitingFiles, whose directories are deleted once
+// empty.
 // This method is only allowed when [Handler.DirectFileMode] is false.
 func (m *manager) DeleteFile(baseName string) error {
 	if m == nil || m.opts.Dir == "" {
@@ -101,7 +122,7 @@ func (m *manager) DeleteFile(baseName string) error {
 	if m.opts.DirectFileMode {
 		return errors.New("deletes not allowed in direct mode")
 	}
-	path, err := joinDir(m.opts.Dir, baseName)
+	path, err := joinRelPath(m.opts.Dir, baseName)
 	if err != nil {
 		return err
 	}
@@ -137,6 +158,7 @@ func (m *manager) DeleteFile(baseName string) error {
 			logf("peerapi: failed to DeleteFile: %v", err)
 			return err
 		}
+		m.removeEmptyDirs(baseName)
 		return nil
 	}
 }
@@ -150,6 +172,7 @@ func touchFile(path string) error {
 }
 
 // OpenFile opens a file of the given baseName from [Handler.Dir].
+// As for DeleteFile, the baseName may be the path of a file in a directory.
 // This method is only allowed when [Handler.DirectFileMode] is false.
 func (m *manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err error) {
 	if m == nil || m.opts.Dir == "" {
@@ -158,7 +181,7 @@ func (m *manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err e
 	if m.opts.DirectFileMode {
 		return nil, 0, errors.New("opens not allowed in direct mode")
 	}
-	path, err := joinDir(m.opts.Dir, baseName)
+	path, err := joinRelPath(m.opts.Dir, baseName)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -96,10 +96,24 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 		// (the actual directory is managed by SAF).
 		dstPath = baseName
 	}
-	m.deleter.Remove(filepath.Base(dstPath)) // avoid deleting the partial file while receiving
+	return m.putFile(id, baseName, dstPath, r, offset, length, nil, manifestFile{})
+}
+
+// putFile stores the file named name, whose contents are read from r, at
+// dstPath. If dir is non-nil, the file is one of the directory transfer
+// dir, and must match want, its entry in the manifest. The other arguments
+// and the result are as for [manager.PutFile].
+func (m *manager) putFile(id clientID, name, dstPath string, r io.Reader, offset, length int64, dir *incomingDir, want manifestFile) (_ int64, err error) {
+	// The name to schedule for deletion if the transfer is abandoned:
+	// the partial file, or the partial directory of a directory transfer.
+	deleteName := filepath.Base(dstPath)
+	if dir != nil {
+		deleteName = filepath.Base(dir.partialPath)
+	}
+	m.deleter.Remove(deleteName) // avoid deleting the partial file while receiving
 
 	// Check whether there is an in-progress transfer for the file.
-	partialFileKey := incomingFileKey{id, baseName}
+	partialFileKey := incomingFileKey{id, name}
 	inFile, loaded := m.incomingFiles.LoadOrInit(partialFileKey, func() *incomingFile {
 		return &incomingFile{
 			clock:          m.opts.Clock,
@@ -114,13 +128,17 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	defer m.incomingFiles.Delete(partialFileKey)
 
 	// Open writer & populate inFile paths
-	wc, partialPath, err := m.openWriterAndPaths(id, m.opts.Mode, inFile, baseName, dstPath, offset)
+	wc, partialPath, err := m.openWriterAndPaths(id, m.opts.Mode, inFile, name, dstPath, offset)
 	if err != nil {
 		return 0, m.redactAndLogError("Create", err)
 	}
 	defer func() {
 		wc.Close()
-		if err != nil {
+		if dir != nil {
+			// Until the directory transfer is finished, it may be
+			// abandoned at any point.
+			m.deleter.Insert(deleteName)
+		} else if err != nil {
 			m.deleter.Insert(filepath.Base(partialPath)) // mark partial file for eventual deletion
 		}
 	}()
@@ -137,7 +155,11 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	}
 
 	// Copy the contents of the file to the writer.
-	copyLength, err := io.Copy(wc, r)
+	size := int64(-1)
+	if dir != nil {
+		size = want.size
+	}
+	copyLength, err := io.Copy(wc, m.limitPut(r, offset, size))
 	if err != nil {
 		return 0, m.redactAndLogError("Copy", err)
 	}
@@ -154,9 +176,26 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	inFile.done = true
 	inFile.mu.Unlock()
 
+	// Let the receive policy's hook vet the file while it is still partial,
+	// and so not yet visible.
+	if dir != nil || m.opts.Mode == PutModeDirect {
+		if err = m.runHook(id, name, partialPath); err != nil {
+			return 0, err
+		}
+	}
+
 	// Finalize rename
-	switch m.opts.Mode {
-	case PutModeDirect:
+	switch {
+	case dir != nil:
+		if err = m.finalizeDirFile(partialPath, dstPath, fileLength, want); err != nil {
+			return 0, m.redactAndLogError("Rename", err)
+		}
+		inFile.finalPath = dstPath
+		// The directory is counted as received once finished.
+		m.opts.SendFileNotify()
+		return fileLength, nil
+
+	case m.opts.Mode == PutModeDirect:
 		var finalDst string
 		finalDst, err = m.finalizeDirect(inFile, partialPath, dstPath, fileLength)
 		if err != nil {
@@ -164,8 +203,8 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 		}
 		inFile.finalPath = finalDst
 
-	case PutModeAndroidSAF:
-		if err = m.finalizeSAF(partialPath, baseName); err != nil {
+	case m.opts.Mode == PutModeAndroidSAF:
+		if err = m.finalizeSAF(partialPath, name); err != nil {
 			return 0, m.redactAndLogError("Rename", err)
 		}
 	}
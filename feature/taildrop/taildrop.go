@@ -23,6 +23,7 @@
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -38,6 +39,15 @@
 	ErrInvalidFileName = errors.New("invalid filename")
 	ErrFileExists      = errors.New("file already exists")
 	ErrNotAccessible   = errors.New("Taildrop folder not configured or accessible")
+
+	ErrInvalidManifest  = errors.New("invalid manifest")
+	ErrNoManifest       = errors.New("no such directory transfer")
+	ErrChecksumMismatch = errors.New("file does not match manifest")
+	ErrIncompleteDir    = errors.New("directory transfer incomplete")
+	ErrDirNotSupported  = errors.New("directory transfers not supported")
+
+	ErrRejected     = errors.New("file rejected by receive policy")
+	ErrFileTooLarge = errors.New("file exceeds receive policy size limits")
 )
 
 const (
@@ -100,6 +110,9 @@ type managerOptions struct {
 	// to the function when reception completes.
 	// It is not called if nil.
 	SendFileNotify func()
+
+	// Policy, if non-nil, limits the files that are received.
+	Policy *receivePolicy
 }
 
 // manager manages the state for receiving and managing taildropped files.
@@ -108,12 +121,22 @@ type manager struct {
 
 	// incomingFiles is a map of files actively being received.
 	incomingFiles syncs.Map[incomingFileKey, *incomingFile]
+	// incomingDirs is a map of directory transfers started with StartDir,
+	// keyed by the base name of the directory.
+	incomingDirs syncs.Map[incomingFileKey, *incomingDir]
 	// deleter managers asynchronous deletion of files.
 	deleter fileDeleter
 
 	// renameMu is used to protect os.Rename calls so that they are atomic.
 	renameMu sync.Mutex
 
+	// pendingMu guards the fields below, which track the bytes in Dir for
+	// the receive policy's limit on pending bytes.
+	pendingMu      sync.Mutex
+	pending        int64     // bytes in Dir, as counted plus received since
+	pendingCounted time.Time // when pending was last counted; zero if never
+	bytesReceived  int64     // in total, to adjust pending after counting
+
 	// totalReceived counts the cumulative total of received files.
 	totalReceived atomic.Int64
 	// emptySince specifies that there were no waiting files
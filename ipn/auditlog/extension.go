@@ -18,6 +18,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/syspolicy"
 )
 
 // featureName is the name of the feature implemented by this package.
@@ -47,6 +48,10 @@ type extension struct {
 	//
 	// It queues, persists, and sends audit logs to the control client.
 	logger *Logger
+	// localLoggers are the audit loggers for the local sinks enabled by
+	// the [syspolicy.AuditLogLocalSinks] policy setting, if any.
+	// They are started and stopped together with logger.
+	localLoggers []*Logger
 }
 
 // newExtension is an [ipnext.NewExtensionFn] that creates a new audit log extension.
@@ -90,24 +95,111 @@ func (e *extension) startNewLogger(cc controlclient.Client, profileID ipn.Profil
 		return nil, fmt.Errorf("failed to create audit log store: %w", err)
 	}
 
+	return e.startLogger(e.logf, store, transport, profileID)
+}
+
+func (e *extension) startLogger(logf logger.Logf, store LogStore, t Transport, profileID ipn.ProfileID) (*Logger, error) {
 	logger := NewLogger(Opts{
-		Logf:       e.logf,
+		Logf:       logf,
 		RetryLimit: 32,
 		Store:      store,
 	})
 	if err := logger.SetProfileID(profileID); err != nil {
 		return nil, fmt.Errorf("set profile failed: %w", err)
 	}
-	if err := logger.Start(transport); err != nil {
+	if err := logger.Start(t); err != nil {
 		return nil, fmt.Errorf("start failed: %w", err)
 	}
 	return logger, nil
 }
 
+// startLocalLoggers creates the local sinks enabled by policy and starts a
+// logger for each of them. It returns the loggers and a function that
+// stops the loggers and closes the sinks.
+//
+// Sinks that cannot be created are logged and skipped.
+func (e *extension) startLocalLoggers(profileID ipn.ProfileID) ([]*Logger, func(context.Context)) {
+	names, err := syspolicy.GetStringArray(syspolicy.AuditLogLocalSinks, nil)
+	if err != nil {
+		e.logf("failed to read local sinks policy: %v", err)
+		return nil, func(context.Context) {}
+	}
+	store, err := e.store.GetErr(func() (LogStore, error) {
+		return newDefaultLogStore(e.logf)
+	})
+	if err != nil {
+		e.logf("failed to create audit log store: %v", err)
+		return nil, func(context.Context) {}
+	}
+
+	var loggers []*Logger
+	var sinks []Sink
+	for _, name := range names {
+		sink, err := newSinkFromPolicy(name)
+		if err != nil {
+			e.logf("local sink %q: %v", name, err)
+			continue
+		}
+		logf := logger.WithPrefix(e.logf, name+": ")
+		l, err := e.startLogger(logf, sinkLogStore{store, name}, sink, profileID)
+		if err != nil {
+			sink.Close()
+			e.logf("local sink %q: %v", name, err)
+			continue
+		}
+		loggers = append(loggers, l)
+		sinks = append(sinks, sink)
+	}
+	return loggers, func(ctx context.Context) {
+		for i, l := range loggers {
+			l.FlushAndStop(ctx)
+			sinks[i].Close()
+		}
+	}
+}
+
+// newSinkFromPolicy creates the named local [Sink], configured according to
+// the current policy settings.
+func newSinkFromPolicy(name string) (Sink, error) {
+	switch name {
+	case SinkFile:
+		path, err := syspolicy.GetString(syspolicy.AuditLogFilePath, "")
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			if path, err = DefaultSinkFilePath(); err != nil {
+				return nil, err
+			}
+		}
+		maxSize, err := syspolicy.GetUint64(syspolicy.AuditLogFileMaxSize, 10<<20)
+		if err != nil {
+			return nil, err
+		}
+		maxBackups, err := syspolicy.GetUint64(syspolicy.AuditLogFileMaxBackups, 5)
+		if err != nil {
+			return nil, err
+		}
+		return NewFileSink(path, int64(maxSize), int(maxBackups))
+	case SinkSyslog:
+		return NewSyslogSink("tailscaled-audit")
+	case SinkJournal:
+		return NewJournalSink("tailscaled-audit")
+	default:
+		return nil, errors.New("unknown sink")
+	}
+}
+
 func (e *extension) controlClientChanged(cc controlclient.Client, profile ipn.LoginProfileView) (cleanup func()) {
 	logger, err := e.startNewLogger(cc, profile.ID())
+	var localLoggers []*Logger
+	stopLocal := func(context.Context) {}
+	if err == nil {
+		localLoggers, stopLocal = e.startLocalLoggers(profile.ID())
+	}
 	e.mu.Lock()
 	e.logger = logger // nil on error
+	e.localLoggers = localLoggers
 	e.mu.Unlock()
 	if err != nil {
 		// If we fail to create or start the logger, log the error
@@ -127,6 +219,7 @@ func (e *extension) controlClientChanged(cc controlclient.Client, profile ipn.Lo
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		logger.FlushAndStop(ctx)
+		stopLocal(ctx)
 	}
 }
 
@@ -140,8 +233,10 @@ func (e *extension) profileChanged(profile ipn.LoginProfileView, _ ipn.PrefsView
 		// The profile info has changed, but it represents the same node.
 		// This includes the case where the login has just been completed
 		// and the profile's [ipn.ProfileID] has been set for the first time.
-		if err := e.logger.SetProfileID(profile.ID()); err != nil {
-			e.logf("[unexpected] failed to set profile ID: %v", err)
+		for _, l := range append([]*Logger{e.logger}, e.localLoggers...) {
+			if err := l.SetProfileID(profile.ID()); err != nil {
+				e.logf("[unexpected] failed to set profile ID: %v", err)
+			}
 		}
 	default:
 		// The profile info has changed, and it represents a different node.
@@ -151,6 +246,7 @@ func (e *extension) profileChanged(profile ipn.LoginProfileView, _ ipn.PrefsView
 		// We don't expect any auditable actions to be attempted in this state.
 		// But if they are, they will fail with [errNoLogger].
 		e.logger = nil
+		e.localLoggers = nil
 	}
 }
 
@@ -172,7 +268,8 @@ func noCurrentLogger(_ tailcfg.ClientAuditAction, _ string) error {
 // getCurrentLogger is an [ipnext.AuditLogProvider] registered with [ipnext.Host].
 // It is called when [ipnlocal.LocalBackend] or an extension needs to audit an action.
 //
-// It returns a function that enqueues the audit log for the current profile,
+// It returns a function that enqueues the audit log for the current profile
+// with the control plane logger and any local sink loggers,
 // or [noCurrentLogger] if the logger is unavailable.
 func (e *extension) getCurrentLogger() ipnauth.AuditLogFunc {
 	e.mu.Lock()
@@ -180,7 +277,17 @@ func (e *extension) getCurrentLogger() ipnauth.AuditLogFunc {
 	if e.logger == nil {
 		return noCurrentLogger
 	}
-	return e.logger.Enqueue
+	if len(e.localLoggers) == 0 {
+		return e.logger.Enqueue
+	}
+	loggers := append([]*Logger{e.logger}, e.localLoggers...)
+	return func(action tailcfg.ClientAuditAction, details string) error {
+		var errs []error
+		for _, l := range loggers {
+			errs = append(errs, l.Enqueue(action, details))
+		}
+		return errors.Join(errs...)
+	}
 }
 
 // Shutdown implements [ipnlocal.Extension].
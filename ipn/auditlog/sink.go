@@ -0,0 +1,229 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package auditlog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// A Sink is a local destination for audit logs, such as a file or the system
+// log, used in addition to the control plane.
+//
+// Each sink is driven by its own [Logger], so entries written to a sink get
+// the same persistence, retry and deduplication as those sent to control.
+// Sinks report failures that may succeed on retry (e.g., a full disk or an
+// unreachable syslog daemon) as retryable errors; see [IsRetryableError].
+type Sink interface {
+	Transport
+	io.Closer
+}
+
+// Names of local sinks, as used in the [syspolicy.AuditLogLocalSinks]
+// policy setting.
+const (
+	SinkFile    = "file"
+	SinkSyslog  = "syslog"
+	SinkJournal = "journald"
+)
+
+// sinkRecord is the representation of an audit log entry written by local
+// sinks.
+type sinkRecord struct {
+	Time    time.Time
+	Action  tailcfg.ClientAuditAction
+	Details string `json:",omitempty"`
+}
+
+func newSinkRecord(req tailcfg.AuditLogRequest) sinkRecord {
+	return sinkRecord{
+		Time:    req.Timestamp.UTC(),
+		Action:  req.Action,
+		Details: req.Details,
+	}
+}
+
+// retryableError is an error that [IsRetryableError] reports as retryable.
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string   { return e.err.Error() }
+func (e retryableError) Unwrap() error   { return e.err }
+func (e retryableError) Retryable() bool { return true }
+
+// FileSink is a [Sink] that appends audit logs to a file as JSON lines,
+// rotating it when it grows too large.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File // nil until first write or after a failed rotation
+	size int64    // current size of f
+}
+
+// NewFileSink returns a [FileSink] writing to the file at path, which is
+// created if it does not exist.
+//
+// When writing an entry would grow the file beyond maxSize bytes, the file is
+// renamed to path.1 (and any older path.N to path.N+1) and a new file is
+// started. At most maxBackups rotated files are kept. If maxSize is zero or
+// negative, the file is never rotated.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) openLocked() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = fi.Size()
+	return nil
+}
+
+// SendAuditLog implements [Transport] by appending req to the file.
+func (s *FileSink) SendAuditLog(_ context.Context, req tailcfg.AuditLogRequest) error {
+	line, err := json.Marshal(newSinkRecord(req))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil && s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotateLocked(); err != nil {
+			return retryableError{fmt.Errorf("rotating audit log file: %w", err)}
+		}
+	}
+	if s.f == nil {
+		if err := s.openLocked(); err != nil {
+			return retryableError{err}
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	if err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		return retryableError{err}
+	}
+	return nil
+}
+
+// rotateLocked closes the current file and shifts it and its backups
+// along by one. s.mu must be held.
+func (s *FileSink) rotateLocked() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", s.path, n) }
+	if s.maxBackups <= 0 {
+		return os.Remove(s.path)
+	}
+	os.Remove(backup(s.maxBackups))
+	for n := s.maxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.path, backup(1))
+}
+
+// Close implements [io.Closer].
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// DefaultSinkFilePath returns the default path of the file written by the
+// [SinkFile] sink, for use when the [syspolicy.AuditLogFilePath] policy
+// setting is not configured. It is in the same directory as the audit log
+// store.
+func DefaultSinkFilePath() (string, error) {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "Tailscale", "audit-log.jsonl"), nil
+	}
+	storePath, err := storeFilePath.GetErr(DefaultStoreFilePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(storePath), "audit-log.jsonl"), nil
+}
+
+// errSinkUnsupported is returned when creating a sink that is not available
+// on the current platform.
+var errSinkUnsupported = errors.New("audit log sink not supported on " + runtime.GOOS)
+
+// sinkLogStore is a [LogStore] that stores the pending logs of a sink
+// separately from those of other sinks and of the control plane logger
+// sharing the same underlying store.
+type sinkLogStore struct {
+	LogStore
+	sink string
+}
+
+func (s sinkLogStore) sinkKey(key ipn.ProfileID) (ipn.ProfileID, error) {
+	if key == "" {
+		return "", errors.New("empty key")
+	}
+	return ipn.ProfileID(s.sink + "-" + string(key)), nil
+}
+
+func (s sinkLogStore) save(key ipn.ProfileID, txns []*transaction) error {
+	k, err := s.sinkKey(key)
+	if err != nil {
+		return err
+	}
+	return s.LogStore.save(k, txns)
+}
+
+func (s sinkLogStore) load(key ipn.ProfileID) ([]*transaction, error) {
+	k, err := s.sinkKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.LogStore.load(k)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// journalSocket is the path of the systemd journal's native protocol socket.
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalSink is a [Sink] that writes audit logs to the systemd journal,
+// with the action and details in structured fields.
+type JournalSink struct {
+	tag  string
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// NewJournalSink returns a [JournalSink] that logs with the given syslog
+// identifier. It fails if the journal is not running.
+func NewJournalSink(tag string) (*JournalSink, error) {
+	return newJournalSink(tag, journalSocket)
+}
+
+func newJournalSink(tag, socket string) (*JournalSink, error) {
+	if _, err := os.Stat(socket); err != nil {
+		return nil, fmt.Errorf("journal not available: %w", err)
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournalSink{
+		tag:  tag,
+		conn: conn,
+		addr: &net.UnixAddr{Name: socket, Net: "unixgram"},
+	}, nil
+}
+
+// SendAuditLog implements [Transport].
+func (s *JournalSink) SendAuditLog(_ context.Context, req tailcfg.AuditLogRequest) error {
+	var buf bytes.Buffer
+	msg := fmt.Sprintf("audit: %s", req.Action)
+	if req.Details != "" {
+		msg += ": " + req.Details
+	}
+	appendJournalField(&buf, "MESSAGE", msg)
+	appendJournalField(&buf, "PRIORITY", "5")         // notice
+	appendJournalField(&buf, "SYSLOG_FACILITY", "10") // authpriv
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", s.tag)
+	appendJournalField(&buf, "TAILSCALE_AUDIT_ACTION", string(req.Action))
+	appendJournalField(&buf, "TAILSCALE_AUDIT_DETAILS", req.Details)
+	appendJournalField(&buf, "TAILSCALE_AUDIT_TIMESTAMP", req.Timestamp.UTC().Format(time.RFC3339Nano))
+	if _, err := s.conn.WriteToUnix(buf.Bytes(), s.addr); err != nil {
+		// The journal may be restarting.
+		return retryableError{err}
+	}
+	return nil
+}
+
+// appendJournalField appends a field in the journal's native protocol
+// format to buf. Values containing newlines use the binary format.
+func appendJournalField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// Close implements [io.Closer].
+func (s *JournalSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestJournalSink(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "socket")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	s, err := newJournalSink("test", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	err = s.SendAuditLog(context.Background(), tailcfg.AuditLogRequest{
+		Action:    tailcfg.AuditNodeDisconnect,
+		Details:   "line 1\nline 2",
+		Timestamp: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	journal.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := journal.ReadFromUnix(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := buf[:n]
+	for _, want := range []string{
+		"SYSLOG_IDENTIFIER=test\n",
+		"TAILSCALE_AUDIT_ACTION=" + string(tailcfg.AuditNodeDisconnect) + "\n",
+		"TAILSCALE_AUDIT_TIMESTAMP=2025-01-02T03:04:05Z\n",
+		// Values with newlines use the binary format.
+		"TAILSCALE_AUDIT_DETAILS\n\x0d\x00\x00\x00\x00\x00\x00\x00line 1\nline 2\n",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("journal entry %q does not contain %q", got, want)
+		}
+	}
+	if strings.Count(string(got), "MESSAGE") != 1 {
+		t.Errorf("journal entry %q: want exactly one MESSAGE field", got)
+	}
+}
+
+func TestJournalSinkUnavailable(t *testing.T) {
+	if _, err := newJournalSink("test", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("newJournalSink succeeded without a journal socket")
+	}
+}
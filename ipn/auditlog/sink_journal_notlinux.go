@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package auditlog
+
+import (
+	"context"
+
+	"tailscale.com/tailcfg"
+)
+
+// JournalSink is a [Sink] that writes audit logs to the systemd journal.
+// It is only supported on Linux.
+type JournalSink struct{}
+
+// NewJournalSink returns an error, as the systemd journal is only
+// supported on Linux.
+func NewJournalSink(tag string) (*JournalSink, error) {
+	return nil, errSinkUnsupported
+}
+
+// SendAuditLog implements [Transport].
+func (*JournalSink) SendAuditLog(context.Context, tailcfg.AuditLogRequest) error {
+	return errSinkUnsupported
+}
+
+// Close implements [io.Closer].
+func (*JournalSink) Close() error { return nil }
@@ -0,0 +1,29 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || plan9
+
+package auditlog
+
+import (
+	"context"
+
+	"tailscale.com/tailcfg"
+)
+
+// SyslogSink is a [Sink] that writes audit logs to the local syslog daemon.
+// It is not supported on this platform.
+type SyslogSink struct{}
+
+// NewSyslogSink returns an error, as syslog is not supported on this platform.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, errSinkUnsupported
+}
+
+// SendAuditLog implements [Transport].
+func (*SyslogSink) SendAuditLog(context.Context, tailcfg.AuditLogRequest) error {
+	return errSinkUnsupported
+}
+
+// Close implements [io.Closer].
+func (*SyslogSink) Close() error { return nil }
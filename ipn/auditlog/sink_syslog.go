@@ -0,0 +1,48 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows && !plan9
+
+package auditlog
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+
+	"tailscale.com/tailcfg"
+)
+
+// SyslogSink is a [Sink] that writes audit logs to the local syslog daemon,
+// with the AUTHPRIV facility, as JSON.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink returns a [SyslogSink] that logs with the given tag.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTHPRIV, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// SendAuditLog implements [Transport].
+func (s *SyslogSink) SendAuditLog(_ context.Context, req tailcfg.AuditLogRequest) error {
+	msg, err := json.Marshal(newSinkRecord(req))
+	if err != nil {
+		return err
+	}
+	// The syslog.Writer reconnects to the daemon on failure,
+	// so errors may go away on retry.
+	if err := s.w.Notice(string(msg)); err != nil {
+		return retryableError{err}
+	}
+	return nil
+}
+
+// Close implements [io.Closer].
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
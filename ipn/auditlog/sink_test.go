@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package auditlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/syspolicy"
+	"tailscale.com/util/syspolicy/setting"
+	"tailscale.com/util/syspolicy/source"
+)
+
+func readSinkFile(t *testing.T, path string) []sinkRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []sinkRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r sinkRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Bytes(), err)
+		}
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	line, _ := json.Marshal(newSinkRecord(tailcfg.AuditLogRequest{Action: tailcfg.AuditNodeDisconnect, Details: "log 0", Timestamp: now}))
+	// Room for two entries per file.
+	s, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	c.Assert(err, qt.IsNil)
+	defer s.Close()
+
+	for i := range 7 {
+		err := s.SendAuditLog(context.Background(), tailcfg.AuditLogRequest{
+			Action:    tailcfg.AuditNodeDisconnect,
+			Details:   fmt.Sprintf("log %d", i),
+			Timestamp: now,
+		})
+		c.Assert(err, qt.IsNil)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want []string
+	}{
+		{path, []string{"log 6"}},
+		{path + ".1", []string{"log 4", "log 5"}},
+		{path + ".2", []string{"log 2", "log 3"}},
+	} {
+		var got []string
+		for _, r := range readSinkFile(t, tt.path) {
+			got = append(got, r.Details)
+		}
+		c.Check(got, qt.DeepEquals, tt.want, qt.Commentf("file %s", tt.path))
+	}
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("found more backups than allowed: %v", err)
+	}
+}
+
+// TestSinkLoggerRetries checks that a local sink gets the same retry
+// semantics as the control plane transport, and that its pending logs are
+// stored separately.
+func TestSinkLoggerRetries(t *testing.T) {
+	c := qt.New(t)
+	store := NewLogStore(&mem.Store{})
+
+	control := newMockTransport(nil)
+	controlLogger := loggerForTest(t, Opts{RetryLimit: 10, Store: store})
+	c.Assert(controlLogger.SetProfileID("test"), qt.IsNil)
+	c.Assert(controlLogger.Start(control), qt.IsNil)
+
+	sink := newMockTransport(&retriableError)
+	sinkLogger := loggerForTest(t, Opts{RetryLimit: 10, Store: sinkLogStore{store, SinkFile}})
+	c.Assert(sinkLogger.SetProfileID("test"), qt.IsNil)
+	c.Assert(sinkLogger.Start(sink), qt.IsNil)
+
+	for _, l := range []*Logger{controlLogger, sinkLogger} {
+		c.Assert(l.Enqueue(tailcfg.AuditNodeDisconnect, "bye"), qt.IsNil)
+	}
+	controlLogger.FlushAndStop(context.Background())
+	sinkLogger.FlushAndStop(context.Background())
+
+	c.Assert(control.sentCount(), qt.Equals, 1)
+	c.Assert(sink.sentCount(), qt.Equals, 0)
+
+	controlPending, err := store.load("test")
+	c.Assert(err, qt.IsNil)
+	c.Assert(controlPending, qt.HasLen, 0)
+	sinkPending, err := sinkLogStore{store, SinkFile}.load("test")
+	c.Assert(err, qt.IsNil)
+	c.Assert(sinkPending, qt.HasLen, 1)
+	c.Assert(sinkPending[0].Retries > 0, qt.IsTrue)
+}
+
+func TestNewSinkFromPolicy(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	syspolicy.RegisterWellKnownSettingsForTest(t)
+	policyStore := source.NewTestStoreOf(t, source.TestSettingOf(syspolicy.AuditLogFilePath, path))
+	syspolicy.MustRegisterStoreForTest(t, "TestStore", setting.DeviceScope, policyStore)
+
+	s, err := newSinkFromPolicy(SinkFile)
+	c.Assert(err, qt.IsNil)
+	defer s.Close()
+	fs, ok := s.(*FileSink)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(fs.path, qt.Equals, path)
+	c.Assert(fs.maxSize, qt.Equals, int64(10<<20))
+	c.Assert(fs.maxBackups, qt.Equals, 5)
+
+	_, err = newSinkFromPolicy("carrier-pigeon")
+	c.Assert(err, qt.IsNotNil)
+}
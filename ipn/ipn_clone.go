@@ -11,6 +11,7 @@
 
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -118,7 +119,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 			if v == nil {
 				dst.TCP[k] = nil
 			} else {
-				dst.TCP[k] = ptr.To(*v)
+				dst.TCP[k] = v.Clone()
 			}
 		}
 	}
@@ -180,7 +181,7 @@ func (src *ServiceConfig) Clone() *ServiceConfig {
 			if v == nil {
 				dst.TCP[k] = nil
 			} else {
-				dst.TCP[k] = ptr.To(*v)
+				dst.TCP[k] = v.Clone()
 			}
 		}
 	}
@@ -212,6 +213,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	}
 	dst := new(TCPPortHandler)
 	*dst = *src
+	dst.LoadBalancer = src.LoadBalancer.Clone()
 	return dst
 }
 
@@ -221,6 +223,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	HTTP         bool
 	TCPForward   string
 	TerminateTLS string
+	LoadBalancer *LoadBalancer
 }{})
 
 // Clone makes a deep copy of HTTPHandler.
@@ -231,14 +234,26 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	}
 	dst := new(HTTPHandler)
 	*dst = *src
+	dst.SetRequestHeaders = maps.Clone(src.SetRequestHeaders)
+	dst.SetResponseHeaders = maps.Clone(src.SetResponseHeaders)
+	dst.LoadBalancer = src.LoadBalancer.Clone()
+	dst.Access = src.Access.Clone()
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path               string
+	Proxy              string
+	Text               string
+	Redirect           string
+	Status             int
+	RewritePath        string
+	SetRequestHeaders  map[string]string
+	SetResponseHeaders map[string]string
+	IdentityHeaders    bool
+	LoadBalancer       *LoadBalancer
+	Access             *ServeAccess
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
@@ -255,7 +270,7 @@ func (src *WebServerConfig) Clone() *WebServerConfig {
 			if v == nil {
 				dst.Handlers[k] = nil
 			} else {
-				dst.Handlers[k] = ptr.To(*v)
+				dst.Handlers[k] = v.Clone()
 			}
 		}
 	}
@@ -266,3 +281,63 @@ func (src *WebServerConfig) Clone() *WebServerConfig {
 var _WebServerConfigCloneNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// Clone makes a deep copy of LoadBalancer.
+// The result aliases no memory with the original.
+func (src *LoadBalancer) Clone() *LoadBalancer {
+	if src == nil {
+		return nil
+	}
+	dst := new(LoadBalancer)
+	*dst = *src
+	dst.Backends = append(src.Backends[:0:0], src.Backends...)
+	dst.HealthCheck = src.HealthCheck.Clone()
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _LoadBalancerCloneNeedsRegeneration = LoadBalancer(struct {
+	Backends    []string
+	Policy      LBPolicy
+	HealthCheck *HealthCheck
+}{})
+
+// Clone makes a deep copy of HealthCheck.
+// The result aliases no memory with the original.
+func (src *HealthCheck) Clone() *HealthCheck {
+	if src == nil {
+		return nil
+	}
+	dst := new(HealthCheck)
+	*dst = *src
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HealthCheckCloneNeedsRegeneration = HealthCheck(struct {
+	Path               string
+	Interval           tstime.GoDuration
+	Timeout            tstime.GoDuration
+	UnhealthyThreshold int
+}{})
+
+// Clone makes a deep copy of ServeAccess.
+// The result aliases no memory with the original.
+func (src *ServeAccess) Clone() *ServeAccess {
+	if src == nil {
+		return nil
+	}
+	dst := new(ServeAccess)
+	*dst = *src
+	dst.Caps = append(src.Caps[:0:0], src.Caps...)
+	dst.Logins = append(src.Logins[:0:0], src.Logins...)
+	dst.Tags = append(src.Tags[:0:0], src.Tags...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ServeAccessCloneNeedsRegeneration = ServeAccess(struct {
+	Caps   []tailcfg.PeerCapability
+	Logins []string
+	Tags   []string
+}{})
@@ -12,13 +12,14 @@
 
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,WebServerConfig,LoadBalancer,HealthCheck,ServeAccess
 
 // View returns a read-only view of LoginProfile.
 func (p *LoginProfile) View() LoginProfileView {
@@ -403,10 +404,11 @@ func (v *TCPPortHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v TCPPortHandlerView) HTTPS() bool          { return v.ж.HTTPS }
-func (v TCPPortHandlerView) HTTP() bool           { return v.ж.HTTP }
-func (v TCPPortHandlerView) TCPForward() string   { return v.ж.TCPForward }
-func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
+func (v TCPPortHandlerView) HTTPS() bool                    { return v.ж.HTTPS }
+func (v TCPPortHandlerView) HTTP() bool                     { return v.ж.HTTP }
+func (v TCPPortHandlerView) TCPForward() string             { return v.ж.TCPForward }
+func (v TCPPortHandlerView) TerminateTLS() string           { return v.ж.TerminateTLS }
+func (v TCPPortHandlerView) LoadBalancer() LoadBalancerView { return v.ж.LoadBalancer.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerViewNeedsRegeneration = TCPPortHandler(struct {
@@ -414,6 +416,7 @@ func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
 	HTTP         bool
 	TCPForward   string
 	TerminateTLS string
+	LoadBalancer *LoadBalancer
 }{})
 
 // View returns a read-only view of HTTPHandler.
@@ -461,15 +464,37 @@ func (v *HTTPHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v HTTPHandlerView) Path() string  { return v.ж.Path }
-func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
-func (v HTTPHandlerView) Text() string  { return v.ж.Text }
+func (v HTTPHandlerView) Path() string        { return v.ж.Path }
+func (v HTTPHandlerView) Proxy() string       { return v.ж.Proxy }
+func (v HTTPHandlerView) Text() string        { return v.ж.Text }
+func (v HTTPHandlerView) Redirect() string    { return v.ж.Redirect }
+func (v HTTPHandlerView) Status() int         { return v.ж.Status }
+func (v HTTPHandlerView) RewritePath() string { return v.ж.RewritePath }
+
+func (v HTTPHandlerView) SetRequestHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetRequestHeaders)
+}
+
+func (v HTTPHandlerView) SetResponseHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetResponseHeaders)
+}
+func (v HTTPHandlerView) IdentityHeaders() bool          { return v.ж.IdentityHeaders }
+func (v HTTPHandlerView) LoadBalancer() LoadBalancerView { return v.ж.LoadBalancer.View() }
+func (v HTTPHandlerView) Access() ServeAccessView        { return v.ж.Access.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path               string
+	Proxy              string
+	Text               string
+	Redirect           string
+	Status             int
+	RewritePath        string
+	SetRequestHeaders  map[string]string
+	SetResponseHeaders map[string]string
+	IdentityHeaders    bool
+	LoadBalancer       *LoadBalancer
+	Access             *ServeAccess
 }{})
 
 // View returns a read-only view of WebServerConfig.
@@ -527,3 +552,173 @@ func (v WebServerConfigView) Handlers() views.MapFn[string, *HTTPHandler, HTTPHa
 var _WebServerConfigViewNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// View returns a read-only view of LoadBalancer.
+func (p *LoadBalancer) View() LoadBalancerView {
+	return LoadBalancerView{ж: p}
+}
+
+// LoadBalancerView provides a read-only view over LoadBalancer.
+//
+// Its methods should only be called if `Valid()` returns true.
+type LoadBalancerView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *LoadBalancer
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v LoadBalancerView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v LoadBalancerView) AsStruct() *LoadBalancer {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v LoadBalancerView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *LoadBalancerView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x LoadBalancer
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v LoadBalancerView) Backends() views.Slice[string] { return views.SliceOf(v.ж.Backends) }
+func (v LoadBalancerView) Policy() LBPolicy              { return v.ж.Policy }
+func (v LoadBalancerView) HealthCheck() HealthCheckView  { return v.ж.HealthCheck.View() }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _LoadBalancerViewNeedsRegeneration = LoadBalancer(struct {
+	Backends    []string
+	Policy      LBPolicy
+	HealthCheck *HealthCheck
+}{})
+
+// View returns a read-only view of HealthCheck.
+func (p *HealthCheck) View() HealthCheckView {
+	return HealthCheckView{ж: p}
+}
+
+// HealthCheckView provides a read-only view over HealthCheck.
+//
+// Its methods should only be called if `Valid()` returns true.
+type HealthCheckView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *HealthCheck
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v HealthCheckView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v HealthCheckView) AsStruct() *HealthCheck {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v HealthCheckView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *HealthCheckView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x HealthCheck
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v HealthCheckView) Path() string                { return v.ж.Path }
+func (v HealthCheckView) Interval() tstime.GoDuration { return v.ж.Interval }
+func (v HealthCheckView) Timeout() tstime.GoDuration  { return v.ж.Timeout }
+func (v HealthCheckView) UnhealthyThreshold() int     { return v.ж.UnhealthyThreshold }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HealthCheckViewNeedsRegeneration = HealthCheck(struct {
+	Path               string
+	Interval           tstime.GoDuration
+	Timeout            tstime.GoDuration
+	UnhealthyThreshold int
+}{})
+
+// View returns a read-only view of ServeAccess.
+func (p *ServeAccess) View() ServeAccessView {
+	return ServeAccessView{ж: p}
+}
+
+// ServeAccessView provides a read-only view over ServeAccess.
+//
+// Its methods should only be called if `Valid()` returns true.
+type ServeAccessView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *ServeAccess
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v ServeAccessView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v ServeAccessView) AsStruct() *ServeAccess {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v ServeAccessView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *ServeAccessView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x ServeAccess
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v ServeAccessView) Caps() views.Slice[tailcfg.PeerCapability] { return views.SliceOf(v.ж.Caps) }
+func (v ServeAccessView) Logins() views.Slice[string]               { return views.SliceOf(v.ж.Logins) }
+func (v ServeAccessView) Tags() views.Slice[string]                 { return views.SliceOf(v.ж.Tags) }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ServeAccessViewNeedsRegeneration = ServeAccess(struct {
+	Caps   []tailcfg.PeerCapability
+	Logins []string
+	Tags   []string
+}{})
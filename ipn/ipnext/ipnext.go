@@ -385,6 +385,9 @@ type NodeBackend interface {
 	// PeerCaps returns the capabilities that src has to this node.
 	PeerCaps(src netip.Addr) tailcfg.PeerCapMap
 
+	// UserByID returns the profile of the user with the given ID, if known.
+	UserByID(tailcfg.UserID) (_ tailcfg.UserProfileView, ok bool)
+
 	// PeerHasCap reports whether the peer has the specified peer capability.
 	PeerHasCap(peer tailcfg.NodeView, cap tailcfg.PeerCapability) bool
 
@@ -58,6 +58,12 @@ func (b *LocalBackend) DriveSetShare(share *drive.Share) error {
 	if err != nil {
 		return err
 	}
+	if !share.Symlinks.Valid() {
+		return drive.ErrInvalidSymlinks
+	}
+	if share.Quota < 0 {
+		return drive.ErrInvalidQuota
+	}
 
 	b.mu.Lock()
 	shares, err := b.driveSetShareLocked(share)
@@ -86,6 +86,7 @@
 	"tailscale.com/types/appctype"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/empty"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -336,6 +337,7 @@ type LocalBackend struct {
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	serveBackendPools  sync.Map                          // string (backendPoolKey) => *backendPool
 
 	// statusLock must be held before calling statusChanged.Wait() or
 	// statusChanged.Broadcast().
@@ -1478,6 +1480,94 @@ func (b *LocalBackend) PeerCaps(src netip.Addr) tailcfg.PeerCapMap {
 	return b.currentNode().PeerCaps(src)
 }
 
+// FilterStats returns the counters of the packet filter's rules.
+func (b *LocalBackend) FilterStats() *apitype.FilterStats {
+	res := &apitype.FilterStats{Rules: []apitype.FilterRuleStats{}}
+	filt := b.e.GetFilter()
+	if filt == nil {
+		return res
+	}
+	rules, drops := filt.Stats()
+	for i, r := range rules {
+		res.Rules = append(res.Rules, apitype.FilterRuleStats{
+			Index: i,
+			Rule:  r.Match.String(),
+			Hits:  r.Hits,
+		})
+	}
+	res.Drops = drops
+	return res
+}
+
+// FilterConnTrack returns the packet filter's connection tracking table.
+func (b *LocalBackend) FilterConnTrack() *apitype.FilterConnTrack {
+	res := &apitype.FilterConnTrack{Flows: []apitype.FilterFlow{}}
+	filt := b.e.GetFilter()
+	if filt == nil {
+		return res
+	}
+	ct := filt.ConnTrack()
+	for _, e := range ct.Entries {
+		proto, _ := e.Proto.MarshalText()
+		res.Flows = append(res.Flows, apitype.FilterFlow{
+			Proto:   string(proto),
+			Src:     e.Src,
+			Dst:     e.Dst,
+			Idle:    e.Idle,
+			Timeout: e.Timeout,
+		})
+	}
+	res.MaxEntries = ct.MaxEntries
+	res.MaxICMPEntries = ct.MaxICMPEntries
+	res.Evicted = ct.Evicted
+	res.Expired = ct.Expired
+	return res
+}
+
+// ExplainFilter reports whether the packet filter allows a new incoming
+// connection of protocol proto from src to dst:port, and which rule
+// decided it. If dst is the zero value, this node's Tailscale IP of the
+// same address family as src is used.
+func (b *LocalBackend) ExplainFilter(src, dst netip.Addr, port uint16, proto ipproto.Proto) (*apitype.FilterExplanation, error) {
+	nm := b.NetMap()
+	if !dst.IsValid() {
+		if nm == nil {
+			return nil, errors.New("no netmap")
+		}
+		addrs := nm.GetAddresses()
+		for i := range addrs.Len() {
+			if a := addrs.At(i); a.IsSingleIP() && a.Addr().Is4() == src.Is4() {
+				dst = a.Addr()
+				break
+			}
+		}
+		if !dst.IsValid() {
+			return nil, fmt.Errorf("no Tailscale IP of the same address family as %v", src)
+		}
+	}
+	filt := b.e.GetFilter()
+	if filt == nil {
+		return nil, errors.New("no packet filter")
+	}
+	e := filt.Explain(src, dst, port, proto)
+	res := &apitype.FilterExplanation{
+		Verdict: e.Verdict.String(),
+		Reason:  e.Reason,
+		Index:   e.Rule,
+	}
+	if e.Rule >= 0 {
+		res.Rule = e.Match.String()
+		// The packet filter is usually derived from the netmap's,
+		// one match per rule from control. Make sure it still is
+		// before reporting the rule.
+		if nm != nil && len(nm.PacketFilter) == nm.PacketFilterRules.Len() &&
+			e.Rule < len(nm.PacketFilter) && nm.PacketFilter[e.Rule].String() == res.Rule {
+			res.FilterRule = ptr.To(nm.PacketFilterRules.At(e.Rule))
+		}
+	}
+	return res, nil
+}
+
 func (b *LocalBackend) GetFilterForTest() *filter.Filter {
 	if !testenv.InTest() {
 		panic("GetFilterForTest called outside of test")
@@ -6217,6 +6307,7 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 			b.updateServeTCPPortNetMapAddrListenersLocked(servePorts)
 		}
 	}
+	b.setServeBackendPoolsLocked()
 
 	// Update funnel and service hash info in hostinfo and kick off control update if needed.
 	b.updateIngressAndServiceHashLocked(prefs)
@@ -6264,25 +6355,23 @@ func (b *LocalBackend) setServeProxyHandlersLocked() {
 	var backends map[string]bool
 	for _, conf := range b.serveConfig.Webs() {
 		for _, h := range conf.Handlers().All() {
-			backend := h.Proxy()
-			if backend == "" {
-				// Only create proxy handlers for servers with a proxy backend.
-				continue
-			}
-			mak.Set(&backends, backend, true)
-			if _, ok := b.serveProxyHandlers.Load(backend); ok {
-				continue
-			}
+			// Only create proxy handlers for servers with proxy backends.
+			for _, backend := range h.ProxyBackends() {
+				mak.Set(&backends, backend, true)
+				if _, ok := b.serveProxyHandlers.Load(backend); ok {
+					continue
+				}
 
-			b.logf("serve: creating a new proxy handler for %s", backend)
-			p, err := b.proxyHandlerForBackend(backend)
-			if err != nil {
-				// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
-				// in the CLI, so just log the error here.
-				b.logf("[unexpected] could not create proxy for %v: %s", backend, err)
-				continue
+				b.logf("serve: creating a new proxy handler for %s", backend)
+				p, err := b.proxyHandlerForBackend(backend)
+				if err != nil {
+					// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
+					// in the CLI, so just log the error here.
+					b.logf("[unexpected] could not create proxy for %v: %s", backend, err)
+					continue
+				}
+				b.serveProxyHandlers.Store(backend, p)
 			}
-			b.serveProxyHandlers.Store(backend, p)
 		}
 	}
 
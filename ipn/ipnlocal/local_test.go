@@ -2981,6 +2981,23 @@ func TestDriveManageShares(t *testing.T) {
 			add:    &drive.Share{Name: "$"},
 			expect: drive.ErrInvalidShareName,
 		},
+		{
+			name:   "add_bad_symlinks",
+			add:    &drive.Share{Name: "a", Symlinks: "follow"},
+			expect: drive.ErrInvalidSymlinks,
+		},
+		{
+			name:   "add_negative_quota",
+			add:    &drive.Share{Name: "a", Quota: -1},
+			expect: drive.ErrInvalidQuota,
+		},
+		{
+			name: "add_with_options",
+			add:  &drive.Share{Name: "a", Quota: 1 << 30, Symlinks: drive.SymlinksWithinShare},
+			expect: []*drive.Share{
+				{Name: "a", Quota: 1 << 30, Symlinks: drive.SymlinksWithinShare},
+			},
+		},
 		{
 			name:     "add_disabled",
 			disabled: true,
@@ -4,6 +4,7 @@
 package ipnlocal
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
@@ -36,6 +37,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/views"
 	"tailscale.com/util/ctxkey"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
@@ -280,6 +282,9 @@ func (b *LocalBackend) setServeConfigLocked(config *ipn.ServeConfig, etag string
 		if err := config.CheckValidServicesConfig(); err != nil {
 			return err
 		}
+		if err := config.CheckValidHandlers(); err != nil {
+			return err
+		}
 	}
 
 	nm := b.NetMap()
@@ -496,9 +501,7 @@ func (b *LocalBackend) tcpHandlerForVIPService(dstAddr, srcAddr netip.AddrPort)
 	if backDst := tcph.TCPForward(); backDst != "" {
 		return func(conn net.Conn) error {
 			defer conn.Close()
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			backConn, err := b.dialer.SystemDial(ctx, "tcp", backDst)
-			cancel()
+			backConn, err := b.dialServeTCPForward(context.Background(), tcph)
 			if err != nil {
 				b.logf("localbackend: failed to TCP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
 				return nil
@@ -583,9 +586,7 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 	if backDst := tcph.TCPForward(); backDst != "" {
 		return func(conn net.Conn) error {
 			defer conn.Close()
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			backConn, err := b.dialer.SystemDial(ctx, "tcp", backDst)
-			cancel()
+			backConn, err := b.dialServeTCPForward(context.Background(), tcph)
 			if err != nil {
 				b.logf("localbackend: failed to TCP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
 				return nil
@@ -750,6 +751,13 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		addProxyForwardedHeaders(r)
 		rp.lb.addTailscaleIdentityHeaders(r)
 	}}
+	if errp := proxyErrorKey.Value(r.Context()); errp != nil {
+		// The request is to a load balanced backend and can be retried
+		// on another one, so don't respond to the client.
+		p.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
+			*errp = err
+		}
+	}
 
 	// There is no way to autodetect h2c as per RFC 9113
 	// https://datatracker.ietf.org/doc/html/rfc9113#name-starting-http-2.
@@ -825,19 +833,26 @@ func addProxyForwardedHeaders(r *httputil.ProxyRequest) {
 }
 
 func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
+	b.setTailscaleIdentityHeaders(r.Out.Context(), r.Out.Header)
+}
+
+// setTailscaleIdentityHeaders replaces the Tailscale-User-* and related
+// headers in h with ones describing the sender of the serve request with
+// context ctx.
+func (b *LocalBackend) setTailscaleIdentityHeaders(ctx context.Context, h http.Header) {
 	// Clear any incoming values squatting in the headers.
-	r.Out.Header.Del("Tailscale-User-Login")
-	r.Out.Header.Del("Tailscale-User-Name")
-	r.Out.Header.Del("Tailscale-User-Profile-Pic")
-	r.Out.Header.Del("Tailscale-Funnel-Request")
-	r.Out.Header.Del("Tailscale-Headers-Info")
+	h.Del("Tailscale-User-Login")
+	h.Del("Tailscale-User-Name")
+	h.Del("Tailscale-User-Profile-Pic")
+	h.Del("Tailscale-Funnel-Request")
+	h.Del("Tailscale-Headers-Info")
 
-	c, ok := serveHTTPContextKey.ValueOk(r.Out.Context())
+	c, ok := serveHTTPContextKey.ValueOk(ctx)
 	if !ok {
 		return
 	}
 	if c.Funnel != nil {
-		r.Out.Header.Set("Tailscale-Funnel-Request", "?1")
+		h.Set("Tailscale-Funnel-Request", "?1")
 		return
 	}
 	node, user, ok := b.WhoIs("tcp", c.SrcAddr)
@@ -849,10 +864,41 @@ func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
 		// Only currently set for nodes with user identities.
 		return
 	}
-	r.Out.Header.Set("Tailscale-User-Login", encTailscaleHeaderValue(user.LoginName))
-	r.Out.Header.Set("Tailscale-User-Name", encTailscaleHeaderValue(user.DisplayName))
-	r.Out.Header.Set("Tailscale-User-Profile-Pic", user.ProfilePicURL)
-	r.Out.Header.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
+	h.Set("Tailscale-User-Login", encTailscaleHeaderValue(user.LoginName))
+	h.Set("Tailscale-User-Name", encTailscaleHeaderValue(user.DisplayName))
+	h.Set("Tailscale-User-Profile-Pic", user.ProfilePicURL)
+	h.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
+}
+
+// serveAccessAllowed reports whether a allows the peer that sent the
+// request with context ctx. Funnel requests and requests from outside of the
+// tailnet are never allowed.
+func (b *LocalBackend) serveAccessAllowed(ctx context.Context, a ipn.ServeAccessView) bool {
+	c, ok := serveHTTPContextKey.ValueOk(ctx)
+	if !ok || c.Funnel != nil {
+		return false
+	}
+	node, user, ok := b.WhoIs("tcp", c.SrcAddr)
+	if !ok {
+		return false
+	}
+	if node.IsTagged() {
+		if node.Tags().ContainsFunc(func(tag string) bool { return views.SliceContains(a.Tags(), tag) }) {
+			return true
+		}
+	} else if a.Logins().ContainsFunc(func(login string) bool { return strings.EqualFold(login, user.LoginName) }) {
+		return true
+	}
+	if a.Caps().Len() == 0 {
+		return false
+	}
+	src := c.SrcAddr.Addr()
+	if !node.Addresses().ContainsFunc(func(p netip.Prefix) bool { return p.Addr() == src }) && node.Addresses().Len() > 0 {
+		// The connection was proxied by tailscaled; use the peer's
+		// Tailscale IP.
+		src = node.Addresses().At(0).Addr()
+	}
+	return a.Caps().ContainsFunc(b.PeerCaps(src).HasCapability)
 }
 
 // encTailscaleHeaderValue cleans or encodes as necessary v, to be suitable in
@@ -878,8 +924,29 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if a := h.Access(); a.Valid() && !b.serveAccessAllowed(r.Context(), a) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if h.IdentityHeaders() && h.Proxy() == "" {
+		b.setTailscaleIdentityHeaders(r.Context(), w.Header())
+	}
+	if hdrs := h.SetResponseHeaders(); hdrs.Len() > 0 {
+		w = &setHeaderResponseWriter{
+			ResponseWriter: w,
+			headers:        hdrs,
+		}
+	}
+	if v := h.Redirect(); v != "" {
+		code := cmp.Or(h.Status(), http.StatusFound)
+		http.Redirect(w, r, expandRedirect(v, r), code)
+		return
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if code := h.Status(); code != 0 {
+			w.WriteHeader(code)
+		}
 		io.WriteString(w, s)
 		return
 	}
@@ -893,18 +960,113 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
 			return
 		}
-		h := p.(http.Handler)
-		// Trim the mount point from the URL path before proxying. (#6571)
-		if r.URL.Path != "/" {
-			h = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), h)
+		if hdrs := h.SetRequestHeaders(); hdrs.Len() > 0 {
+			r = r.Clone(r.Context())
+			for k, v := range hdrs.All() {
+				if v == "" {
+					r.Header.Del(k)
+				} else {
+					r.Header.Set(k, v)
+				}
+			}
+		}
+		h2 := p.(http.Handler)
+		if h.LoadBalancer().Valid() {
+			if pool, ok := b.backendPoolFor(h.ProxyBackends(), h.LoadBalancer()); ok {
+				h2 = pool
+			}
+		}
+		prefix := strings.TrimSuffix(mountPoint, "/")
+		if rw := h.RewritePath(); rw != "" {
+			h2 = rewritePathPrefix(prefix, strings.TrimSuffix(rw, "/"), h2)
+		} else if r.URL.Path != "/" {
+			// Trim the mount point from the URL path before proxying. (#6571)
+			h2 = http.StripPrefix(prefix, h2)
 		}
-		h.ServeHTTP(w, r)
+		h2.ServeHTTP(w, r)
+		return
+	}
+	if code := h.Status(); code != 0 {
+		http.Error(w, http.StatusText(code), code)
 		return
 	}
 
 	http.Error(w, "empty handler", 500)
 }
 
+// expandRedirect returns the HTTPHandler.Redirect target for r, with its
+// placeholders expanded.
+func expandRedirect(target string, r *http.Request) string {
+	return strings.NewReplacer(
+		"${HOST}", r.Host,
+		"${REQUEST_URI}", r.URL.RequestURI(),
+	).Replace(target)
+}
+
+// rewritePathPrefix returns a handler that serves requests by replacing
+// prefix at the start of the request URL's path with newPrefix and invoking
+// h. Requests whose path does not start with prefix get a 404.
+//
+// It is like [http.StripPrefix], but for HTTPHandler.RewritePath.
+func rewritePathPrefix(prefix, newPrefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		rp, ok := strings.CutPrefix(r.URL.RawPath, prefix)
+		if r.URL.RawPath != "" && !ok {
+			http.NotFound(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = newPrefix + p
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = newPrefix + rp
+		}
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// setHeaderResponseWriter is an http.ResponseWriter wrapper that, upon
+// flushing HTTP headers, applies HTTPHandler.SetResponseHeaders.
+type setHeaderResponseWriter struct {
+	http.ResponseWriter
+	headers views.Map[string, string]
+	setOnce sync.Once // guards call to set
+}
+
+func (w *setHeaderResponseWriter) set() {
+	h := w.ResponseWriter.Header()
+	for k, v := range w.headers.All() {
+		if v == "" {
+			h.Del(k)
+		} else {
+			h.Set(k, v)
+		}
+	}
+}
+
+func (w *setHeaderResponseWriter) WriteHeader(code int) {
+	w.setOnce.Do(w.set)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *setHeaderResponseWriter) Write(p []byte) (int, error) {
+	w.setOnce.Do(w.set)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying ResponseWriter, so that
+// [http.ResponseController] can flush and hijack proxied connections.
+func (w *setHeaderResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, fileOrDir, mountPoint string) {
 	fi, err := os.Stat(fileOrDir)
 	if err != nil {
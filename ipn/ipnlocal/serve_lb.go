@@ -0,0 +1,444 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/util/ctxkey"
+	"tailscale.com/util/mak"
+)
+
+// Defaults for the unset fields of an ipn.HealthCheck.
+const (
+	defaultHealthCheckInterval  = 10 * time.Second
+	defaultHealthCheckTimeout   = 5 * time.Second
+	defaultUnhealthyThreshold   = 2
+	tcpBackendDialTimeout       = 10 * time.Second
+	healthCheckMaxResponseBytes = 4 << 10
+)
+
+// proxyErrorKey, if present in the context of a request to a reverseProxy,
+// receives the error of a failed attempt to reach the backend instead of
+// the client receiving a 502, so that the request can be retried on
+// another backend.
+var proxyErrorKey = ctxkey.New[*error]("ipnlocal.proxyError", nil)
+
+// backendPool is a set of load balanced backends, as configured by an
+// ipn.LoadBalancer together with an HTTPHandler.Proxy or
+// TCPPortHandler.TCPForward.
+type backendPool struct {
+	b        *LocalBackend
+	web      bool // backends are HTTPHandler.Proxy values, not TCPForward
+	policy   ipn.LBPolicy
+	checks   bool // whether health checks are enabled
+	backends []*poolBackend
+	next     atomic.Uint64 // round-robin counter
+	cancel   context.CancelFunc
+}
+
+// poolBackend is a backend of a backendPool.
+type poolBackend struct {
+	target  string // as in the ServeConfig
+	active  atomic.Int64
+	healthy atomic.Bool
+
+	mu        sync.Mutex
+	failures  int // consecutive failed health checks
+	lastCheck time.Time
+	lastErr   error
+}
+
+// backendPoolKey returns the key of the backendPool of backends, load
+// balanced as configured by lb, in LocalBackend.serveBackendPools. The
+// configuration is part of the key so that changing it replaces the pool.
+func backendPoolKey(backends []string, lb ipn.LoadBalancerView) string {
+	conf, _ := lb.MarshalJSON()
+	return strings.Join(backends, ",") + " " + string(conf)
+}
+
+// newBackendPool returns a new pool of backends, configured by lb, and
+// starts its health checks, if any, until close is called.
+func (b *LocalBackend) newBackendPool(backends []string, web bool, lb ipn.LoadBalancerView) *backendPool {
+	ctx, cancel := context.WithCancel(b.ctx)
+	p := &backendPool{
+		b:      b,
+		web:    web,
+		policy: cmp.Or(lb.Policy(), ipn.LBRoundRobin),
+		checks: lb.HealthCheck().Valid(),
+		cancel: cancel,
+	}
+	for _, target := range backends {
+		be := &poolBackend{target: target}
+		be.healthy.Store(true)
+		p.backends = append(p.backends, be)
+	}
+	if hc := lb.HealthCheck(); hc.Valid() {
+		go p.runHealthChecks(ctx, hc.AsStruct())
+	}
+	return p
+}
+
+// close stops the health checks of p.
+func (p *backendPool) close() {
+	p.cancel()
+}
+
+// pick returns the backend to use for the next request or connection,
+// among those not in tried. It prefers healthy backends, falling back to
+// unhealthy ones if no healthy ones remain. It returns nil if all backends
+// have been tried.
+func (p *backendPool) pick(tried []*poolBackend) *poolBackend {
+	var healthy, untried []*poolBackend
+	start := int(p.next.Add(1) - 1)
+	for i := range p.backends {
+		be := p.backends[(start+i)%len(p.backends)]
+		if slices.Contains(tried, be) {
+			continue
+		}
+		untried = append(untried, be)
+		if be.healthy.Load() {
+			healthy = append(healthy, be)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = untried
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	if p.policy == ipn.LBLeastConn {
+		return slices.MinFunc(candidates, func(a, b *poolBackend) int {
+			return cmp.Compare(a.active.Load(), b.active.Load())
+		})
+	}
+	return candidates[0]
+}
+
+// markFailed records that connecting to be failed with err. If health
+// checks are enabled, be is unhealthy until its next successful check.
+func (p *backendPool) markFailed(be *poolBackend, err error) {
+	if !p.checks {
+		return
+	}
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.lastErr = err
+	if be.healthy.Swap(false) {
+		p.b.logf("serve: backend %s is unhealthy: %v", be.target, err)
+	}
+}
+
+// ServeHTTP proxies r to a backend of p. Requests without a body fail over
+// to another backend if the selected one cannot be reached.
+func (p *backendPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	canRetry := r.Body == nil || r.Body == http.NoBody
+	var tried []*poolBackend
+	for {
+		be := p.pick(tried)
+		if be == nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		tried = append(tried, be)
+		v, ok := p.b.serveProxyHandlers.Load(be.target)
+		if !ok {
+			p.markFailed(be, errors.New("no proxy handler"))
+			continue
+		}
+		var proxyErr error
+		r2 := r
+		if canRetry {
+			r2 = r.WithContext(proxyErrorKey.WithValue(r.Context(), &proxyErr))
+		}
+		func() {
+			be.active.Add(1)
+			defer be.active.Add(-1) // ServeHTTP may panic with http.ErrAbortHandler
+			v.(http.Handler).ServeHTTP(w, r2)
+		}()
+		if proxyErr == nil {
+			return
+		}
+		if r.Context().Err() != nil {
+			return // client went away
+		}
+		p.b.logf("serve: proxy to %s failed: %v", be.target, proxyErr)
+		p.markFailed(be, proxyErr)
+	}
+}
+
+// dial connects to a backend of p, trying each backend in turn until one
+// succeeds. The backend counts as active until the returned conn is
+// closed.
+func (p *backendPool) dial(ctx context.Context) (net.Conn, error) {
+	var tried []*poolBackend
+	var errs []error
+	for {
+		be := p.pick(tried)
+		if be == nil {
+			return nil, errors.Join(errs...)
+		}
+		tried = append(tried, be)
+		dctx, cancel := context.WithTimeout(ctx, tcpBackendDialTimeout)
+		c, err := p.b.dialer.SystemDial(dctx, "tcp", be.target)
+		cancel()
+		if err != nil {
+			errs = append(errs, err)
+			p.markFailed(be, err)
+			continue
+		}
+		be.active.Add(1)
+		return &poolConn{Conn: c, be: be}, nil
+	}
+}
+
+// poolConn is a connection to a poolBackend, which it counts as active
+// until closed.
+type poolConn struct {
+	net.Conn
+	be        *poolBackend
+	closeOnce sync.Once
+}
+
+func (c *poolConn) Close() error {
+	c.closeOnce.Do(func() { c.be.active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// runHealthChecks checks the health of the backends of p every interval
+// of hc until ctx is done.
+func (p *backendPool) runHealthChecks(ctx context.Context, hc *ipn.HealthCheck) {
+	interval := cmp.Or(hc.Interval.Duration, defaultHealthCheckInterval)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		p.checkHealth(ctx, hc)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkHealth checks the health of all the backends of p once,
+// concurrently.
+func (p *backendPool) checkHealth(ctx context.Context, hc *ipn.HealthCheck) {
+	interval := cmp.Or(hc.Interval.Duration, defaultHealthCheckInterval)
+	timeout := min(cmp.Or(hc.Timeout.Duration, defaultHealthCheckTimeout), interval)
+	threshold := cmp.Or(hc.UnhealthyThreshold, defaultUnhealthyThreshold)
+
+	var wg sync.WaitGroup
+	for _, be := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := p.checkBackend(cctx, be, hc.Path)
+			if ctx.Err() != nil {
+				return // pool closed
+			}
+			be.mu.Lock()
+			defer be.mu.Unlock()
+			be.lastCheck = time.Now()
+			if err == nil {
+				be.failures = 0
+				be.lastErr = nil
+				if !be.healthy.Swap(true) {
+					p.b.logf("serve: backend %s is healthy", be.target)
+				}
+				return
+			}
+			be.failures++
+			be.lastErr = err
+			if be.failures >= threshold && be.healthy.Swap(false) {
+				p.b.logf("serve: backend %s is unhealthy: %v", be.target, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// checkBackend performs a health check of be: an HTTP GET request of
+// checkPath if non-empty, else a TCP connection.
+func (p *backendPool) checkBackend(ctx context.Context, be *poolBackend, checkPath string) error {
+	if !p.web {
+		c, err := p.b.dialer.SystemDial(ctx, "tcp", be.target)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+	targetURL, _ := expandProxyArg(be.target)
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return err
+	}
+	v, ok := p.b.serveProxyHandlers.Load(be.target)
+	if !ok {
+		return errors.New("no proxy handler")
+	}
+	tr := v.(*reverseProxy).getTransport()
+	if checkPath == "" {
+		c, err := tr.DialContext(ctx, "tcp", hostPortWithDefault(u))
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+	u.Path, u.RawPath, u.RawQuery = checkPath, "", ""
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, healthCheckMaxResponseBytes))
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return fmt.Errorf("health check returned %v", res.Status)
+	}
+	return nil
+}
+
+// hostPortWithDefault returns the host:port of u, using the default port of
+// its scheme if it has none.
+func hostPortWithDefault(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (be *poolBackend) status() ipn.ServeBackendStatus {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	st := ipn.ServeBackendStatus{
+		Backend:   be.target,
+		Healthy:   be.healthy.Load(),
+		Active:    be.active.Load(),
+		LastCheck: be.lastCheck,
+	}
+	if be.lastErr != nil && !st.Healthy {
+		st.LastError = be.lastErr.Error()
+	}
+	return st
+}
+
+// setServeBackendPoolsLocked ensures there is a backendPool for each load
+// balanced handler in b.serveConfig, and closes the pools no longer in use.
+// It must be called after setServeProxyHandlersLocked.
+func (b *LocalBackend) setServeBackendPoolsLocked() {
+	var keys map[string]bool
+	add := func(backends []string, web bool, lb ipn.LoadBalancerView) {
+		if !lb.Valid() || len(backends) < 2 {
+			return
+		}
+		key := backendPoolKey(backends, lb)
+		mak.Set(&keys, key, true)
+		if _, ok := b.serveBackendPools.Load(key); ok {
+			return
+		}
+		b.logf("serve: creating a load balancer for %s", strings.Join(backends, ","))
+		b.serveBackendPools.Store(key, b.newBackendPool(backends, web, lb))
+	}
+	if b.serveConfig.Valid() {
+		// Webs includes the Web handlers of foreground configs and
+		// services, but TCPs doesn't include those of services.
+		for _, conf := range b.serveConfig.Webs() {
+			for _, h := range conf.Handlers().All() {
+				add(h.ProxyBackends(), true, h.LoadBalancer())
+			}
+		}
+		for _, h := range b.serveConfig.TCPs() {
+			add(h.ForwardBackends(), false, h.LoadBalancer())
+		}
+		for _, svc := range b.serveConfig.Services().All() {
+			for _, h := range svc.TCP().All() {
+				add(h.ForwardBackends(), false, h.LoadBalancer())
+			}
+		}
+	}
+
+	b.serveBackendPools.Range(func(key, value any) bool {
+		if !keys[key.(string)] {
+			p := value.(*backendPool)
+			b.logf("serve: closing the load balancer for %s", p.statusKey())
+			b.serveBackendPools.Delete(key)
+			p.close()
+		}
+		return true
+	})
+}
+
+// backendPoolFor returns the backendPool of backends, load balanced as
+// configured by lb, if any.
+func (b *LocalBackend) backendPoolFor(backends []string, lb ipn.LoadBalancerView) (*backendPool, bool) {
+	v, ok := b.serveBackendPools.Load(backendPoolKey(backends, lb))
+	if !ok {
+		return nil, false
+	}
+	return v.(*backendPool), true
+}
+
+// dialServeTCPForward connects to the TCPForward backend of h, or to one of
+// its load balanced backends.
+func (b *LocalBackend) dialServeTCPForward(ctx context.Context, h ipn.TCPPortHandlerView) (net.Conn, error) {
+	if h.LoadBalancer().Valid() {
+		if p, ok := b.backendPoolFor(h.ForwardBackends(), h.LoadBalancer()); ok {
+			return p.dial(ctx)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, tcpBackendDialTimeout)
+	defer cancel()
+	return b.dialer.SystemDial(ctx, "tcp", h.TCPForward())
+}
+
+// ServeBackendStatus returns the state of the load balanced serve
+// backends, keyed by the comma-separated list of backends of each load
+// balancer, in the same order as the ServeConfig handler's.
+func (b *LocalBackend) ServeBackendStatus() map[string][]ipn.ServeBackendStatus {
+	ret := map[string][]ipn.ServeBackendStatus{}
+	b.serveBackendPools.Range(func(_, value any) bool {
+		p := value.(*backendPool)
+		st := make([]ipn.ServeBackendStatus, 0, len(p.backends))
+		for _, be := range p.backends {
+			st = append(st, be.status())
+		}
+		ret[p.statusKey()] = st
+		return true
+	})
+	return ret
+}
+
+// statusKey returns the key of p in the result of ServeBackendStatus: the
+// comma-separated list of its backends.
+func (p *backendPool) statusKey() string {
+	targets := make([]string, len(p.backends))
+	for i, be := range p.backends {
+		targets[i] = be.target
+	}
+	return strings.Join(targets, ",")
+}
@@ -0,0 +1,269 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// closedAddr returns the address of a TCP listener that has been closed, to
+// which connections fail.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func newTestBackendPool(b *LocalBackend, policy ipn.LBPolicy, checks bool, targets ...string) *backendPool {
+	p := &backendPool{b: b, policy: policy, checks: checks, cancel: func() {}}
+	for _, target := range targets {
+		be := &poolBackend{target: target}
+		be.healthy.Store(true)
+		p.backends = append(p.backends, be)
+	}
+	return p
+}
+
+func TestBackendPoolPick(t *testing.T) {
+	b := newTestBackend(t)
+
+	p := newTestBackendPool(b, ipn.LBRoundRobin, true, "a", "b", "c")
+	var got []string
+	for range 4 {
+		got = append(got, p.pick(nil).target)
+	}
+	if want := "a,b,c,a"; strings.Join(got, ",") != want {
+		t.Errorf("round-robin picks = %q; want %q", strings.Join(got, ","), want)
+	}
+
+	// Unhealthy backends are skipped while others remain, their turns
+	// going to the next backend.
+	p.backends[1].healthy.Store(false)
+	got = got[:0]
+	for range 3 {
+		got = append(got, p.pick(nil).target)
+	}
+	if want := "c,c,a"; strings.Join(got, ",") != want {
+		t.Errorf("picks with b unhealthy = %q; want %q", strings.Join(got, ","), want)
+	}
+	tried := []*poolBackend{p.backends[0], p.backends[2]}
+	if be := p.pick(tried); be != p.backends[1] {
+		t.Errorf("pick with only b untried = %v; want b", be)
+	}
+	if be := p.pick(p.backends); be != nil {
+		t.Errorf("pick with all tried = %v; want nil", be.target)
+	}
+
+	p = newTestBackendPool(b, ipn.LBLeastConn, true, "a", "b", "c")
+	p.backends[0].active.Store(3)
+	p.backends[1].active.Store(1)
+	p.backends[2].active.Store(2)
+	for range 3 {
+		if be := p.pick(nil); be != p.backends[1] {
+			t.Errorf("least-conn pick = %q; want b", be.target)
+		}
+	}
+}
+
+func TestBackendPoolHealthChecks(t *testing.T) {
+	b := newTestBackend(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	down := closedAddr(t)
+
+	p := newTestBackendPool(b, ipn.LBRoundRobin, true, down, ln.Addr().String())
+	hc := &ipn.HealthCheck{UnhealthyThreshold: 2}
+	p.checkHealth(context.Background(), hc)
+	if !p.backends[0].healthy.Load() {
+		t.Fatalf("backend unhealthy after one failed check; want healthy until threshold")
+	}
+	p.checkHealth(context.Background(), hc)
+	st := p.backends[0].status()
+	if st.Healthy || st.LastError == "" || st.LastCheck.IsZero() {
+		t.Errorf("status of down backend = %+v; want unhealthy with error", st)
+	}
+	if st := p.backends[1].status(); !st.Healthy || st.LastError != "" {
+		t.Errorf("status of up backend = %+v; want healthy", st)
+	}
+
+	// Connections go to the healthy backend, and count as active until
+	// closed.
+	c, err := p.dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.backends[1].active.Load(); got != 1 {
+		t.Errorf("active = %d; want 1", got)
+	}
+	c.Close()
+	c.Close()
+	if got := p.backends[1].active.Load(); got != 0 {
+		t.Errorf("active after close = %d; want 0", got)
+	}
+}
+
+func TestServeLoadBalancer(t *testing.T) {
+	b := newTestBackend(t)
+
+	var hits [2]atomic.Int32
+	newServer := func(i int) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthz" {
+				hits[i].Add(1)
+			}
+		}))
+		t.Cleanup(s.Close)
+		return s
+	}
+	s0, s1 := newServer(0), newServer(1)
+	down := "http://" + closedAddr(t)
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {
+					Proxy: down,
+					LoadBalancer: &ipn.LoadBalancer{
+						Backends:    []string{s0.URL, s1.URL},
+						HealthCheck: &ipn.HealthCheck{Path: "/healthz"},
+					},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 6 {
+		req := httptest.NewRequest("GET", "https://example.ts.net/", nil)
+		req.TLS = &tls.ConnectionState{ServerName: "example.ts.net"}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+		}
+	}
+	if hits[0].Load() == 0 || hits[1].Load() == 0 {
+		t.Errorf("backend hits = %d, %d; want requests spread over both", hits[0].Load(), hits[1].Load())
+	}
+
+	key := strings.Join([]string{down, s0.URL, s1.URL}, ",")
+	st := b.ServeBackendStatus()[key]
+	if len(st) != 3 {
+		t.Fatalf("ServeBackendStatus()[%q] = %+v; want 3 backends", key, st)
+	}
+	if st[0].Backend != down || st[0].Healthy {
+		t.Errorf("status of down backend = %+v; want unhealthy", st[0])
+	}
+
+	// Changing only the settings of the load balancer replaces its pool.
+	pool := func() (p *backendPool) {
+		b.serveBackendPools.Range(func(_, v any) bool {
+			p = v.(*backendPool)
+			return false
+		})
+		return p
+	}
+	before := pool()
+	conf.Web["example.ts.net:443"].Handlers["/"].LoadBalancer.Policy = ipn.LBLeastConn
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if after := pool(); after == before || after.policy != ipn.LBLeastConn {
+		t.Errorf("pool after changing the policy = %p with policy %q; want a new pool with %q", after, after.policy, ipn.LBLeastConn)
+	}
+
+	// Removing the load balancer closes its pool.
+	if err := b.SetServeConfig(nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.ServeBackendStatus(); len(got) != 0 {
+		t.Errorf("ServeBackendStatus after reset = %+v; want empty", got)
+	}
+}
+
+func TestServeLoadBalancerService(t *testing.T) {
+	b := newTestBackend(t)
+
+	var hits atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(s.Close)
+	down := "http://" + closedAddr(t)
+
+	conf := &ipn.ServeConfig{
+		Services: map[tailcfg.ServiceName]*ipn.ServiceConfig{
+			"svc:foo": {
+				TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"foo.example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {
+							Proxy:        down,
+							LoadBalancer: &ipn.LoadBalancer{Backends: []string{s.URL}},
+						},
+					}},
+				},
+			},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.ServeBackendStatus()[down+","+s.URL]; !ok {
+		t.Fatalf("ServeBackendStatus() = %+v; want a pool for the service's backends", b.ServeBackendStatus())
+	}
+
+	// The backend that's down is passed over for the other one.
+	for range 2 {
+		req := httptest.NewRequest("GET", "https://foo.example.ts.net/", nil)
+		req.TLS = &tls.ConnectionState{ServerName: "foo.example.ts.net"}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			ForVIPService: "svc:foo",
+			DestPort:      443,
+			SrcAddr:       netip.MustParseAddrPort("100.150.151.152:1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+		}
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("backend hits = %d; want 2", got)
+	}
+}
@@ -24,18 +24,22 @@
 	"testing"
 	"time"
 
+	"go4.org/netipx"
 	"tailscale.com/health"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/views"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/must"
 	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/filter"
 )
 
 func TestExpandProxyArg(t *testing.T) {
@@ -572,6 +576,7 @@ func(w http.ResponseWriter, r *http.Request) {
 		name            string
 		mountPoint      string
 		proxyPath       string
+		rewritePath     string
 		requestPath     string
 		wantRequestPath string
 	}{
@@ -624,13 +629,51 @@ func(w http.ResponseWriter, r *http.Request) {
 			requestPath:     "/foo/bar/baz",
 			wantRequestPath: "/foo/bar/baz",
 		},
+		{
+			name:            "/api/users -> /v2/users, with mount point /api and rewrite /v2",
+			mountPoint:      "/api",
+			rewritePath:     "/v2",
+			requestPath:     "/api/users",
+			wantRequestPath: "/v2/users",
+		},
+		{
+			name:            "/api/ -> /v2/, with mount point /api/ and rewrite /v2/",
+			mountPoint:      "/api/",
+			rewritePath:     "/v2/",
+			requestPath:     "/api/",
+			wantRequestPath: "/v2/",
+		},
+		{
+			name:            "/api/users -> /api/users, with mount point /api and rewrite /api",
+			mountPoint:      "/api",
+			rewritePath:     "/api",
+			requestPath:     "/api/users",
+			wantRequestPath: "/api/users",
+		},
+		{
+			name:            "/users -> /v2/users, with mount point / and rewrite /v2",
+			mountPoint:      "/",
+			rewritePath:     "/v2",
+			requestPath:     "/users",
+			wantRequestPath: "/v2/users",
+		},
+		{
+			name:            "/api/users -> /users, with mount point /api and rewrite /",
+			mountPoint:      "/api",
+			rewritePath:     "/",
+			requestPath:     "/api/users",
+			wantRequestPath: "/users",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			conf := &ipn.ServeConfig{
 				Web: map[ipn.HostPort]*ipn.WebServerConfig{
 					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
-						tt.mountPoint: {Proxy: testServ.URL + tt.proxyPath},
+						tt.mountPoint: {
+							Proxy:       testServ.URL + tt.proxyPath,
+							RewritePath: tt.rewritePath,
+						},
 					}},
 				},
 			}
@@ -676,7 +719,13 @@ func(w http.ResponseWriter, r *http.Request) {
 	conf := &ipn.ServeConfig{
 		Web: map[ipn.HostPort]*ipn.WebServerConfig{
 			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
-				"/": {Proxy: testServ.URL},
+				"/": {
+					Proxy: testServ.URL,
+					SetRequestHeaders: map[string]string{
+						"X-Env":    "prod",
+						"X-Remove": "",
+					},
+				},
 			}},
 		},
 	}
@@ -737,6 +786,11 @@ type headerCheck struct {
 			req := &http.Request{
 				URL: &url.URL{Path: "/"},
 				TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+				Header: http.Header{
+					"X-Env":                {"dev"},
+					"X-Remove":             {"1"},
+					"Tailscale-User-Login": {"spoofed@example.com"},
+				},
 			}
 			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
 				DestPort: 443,
@@ -748,7 +802,7 @@ type headerCheck struct {
 
 			// Verify the headers.
 			h := w.Result().Header
-			for _, c := range tt.wantHeaders {
+			for _, c := range append(tt.wantHeaders, headerCheck{"X-Env", "prod"}, headerCheck{"X-Remove", ""}) {
 				if got := h.Get(c.header); got != c.want {
 					t.Errorf("invalid %q header; want=%q, got=%q", c.header, c.want, got)
 				}
@@ -757,6 +811,118 @@ type headerCheck struct {
 	}
 }
 
+func TestServeWebHandlerKinds(t *testing.T) {
+	b := newTestBackend(t)
+	td := t.TempDir()
+	if err := os.WriteFile(filepath.Join(td, "index.html"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testServ := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Backend", "1")
+	}))
+	defer testServ.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/old/": {Redirect: "https://${HOST}/new${REQUEST_URI}", Status: http.StatusMovedPermanently},
+				"/away": {Redirect: "https://example.com/"},
+				"/gone": {Status: http.StatusGone},
+				"/teapot": {
+					Text:               "short and stout",
+					Status:             http.StatusTeapot,
+					SetResponseHeaders: map[string]string{"Cache-Control": "no-store"},
+				},
+				"/index.html": {
+					Path:               filepath.Join(td, "index.html"),
+					IdentityHeaders:    true,
+					SetResponseHeaders: map[string]string{"Content-Type": "text/x-custom"},
+				},
+				"/api": {
+					Proxy:              testServ.URL,
+					SetResponseHeaders: map[string]string{"Server": "tailscale", "X-Backend": ""},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{
+			path:        "/old/page?x=1",
+			wantStatus:  http.StatusMovedPermanently,
+			wantHeaders: map[string]string{"Location": "https://example.ts.net/new/old/page?x=1"},
+		},
+		{
+			path:        "/away",
+			wantStatus:  http.StatusFound,
+			wantHeaders: map[string]string{"Location": "https://example.com/"},
+		},
+		{
+			path:       "/gone",
+			wantStatus: http.StatusGone,
+			wantBody:   "Gone\n",
+		},
+		{
+			path:        "/teapot",
+			wantStatus:  http.StatusTeapot,
+			wantBody:    "short and stout",
+			wantHeaders: map[string]string{"Cache-Control": "no-store"},
+		},
+		{
+			path:       "/index.html",
+			wantStatus: http.StatusOK,
+			wantBody:   "hello",
+			wantHeaders: map[string]string{
+				"Content-Type":         "text/x-custom",
+				"Tailscale-User-Login": "someone@example.com",
+				"Tailscale-User-Name":  "Some One",
+			},
+		},
+		{
+			path:        "/api",
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Server": "tailscale", "X-Backend": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://example.ts.net"+tt.path, nil)
+			req.TLS = &tls.ConnectionState{ServerName: "example.ts.net"}
+			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+			}))
+
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+
+			res := w.Result()
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" {
+				if got := w.Body.String(); got != tt.wantBody {
+					t.Errorf("body = %q; want %q", got, tt.wantBody)
+				}
+			}
+			for k, want := range tt.wantHeaders {
+				if got := res.Header.Get(k); got != want {
+					t.Errorf("%s header = %q; want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
 func Test_reverseProxyConfiguration(t *testing.T) {
 	b := newTestBackend(t)
 	type test struct {
@@ -870,6 +1036,79 @@ func mustCreateURL(t *testing.T, u string) url.URL {
 	return *uParsed
 }
 
+func TestServeAccess(t *testing.T) {
+	b := newTestBackend(t)
+
+	// Give the node an address, which PeerCaps needs, and grant the admin
+	// capability to the tagged peer only.
+	const capAdmin tailcfg.PeerCapability = "example.com/cap/serve-admin"
+	nm := *b.currentNode().NetMap()
+	self := nm.SelfNode.AsStruct()
+	self.Addresses = []netip.Prefix{netip.MustParsePrefix("100.150.151.151/32")}
+	nm.SelfNode = self.View()
+	b.currentNode().SetNetMap(&nm)
+	b.setFilter(filter.New([]filter.Match{{
+		IPProto: views.SliceOf([]ipproto.Proto{ipproto.TCP}),
+		Srcs:    []netip.Prefix{netip.MustParsePrefix("100.150.151.153/32")},
+		Caps:    []filter.CapMatch{{Dst: netip.MustParsePrefix("100.150.151.151/32"), Cap: capAdmin}},
+	}}, nil, new(netipx.IPSet), new(netipx.IPSet), nil, logger.Discard))
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":        {Text: "public"},
+				"/admin":   {Text: "admin", Access: &ipn.ServeAccess{Caps: []tailcfg.PeerCapability{capAdmin}}},
+				"/users":   {Text: "users", Access: &ipn.ServeAccess{Logins: []string{"SomeOne@example.com"}}},
+				"/servers": {Text: "servers", Access: &ipn.ServeAccess{Tags: []string{"tag:server"}}},
+			}},
+		},
+		AllowFunnel: map[ipn.HostPort]bool{"example.ts.net:443": true},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		userPeer   = "100.150.151.152"
+		taggedPeer = "100.150.151.153"
+		stranger   = "100.160.161.162"
+	)
+	tests := []struct {
+		path   string
+		src    string
+		funnel bool
+		want   int
+	}{
+		{"/", stranger, true, http.StatusOK},
+		{"/admin", taggedPeer, false, http.StatusOK},
+		{"/admin", userPeer, false, http.StatusForbidden},
+		{"/users", userPeer, false, http.StatusOK},
+		{"/users", taggedPeer, false, http.StatusForbidden},
+		{"/users", stranger, false, http.StatusForbidden},
+		{"/servers", taggedPeer, false, http.StatusOK},
+		{"/servers", userPeer, false, http.StatusForbidden},
+		{"/servers", taggedPeer, true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "https://example.ts.net"+tt.path, nil)
+		req.TLS = &tls.ConnectionState{ServerName: "example.ts.net"}
+		sctx := &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort(tt.src + ":1234"),
+		}
+		if tt.funnel {
+			sctx.Funnel = &funnelFlow{Host: "example.ts.net:443"}
+		}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), sctx))
+
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s from %s (funnel=%v): status = %d; want %d", tt.path, tt.src, tt.funnel, w.Code, tt.want)
+		}
+	}
+}
+
 func newTestBackend(t *testing.T) *LocalBackend {
 	var logf logger.Logf = logger.Discard
 	const debug = true
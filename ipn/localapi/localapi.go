@@ -47,6 +47,7 @@
 	"tailscale.com/tka"
 	"tailscale.com/tstime"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -95,6 +96,9 @@
 	"debug-bus-events":             (*Handler).serveDebugBusEvents,
 	"debug-derp-region":            (*Handler).serveDebugDERPRegion,
 	"debug-dial-types":             (*Handler).serveDebugDialTypes,
+	"debug-filter-conntrack":       (*Handler).serveDebugFilterConnTrack,
+	"debug-filter-explain":         (*Handler).serveDebugFilterExplain,
+	"debug-filter-stats":           (*Handler).serveDebugFilterStats,
 	"debug-log":                    (*Handler).serveDebugLog,
 	"debug-packet-filter-matches":  (*Handler).serveDebugPacketFilterMatches,
 	"debug-packet-filter-rules":    (*Handler).serveDebugPacketFilterRules,
@@ -121,6 +125,7 @@
 	"query-feature":                (*Handler).serveQueryFeature,
 	"reload-config":                (*Handler).reloadConfig,
 	"reset-auth":                   (*Handler).serveResetAuth,
+	"serve-backends":               (*Handler).serveServeBackends,
 	"serve-config":                 (*Handler).serveServeConfig,
 	"set-dns":                      (*Handler).serveSetDNS,
 	"set-expiry-sooner":            (*Handler).serveSetExpirySooner,
@@ -760,6 +765,74 @@ func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.R
 	enc.Encode(nm.PacketFilter)
 }
 
+func (h *Handler) serveDebugFilterStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(h.b.FilterStats())
+}
+
+func (h *Handler) serveDebugFilterConnTrack(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(h.b.FilterConnTrack())
+}
+
+// serveDebugFilterExplain explains the packet filter's verdict on a new
+// incoming connection from the "src" IP to the "dst" IP (by default this
+// node's) and "port", of protocol "proto" (by default TCP).
+func (h *Handler) serveDebugFilterExplain(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	src, err := netip.ParseAddr(r.FormValue("src"))
+	if err != nil {
+		http.Error(w, "invalid src: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var dst netip.Addr
+	if v := r.FormValue("dst"); v != "" {
+		if dst, err = netip.ParseAddr(v); err != nil {
+			http.Error(w, "invalid dst: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	port, err := strconv.ParseUint(r.FormValue("port"), 10, 16)
+	if err != nil {
+		http.Error(w, "invalid port: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	proto := ipproto.TCP
+	if v := r.FormValue("proto"); v != "" {
+		if err := proto.UnmarshalText([]byte(v)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := h.b.ExplainFilter(src, dst, uint16(port), proto)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(res)
+}
+
 func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -1223,6 +1296,21 @@ func authorizeServeConfigForGOOSAndUserContext(goos string, configIn *ipn.ServeC
 	}
 }
 
+// serveServeBackends reports the state of the load balanced serve
+// backends.
+func (h *Handler) serveServeBackends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	if !h.PermitRead {
+		http.Error(w, "serve backends access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.ServeBackendStatus())
+}
+
 func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "IP forwarding check access denied", http.StatusForbidden)
@@ -2675,6 +2763,10 @@ func (h *Handler) serveShares(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "invalid share name", http.StatusBadRequest)
 				return
 			}
+			if errors.Is(err, drive.ErrInvalidSymlinks) || errors.Is(err, drive.ErrInvalidQuota) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -13,9 +13,12 @@
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"golang.org/x/net/http/httpguts"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/set"
@@ -149,19 +152,327 @@ type TCPPortHandler struct {
 	// SNI name with this value. It is only used if TCPForward is non-empty.
 	// (the HTTPS mode uses ServeConfig.Web)
 	TerminateTLS string `json:",omitempty"`
+
+	// LoadBalancer, if non-nil, spreads connections across TCPForward and
+	// further backends. It is only used if TCPForward is non-empty.
+	LoadBalancer *LoadBalancer `json:",omitempty"`
 }
 
-// HTTPHandler is either a path or a proxy to serve.
+// LoadBalancer configures spreading the requests of an HTTPHandler.Proxy,
+// or the connections of a TCPPortHandler.TCPForward, across several
+// backends.
+//
+// Handlers with the same backends share their state, such as the health of
+// each backend, along with the Policy and HealthCheck of one of them.
+type LoadBalancer struct {
+	// Backends are the backends besides the Proxy or TCPForward of the
+	// handler, in the same form.
+	Backends []string `json:",omitempty"`
+
+	// Policy is how a backend is selected for each request or connection.
+	// Empty means LBRoundRobin.
+	Policy LBPolicy `json:",omitempty"`
+
+	// HealthCheck, if non-nil, enables active health checks of the
+	// backends.
+	HealthCheck *HealthCheck `json:",omitempty"`
+}
+
+// LBPolicy is a load balancing policy.
+type LBPolicy string
+
+const (
+	LBRoundRobin LBPolicy = "round-robin" // each backend in turn
+	LBLeastConn  LBPolicy = "least-conn"  // the backend with the fewest active requests or connections
+)
+
+// HealthCheck configures active health checks of load balanced backends.
+//
+// A backend becomes unhealthy after UnhealthyThreshold consecutive failed
+// checks, or after a failure to connect to it between checks, and becomes
+// healthy again after a successful check. Unhealthy backends are not
+// selected unless all backends are unhealthy.
+type HealthCheck struct {
+	// Path, if non-empty, makes each check an HTTP GET request for Path,
+	// which succeeds on a 2xx or 3xx response. Otherwise a check succeeds
+	// if a TCP connection to the backend can be established. Path can only
+	// be set for HTTPHandler backends.
+	Path string `json:",omitempty"`
+
+	// Interval is the time between checks of each backend. Zero means 10
+	// seconds.
+	Interval tstime.GoDuration `json:",omitzero"`
+
+	// Timeout is how long a check may take. Zero means 5 seconds, or
+	// Interval if it is shorter.
+	Timeout tstime.GoDuration `json:",omitzero"`
+
+	// UnhealthyThreshold is the number of consecutive failed checks after
+	// which a backend is unhealthy. Zero means 2.
+	UnhealthyThreshold int `json:",omitempty"`
+}
+
+// checkValid reports whether lb is a valid load balancer. forWeb reports
+// whether it is one of an HTTPHandler.
+func (lb *LoadBalancer) checkValid(forWeb bool) error {
+	if len(lb.Backends) == 0 {
+		return errors.New("load balancer has no additional backends")
+	}
+	if slices.Contains(lb.Backends, "") {
+		return errors.New("load balancer has an empty backend")
+	}
+	switch lb.Policy {
+	case "", LBRoundRobin, LBLeastConn:
+	default:
+		return fmt.Errorf("unknown load balancing policy %q", lb.Policy)
+	}
+	if hc := lb.HealthCheck; hc != nil {
+		if hc.Path != "" {
+			if !forWeb {
+				return errors.New("HTTP health checks are only supported for web handlers")
+			}
+			if !strings.HasPrefix(hc.Path, "/") {
+				return fmt.Errorf("health check path %q must start with /", hc.Path)
+			}
+		}
+		if hc.Interval.Duration < 0 || hc.Timeout.Duration < 0 || hc.UnhealthyThreshold < 0 {
+			return errors.New("health check intervals, timeouts and thresholds cannot be negative")
+		}
+	}
+	return nil
+}
+
+// CheckValid reports whether h is a valid handler.
+func (h *TCPPortHandler) CheckValid() error {
+	if h.LoadBalancer != nil {
+		if h.TCPForward == "" {
+			return errors.New("LoadBalancer can only be set with TCPForward")
+		}
+		return h.LoadBalancer.checkValid(false)
+	}
+	return nil
+}
+
+// ForwardBackends returns the backends connections to v are forwarded to:
+// TCPForward, followed by those of its LoadBalancer, if any.
+func (v TCPPortHandlerView) ForwardBackends() []string {
+	if v.TCPForward() == "" {
+		return nil
+	}
+	if !v.LoadBalancer().Valid() {
+		return []string{v.TCPForward()}
+	}
+	return append([]string{v.TCPForward()}, v.LoadBalancer().Backends().AsSlice()...)
+}
+
+// HTTPHandler is either a path, a proxy, text or a redirect to serve.
 type HTTPHandler struct {
-	// Exactly one of the following may be set.
+	// At most one of the following may be set. If none is, Status must be
+	// set, and the handler responds with that status code only.
 
 	Path  string `json:",omitempty"` // absolute path to directory or file to serve
 	Proxy string `json:",omitempty"` // http://localhost:3000/, localhost:3030, 3030
 
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
+	// Redirect is the URL to redirect requests to. The placeholders
+	// ${HOST} and ${REQUEST_URI} are replaced by the host and the path and
+	// query of the request, respectively.
+	Redirect string `json:",omitempty"`
+
+	// Status is the HTTP status code of Text, Redirect and status-only
+	// responses. Zero means 200 for Text and 302 for Redirect. It must be a
+	// redirect code (301, 302, 303, 307 or 308) for Redirect, must not be a
+	// 1xx or 3xx code otherwise, and must be zero for Path and Proxy.
+	Status int `json:",omitempty"`
+
+	// RewritePath, if non-empty, replaces the mount point at the start of
+	// the path of requests sent to Proxy. By default the mount point is
+	// stripped: with a mount point of /api, a request for /api/users is
+	// proxied as /users, or as /v2/users with a RewritePath of /v2. Setting
+	// RewritePath to the mount point keeps the path unchanged.
+	RewritePath string `json:",omitempty"`
+
+	// SetRequestHeaders are headers to set on requests sent to Proxy,
+	// replacing any sent by the client. An empty value removes the header.
+	// The X-Forwarded-* and Tailscale-* headers set by tailscaled cannot be
+	// overridden.
+	SetRequestHeaders map[string]string `json:",omitempty"`
+
+	// SetResponseHeaders are headers to set on responses, replacing any set
+	// by the Path or Proxy backend. An empty value removes the header.
+	SetResponseHeaders map[string]string `json:",omitempty"`
+
+	// IdentityHeaders, if true, sets the Tailscale-User-Login,
+	// Tailscale-User-Name and Tailscale-User-Profile-Pic headers identifying
+	// the requesting user on responses of Path, Text and Redirect handlers,
+	// for pages that want to know who is viewing them. Proxy handlers
+	// always send these headers to the backend instead.
+	IdentityHeaders bool `json:",omitempty"`
+
+	// LoadBalancer, if non-nil, spreads requests across Proxy and further
+	// backends.
+	LoadBalancer *LoadBalancer `json:",omitempty"`
+
+	// Access, if non-nil, restricts the handler to the peers it allows.
+	// Other requests, including all Funnel requests, get a 403 Forbidden
+	// response.
+	Access *ServeAccess `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
-	// temporary ones? Error codes? Redirects?
+	// temporary ones?
+}
+
+// CheckValid reports whether h is a valid handler.
+func (h *HTTPHandler) CheckValid() error {
+	var kinds []string
+	for kind, v := range map[string]string{
+		"Path":     h.Path,
+		"Proxy":    h.Proxy,
+		"Text":     h.Text,
+		"Redirect": h.Redirect,
+	} {
+		if v != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) > 1 {
+		slices.Sort(kinds)
+		return fmt.Errorf("only one of %s may be set", strings.Join(kinds, ", "))
+	}
+	switch {
+	case h.Path != "" || h.Proxy != "":
+		if h.Status != 0 {
+			return errors.New("Status cannot be set for Path or Proxy handlers")
+		}
+	case h.Redirect != "":
+		switch h.Status {
+		case 0, 301, 302, 303, 307, 308:
+		default:
+			return fmt.Errorf("invalid redirect status %d", h.Status)
+		}
+		if _, err := url.Parse(strings.NewReplacer("${HOST}", "host", "${REQUEST_URI}", "/").Replace(h.Redirect)); err != nil {
+			return fmt.Errorf("invalid redirect URL: %w", err)
+		}
+	case h.Text == "" && h.Status == 0:
+		return errors.New("empty handler")
+	default:
+		if h.Status != 0 && (h.Status < 200 || h.Status > 599 || h.Status/100 == 3) {
+			return fmt.Errorf("invalid status %d", h.Status)
+		}
+	}
+	if h.Access != nil {
+		if err := h.Access.checkValid(); err != nil {
+			return err
+		}
+	}
+	if h.LoadBalancer != nil {
+		if h.Proxy == "" {
+			return errors.New("LoadBalancer can only be set for Proxy handlers")
+		}
+		if err := h.LoadBalancer.checkValid(true); err != nil {
+			return err
+		}
+	}
+	if h.Proxy == "" {
+		if h.RewritePath != "" {
+			return errors.New("RewritePath can only be set for Proxy handlers")
+		}
+		if len(h.SetRequestHeaders) > 0 {
+			return errors.New("SetRequestHeaders can only be set for Proxy handlers")
+		}
+	}
+	if h.RewritePath != "" && !strings.HasPrefix(h.RewritePath, "/") {
+		return fmt.Errorf("RewritePath %q must start with /", h.RewritePath)
+	}
+	for k, v := range h.SetRequestHeaders {
+		if err := checkHeader(k, v); err != nil {
+			return err
+		}
+		lk := strings.ToLower(k)
+		if lk == "host" || strings.HasPrefix(lk, "x-forwarded-") || strings.HasPrefix(lk, "tailscale-") {
+			return fmt.Errorf("request header %q cannot be set", k)
+		}
+	}
+	for k, v := range h.SetResponseHeaders {
+		if err := checkHeader(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProxyBackends returns the backends requests to v are proxied to: Proxy,
+// followed by those of its LoadBalancer, if any.
+func (v HTTPHandlerView) ProxyBackends() []string {
+	if v.Proxy() == "" {
+		return nil
+	}
+	if !v.LoadBalancer().Valid() {
+		return []string{v.Proxy()}
+	}
+	return append([]string{v.Proxy()}, v.LoadBalancer().Backends().AsSlice()...)
+}
+
+// ServeAccess restricts an HTTPHandler to some peers. A peer is allowed if
+// it matches any entry of any of the fields.
+type ServeAccess struct {
+	// Caps are peer capabilities granted to peers on this node, as in the
+	// tailnet policy's grants.
+	Caps []tailcfg.PeerCapability `json:",omitempty"`
+
+	// Logins are the login names of users whose untagged nodes are
+	// allowed, compared case-insensitively.
+	Logins []string `json:",omitempty"`
+
+	// Tags are the ACL tags of tagged nodes that are allowed.
+	Tags []string `json:",omitempty"`
+}
+
+func (a *ServeAccess) checkValid() error {
+	if len(a.Caps) == 0 && len(a.Logins) == 0 && len(a.Tags) == 0 {
+		return errors.New("Access allows no one")
+	}
+	if slices.Contains(a.Caps, "") || slices.Contains(a.Logins, "") {
+		return errors.New("Access has an empty capability or login")
+	}
+	for _, tag := range a.Tags {
+		if err := tailcfg.CheckTag(tag); err != nil {
+			return fmt.Errorf("invalid Access tag: %w", err)
+		}
+	}
+	return nil
+}
+
+// ServeBackendStatus is the state of a load balanced serve backend, as
+// reported by the serve-backends LocalAPI endpoint.
+type ServeBackendStatus struct {
+	// Backend is the backend, as in the ServeConfig.
+	Backend string
+
+	// Healthy reports whether the backend is eligible for selection.
+	Healthy bool
+
+	// Active is the number of requests or connections currently proxied to
+	// the backend.
+	Active int64
+
+	// LastCheck is when the backend was last health checked, if ever.
+	LastCheck time.Time `json:",omitzero"`
+
+	// LastError is the error of the last failed health check or
+	// connection, if the backend is unhealthy.
+	LastError string `json:",omitempty"`
+}
+
+func checkHeader(k, v string) error {
+	if !httpguts.ValidHeaderFieldName(k) {
+		return fmt.Errorf("invalid header name %q", k)
+	}
+	if !httpguts.ValidHeaderFieldValue(v) {
+		return fmt.Errorf("invalid value for header %q", k)
+	}
+	return nil
 }
 
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
@@ -213,6 +524,43 @@ func (sc *ServeConfig) HasPathHandler() bool {
 	return false
 }
 
+// HasLoadBalancer reports whether ServeConfig has at least one load
+// balanced handler, including in services and foreground configs.
+func (sc *ServeConfig) HasLoadBalancer() bool {
+	for _, h := range sc.TCP {
+		if h.LoadBalancer != nil {
+			return true
+		}
+	}
+	for _, conf := range sc.Web {
+		for _, h := range conf.Handlers {
+			if h.LoadBalancer != nil {
+				return true
+			}
+		}
+	}
+	for _, svc := range sc.Services {
+		for _, h := range svc.TCP {
+			if h.LoadBalancer != nil {
+				return true
+			}
+		}
+		for _, conf := range svc.Web {
+			for _, h := range conf.Handlers {
+				if h.LoadBalancer != nil {
+					return true
+				}
+			}
+		}
+	}
+	for _, fg := range sc.Foreground {
+		if fg.HasLoadBalancer() {
+			return true
+		}
+	}
+	return false
+}
+
 // IsTCPForwardingAny reports whether ServeConfig is currently forwarding in
 // TCPForward mode on any port. This is exclusive of Web/HTTPS serving.
 func (sc *ServeConfig) IsTCPForwardingAny() bool {
@@ -687,6 +1035,40 @@ func (v ServeConfigView) HasFunnelForTarget(target HostPort) bool {
 	return false
 }
 
+// CheckValidHandlers reports whether all the web and TCP handlers of the
+// ServeConfig, including its foreground and service configs, are valid.
+func (sc *ServeConfig) CheckValidHandlers() error {
+	check := func(tcp map[uint16]*TCPPortHandler, web map[HostPort]*WebServerConfig) error {
+		for port, h := range tcp {
+			if err := h.CheckValid(); err != nil {
+				return fmt.Errorf("invalid handler for port %d: %w", port, err)
+			}
+		}
+		for hp, conf := range web {
+			for mount, h := range conf.Handlers {
+				if err := h.CheckValid(); err != nil {
+					return fmt.Errorf("invalid handler for %s%s: %w", hp, mount, err)
+				}
+			}
+		}
+		return nil
+	}
+	if err := check(sc.TCP, sc.Web); err != nil {
+		return err
+	}
+	for _, fg := range sc.Foreground {
+		if err := check(fg.TCP, fg.Web); err != nil {
+			return err
+		}
+	}
+	for _, svc := range sc.Services {
+		if err := check(svc.TCP, svc.Web); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CheckValidServicesConfig reports whether the ServeConfig has
 // invalid service configurations.
 func (sc *ServeConfig) CheckValidServicesConfig() error {
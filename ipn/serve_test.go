@@ -4,6 +4,7 @@
 package ipn
 
 import (
+	"strings"
 	"testing"
 
 	"tailscale.com/ipn/ipnstate"
@@ -128,6 +129,77 @@ func TestHasPathHandler(t *testing.T) {
 	}
 }
 
+func TestHasLoadBalancer(t *testing.T) {
+	lb := &LoadBalancer{Backends: []string{"http://127.0.0.1:3001"}}
+	web := func(h *HTTPHandler) map[HostPort]*WebServerConfig {
+		return map[HostPort]*WebServerConfig{
+			"foo.test.ts.net:443": {Handlers: map[string]*HTTPHandler{"/": h}},
+		}
+	}
+	tests := []struct {
+		name string
+		cfg  ServeConfig
+		want bool
+	}{
+		{
+			name: "empty-config",
+			cfg:  ServeConfig{},
+			want: false,
+		},
+		{
+			name: "without-load-balancer",
+			cfg: ServeConfig{
+				Web: web(&HTTPHandler{Proxy: "http://127.0.0.1:3000"}),
+			},
+			want: false,
+		},
+		{
+			name: "with-bg-web",
+			cfg: ServeConfig{
+				Web: web(&HTTPHandler{Proxy: "http://127.0.0.1:3000", LoadBalancer: lb}),
+			},
+			want: true,
+		},
+		{
+			name: "with-fg-web",
+			cfg: ServeConfig{
+				Foreground: map[string]*ServeConfig{
+					"abc123": {Web: web(&HTTPHandler{Proxy: "http://127.0.0.1:3000", LoadBalancer: lb})},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "with-service-tcp",
+			cfg: ServeConfig{
+				Services: map[tailcfg.ServiceName]*ServiceConfig{
+					"svc:foo": {TCP: map[uint16]*TCPPortHandler{
+						5432: {TCPForward: "127.0.0.1:5432", LoadBalancer: &LoadBalancer{Backends: []string{"127.0.0.1:5433"}}},
+					}},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "with-service-web",
+			cfg: ServeConfig{
+				Services: map[tailcfg.ServiceName]*ServiceConfig{
+					"svc:foo": {Web: web(&HTTPHandler{Proxy: "http://127.0.0.1:3000", LoadBalancer: lb})},
+				},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cfg.HasLoadBalancer()
+			if tt.want != got {
+				t.Errorf("HasLoadBalancer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestExpandProxyTargetDev(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -268,3 +340,64 @@ func TestIsFunnelOn(t *testing.T) {
 		})
 	}
 }
+
+func TestTCPPortHandlerCheckValid(t *testing.T) {
+	lb := &LoadBalancer{Backends: []string{"127.0.0.1:5433"}, HealthCheck: &HealthCheck{}}
+	if err := (&TCPPortHandler{TCPForward: "127.0.0.1:5432", LoadBalancer: lb}).CheckValid(); err != nil {
+		t.Errorf("CheckValid: %v", err)
+	}
+	if err := (&TCPPortHandler{HTTPS: true, LoadBalancer: lb}).CheckValid(); err == nil {
+		t.Errorf("CheckValid of LoadBalancer without TCPForward succeeded")
+	}
+	lb = &LoadBalancer{Backends: []string{"127.0.0.1:5433"}, HealthCheck: &HealthCheck{Path: "/healthz"}}
+	if err := (&TCPPortHandler{TCPForward: "127.0.0.1:5432", LoadBalancer: lb}).CheckValid(); err == nil {
+		t.Errorf("CheckValid of HTTP health check for TCPForward succeeded")
+	}
+}
+
+func TestHTTPHandlerCheckValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       HTTPHandler
+		wantErr string
+	}{
+		{name: "proxy", h: HTTPHandler{Proxy: "http://127.0.0.1:3000", RewritePath: "/v2", SetRequestHeaders: map[string]string{"X-Env": "prod"}}},
+		{name: "redirect", h: HTTPHandler{Redirect: "https://${HOST}${REQUEST_URI}", Status: 308}},
+		{name: "text_status", h: HTTPHandler{Text: "nope", Status: 404}},
+		{name: "status_only", h: HTTPHandler{Status: 410}},
+		{name: "path_identity", h: HTTPHandler{Path: "/srv", IdentityHeaders: true, SetResponseHeaders: map[string]string{"Cache-Control": "no-store"}}},
+		{name: "empty", h: HTTPHandler{}, wantErr: "empty handler"},
+		{name: "two_kinds", h: HTTPHandler{Text: "a", Redirect: "/b"}, wantErr: "only one of Redirect, Text"},
+		{name: "proxy_status", h: HTTPHandler{Proxy: "3000", Status: 404}, wantErr: "Status cannot be set"},
+		{name: "redirect_status", h: HTTPHandler{Redirect: "/b", Status: 200}, wantErr: "invalid redirect status"},
+		{name: "text_3xx", h: HTTPHandler{Text: "a", Status: 302}, wantErr: "invalid status"},
+		{name: "rewrite_text", h: HTTPHandler{Text: "a", RewritePath: "/b"}, wantErr: "RewritePath can only"},
+		{name: "rewrite_relative", h: HTTPHandler{Proxy: "3000", RewritePath: "b"}, wantErr: "must start with /"},
+		{name: "request_headers_path", h: HTTPHandler{Path: "/srv", SetRequestHeaders: map[string]string{"X-A": "b"}}, wantErr: "SetRequestHeaders can only"},
+		{name: "request_header_identity", h: HTTPHandler{Proxy: "3000", SetRequestHeaders: map[string]string{"Tailscale-User-Login": "x"}}, wantErr: "cannot be set"},
+		{name: "bad_header_name", h: HTTPHandler{Text: "a", SetResponseHeaders: map[string]string{"X A": "b"}}, wantErr: "invalid header name"},
+		{name: "bad_header_value", h: HTTPHandler{Text: "a", SetResponseHeaders: map[string]string{"X-A": "b\r\nc"}}, wantErr: "invalid value"},
+		{name: "load_balancer", h: HTTPHandler{Proxy: "3000", LoadBalancer: &LoadBalancer{Backends: []string{"3001"}, Policy: LBLeastConn, HealthCheck: &HealthCheck{Path: "/healthz"}}}},
+		{name: "load_balancer_text", h: HTTPHandler{Text: "a", LoadBalancer: &LoadBalancer{Backends: []string{"3001"}}}, wantErr: "LoadBalancer can only"},
+		{name: "load_balancer_no_backends", h: HTTPHandler{Proxy: "3000", LoadBalancer: &LoadBalancer{}}, wantErr: "no additional backends"},
+		{name: "load_balancer_policy", h: HTTPHandler{Proxy: "3000", LoadBalancer: &LoadBalancer{Backends: []string{"3001"}, Policy: "random"}}, wantErr: "unknown load balancing policy"},
+		{name: "access", h: HTTPHandler{Text: "a", Access: &ServeAccess{Caps: []tailcfg.PeerCapability{"example.com/cap/admin"}, Logins: []string{"a@example.com"}, Tags: []string{"tag:eng"}}}},
+		{name: "access_empty", h: HTTPHandler{Text: "a", Access: &ServeAccess{}}, wantErr: "allows no one"},
+		{name: "access_bad_tag", h: HTTPHandler{Text: "a", Access: &ServeAccess{Tags: []string{"eng"}}}, wantErr: "invalid Access tag"},
+		{name: "health_check_path", h: HTTPHandler{Proxy: "3000", LoadBalancer: &LoadBalancer{Backends: []string{"3001"}, HealthCheck: &HealthCheck{Path: "healthz"}}}, wantErr: "must start with /"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.h.CheckValid()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckValid: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("CheckValid error = %v; want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -52,8 +52,9 @@ func (t Tuple) DstAddr() netip.Addr {
 	return netip.AddrFrom16(t.dst).Unmap()
 }
 
-func (t Tuple) SrcPort() uint16 { return t.srcPort }
-func (t Tuple) DstPort() uint16 { return t.dstPort }
+func (t Tuple) SrcPort() uint16      { return t.srcPort }
+func (t Tuple) DstPort() uint16      { return t.dstPort }
+func (t Tuple) Proto() ipproto.Proto { return t.proto }
 
 func (t Tuple) String() string {
 	return fmt.Sprintf("(%v %v => %v)", t.proto,
@@ -98,6 +99,11 @@ type Cache[Value any] struct {
 	// an item is evicted. Zero means no limit.
 	MaxEntries int
 
+	// OnEvicted optionally specifies a callback function to be
+	// executed when an entry is evicted by Add to stay within
+	// MaxEntries.
+	OnEvicted func(key Tuple, value Value)
+
 	ll *list.List
 	m  map[Tuple]*list.Element // of *entry
 }
@@ -126,7 +132,11 @@ func (c *Cache[Value]) Add(key Tuple, value Value) {
 	ele := c.ll.PushFront(&entry[Value]{key, value})
 	c.m[key] = ele
 	if c.MaxEntries != 0 && c.Len() > c.MaxEntries {
+		oldest := c.ll.Back().Value.(*entry[Value])
 		c.RemoveOldest()
+		if c.OnEvicted != nil {
+			c.OnEvicted(oldest.key, oldest.value)
+		}
 	}
 }
 
@@ -163,3 +173,15 @@ func (c *Cache[Value]) removeElement(e *list.Element) {
 
 // Len returns the number of items in the cache.
 func (c *Cache[Value]) Len() int { return len(c.m) }
+
+// ForEach calls fn for each entry in the cache, from the most to the least
+// recently used. fn must not modify the cache.
+func (c *Cache[Value]) ForEach(fn func(key Tuple, value Value)) {
+	if c.ll == nil {
+		return
+	}
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		ent := e.Value.(*entry[Value])
+		fn(ent.key, ent.value)
+	}
+}
@@ -126,3 +126,30 @@ func TestStringJSON(t *testing.T) {
 		t.Errorf("back = %v; want %v", back, v)
 	}
 }
+
+func TestCacheEvictionsAndForEach(t *testing.T) {
+	var evicted []int
+	c := &Cache[int]{
+		MaxEntries: 2,
+		OnEvicted:  func(_ Tuple, v int) { evicted = append(evicted, v) },
+	}
+	for i := range 4 {
+		c.Add(MakeTuple(0, netip.MustParseAddrPort("1.1.1.1:1"), netip.AddrPortFrom(netip.MustParseAddr("2.2.2.2"), uint16(i))), i)
+	}
+	if len(evicted) != 2 || evicted[0] != 0 || evicted[1] != 1 {
+		t.Errorf("evicted = %v; want [0 1]", evicted)
+	}
+
+	var got []int
+	c.ForEach(func(k Tuple, v int) {
+		if int(k.DstPort()) != v {
+			t.Errorf("ForEach key %v has value %d", k, v)
+		}
+		got = append(got, v)
+	})
+	if len(got) != 2 || got[0] != 3 || got[1] != 2 {
+		t.Errorf("ForEach values = %v; want [3 2]", got)
+	}
+
+	(&Cache[int]{}).ForEach(func(Tuple, int) { t.Error("ForEach on empty cache called fn") }) // shouldn't panic
+}
@@ -464,6 +464,15 @@ func (q *Parsed) IsEchoResponse() bool {
 	}
 }
 
+// EchoID returns the identifier of an ICMP Echo request or response, or 0 if
+// q is neither.
+func (q *Parsed) EchoID() uint16 {
+	if !q.IsEchoRequest() && !q.IsEchoResponse() {
+		return 0
+	}
+	return binary.BigEndian.Uint16(q.b[q.subofs+4:])
+}
+
 // EchoIDSeq extracts the identifier/sequence bytes from an ICMP Echo response,
 // and returns them as a uint32, used to lookup internally routed ICMP echo
 // responses. This function is intentionally lightweight as it is called on
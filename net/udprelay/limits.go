@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import "time"
+
+// Limits are resource limits enforced by a [Server]. A zero value for any
+// field means that there is no limit.
+type Limits struct {
+	// MaxEndpoints is the maximum number of endpoints allocated at a time.
+	MaxEndpoints int
+	// MaxEndpointsPerNode is the maximum number of endpoints that a single
+	// disco key (i.e. a single client node) may be part of at a time.
+	MaxEndpointsPerNode int
+	// PacketsPerSecond is the maximum rate of data packets relayed by an
+	// endpoint, in both directions combined. Bursts of up to one second's
+	// worth of packets are allowed.
+	PacketsPerSecond int
+	// BytesPerSecond is the maximum rate of data bytes relayed by an
+	// endpoint, in both directions combined. Bursts of up to one second's
+	// worth of bytes, but at least one maximum sized packet, are allowed.
+	BytesPerSecond int
+}
+
+// maxPacketSize is the largest UDP payload the server can receive.
+const maxPacketSize = 1<<16 - 1
+
+// tokenBucket is a token bucket rate limiter, filled at rate tokens per
+// second up to burst. The zero value, or a bucket with a rate of zero,
+// allows everything.
+//
+// tokenBucket is not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int, now time.Time) tokenBucket {
+	return tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// has reports whether n tokens are available.
+func (b *tokenBucket) has(n int) bool {
+	return b.rate == 0 || b.tokens >= float64(n)
+}
+
+// take consumes n tokens.
+func (b *tokenBucket) take(n int) {
+	if b.rate != 0 {
+		b.tokens -= float64(n)
+	}
+}
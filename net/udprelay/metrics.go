@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"tailscale.com/metrics"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/usermetric"
+)
+
+// dropReason is the reason a packet was not relayed.
+type dropReason string
+
+const (
+	// dropRateLimited means that the packet exceeded its endpoint's packet
+	// or byte rate limit.
+	dropRateLimited dropReason = "rate_limited"
+	// dropUnknownVNI means that the packet's VNI does not belong to an
+	// allocated endpoint.
+	dropUnknownVNI dropReason = "unknown_vni"
+	// dropNotBound means that a data packet arrived for an endpoint that
+	// has not completed its handshakes, or from an unrecognized source.
+	dropNotBound dropReason = "not_bound"
+)
+
+type dropLabels struct {
+	Reason dropReason
+}
+
+// rejectReason is the reason an endpoint allocation was rejected.
+type rejectReason string
+
+const (
+	// rejectServerQuota means that [Limits.MaxEndpoints] was reached.
+	rejectServerQuota rejectReason = "server_quota"
+	// rejectNodeQuota means that [Limits.MaxEndpointsPerNode] was reached
+	// for one of the disco keys.
+	rejectNodeQuota rejectReason = "node_quota"
+	// rejectVNIExhausted means that the pool of VNIs was exhausted.
+	rejectVNIExhausted rejectReason = "vni_exhausted"
+)
+
+type rejectLabels struct {
+	Reason rejectReason
+}
+
+// Metrics are the user-facing metrics of a [Server]. A Metrics may be
+// shared by successive Servers, so that the counters keep increasing
+// across restarts of the relay server.
+type Metrics struct {
+	endpoints          *usermetric.Gauge
+	forwardedPackets   *usermetric.Counter
+	forwardedBytes     *usermetric.Counter
+	droppedPackets     *metrics.MultiLabelMap[dropLabels]
+	rejectedAllocation *metrics.MultiLabelMap[rejectLabels]
+}
+
+// NewMetrics returns a new [Metrics] registered with reg.
+// It must be called at most once per registry.
+func NewMetrics(reg *usermetric.Registry) *Metrics {
+	return &Metrics{
+		endpoints: reg.NewGauge(
+			"tailscaled_relay_endpoints",
+			"Number of endpoints currently allocated by the UDP relay server",
+		),
+		forwardedPackets: reg.NewCounter(
+			"tailscaled_relay_forwarded_packets_total",
+			"Counts the number of packets relayed by the UDP relay server",
+		),
+		forwardedBytes: reg.NewCounter(
+			"tailscaled_relay_forwarded_bytes_total",
+			"Counts the number of bytes relayed by the UDP relay server",
+		),
+		droppedPackets: usermetric.NewMultiLabelMapWithRegistry[dropLabels](
+			reg,
+			"tailscaled_relay_dropped_packets_total",
+			"counter",
+			"Counts the number of packets dropped by the UDP relay server",
+		),
+		rejectedAllocation: usermetric.NewMultiLabelMapWithRegistry[rejectLabels](
+			reg,
+			"tailscaled_relay_rejected_allocations_total",
+			"counter",
+			"Counts the number of endpoint allocations rejected by the UDP relay server",
+		),
+	}
+}
+
+var (
+	metricEndpointsAllocated  = clientmetric.NewCounter("udprelay_endpoints_allocated")
+	metricAllocationsRejected = clientmetric.NewCounter("udprelay_allocations_rejected")
+	metricForwardedPackets    = clientmetric.NewCounter("udprelay_forwarded_packets")
+	metricForwardedBytes      = clientmetric.NewCounter("udprelay_forwarded_bytes")
+	metricDroppedRateLimited  = clientmetric.NewCounter("udprelay_dropped_rate_limited")
+)
+
+func (m *Metrics) setEndpoints(n int) {
+	m.endpoints.Set(float64(n))
+}
+
+func (m *Metrics) forwarded(n int) {
+	m.forwardedPackets.Add(1)
+	m.forwardedBytes.Add(int64(n))
+	metricForwardedPackets.Add(1)
+	metricForwardedBytes.Add(int64(n))
+}
+
+func (m *Metrics) dropped(reason dropReason) {
+	m.droppedPackets.Add(dropLabels{Reason: reason}, 1)
+	if reason == dropRateLimited {
+		metricDroppedRateLimited.Add(1)
+	}
+}
+
+func (m *Metrics) rejected(reason rejectReason) {
+	m.rejectedAllocation.Add(rejectLabels{Reason: reason}, 1)
+	metricAllocationsRejected.Add(1)
+}
@@ -32,6 +32,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/util/eventbus"
 	"tailscale.com/util/set"
+	"tailscale.com/util/usermetric"
 )
 
 const (
@@ -62,14 +63,17 @@ type Server struct {
 	wg                  sync.WaitGroup
 	closeCh             chan struct{}
 	netChecker          *netcheck.Client
-
-	mu        sync.Mutex       // guards the following fields
-	addrPorts []netip.AddrPort // the ip:port pairs returned as candidate endpoints
-	closed    bool
-	lamportID uint64
-	vniPool   []uint32 // the pool of available VNIs
-	byVNI     map[uint32]*serverEndpoint
-	byDisco   map[pairOfDiscoPubKeys]*serverEndpoint
+	metrics             *Metrics
+
+	mu         sync.Mutex       // guards the following fields
+	addrPorts  []netip.AddrPort // the ip:port pairs returned as candidate endpoints
+	closed     bool
+	lamportID  uint64
+	vniPool    []uint32 // the pool of available VNIs
+	byVNI      map[uint32]*serverEndpoint
+	byDisco    map[pairOfDiscoPubKeys]*serverEndpoint
+	limits     Limits
+	perNodeEps map[key.DiscoPublic]int // number of endpoints each disco key is part of
 }
 
 // pairOfDiscoPubKeys is a pair of key.DiscoPublic. It must be constructed via
@@ -105,6 +109,16 @@ type serverEndpoint struct {
 	lamportID   uint64
 	vni         uint32
 	allocatedAt time.Time
+
+	// packetLimit and byteLimit rate limit relayed data packets.
+	packetLimit tokenBucket
+	byteLimit   tokenBucket
+}
+
+// setLimits sets the rate limits of the endpoint according to l.
+func (e *serverEndpoint) setLimits(l Limits, now time.Time) {
+	e.packetLimit = newTokenBucket(l.PacketsPerSecond, l.PacketsPerSecond, now)
+	e.byteLimit = newTokenBucket(l.BytesPerSecond, max(l.BytesPerSecond, maxPacketSize), now)
 }
 
 func (e *serverEndpoint) handleDiscoControlMsg(from netip.AddrPort, senderIndex int, discoMsg disco.Message, uw udpWriter, serverDisco key.DiscoPublic) {
@@ -216,26 +230,40 @@ type udpWriter interface {
 	WriteMsgUDPAddrPort(b []byte, oob []byte, addr netip.AddrPort) (n, oobn int, err error)
 }
 
-func (e *serverEndpoint) handlePacket(from netip.AddrPort, gh packet.GeneveHeader, b []byte, uw udpWriter, serverDisco key.DiscoPublic) {
+func (e *serverEndpoint) handlePacket(from netip.AddrPort, gh packet.GeneveHeader, b []byte, uw udpWriter, serverDisco key.DiscoPublic, m *Metrics) {
 	if !gh.Control {
 		if !e.isBound() {
 			// not a control packet, but serverEndpoint isn't bound
+			m.dropped(dropNotBound)
 			return
 		}
+		now := time.Now()
 		var to netip.AddrPort
 		switch {
 		case from == e.addrPorts[0]:
-			e.lastSeen[0] = time.Now()
+			e.lastSeen[0] = now
 			to = e.addrPorts[1]
 		case from == e.addrPorts[1]:
-			e.lastSeen[1] = time.Now()
+			e.lastSeen[1] = now
 			to = e.addrPorts[0]
 		default:
 			// unrecognized source
+			m.dropped(dropNotBound)
+			return
+		}
+		// Both limits are checked before either is consumed from, so that
+		// a packet dropped by one doesn't use up the other.
+		e.packetLimit.refill(now)
+		e.byteLimit.refill(now)
+		if !e.packetLimit.has(1) || !e.byteLimit.has(len(b)) {
+			m.dropped(dropRateLimited)
 			return
 		}
+		e.packetLimit.take(1)
+		e.byteLimit.take(len(b))
 		// relay packet
 		uw.WriteMsgUDPAddrPort(b, nil, to)
+		m.forwarded(len(b))
 		return
 	}
 
@@ -278,8 +306,14 @@ func (e *serverEndpoint) isBound() bool {
 // 'boundPort'. If len(overrideAddrs) > 0 these will be used in place of dynamic
 // discovery, which is useful to override in tests.
 //
+// The server updates m, which may be nil if the server's metrics don't need
+// to be exported. The server starts without [Limits]; see [Server.SetLimits].
+//
 // TODO: IPv6 support
-func NewServer(logf logger.Logf, port int, overrideAddrs []netip.Addr) (s *Server, boundPort uint16, err error) {
+func NewServer(logf logger.Logf, port int, overrideAddrs []netip.Addr, m *Metrics) (s *Server, boundPort uint16, err error) {
+	if m == nil {
+		m = NewMetrics(new(usermetric.Registry))
+	}
 	s = &Server{
 		logf:                logger.WithPrefix(logf, "relayserver"),
 		disco:               key.NewDisco(),
@@ -288,6 +322,8 @@ func NewServer(logf logger.Logf, port int, overrideAddrs []netip.Addr) (s *Serve
 		closeCh:             make(chan struct{}),
 		byDisco:             make(map[pairOfDiscoPubKeys]*serverEndpoint),
 		byVNI:               make(map[uint32]*serverEndpoint),
+		perNodeEps:          make(map[key.DiscoPublic]int),
+		metrics:             m,
 	}
 	s.discoPublic = s.disco.Public()
 	// TODO: instead of allocating 10s of MBs for the full pool, allocate
@@ -439,6 +475,8 @@ func (s *Server) Close() error {
 		s.wg.Wait()
 		clear(s.byVNI)
 		clear(s.byDisco)
+		clear(s.perNodeEps)
+		s.metrics.setEndpoints(0)
 		s.vniPool = nil
 		s.closed = true
 		s.bus.Close()
@@ -457,13 +495,12 @@ func (s *Server) endpointGCLoop() {
 		// holding s.mu for the duration. Keep it simple (and slow) for now.
 		s.mu.Lock()
 		defer s.mu.Unlock()
-		for k, v := range s.byDisco {
+		for _, v := range s.byDisco {
 			if v.isExpired(now, s.bindLifetime, s.steadyStateLifetime) {
-				delete(s.byDisco, k)
-				delete(s.byVNI, v.vni)
-				s.vniPool = append(s.vniPool, v.vni)
+				s.deleteEndpointLocked(v)
 			}
 		}
+		s.metrics.setEndpoints(len(s.byVNI))
 	}
 
 	for {
@@ -476,6 +513,33 @@ func (s *Server) endpointGCLoop() {
 	}
 }
 
+// deleteEndpointLocked removes e from the server and releases its VNI.
+// s.mu must be held.
+func (s *Server) deleteEndpointLocked(e *serverEndpoint) {
+	delete(s.byDisco, e.discoPubKeys)
+	delete(s.byVNI, e.vni)
+	s.vniPool = append(s.vniPool, e.vni)
+	for _, k := range e.discoPubKeys {
+		if s.perNodeEps[k]--; s.perNodeEps[k] <= 0 {
+			delete(s.perNodeEps, k)
+		}
+	}
+}
+
+// SetLimits sets the resource limits of the server. Allocation quotas apply
+// to future allocations only: endpoints already allocated beyond a reduced
+// quota are kept until they expire. Rate limits apply to all endpoints
+// immediately.
+func (s *Server) SetLimits(l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = l
+	now := time.Now()
+	for _, e := range s.byVNI {
+		e.setLimits(l, now)
+	}
+}
+
 func (s *Server) handlePacket(from netip.AddrPort, b []byte, uw udpWriter) {
 	if stun.Is(b) && b[1] == 0x01 {
 		// A b[1] value of 0x01 (STUN method binding) is sufficiently
@@ -497,10 +561,11 @@ func (s *Server) handlePacket(from netip.AddrPort, b []byte, uw udpWriter) {
 	e, ok := s.byVNI[gh.VNI]
 	if !ok {
 		// unknown VNI
+		s.metrics.dropped(dropUnknownVNI)
 		return
 	}
 
-	e.handlePacket(from, gh, b, uw, s.discoPublic)
+	e.handlePacket(from, gh, b, uw, s.discoPublic, s.metrics)
 }
 
 func (s *Server) packetReadLoop() {
@@ -521,10 +586,15 @@ func (s *Server) packetReadLoop() {
 
 var ErrServerClosed = errors.New("server closed")
 
+// ErrQuotaExceeded is returned by [Server.AllocateEndpoint] when allocating an
+// endpoint would exceed the server's [Limits].
+var ErrQuotaExceeded = errors.New("endpoint quota exceeded")
+
 // AllocateEndpoint allocates an [endpoint.ServerEndpoint] for the provided pair
 // of [key.DiscoPublic]'s. If an allocation already exists for discoA and discoB
 // it is returned without modification/reallocation. AllocateEndpoint returns
-// [ErrServerClosed] if the server has been closed.
+// [ErrServerClosed] if the server has been closed, and [ErrQuotaExceeded] if
+// allocating a new endpoint would exceed the server's [Limits].
 func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -563,16 +633,31 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 		}, nil
 	}
 
+	if limit := s.limits.MaxEndpoints; limit > 0 && len(s.byVNI) >= limit {
+		s.metrics.rejected(rejectServerQuota)
+		return endpoint.ServerEndpoint{}, fmt.Errorf("%w: server has %d endpoints", ErrQuotaExceeded, limit)
+	}
+	if limit := s.limits.MaxEndpointsPerNode; limit > 0 {
+		for _, k := range pair {
+			if s.perNodeEps[k] >= limit {
+				s.metrics.rejected(rejectNodeQuota)
+				return endpoint.ServerEndpoint{}, fmt.Errorf("%w: %s is part of %d endpoints", ErrQuotaExceeded, k.ShortString(), limit)
+			}
+		}
+	}
 	if len(s.vniPool) == 0 {
+		s.metrics.rejected(rejectVNIExhausted)
 		return endpoint.ServerEndpoint{}, errors.New("VNI pool exhausted")
 	}
 
 	s.lamportID++
+	now := time.Now()
 	e = &serverEndpoint{
 		discoPubKeys: pair,
 		lamportID:    s.lamportID,
-		allocatedAt:  time.Now(),
+		allocatedAt:  now,
 	}
+	e.setLimits(s.limits, now)
 	e.discoSharedSecrets[0] = s.disco.Shared(e.discoPubKeys[0])
 	e.discoSharedSecrets[1] = s.disco.Shared(e.discoPubKeys[1])
 	e.vni, s.vniPool = s.vniPool[0], s.vniPool[1:]
@@ -581,6 +666,11 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 
 	s.byDisco[pair] = e
 	s.byVNI[e.vni] = e
+	for _, k := range pair {
+		s.perNodeEps[k]++
+	}
+	s.metrics.setEndpoints(len(s.byVNI))
+	metricEndpointsAllocated.Add(1)
 
 	return endpoint.ServerEndpoint{
 		ServerDisco:         s.discoPublic,
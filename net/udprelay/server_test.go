@@ -5,6 +5,8 @@
 
 import (
 	"bytes"
+	"errors"
+	"expvar"
 	"net"
 	"net/netip"
 	"testing"
@@ -16,6 +18,7 @@
 	"tailscale.com/disco"
 	"tailscale.com/net/packet"
 	"tailscale.com/types/key"
+	"tailscale.com/util/usermetric"
 )
 
 type testClient struct {
@@ -156,7 +159,7 @@ func TestServer(t *testing.T) {
 
 	ipv4LoopbackAddr := netip.MustParseAddr("127.0.0.1")
 
-	server, _, err := NewServer(t.Logf, 0, []netip.Addr{ipv4LoopbackAddr})
+	server, _, err := NewServer(t.Logf, 0, []netip.Addr{ipv4LoopbackAddr}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -210,3 +213,108 @@ func TestServer(t *testing.T) {
 		t.Fatal("unexpected msg B->A")
 	}
 }
+
+func TestServerLimits(t *testing.T) {
+	discoA := key.NewDisco()
+	discoB := key.NewDisco()
+	discoC := key.NewDisco()
+
+	reg := new(usermetric.Registry)
+	m := NewMetrics(reg)
+	server, _, err := NewServer(t.Logf, 0, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.SetLimits(Limits{MaxEndpointsPerNode: 1, PacketsPerSecond: 2})
+
+	ep, err := server.AllocateEndpoint(discoA.Public(), discoB.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// discoA is already part of an endpoint.
+	if _, err := server.AllocateEndpoint(discoA.Public(), discoC.Public()); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("AllocateEndpoint over node quota: got err %v, want %v", err, ErrQuotaExceeded)
+	}
+	// An existing allocation is returned regardless of quotas.
+	if _, err := server.AllocateEndpoint(discoB.Public(), discoA.Public()); err != nil {
+		t.Fatalf("AllocateEndpoint of existing endpoint: %v", err)
+	}
+	server.SetLimits(Limits{MaxEndpoints: 1, PacketsPerSecond: 2})
+	if _, err := server.AllocateEndpoint(discoC.Public(), key.NewDisco().Public()); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("AllocateEndpoint over server quota: got err %v, want %v", err, ErrQuotaExceeded)
+	}
+	if got, want := m.rejectedAllocation.Get(rejectLabels{Reason: rejectNodeQuota}).String(), "1"; got != want {
+		t.Errorf("node quota rejections = %v, want %v", got, want)
+	}
+	if got, want := m.rejectedAllocation.Get(rejectLabels{Reason: rejectServerQuota}).String(), "1"; got != want {
+		t.Errorf("server quota rejections = %v, want %v", got, want)
+	}
+	if got, want := m.endpoints.String(), "1"; got != want {
+		t.Errorf("endpoints gauge = %v, want %v", got, want)
+	}
+
+	tcA := newTestClient(t, ep.VNI, ep.AddrPorts[0], discoA, ep.ServerDisco)
+	defer tcA.close()
+	tcB := newTestClient(t, ep.VNI, ep.AddrPorts[0], discoB, ep.ServerDisco)
+	defer tcB.close()
+	tcA.handshake(t)
+	tcB.handshake(t)
+
+	// Only a burst of PacketsPerSecond packets is relayed.
+	const sent = 5
+	for i := range sent {
+		tcA.writeDataPkt(t, []byte{byte(i)})
+	}
+	for i := range 2 {
+		if got := tcB.readDataPkt(t); !bytes.Equal(got, []byte{byte(i)}) {
+			t.Fatalf("packet %d: got %v", i, got)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.forwardedPackets.Value()+dropCount(m, dropRateLimited) < sent {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %d packets to be handled", sent)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := m.forwardedPackets.Value(); got != 2 {
+		t.Errorf("forwarded packets = %d, want 2", got)
+	}
+	if got := m.forwardedBytes.Value(); got != 2*(packet.GeneveFixedHeaderLength+1) {
+		t.Errorf("forwarded bytes = %d, want %d", got, 2*(packet.GeneveFixedHeaderLength+1))
+	}
+	if got := dropCount(m, dropRateLimited); got != sent-2 {
+		t.Errorf("rate limited drops = %d, want %d", got, sent-2)
+	}
+}
+
+func dropCount(m *Metrics, reason dropReason) int64 {
+	v, ok := m.droppedPackets.Get(dropLabels{Reason: reason}).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 20, now)
+	if !b.has(20) || b.has(21) {
+		t.Fatalf("new bucket should hold exactly its burst")
+	}
+	b.take(20)
+	b.refill(now.Add(500 * time.Millisecond))
+	if !b.has(5) || b.has(6) {
+		t.Errorf("after 500ms at 10/s, bucket has %v tokens, want 5", b.tokens)
+	}
+	b.refill(now.Add(time.Hour))
+	if b.tokens != 20 {
+		t.Errorf("bucket refilled to %v, want burst of 20", b.tokens)
+	}
+
+	var unlimited tokenBucket
+	if !unlimited.has(1 << 30) {
+		t.Errorf("zero tokenBucket should allow everything")
+	}
+}
@@ -112,14 +112,15 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 		}
 	}()
 
+	subsystem, rawCommand := ss.command()
 	var isSFTP, isShell bool
-	switch ss.Subsystem() {
+	switch subsystem {
 	case "sftp":
 		isSFTP = true
 	case "":
-		isShell = ss.RawCommand() == ""
+		isShell = rawCommand == ""
 	default:
-		panic(fmt.Sprintf("unexpected subsystem: %v", ss.Subsystem()))
+		panic(fmt.Sprintf("unexpected subsystem: %v", subsystem))
 	}
 
 	if ss.conn.srv.tailscaledPath == "" {
@@ -130,7 +131,7 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 		}
 
 		loginShell := ss.conn.localUser.LoginShell()
-		args := shellArgs(isShell, ss.RawCommand())
+		args := shellArgs(isShell, rawCommand)
 		logf("directly running %s %q", loginShell, args)
 		cmd = exec.CommandContext(ss.ctx, loginShell, args...)
 
@@ -215,7 +216,7 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 	case isShell:
 		incubatorArgs = append(incubatorArgs, "--shell")
 	default:
-		incubatorArgs = append(incubatorArgs, "--cmd="+ss.RawCommand())
+		incubatorArgs = append(incubatorArgs, "--cmd="+rawCommand)
 	}
 
 	allowSendEnv := nm.HasCap(tailcfg.NodeAttrSSHEnvironmentVariables)
@@ -852,6 +853,9 @@ func (ss *sshSession) launchProcess() error {
 	if ss.agentListener != nil {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("SSH_AUTH_SOCK=%s", ss.agentListener.Addr()))
 	}
+	if ss.forcedCommand() != "" && ss.RawCommand() != "" {
+		cmd.Env = append(cmd.Env, "SSH_ORIGINAL_COMMAND="+ss.RawCommand())
+	}
 
 	ptyReq, winCh, isPty := ss.Pty()
 	if !isPty {
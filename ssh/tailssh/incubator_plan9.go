@@ -50,14 +50,15 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 		}
 	}()
 
+	subsystem, rawCommand := ss.command()
 	var isSFTP, isShell bool
-	switch ss.Subsystem() {
+	switch subsystem {
 	case "sftp":
 		isSFTP = true
 	case "":
-		isShell = ss.RawCommand() == ""
+		isShell = rawCommand == ""
 	default:
-		panic(fmt.Sprintf("unexpected subsystem: %v", ss.Subsystem()))
+		panic(fmt.Sprintf("unexpected subsystem: %v", subsystem))
 	}
 
 	if ss.conn.srv.tailscaledPath == "" {
@@ -68,8 +69,8 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 		}
 
 		loginShell := ss.conn.localUser.LoginShell()
-		logf("directly running /bin/rc -c %q", ss.RawCommand())
-		return exec.CommandContext(ss.ctx, loginShell, "-c", ss.RawCommand()), nil
+		logf("directly running /bin/rc -c %q", rawCommand)
+		return exec.CommandContext(ss.ctx, loginShell, "-c", rawCommand), nil
 	}
 
 	lu := ss.conn.localUser
@@ -114,7 +115,7 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 	case isShell:
 		incubatorArgs = append(incubatorArgs, "--shell")
 	default:
-		incubatorArgs = append(incubatorArgs, "--cmd="+ss.RawCommand())
+		incubatorArgs = append(incubatorArgs, "--cmd="+rawCommand)
 	}
 
 	allowSendEnv := nm.HasCap(tailcfg.NodeAttrSSHEnvironmentVariables)
@@ -8,6 +8,7 @@
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
 	"encoding/json"
@@ -467,6 +468,7 @@ func (srv *server) newConn() (*conn, error) {
 		Handler:                       c.handleSessionPostSSHAuth,
 		LocalPortForwardingCallback:   c.mayForwardLocalPortTo,
 		ReversePortForwardingCallback: c.mayReversePortForwardTo,
+		PtyCallback:                   c.mayAllocatePTY,
 		SubsystemHandlers: map[string]ssh.SubsystemHandler{
 			"sftp": c.handleSessionPostSSHAuth,
 		},
@@ -529,6 +531,11 @@ func (c *conn) mayForwardLocalPortTo(ctx ssh.Context, destinationHost string, de
 	return false
 }
 
+// mayAllocatePTY reports whether the ctx should be allowed a pseudo-terminal.
+func (c *conn) mayAllocatePTY(ctx ssh.Context, pty ssh.Pty) bool {
+	return c.finalAction == nil || !c.finalAction.DisallowPTY
+}
+
 // sshPolicy returns the SSHPolicy for current node.
 // If there is no SSHPolicy in the netmap, it returns a debugPolicy
 // if one is defined.
@@ -886,6 +893,37 @@ func (ss *sshSession) handleSSHAgentForwarding(s ssh.Session, lu *userMeta) erro
 	return nil
 }
 
+// checkRestrictions returns an error if the session requests something the
+// final action restricts it from doing.
+func (ss *sshSession) checkRestrictions() error {
+	a := ss.conn.finalAction
+	if a.SFTPOnly && ss.Subsystem() != "sftp" {
+		return errors.New("only SFTP is allowed")
+	}
+	if _, _, isPty := ss.Pty(); isPty && a.DisallowPTY {
+		return errors.New("PTY not allowed")
+	}
+	return nil
+}
+
+// forcedCommand returns the command the final action forces the session to
+// run, or the empty string if it runs what the client requests.
+func (ss *sshSession) forcedCommand() string {
+	if a := ss.conn.finalAction; !a.SFTPOnly {
+		return a.ForceCommand
+	}
+	return ""
+}
+
+// command returns the subsystem and command the session runs. They are
+// those the client requested, unless the final action forces a command.
+func (ss *sshSession) command() (subsystem, rawCommand string) {
+	if fc := ss.forcedCommand(); fc != "" {
+		return "", fc
+	}
+	return ss.Subsystem(), ss.RawCommand()
+}
+
 // run is the entrypoint for a newly accepted SSH session.
 //
 // It handles ss once it's been accepted and determined
@@ -924,12 +962,19 @@ func (ss *sshSession) run() {
 		}
 	}
 
+	if err := ss.checkRestrictions(); err != nil {
+		ss.logf("%v", err)
+		fmt.Fprintf(ss.Stderr(), "%v\r\n", err)
+		ss.Exit(1)
+		return
+	}
+
 	// Take control of the PTY so that we can configure it below.
 	// See https://github.com/tailscale/tailscale/issues/4146
 	ss.DisablePTYEmulation()
 
 	var rec *recording // or nil if disabled
-	if ss.Subsystem() != "sftp" {
+	if subsystem, _ := ss.command(); subsystem != "sftp" {
 		if err := ss.handleSSHAgentForwarding(ss, lu); err != nil {
 			ss.logf("agent forwarding failed: %v", err)
 		} else if ss.agentListener != nil {
@@ -1340,7 +1385,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 		Width:     w.Width,
 		Height:    w.Height,
 		Timestamp: now.Unix(),
-		Command:   strings.Join(ss.Command(), " "),
+		Command:   cmp.Or(ss.forcedCommand(), strings.Join(ss.Command(), " ")),
 		Env: map[string]string{
 			"TERM": term,
 			// TODO(bradfitz): anything else important?
@@ -1216,6 +1216,55 @@ func TestSSH(t *testing.T) {
 			t.Errorf("got %q; want %q", got, str)
 		}
 	})
+
+	withAction := func(t *testing.T, action *tailcfg.SSHAction) {
+		sc.finalAction = action
+		t.Cleanup(func() { sc.finalAction = sc.action0 })
+	}
+
+	t.Run("force_command", func(t *testing.T) {
+		withAction(t, &tailcfg.SSHAction{Accept: true, ForceCommand: `echo "forced: $SSH_ORIGINAL_COMMAND"`})
+		got, err := execSSH("echo", "requested").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "forced: echo requested\n"; string(got) != want {
+			t.Errorf("got %q; want %q", got, want)
+		}
+	})
+
+	t.Run("sftp_only", func(t *testing.T) {
+		withAction(t, &tailcfg.SSHAction{Accept: true, SFTPOnly: true})
+		cmd := execSSH("echo", "requested")
+		var outBuf, errBuf bytes.Buffer
+		cmd.Stdout = &outBuf
+		cmd.Stderr = &errBuf
+		if err := cmd.Run(); err == nil {
+			t.Errorf("command succeeded; want failure")
+		}
+		if outBuf.Len() > 0 {
+			t.Errorf("command ran: %q", outBuf.Bytes())
+		}
+		if !strings.Contains(errBuf.String(), "only SFTP is allowed") {
+			t.Errorf("stderr = %q; want the restriction explained", errBuf.Bytes())
+		}
+	})
+
+	t.Run("disallow_pty", func(t *testing.T) {
+		withAction(t, &tailcfg.SSHAction{Accept: true, DisallowPTY: true})
+		cmd := execSSH("tty")
+		cmd.Args = slices.Insert(cmd.Args, 1, "-tt")
+		var outBuf, errBuf bytes.Buffer
+		cmd.Stdout = &outBuf
+		cmd.Stderr = &errBuf
+		cmd.Run() // tty fails without one
+		if !strings.Contains(errBuf.String(), "PTY allocation request failed") {
+			t.Errorf("PTY allocated; stderr: %q", errBuf.Bytes())
+		}
+		if strings.Contains(outBuf.String(), "/dev/") {
+			t.Errorf("tty = %q; want none", outBuf.Bytes())
+		}
+	})
 }
 
 func parseEnv(out []byte) map[string]string {
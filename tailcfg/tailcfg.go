@@ -162,7 +162,8 @@
 //   - 115: 2025-03-07: Client understands DERPRegion.NoMeasureNoHome.
 //   - 116: 2025-05-05: Client serves MagicDNS "AAAA" if NodeAttrMagicDNSPeerAAAA set on self node
 //   - 117: 2025-05-28: Client understands DisplayMessages (structured health messages), but not necessarily PrimaryAction.
-const CurrentCapabilityVersion CapabilityVersion = 117
+//   - 118: 2026-10-17: Client understands SSHAction.ForceCommand, SSHAction.SFTPOnly and SSHAction.DisallowPTY
+const CurrentCapabilityVersion CapabilityVersion = 118
 
 // ID is an integer ID for a user, node, or login allocated by the
 // control plane.
@@ -2829,6 +2830,21 @@ type SSHAction struct {
 	// OnRecorderFailure is the action to take if recording fails.
 	// If nil, the default action is to fail open.
 	OnRecordingFailure *SSHRecorderFailureAction `json:"onRecordingFailure,omitempty"`
+
+	// ForceCommand, if non-empty, is the command that accepted sessions
+	// run with the local user's login shell, instead of any shell, command
+	// or subsystem the client requests. The command the client requested,
+	// if any, is passed in the SSH_ORIGINAL_COMMAND environment variable.
+	ForceCommand string `json:"forceCommand,omitempty"`
+
+	// SFTPOnly, if true, restricts accepted connections to sessions of
+	// the built-in SFTP subsystem. Requests for shells and commands fail.
+	// It takes precedence over ForceCommand.
+	SFTPOnly bool `json:"sftpOnly,omitempty"`
+
+	// DisallowPTY, if true, refuses requests from accepted connections to
+	// allocate a pseudo-terminal.
+	DisallowPTY bool `json:"disallowPTY,omitempty"`
 }
 
 // SSHRecorderFailureAction is the action to take if recording fails.
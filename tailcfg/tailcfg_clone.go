@@ -549,6 +549,9 @@ func (src *SSHAction) Clone() *SSHAction {
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	ForceCommand              string
+	SFTPOnly                  bool
+	DisallowPTY               bool
 }{})
 
 // Clone makes a deep copy of SSHPrincipal.
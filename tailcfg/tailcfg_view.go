@@ -1164,6 +1164,9 @@ func (v SSHActionView) Recorders() views.Slice[netip.AddrPort] { return views.Sl
 func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailureAction] {
 	return views.ValuePointerOf(v.ж.OnRecordingFailure)
 }
+func (v SSHActionView) ForceCommand() string { return v.ж.ForceCommand }
+func (v SSHActionView) SFTPOnly() bool       { return v.ж.SFTPOnly }
+func (v SSHActionView) DisallowPTY() bool    { return v.ж.DisallowPTY }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHActionViewNeedsRegeneration = SSHAction(struct {
@@ -1177,6 +1180,9 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	ForceCommand              string
+	SFTPOnly                  bool
+	DisallowPTY               bool
 }{})
 
 // View returns a read-only view of SSHPrincipal.
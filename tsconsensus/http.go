@@ -22,6 +22,14 @@ type joinRequest struct {
 	RemoteID   string
 }
 
+type removeRequest struct {
+	RemoteID string
+}
+
+type readIndexResponse struct {
+	Index uint64
+}
+
 type commandClient struct {
 	port       uint16
 	httpClient *http.Client
@@ -37,14 +45,32 @@ func readAllMaxBytes(r io.Reader) ([]byte, error) {
 	return io.ReadAll(io.LimitReader(r, maxBodyBytes+1))
 }
 
-func (rac *commandClient) join(host string, jr joinRequest) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (rac *commandClient) join(ctx context.Context, host string, jr joinRequest) error {
+	return rac.post(ctx, host, "/join", jr, nil)
+}
+
+func (rac *commandClient) remove(ctx context.Context, host string, rr removeRequest) error {
+	return rac.post(ctx, host, "/removeVoter", rr, nil)
+}
+
+func (rac *commandClient) readIndex(ctx context.Context, host string) (uint64, error) {
+	var res readIndexResponse
+	if err := rac.post(ctx, host, "/readIndex", struct{}{}, &res); err != nil {
+		return 0, err
+	}
+	return res.Index, nil
+}
+
+// post sends reqBody as JSON to path on host and, if resBody is non-nil,
+// decodes the JSON response into it.
+func (rac *commandClient) post(ctx context.Context, host, path string, reqBody, resBody any) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
-	rBs, err := json.Marshal(jr)
+	rBs, err := json.Marshal(reqBody)
 	if err != nil {
 		return err
 	}
-	url := rac.url(host, "/join")
+	url := rac.url(host, path)
 	req, err := http.NewRequestWithContext(ctx, httpm.POST, url, bytes.NewReader(rBs))
 	if err != nil {
 		return err
@@ -54,14 +80,17 @@ func (rac *commandClient) join(host string, jr joinRequest) error {
 		return err
 	}
 	defer resp.Body.Close()
+	respBs, err := readAllMaxBytes(resp.Body)
+	if err != nil {
+		return err
+	}
 	if resp.StatusCode != 200 {
-		respBs, err := readAllMaxBytes(resp.Body)
-		if err != nil {
-			return err
-		}
 		return fmt.Errorf("remote responded %d: %s", resp.StatusCode, string(respBs))
 	}
-	return nil
+	if resBody == nil {
+		return nil
+	}
+	return json.Unmarshal(respBs, resBody)
 }
 
 func (rac *commandClient) executeCommand(host string, bs []byte) (CommandResult, error) {
@@ -147,6 +176,36 @@ func (c *Consensus) handleJoinHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (c *Consensus) handleRemoveVoterHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var rr removeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes+1)).Decode(&rr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rr.RemoteID == "" {
+		http.Error(w, "Required: remoteID", http.StatusBadRequest)
+		return
+	}
+	if err := c.handleRemove(rr); err != nil {
+		log.Printf("remove voter handler error: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *Consensus) handleReadIndexHTTP(w http.ResponseWriter, r *http.Request) {
+	idx, err := c.leaderReadIndex()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(readIndexResponse{Index: idx}); err != nil {
+		log.Printf("error encoding read index response: %v", err)
+		return
+	}
+}
+
 func (c *Consensus) handleExecuteCommandHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	decoder := json.NewDecoder(r.Body)
@@ -171,6 +230,11 @@ func (c *Consensus) makeCommandMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /join", c.handleJoinHTTP)
 	mux.HandleFunc("POST /executeCommand", c.handleExecuteCommandHTTP)
+	mux.HandleFunc("POST /removeVoter", c.handleRemoveVoterHTTP)
+	mux.HandleFunc("POST /readIndex", c.handleReadIndexHTTP)
+	if kv, ok := c.fsm.FSM.(*kvFSM); ok {
+		mux.HandleFunc("GET /kv/watch", kv.handleWatchHTTP)
+	}
 	return mux
 }
 
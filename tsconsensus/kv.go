@@ -0,0 +1,485 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"tailscale.com/tsnet"
+)
+
+// Command names used by the KV state machine.
+const (
+	kvCmdPut    = "kv.put"
+	kvCmdCAS    = "kv.cas"
+	kvCmdDelete = "kv.delete"
+)
+
+// kvWatchBuffer is the number of events buffered per watcher before a slow
+// watcher is dropped.
+const kvWatchBuffer = 128
+
+// A KVEntry is a key and its value in a KV store.
+type KVEntry struct {
+	Key   string
+	Value []byte
+	// Version is the Raft log index of the command that last modified the
+	// key. It increases every time the key is written, and is the value to
+	// pass to CompareAndSwap.
+	Version uint64
+	// Expires is when the entry expires, or the zero value if it doesn't.
+	Expires time.Time `json:",omitzero"`
+}
+
+func (e KVEntry) expiredAt(t time.Time) bool {
+	return !e.Expires.IsZero() && !t.Before(e.Expires)
+}
+
+// KVEventType is the type of change in a KVEvent.
+type KVEventType string
+
+const (
+	KVPut    KVEventType = "put"
+	KVDelete KVEventType = "delete"
+	KVExpire KVEventType = "expire"
+)
+
+// A KVEvent describes a change to a key in a KV store.
+type KVEvent struct {
+	Type KVEventType
+	// Entry is the new entry for KVPut, and the removed entry for
+	// KVDelete and KVExpire.
+	Entry KVEntry
+}
+
+// kvArgs are the arguments of all KV commands.
+type kvArgs struct {
+	Key   string
+	Value []byte        `json:",omitempty"`
+	TTL   time.Duration `json:",omitempty"`
+	// Version is the expected current version of the key for kvCmdCAS,
+	// with zero meaning that the key must not exist.
+	Version uint64 `json:",omitempty"`
+	// Now is the proposer's clock when the command was created. It is
+	// used to compute expiry times and to expire old entries, so that all
+	// replicas make the same decisions.
+	Now time.Time
+}
+
+// kvResult is the result of all KV commands.
+type kvResult struct {
+	// OK is whether the command had an effect: always true for a put,
+	// whether the swap happened for a CAS and whether the key existed for
+	// a delete.
+	OK    bool
+	Entry KVEntry
+	Err   string `json:",omitempty"`
+}
+
+// kvFSM is a raft.FSM implementing a key/value store.
+type kvFSM struct {
+	mu       sync.Mutex
+	entries  map[string]KVEntry
+	expiries kvExpiryHeap // of the entries with TTLs, and ones since replaced
+	lastNow  time.Time    // latest kvArgs.Now seen; entries are expired relative to it
+	watchers map[*kvWatcher]bool
+	stopped  bool // whether stopWatches was called
+}
+
+// kvExpiry is when the entry for key expires, unless it's since been
+// replaced.
+type kvExpiry struct {
+	at  time.Time
+	key string
+}
+
+// kvExpiryHeap is a min-heap of expiries, soonest first.
+type kvExpiryHeap []kvExpiry
+
+func (h kvExpiryHeap) Len() int           { return len(h) }
+func (h kvExpiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h kvExpiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *kvExpiryHeap) Push(x any)        { *h = append(*h, x.(kvExpiry)) }
+func (h *kvExpiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type kvWatcher struct {
+	prefix string
+	ch     chan KVEvent
+}
+
+func (f *kvFSM) Apply(l *raft.Log) any {
+	var cmd Command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return kvCommandResult(kvResult{Err: err.Error()})
+	}
+	var args kvArgs
+	if err := json.Unmarshal(cmd.Args, &args); err != nil {
+		return kvCommandResult(kvResult{Err: err.Error()})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.entries == nil {
+		f.entries = make(map[string]KVEntry)
+	}
+	if args.Now.After(f.lastNow) {
+		f.lastNow = args.Now
+		f.expireLocked()
+	}
+
+	var res kvResult
+	switch cmd.Name {
+	case kvCmdPut, kvCmdCAS:
+		if cmd.Name == kvCmdCAS && f.entries[args.Key].Version != args.Version {
+			res.Entry = f.entries[args.Key]
+			break
+		}
+		e := KVEntry{
+			Key:     args.Key,
+			Value:   args.Value,
+			Version: l.Index,
+		}
+		if args.TTL > 0 {
+			e.Expires = args.Now.Add(args.TTL)
+			heap.Push(&f.expiries, kvExpiry{e.Expires, e.Key})
+		}
+		f.entries[args.Key] = e
+		f.notifyLocked(KVEvent{Type: KVPut, Entry: e})
+		res = kvResult{OK: true, Entry: e}
+	case kvCmdDelete:
+		e, ok := f.entries[args.Key]
+		if ok {
+			delete(f.entries, args.Key)
+			f.notifyLocked(KVEvent{Type: KVDelete, Entry: e})
+		}
+		res = kvResult{OK: ok, Entry: e}
+	default:
+		res.Err = fmt.Sprintf("unknown KV command %q", cmd.Name)
+	}
+	return kvCommandResult(res)
+}
+
+func kvCommandResult(res kvResult) CommandResult {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return CommandResult{Err: err}
+	}
+	return CommandResult{Result: b}
+}
+
+// expireLocked removes the entries that have expired as of f.lastNow.
+// f.mu must be held.
+func (f *kvFSM) expireLocked() {
+	for len(f.expiries) > 0 && !f.lastNow.Before(f.expiries[0].at) {
+		x := heap.Pop(&f.expiries).(kvExpiry)
+		e, ok := f.entries[x.key]
+		if !ok || !e.Expires.Equal(x.at) {
+			continue // deleted or replaced since
+		}
+		delete(f.entries, x.key)
+		f.notifyLocked(KVEvent{Type: KVExpire, Entry: e})
+	}
+}
+
+// notifyLocked sends ev to the watchers of its key. Watchers that aren't
+// keeping up are closed and removed. f.mu must be held.
+func (f *kvFSM) notifyLocked(ev KVEvent) {
+	for w := range f.watchers {
+		if !strings.HasPrefix(ev.Entry.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			delete(f.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+func (f *kvFSM) addWatcher(prefix string) *kvWatcher {
+	w := &kvWatcher{prefix: prefix, ch: make(chan KVEvent, kvWatchBuffer)}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		close(w.ch)
+		return w
+	}
+	if f.watchers == nil {
+		f.watchers = make(map[*kvWatcher]bool)
+	}
+	f.watchers[w] = true
+	return w
+}
+
+func (f *kvFSM) removeWatcher(w *kvWatcher) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watchers[w] {
+		delete(f.watchers, w)
+		close(w.ch)
+	}
+}
+
+// stopWatches closes and removes all watchers, and closes those added
+// later immediately, so that the watch handlers return and don't hold up
+// the shutdown of the command HTTP server.
+func (f *kvFSM) stopWatches() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+	for w := range f.watchers {
+		close(w.ch)
+	}
+	f.watchers = nil
+}
+
+// get returns the entry for key, ignoring entries that have expired
+// according to the local clock but haven't been removed yet.
+func (f *kvFSM) get(key string, now time.Time) (KVEntry, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[key]
+	if !ok || e.expiredAt(now) {
+		return KVEntry{}, false
+	}
+	return e, true
+}
+
+// list returns the unexpired entries with keys starting with prefix, sorted
+// by key.
+func (f *kvFSM) list(prefix string, now time.Time) []KVEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ret []KVEntry
+	for k, e := range f.entries {
+		if strings.HasPrefix(k, prefix) && !e.expiredAt(now) {
+			ret = append(ret, e)
+		}
+	}
+	slices.SortFunc(ret, func(a, b KVEntry) int { return cmp.Compare(a.Key, b.Key) })
+	return ret
+}
+
+type kvSnapshot struct {
+	Entries map[string]KVEntry
+	LastNow time.Time
+}
+
+func (f *kvFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := json.Marshal(kvSnapshot{
+		Entries: maps.Clone(f.entries),
+		LastNow: f.lastNow,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kvFSMSnapshot(b), nil
+}
+
+func (f *kvFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var snap kvSnapshot
+	if err := json.NewDecoder(rc).Decode(&snap); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.entries
+	f.entries = snap.Entries
+	f.lastNow = snap.LastNow
+	f.expiries = f.expiries[:0]
+	for k, e := range f.entries {
+		if !e.Expires.IsZero() {
+			f.expiries = append(f.expiries, kvExpiry{e.Expires, k})
+		}
+	}
+	heap.Init(&f.expiries)
+
+	// Tell the watchers how the snapshot differs from what they've seen.
+	for k, e := range old {
+		if _, ok := f.entries[k]; !ok {
+			f.notifyLocked(KVEvent{Type: KVDelete, Entry: e})
+		}
+	}
+	for k, e := range f.entries {
+		if oe, ok := old[k]; !ok || oe.Version != e.Version {
+			f.notifyLocked(KVEvent{Type: KVPut, Entry: e})
+		}
+	}
+	return nil
+}
+
+type kvFSMSnapshot []byte
+
+func (s kvFSMSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s kvFSMSnapshot) Release() {}
+
+// A KV is a replicated key/value store backed by a Consensus cluster.
+//
+// Writes go through Raft via the cluster leader; reads are linearizable and
+// are served from the local replica. All nodes of the cluster must use
+// StartKV rather than Start.
+type KV struct {
+	c   *Consensus
+	fsm *kvFSM
+}
+
+// StartKV is like Start, but runs a key/value store state machine rather
+// than a caller-provided one.
+//
+// In addition to the methods of KV, the store's changes can be watched from
+// other cluster members with a streaming GET request to
+// /kv/watch?prefix=<prefix> on the command port, which writes one
+// JSON-encoded KVEvent per line.
+func StartKV(ctx context.Context, ts *tsnet.Server, clusterTag string, cfg Config) (*KV, error) {
+	fsm := &kvFSM{}
+	c, err := Start(ctx, ts, fsm, clusterTag, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &KV{c: c, fsm: fsm}, nil
+}
+
+// Consensus returns the underlying Consensus, for example to manage cluster
+// membership or to Stop it.
+func (kv *KV) Consensus() *Consensus { return kv.c }
+
+func (kv *KV) execute(name string, args kvArgs) (kvResult, error) {
+	args.Now = time.Now()
+	b, err := json.Marshal(args)
+	if err != nil {
+		return kvResult{}, err
+	}
+	cr, err := kv.c.ExecuteCommand(Command{Name: name, Args: b})
+	if err != nil {
+		return kvResult{}, err
+	}
+	if cr.Err != nil {
+		return kvResult{}, cr.Err
+	}
+	var res kvResult
+	if err := json.Unmarshal(cr.Result, &res); err != nil {
+		return kvResult{}, err
+	}
+	if res.Err != "" {
+		return kvResult{}, errors.New(res.Err)
+	}
+	return res, nil
+}
+
+// Put sets key to value. If ttl is positive, the key expires after ttl.
+// It returns the new entry.
+func (kv *KV) Put(key string, value []byte, ttl time.Duration) (KVEntry, error) {
+	res, err := kv.execute(kvCmdPut, kvArgs{Key: key, Value: value, TTL: ttl})
+	return res.Entry, err
+}
+
+// CompareAndSwap sets key to value only if the key's current version is
+// version, where a version of zero means that the key must not exist. If ttl
+// is positive, the key expires after ttl.
+//
+// It reports whether the swap happened, and returns the key's entry after
+// the operation: the new entry if swapped, otherwise the current one (the
+// zero KVEntry if the key doesn't exist).
+func (kv *KV) CompareAndSwap(key string, version uint64, value []byte, ttl time.Duration) (_ KVEntry, swapped bool, _ error) {
+	res, err := kv.execute(kvCmdCAS, kvArgs{Key: key, Value: value, TTL: ttl, Version: version})
+	return res.Entry, res.OK, err
+}
+
+// Delete removes key. It reports whether the key existed.
+func (kv *KV) Delete(key string) (existed bool, _ error) {
+	res, err := kv.execute(kvCmdDelete, kvArgs{Key: key})
+	return res.OK, err
+}
+
+// Get returns the entry for key, reflecting all writes committed before the
+// call.
+func (kv *KV) Get(ctx context.Context, key string) (_ KVEntry, ok bool, _ error) {
+	if err := kv.c.LinearizableRead(ctx); err != nil {
+		return KVEntry{}, false, err
+	}
+	e, ok := kv.fsm.get(key, time.Now())
+	return e, ok, nil
+}
+
+// List returns the entries whose keys start with prefix, sorted by key,
+// reflecting all writes committed before the call.
+func (kv *KV) List(ctx context.Context, prefix string) ([]KVEntry, error) {
+	if err := kv.c.LinearizableRead(ctx); err != nil {
+		return nil, err
+	}
+	return kv.fsm.list(prefix, time.Now()), nil
+}
+
+// Watch returns a channel of the changes to keys starting with prefix, as
+// they are applied to the local replica, including when it's restored from
+// a snapshot. The channel is closed when ctx is done, when the Consensus is
+// stopped, or if the caller doesn't keep up with the changes.
+func (kv *KV) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	w := kv.fsm.addWatcher(prefix)
+	go func() {
+		<-ctx.Done()
+		kv.fsm.removeWatcher(w)
+	}()
+	return w.ch
+}
+
+func (f *kvFSM) handleWatchHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	kw := f.addWatcher(r.FormValue("prefix"))
+	defer f.removeWatcher(kw)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-kw.ch:
+			if !ok {
+				return // too slow
+			}
+			if err := enc.Encode(ev); err != nil {
+				log.Printf("kv watch: error encoding event: %v", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// kvApply applies a KV command to f as raft log entry idx.
+func kvApply(t *testing.T, f *kvFSM, idx uint64, name string, args kvArgs) kvResult {
+	t.Helper()
+	ab, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := json.Marshal(Command{Name: name, Args: ab})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := f.Apply(&raft.Log{Index: idx, Data: cb}).(CommandResult)
+	if cr.Err != nil {
+		t.Fatal(cr.Err)
+	}
+	var res kvResult
+	if err := json.Unmarshal(cr.Result, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Err != "" {
+		t.Fatalf("%s: %s", name, res.Err)
+	}
+	return res
+}
+
+func TestKVFSM(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &kvFSM{}
+
+	res := kvApply(t, f, 1, kvCmdPut, kvArgs{Key: "a/1", Value: []byte("one"), Now: now})
+	if !res.OK || res.Entry.Version != 1 {
+		t.Fatalf("put: got %+v", res)
+	}
+	kvApply(t, f, 2, kvCmdPut, kvArgs{Key: "a/2", Value: []byte("two"), Now: now})
+	kvApply(t, f, 3, kvCmdPut, kvArgs{Key: "b/1", Value: []byte("three"), Now: now})
+
+	// CAS with the wrong version fails and returns the current entry.
+	res = kvApply(t, f, 4, kvCmdCAS, kvArgs{Key: "a/1", Value: []byte("uno"), Version: 7, Now: now})
+	if res.OK || string(res.Entry.Value) != "one" {
+		t.Fatalf("CAS with wrong version: got %+v", res)
+	}
+	// CAS with version 0 fails on an existing key.
+	res = kvApply(t, f, 5, kvCmdCAS, kvArgs{Key: "a/1", Value: []byte("uno"), Now: now})
+	if res.OK {
+		t.Fatalf("CAS with version 0 on existing key: got %+v", res)
+	}
+	res = kvApply(t, f, 6, kvCmdCAS, kvArgs{Key: "a/1", Value: []byte("uno"), Version: 1, Now: now})
+	if !res.OK || res.Entry.Version != 6 {
+		t.Fatalf("CAS: got %+v", res)
+	}
+	res = kvApply(t, f, 7, kvCmdCAS, kvArgs{Key: "c", Value: []byte("new"), Now: now})
+	if !res.OK {
+		t.Fatalf("CAS with version 0 on new key: got %+v", res)
+	}
+
+	var keys []string
+	for _, e := range f.list("a/", now) {
+		keys = append(keys, e.Key+"="+string(e.Value))
+	}
+	if got, want := fmt.Sprint(keys), "[a/1=uno a/2=two]"; got != want {
+		t.Errorf("list a/ = %v, want %v", got, want)
+	}
+
+	res = kvApply(t, f, 8, kvCmdDelete, kvArgs{Key: "a/2", Now: now})
+	if !res.OK {
+		t.Errorf("delete of existing key: got %+v", res)
+	}
+	res = kvApply(t, f, 9, kvCmdDelete, kvArgs{Key: "a/2", Now: now})
+	if res.OK {
+		t.Errorf("delete of missing key: got %+v", res)
+	}
+	if _, ok := f.get("a/2", now); ok {
+		t.Errorf("a/2 exists after delete")
+	}
+}
+
+func TestKVFSMExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &kvFSM{}
+	w := f.addWatcher("")
+
+	kvApply(t, f, 1, kvCmdPut, kvArgs{Key: "k", Value: []byte("v"), TTL: time.Minute, Now: now})
+	if _, ok := f.get("k", now.Add(30*time.Second)); !ok {
+		t.Fatalf("k missing before its expiry")
+	}
+	// Reads hide expired entries before they're removed.
+	if _, ok := f.get("k", now.Add(time.Minute)); ok {
+		t.Fatalf("k readable after its expiry")
+	}
+	// The next command proposed after the expiry removes the entry.
+	kvApply(t, f, 2, kvCmdPut, kvArgs{Key: "other", Value: []byte("v"), Now: now.Add(2 * time.Minute)})
+	if _, ok := f.entries["k"]; ok {
+		t.Fatalf("k not removed")
+	}
+
+	var got []string
+	for range 3 {
+		ev := <-w.ch
+		got = append(got, string(ev.Type)+" "+ev.Entry.Key)
+	}
+	if got, want := fmt.Sprint(got), "[put k expire k put other]"; got != want {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// Replacing an entry replaces its expiry.
+	kvApply(t, f, 3, kvCmdPut, kvArgs{Key: "k", Value: []byte("v"), TTL: time.Minute, Now: now.Add(2 * time.Minute)})
+	kvApply(t, f, 4, kvCmdPut, kvArgs{Key: "k", Value: []byte("forever"), Now: now.Add(2 * time.Minute)})
+	kvApply(t, f, 5, kvCmdPut, kvArgs{Key: "other", Value: []byte("v"), Now: now.Add(time.Hour)})
+	if e, ok := f.get("k", now.Add(time.Hour)); !ok || string(e.Value) != "forever" {
+		t.Errorf("replaced entry without a TTL = %+v, %v; want it not expired", e, ok)
+	}
+}
+
+func TestKVFSMWatch(t *testing.T) {
+	f := &kvFSM{}
+	wa := f.addWatcher("a/")
+	slow := f.addWatcher("")
+
+	for i := range kvWatchBuffer + 1 {
+		kvApply(t, f, uint64(i+1), kvCmdPut, kvArgs{Key: fmt.Sprintf("b/%d", i)})
+	}
+	kvApply(t, f, kvWatchBuffer+2, kvCmdPut, kvArgs{Key: "a/x"})
+
+	if ev := <-wa.ch; ev.Type != KVPut || ev.Entry.Key != "a/x" {
+		t.Errorf("a/ watcher got %+v, want put of a/x", ev)
+	}
+	// The watcher that didn't read its events is closed.
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	if n != kvWatchBuffer {
+		t.Errorf("slow watcher got %d events before being closed, want %d", n, kvWatchBuffer)
+	}
+	f.removeWatcher(slow) // no-op
+	f.removeWatcher(wa)
+	if _, ok := <-wa.ch; ok {
+		t.Errorf("watcher channel not closed after removeWatcher")
+	}
+
+	// Stopping closes the watchers, including ones added later.
+	w := f.addWatcher("")
+	f.stopWatches()
+	if _, ok := <-w.ch; ok {
+		t.Errorf("watcher channel not closed after stopWatches")
+	}
+	if _, ok := <-f.addWatcher("").ch; ok {
+		t.Errorf("watcher added after stopWatches not closed")
+	}
+}
+
+func TestKVFSMSnapshotRestore(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &kvFSM{}
+	kvApply(t, f, 1, kvCmdPut, kvArgs{Key: "k1", Value: []byte("v1"), Now: now})
+	kvApply(t, f, 2, kvCmdPut, kvArgs{Key: "k2", Value: []byte("v2"), TTL: time.Hour, Now: now})
+
+	snap, err := f.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2 := &kvFSM{}
+	kvApply(t, f2, 1, kvCmdPut, kvArgs{Key: "k1", Value: []byte("v1"), Now: now})
+	kvApply(t, f2, 2, kvCmdPut, kvArgs{Key: "gone", Value: []byte("v"), Now: now})
+	w := f2.addWatcher("")
+	if err := f2.Restore(io.NopCloser(bytes.NewReader(snap.(kvFSMSnapshot)))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(f2.list("", now)), fmt.Sprint(f.list("", now)); got != want {
+		t.Errorf("restored entries = %v, want %v", got, want)
+	}
+	if !f2.lastNow.Equal(now) {
+		t.Errorf("restored lastNow = %v, want %v", f2.lastNow, now)
+	}
+
+	// The watchers are told of the changes, and restored entries expire.
+	kvApply(t, f2, 3, kvCmdPut, kvArgs{Key: "other", Value: []byte("v"), Now: now.Add(2 * time.Hour)})
+	var got []string
+	for range 3 {
+		ev := <-w.ch
+		got = append(got, string(ev.Type)+" "+ev.Entry.Key)
+	}
+	if got, want := fmt.Sprint(got), "[delete gone put k2 expire k2]"; got != want {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestKV(t *testing.T) {
+	testConfig(t)
+	ctx := context.Background()
+	clusterTag := "tag:whatever"
+	ps, _, _ := startNodesAndWaitForPeerStatus(t, ctx, clusterTag, 2)
+	cfg := warnLogConfig()
+
+	kvs := make([]*KV, len(ps))
+	for i, p := range ps {
+		kv, err := StartKV(ctx, p.ts, clusterTag, addIDedLogger(fmt.Sprint(i), cfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kv.Consensus().Stop(ctx)
+		kvs[i] = kv
+		if i == 0 {
+			waitFor(t, "node 0 is leader", func() bool {
+				return kv.Consensus().raft.State() == raft.Leader
+			}, 2*time.Second)
+		}
+	}
+	waitFor(t, "node 1 joined", func() bool {
+		vs, err := kvs[0].Consensus().Voters()
+		return err == nil && len(vs) == 2
+	}, 2*time.Second)
+
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	defer cancelWatch()
+	events := kvs[1].Watch(watchCtx, "app/")
+
+	// Watch node 0 over the command port from node 1.
+	req, err := http.NewRequestWithContext(watchCtx, "GET",
+		fmt.Sprintf("http://%s/kv/watch?prefix=app/", kvs[0].Consensus().commandAddr(kvs[0].Consensus().self.hostAddr)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ps[1].ts.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("watch: %v", resp.Status)
+	}
+
+	// Write through the follower.
+	e, err := kvs[1].Put("app/x", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, swapped, err := kvs[1].CompareAndSwap("app/x", e.Version+100, []byte("2"), 0); err != nil || swapped {
+		t.Fatalf("CompareAndSwap with wrong version: swapped=%v, err=%v", swapped, err)
+	}
+	if _, swapped, err := kvs[1].CompareAndSwap("app/x", e.Version, []byte("2"), 0); err != nil || !swapped {
+		t.Fatalf("CompareAndSwap: swapped=%v, err=%v", swapped, err)
+	}
+	if _, err := kvs[0].Put("other", []byte("ignored"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, kv := range kvs {
+		got, ok, err := kv.Get(ctx, "app/x")
+		if err != nil || !ok || string(got.Value) != "2" {
+			t.Errorf("%d: Get = %q, %v, %v; want \"2\"", i, got.Value, ok, err)
+		}
+		list, err := kv.List(ctx, "")
+		if err != nil || len(list) != 2 {
+			t.Errorf("%d: List = %v, %v; want 2 entries", i, list, err)
+		}
+	}
+	if existed, err := kvs[0].Delete("app/x"); err != nil || !existed {
+		t.Errorf("Delete = %v, %v; want true", existed, err)
+	}
+
+	want := []string{"put 1", "put 2", "delete 2"}
+	for i := range want {
+		ev := <-events
+		if got := fmt.Sprintf("%s %s", ev.Type, ev.Entry.Value); got != want[i] {
+			t.Errorf("local watch event %d = %q, want %q", i, got, want[i])
+		}
+	}
+	br := bufio.NewReader(resp.Body)
+	for i := range want {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ev KVEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%s %s", ev.Type, ev.Entry.Value); got != want[i] {
+			t.Errorf("HTTP watch event %d = %q, want %q", i, got, want[i])
+		}
+	}
+	// Stopping the node ends the watch, rather than waiting for it.
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	kvs[0].Consensus().Stop(stopCtx)
+	if stopCtx.Err() != nil {
+		t.Errorf("Stop waited for the watch to end")
+	}
+	if _, err := br.ReadBytes('\n'); err != io.EOF {
+		t.Errorf("reading watch after Stop: %v; want EOF", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/hashicorp/raft"
+)
+
+// A Voter is a voting member of the Raft cluster.
+type Voter struct {
+	// ID is the Raft server ID. tsconsensus uses the member's Tailscale
+	// IPv4 address as its ID.
+	ID string
+	// Addr is the Tailscale IP address of the member.
+	Addr netip.Addr
+	// Leader is whether this node believes the member is the current leader.
+	Leader bool
+}
+
+// Voters returns the voting members of the cluster, according to the latest
+// Raft configuration known to this node. The configuration may not yet be
+// committed.
+func (c *Consensus) Voters() ([]Voter, error) {
+	fut := c.raft.GetConfiguration()
+	if err := fut.Error(); err != nil {
+		return nil, err
+	}
+	_, leaderID := c.raft.LeaderWithID()
+	var voters []Voter
+	for _, s := range fut.Configuration().Servers {
+		if s.Suffrage != raft.Voter {
+			continue
+		}
+		addr, err := addrFromServerAddress(string(s.Address))
+		if err != nil {
+			return nil, fmt.Errorf("server %q: %w", s.ID, err)
+		}
+		voters = append(voters, Voter{
+			ID:     string(s.ID),
+			Addr:   addr,
+			Leader: s.ID == leaderID,
+		})
+	}
+	return voters, nil
+}
+
+// AddVoter adds the node with the Tailscale IPv4 address addr to the cluster
+// as a voter. The node must be tagged with the cluster tag. The request is
+// forwarded to the leader if this node is not the leader.
+//
+// Nodes normally join the cluster by themselves when they Start; AddVoter is
+// for operators that manage membership explicitly, for example to re-add a
+// node after a RemoveVoter.
+func (c *Consensus) AddVoter(ctx context.Context, addr netip.Addr) error {
+	if !addr.Is4() {
+		return fmt.Errorf("AddVoter: %v is not an IPv4 address", addr)
+	}
+	if err := c.auth.Refresh(ctx); err != nil {
+		return fmt.Errorf("AddVoter: auth refresh: %w", err)
+	}
+	if !c.auth.AllowsHost(addr) {
+		return fmt.Errorf("AddVoter: %v is not tagged with the cluster tag", addr)
+	}
+	jr := joinRequest{
+		RemoteHost: addr.String(),
+		RemoteID:   addr.String(),
+	}
+	err := c.handleJoin(jr)
+	if !errors.Is(err, raft.ErrNotLeader) {
+		return err
+	}
+	leader, err := c.getLeader()
+	if err != nil {
+		return err
+	}
+	return c.commandClient.join(ctx, leader, jr)
+}
+
+// RemoveVoter removes the member with the Tailscale IPv4 address addr from
+// the cluster. The request is forwarded to the leader if this node is not the
+// leader. Removing the leader causes a new election.
+func (c *Consensus) RemoveVoter(ctx context.Context, addr netip.Addr) error {
+	rr := removeRequest{RemoteID: addr.String()}
+	err := c.handleRemove(rr)
+	if !errors.Is(err, raft.ErrNotLeader) {
+		return err
+	}
+	leader, err := c.getLeader()
+	if err != nil {
+		return err
+	}
+	return c.commandClient.remove(ctx, leader, rr)
+}
+
+func (c *Consensus) handleRemove(rr removeRequest) error {
+	return c.raft.RemoveServer(raft.ServerID(rr.RemoteID), 0, 0).Error()
+}
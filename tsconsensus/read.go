@@ -0,0 +1,115 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// indexedFSM wraps the user's raft.FSM to keep track of the index of the
+// last log entry that the FSM has finished applying.
+//
+// raft.Raft.AppliedIndex can't be used for that, as it's updated as soon as
+// an entry is handed to the FSM, not once the FSM is done with it.
+type indexedFSM struct {
+	raft.FSM
+
+	mu       sync.Mutex
+	applied  uint64 // index of the last entry Apply returned for
+	restored bool   // whether Restore was called since the last Apply
+}
+
+func (f *indexedFSM) Apply(l *raft.Log) any {
+	res := f.FSM.Apply(l)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applied = l.Index
+	f.restored = false
+	return res
+}
+
+func (f *indexedFSM) Restore(rc io.ReadCloser) error {
+	if err := f.FSM.Restore(rc); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.restored = true
+	return nil
+}
+
+// hasApplied reports whether the FSM reflects all entries up to and
+// including index idx.
+//
+// raftApplied is the raft.Raft.AppliedIndex, which after a snapshot restore
+// is the index of the snapshot that the FSM was restored from.
+func (f *indexedFSM) hasApplied(idx, raftApplied uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.applied >= idx {
+		return true
+	}
+	return f.restored && raftApplied >= idx
+}
+
+// readPollInterval is how often LinearizableRead checks whether the local
+// state machine has caught up with the read index.
+const readPollInterval = 5 * time.Millisecond
+
+// LinearizableRead blocks until reads of the local state machine are
+// guaranteed to reflect every command committed before LinearizableRead was
+// called, without writing to the Raft log.
+//
+// It implements the "read index" protocol from section 6.4 of the Raft
+// thesis: the leader records its commit index and confirms that it is still
+// the leader with a round of heartbeats, then this node waits for its state
+// machine to apply up to that index. On followers, the read index is
+// requested from the leader over the command port.
+//
+// Callers read directly from their raft.FSM after LinearizableRead returns
+// nil.
+func (c *Consensus) LinearizableRead(ctx context.Context) error {
+	idx, err := c.leaderReadIndex()
+	if errors.Is(err, raft.ErrNotLeader) {
+		var leader string
+		leader, err = c.getLeader()
+		if err != nil {
+			return err
+		}
+		idx, err = c.commandClient.readIndex(ctx, leader)
+	}
+	if err != nil {
+		return err
+	}
+	for !c.fsm.hasApplied(idx, c.raft.AppliedIndex()) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(readPollInterval):
+		}
+	}
+	return nil
+}
+
+// leaderReadIndex returns the commit index to wait for before serving a
+// linearizable read. It returns raft.ErrNotLeader if this node is not the
+// leader.
+func (c *Consensus) leaderReadIndex() (uint64, error) {
+	if c.raft.State() != raft.Leader {
+		return 0, raft.ErrNotLeader
+	}
+	// The commit index must be read before verifying leadership, so that
+	// any write acknowledged before this call is covered.
+	idx := c.raft.CommitIndex()
+	if err := c.raft.VerifyLeader().Error(); err != nil {
+		return 0, err
+	}
+	return idx, nil
+}
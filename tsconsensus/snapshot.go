@@ -0,0 +1,98 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/raft"
+)
+
+// Snapshot forces Raft to take a snapshot of the local state machine and
+// compact its log. It blocks until the snapshot is complete.
+//
+// Snapshots are also taken automatically according to the snapshot settings
+// in Config.Raft; Snapshot is for operators that want one now, for example
+// before replacing nodes.
+func (c *Consensus) Snapshot() error {
+	return c.raft.Snapshot().Error()
+}
+
+// ExportSnapshot takes a snapshot of the local state machine and writes it to
+// w, in the format written by the state machine's raft.FSMSnapshot.Persist.
+// The result can be passed to RestoreSnapshot, on this or a new cluster.
+func (c *Consensus) ExportSnapshot(w io.Writer) error {
+	fut := c.raft.Snapshot()
+	if err := fut.Error(); err != nil {
+		if !errors.Is(err, raft.ErrNothingNewToSnapshot) {
+			return err
+		}
+		// There is already a snapshot of the current state; export
+		// that one.
+		fut = c.latestSnapshot()
+	}
+	_, rc, err := fut.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// latestSnapshot returns a raft.SnapshotFuture for the most recent snapshot
+// in the snapshot store.
+func (c *Consensus) latestSnapshot() raft.SnapshotFuture {
+	return existingSnapshot{c.snapStore}
+}
+
+type existingSnapshot struct {
+	store raft.SnapshotStore
+}
+
+func (existingSnapshot) Error() error { return nil }
+
+func (s existingSnapshot) Open() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	snaps, err := s.store.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, nil, errors.New("no snapshot available")
+	}
+	return s.store.Open(snaps[0].ID) // List returns newest first
+}
+
+// RestoreSnapshot replaces the state of the cluster with the snapshot read
+// from r, which must have been produced by ExportSnapshot with the same kind
+// of state machine. The snapshot is restored into the leader's state machine
+// and then replicated to the followers.
+//
+// It must be called on the leader, and is meant for disaster recovery into a
+// fresh cluster: while it runs, the leader commits ahead of its followers.
+// The cluster keeps its current membership. If the state machine fails to
+// restore the snapshot, the process panics. See raft.Raft.Restore for
+// details.
+func (c *Consensus) RestoreSnapshot(r io.Reader) error {
+	if c.raft.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+	// Raft needs to know the size of the snapshot up front.
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// Raft picks the Index and Term of the restored snapshot itself.
+	meta := &raft.SnapshotMeta{
+		Version: raft.SnapshotVersionMax,
+		Size:    int64(len(b)),
+	}
+	if err := c.raft.Restore(meta, bytes.NewReader(b), 0); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	return nil
+}
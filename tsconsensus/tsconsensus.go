@@ -12,6 +12,10 @@
 //   - cluster peer discovery based on tailscale tags
 //   - executing a command on the leader
 //   - communication between cluster peers over tailscale using tsnet
+//   - explicit membership management (Voters, AddVoter, RemoveVoter)
+//   - snapshot export and restore (ExportSnapshot, RestoreSnapshot)
+//   - linearizable reads of the local state machine (LinearizableRead)
+//   - a ready-made replicated key/value store with TTLs and watches (StartKV)
 //
 // Users implement a state machine that satisfies the raft.FSM interface, with the business logic they desire.
 // When changes to state are needed any node may
@@ -178,14 +182,16 @@ func Start(ctx context.Context, ts *tsnet.Server, fsm raft.FSM, clusterTag strin
 		hostAddr: v4,
 	}
 	shutdownCtx, shutdownCtxCancel := context.WithCancel(ctx)
+	auth := newAuthorization(ts, clusterTag)
 	c := Consensus{
 		commandClient:     &cc,
 		self:              self,
 		config:            cfg,
+		auth:              auth,
+		fsm:               &indexedFSM{FSM: fsm},
 		shutdownCtxCancel: shutdownCtxCancel,
 	}
 
-	auth := newAuthorization(ts, clusterTag)
 	err := auth.Refresh(shutdownCtx)
 	if err != nil {
 		return nil, fmt.Errorf("auth refresh: %w", err)
@@ -203,11 +209,13 @@ func Start(ctx context.Context, ts *tsnet.Server, fsm raft.FSM, clusterTag strin
 	// after startRaft it's possible some other raft node that has us in their configuration will get
 	// in contact, so by the time we do anything else we may already be a functioning member
 	// of a consensus
-	r, err := startRaft(shutdownCtx, ts, &fsm, c.self, auth, cfg)
+	var rfsm raft.FSM = c.fsm
+	r, snapStore, err := startRaft(shutdownCtx, ts, &rfsm, c.self, auth, cfg)
 	if err != nil {
 		return nil, err
 	}
 	c.raft = r
+	c.snapStore = snapStore
 
 	c.bootstrap(auth.AllowedPeers())
 
@@ -222,7 +230,7 @@ func Start(ctx context.Context, ts *tsnet.Server, fsm raft.FSM, clusterTag strin
 	return &c, nil
 }
 
-func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, self selfRaftNode, auth *authorization, cfg Config) (*raft.Raft, error) {
+func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, self selfRaftNode, auth *authorization, cfg Config) (*raft.Raft, raft.SnapshotStore, error) {
 	cfg.Raft.LocalID = raft.ServerID(self.id)
 
 	var logStore raft.LogStore
@@ -238,7 +246,7 @@ func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, sel
 		var err error
 		stableStore, logStore, err = boltStore(filepath.Join(cfg.StateDirPath, "store"))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		snaplogger := hclog.New(&hclog.LoggerOptions{
 			Name:   "raft-snap",
@@ -247,14 +255,14 @@ func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, sel
 		})
 		snapStore, err = raft.NewFileSnapshotStoreWithLogger(filepath.Join(cfg.StateDirPath, "snapstore"), 2, snaplogger)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
 	// opens the listener on the raft port, raft will close it when it thinks it's appropriate
 	ln, err := ts.Listen("tcp", raftAddr(self.hostAddr, cfg))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	transportLogger := hclog.New(&hclog.LoggerOptions{
@@ -273,7 +281,11 @@ func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, sel
 		cfg.ConnTimeout,
 		transportLogger)
 
-	return raft.NewRaft(cfg.Raft, *fsm, logStore, stableStore, snapStore, transport)
+	r, err := raft.NewRaft(cfg.Raft, *fsm, logStore, stableStore, snapStore, transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, snapStore, nil
 }
 
 // A Consensus is the consensus algorithm for a tsnet.Server
@@ -284,6 +296,9 @@ type Consensus struct {
 	commandClient     *commandClient
 	self              selfRaftNode
 	config            Config
+	auth              *authorization
+	fsm               *indexedFSM // wraps the user's raft.FSM
+	snapStore         raft.SnapshotStore
 	cmdHttpServer     *http.Server
 	monitorHttpServer *http.Server
 	shutdownCtxCancel context.CancelFunc
@@ -312,7 +327,7 @@ func (c *Consensus) bootstrap(targets views.Slice[*ipnstate.PeerStatus]) error {
 			continue
 		}
 		log.Printf("Trying to find cluster: trying %s", p.TailscaleIPs[0])
-		err := c.commandClient.join(p.TailscaleIPs[0].String(), joinRequest{
+		err := c.commandClient.join(context.Background(), p.TailscaleIPs[0].String(), joinRequest{
 			RemoteHost: c.self.hostAddr.String(),
 			RemoteID:   c.self.id,
 		})
@@ -360,6 +375,9 @@ func (c *Consensus) Stop(ctx context.Context) error {
 		log.Printf("Stop: Error in Raft Shutdown: %v", err)
 	}
 	c.shutdownCtxCancel()
+	if kv, ok := c.fsm.FSM.(*kvFSM); ok {
+		kv.stopWatches() // or their long polls hold up Shutdown
+	}
 	err = c.cmdHttpServer.Shutdown(ctx)
 	if err != nil {
 		log.Printf("Stop: Error in command HTTP Shutdown: %v", err)
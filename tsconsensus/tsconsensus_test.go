@@ -105,13 +105,39 @@ func (f *fsm) eventsMatch(es []string) bool {
 }
 
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	return nil, nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := json.Marshal(f.applyEvents)
+	if err != nil {
+		return nil, err
+	}
+	return fsmSnapshot(b), nil
 }
 
 func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var events []string
+	if err := json.NewDecoder(rc).Decode(&events); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applyEvents = events
 	return nil
 }
 
+type fsmSnapshot []byte
+
+func (s fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s fsmSnapshot) Release() {}
+
 func testConfig(t *testing.T) {
 	// -race AND Parallel makes things start to take too long.
 	if !racebuild.On {
@@ -739,3 +765,138 @@ func TestOnlyTaggedPeersCanJoin(t *testing.T) {
 		t.Fatalf("join req when not tagged, expected body: %s, got: %s", expected, sBody)
 	}
 }
+
+func TestMembership(t *testing.T) {
+	testConfig(t)
+	ctx := context.Background()
+	clusterTag := "tag:whatever"
+	ps, _, _ := startNodesAndWaitForPeerStatus(t, ctx, clusterTag, 3)
+	cfg := warnLogConfig()
+	createConsensusCluster(t, ctx, clusterTag, ps, cfg)
+	for _, p := range ps {
+		defer p.c.Stop(ctx)
+	}
+
+	voters, err := ps[1].c.Voters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(voters) != 3 {
+		t.Fatalf("got %d voters, want 3: %+v", len(voters), voters)
+	}
+	numLeaders := 0
+	for _, v := range voters {
+		if v.Leader {
+			numLeaders++
+		}
+	}
+	if numLeaders != 1 {
+		t.Fatalf("got %d leaders, want 1: %+v", numLeaders, voters)
+	}
+
+	numVoters := func(i int) func() bool {
+		return func() bool {
+			for _, p := range ps[:2] {
+				voters, err := p.c.Voters()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(voters) != i {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	// Remove the third node, asking a follower; it's forwarded to the leader.
+	third := ps[2].c.self.hostAddr
+	if err := ps[1].c.RemoveVoter(ctx, third); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "third node removed from config", numVoters(2), time.Second)
+
+	if err := ps[1].c.AddVoter(ctx, third); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "third node added back to config", numVoters(3), time.Second)
+
+	if err := ps[1].c.AddVoter(ctx, netip.MustParseAddr("100.64.99.99")); err == nil {
+		t.Fatal("AddVoter of untagged node succeeded")
+	}
+}
+
+func TestLinearizableRead(t *testing.T) {
+	testConfig(t)
+	ctx := context.Background()
+	clusterTag := "tag:whatever"
+	ps, _, _ := startNodesAndWaitForPeerStatus(t, ctx, clusterTag, 2)
+	cfg := warnLogConfig()
+	createConsensusCluster(t, ctx, clusterTag, ps, cfg)
+	for _, p := range ps {
+		defer p.c.Stop(ctx)
+	}
+
+	for i, p := range ps {
+		bs, err := json.Marshal(fmt.Sprint(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ps[0].c.ExecuteCommand(Command{Args: bs}); err != nil {
+			t.Fatal(err)
+		}
+		readCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err = p.c.LinearizableRead(readCtx)
+		cancel()
+		if err != nil {
+			t.Fatalf("%d: LinearizableRead: %v", i, err)
+		}
+		if got := p.sm.numEvents(); got != i+1 {
+			t.Fatalf("%d: after LinearizableRead, state machine has %d events, want %d", i, got, i+1)
+		}
+	}
+}
+
+func TestSnapshotExportRestore(t *testing.T) {
+	testConfig(t)
+	ctx := context.Background()
+	clusterTag := "tag:whatever"
+	ps, _, _ := startNodesAndWaitForPeerStatus(t, ctx, clusterTag, 2)
+	cfg := warnLogConfig()
+	createConsensusCluster(t, ctx, clusterTag, ps, cfg)
+	for _, p := range ps {
+		defer p.c.Stop(ctx)
+	}
+	leader := ps[0]
+
+	for _, s := range []string{"a", "b"} {
+		if err := leader.c.raft.Apply(commandWith(t, s), 2*time.Second).Error(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := leader.c.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	// Nothing new since the last snapshot; the existing one is exported.
+	if err := leader.c.ExportSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `["a","b"]`; got != want {
+		t.Fatalf("exported snapshot = %q, want %q", got, want)
+	}
+
+	if err := leader.c.raft.Apply(commandWith(t, "c"), 2*time.Second).Error(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps[1].c.RestoreSnapshot(bytes.NewReader(buf.Bytes())); !errors.Is(err, raft.ErrNotLeader) {
+		t.Fatalf("RestoreSnapshot on follower: got %v, want ErrNotLeader", err)
+	}
+	if err := leader.c.RestoreSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "b"}
+	waitFor(t, "restored snapshot replicated to all state machines", func() bool {
+		return ps[0].sm.eventsMatch(want) && ps[1].sm.eventsMatch(want)
+	}, time.Second)
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// identityConn is a net.Conn accepted from the tailnet, annotated with the
+// identity of its peer as of when the connection was accepted.
+type identityConn struct {
+	net.Conn
+	who *apitype.WhoIsResponse // or nil if the peer was unknown
+}
+
+// NetConn returns the underlying connection, like [tls.Conn.NetConn].
+func (c *identityConn) NetConn() net.Conn { return c.Conn }
+
+// PeerIdentity returns the tailnet identity of the remote end of c: its
+// node, the node's owner and the peer capabilities granted to it by the
+// tailnet policy. It reports false if c did not come from a listener
+// returned by [Server.Listen], [Server.ListenTLS] or [Server.ListenFunnel],
+// or if the peer was not found in the netmap, such as for connections
+// arriving over Funnel.
+//
+// The identity is resolved from the local netmap when the connection is
+// accepted, without a LocalAPI round trip. The caller must not modify the
+// returned value.
+func PeerIdentity(c net.Conn) (_ *apitype.WhoIsResponse, ok bool) {
+	for c != nil {
+		if ic, ok := c.(*identityConn); ok {
+			return ic.who, ic.who != nil
+		}
+		nc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = nc.NetConn()
+	}
+	return nil, false
+}
+
+// whoIs resolves the tailnet identity of src, the address of a peer that
+// connected over protocol proto ("tcp" or "udp"), from the current netmap.
+// It returns nil if the peer is unknown.
+func (s *Server) whoIs(proto string, src netip.AddrPort) *apitype.WhoIsResponse {
+	if s.lb == nil {
+		return nil
+	}
+	n, u, ok := s.lb.WhoIs(proto, src)
+	if !ok {
+		return nil
+	}
+	res := &apitype.WhoIsResponse{
+		Node:        n.AsStruct(),
+		UserProfile: &u,
+	}
+	if n.Addresses().Len() > 0 {
+		res.CapMap = s.lb.PeerCaps(n.Addresses().At(0).Addr())
+	}
+	return res
+}
+
+// withIdentity returns handle wrapped such that the conns it's passed are
+// annotated with the identity of src. See PeerIdentity.
+func (s *Server) withIdentity(src netip.AddrPort, handle func(net.Conn)) func(net.Conn) {
+	return func(c net.Conn) {
+		handle(&identityConn{Conn: c, who: s.whoIs("tcp", src)})
+	}
+}
+
+type peerIdentityContextKey struct{}
+
+// PeerIdentityFromContext returns the peer identity stored in ctx by the
+// handler returned by [Server.WithPeerIdentity], if any.
+// The caller must not modify the returned value.
+func PeerIdentityFromContext(ctx context.Context) (_ *apitype.WhoIsResponse, ok bool) {
+	who, ok := ctx.Value(peerIdentityContextKey{}).(*apitype.WhoIsResponse)
+	return who, ok
+}
+
+// WithPeerIdentity returns an HTTP handler that resolves the tailnet
+// identity of each request's peer from s's netmap and makes it available to
+// h through [PeerIdentityFromContext]. Requests from peers that can't be
+// identified are passed to h without an identity.
+//
+// It is intended for use with servers serving on listeners returned by
+// [Server.Listen], [Server.ListenTLS] or [Server.ListenFunnel].
+func (s *Server) WithPeerIdentity(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if who := s.whoIs("tcp", src); who != nil {
+			r = r.WithContext(context.WithValue(r.Context(), peerIdentityContextKey{}, who))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -883,7 +883,7 @@ func (s *Server) getTCPHandlerForFlow(src, dst netip.AddrPort) (handler func(net
 		}
 		return nil, true // don't handle, don't forward to localhost
 	}
-	return ln.handle, true
+	return s.withIdentity(src, ln.handle), true
 }
 
 func (s *Server) getUDPHandlerForFlow(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool) {
@@ -956,6 +956,9 @@ func (s *Server) AuthenticatedAPITransport() (http.RoundTripper, error) {
 // IPv6 address of this node) only. To listen for traffic on other addresses
 // such as those routed inbound via subnet routes, explicitly specify
 // the listening address or use RegisterFallbackTCPHandler.
+//
+// The identity of the peer of an accepted TCP connection is available
+// via PeerIdentity.
 func (s *Server) Listen(network, addr string) (net.Listener, error) {
 	return s.listen(network, addr, listenOnTailnet)
 }
@@ -1302,3 +1302,77 @@ func mustDirect(t *testing.T, logf logger.Logf, lc1, lc2 *local.Client) {
 	}
 	t.Error("magicsock did not find a direct path from lc1 to lc2")
 }
+
+func TestPeerIdentity(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, s2PubKey := startServer(t, ctx, controlURL, "s2")
+
+	ln, err := s1.Listen("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	w, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8081", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	who, ok := PeerIdentity(r)
+	if !ok {
+		t.Fatal("PeerIdentity: no identity on accepted conn")
+	}
+	if who.Node.Key != s2PubKey {
+		t.Errorf("PeerIdentity node key = %v; want %v", who.Node.Key, s2PubKey)
+	}
+	if who.UserProfile == nil || who.UserProfile.LoginName == "" {
+		t.Errorf("PeerIdentity user profile = %+v", who.UserProfile)
+	}
+	if _, ok := PeerIdentity(tls.Server(r, &tls.Config{})); !ok {
+		t.Error("PeerIdentity of TLS-wrapped conn: no identity")
+	}
+	if _, ok := PeerIdentity(w); ok {
+		t.Error("PeerIdentity of dialed conn: got identity; want none")
+	}
+
+	// And via HTTP.
+	hln, err := s1.Listen("tcp", ":8082")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hln.Close()
+	hs := &http.Server{Handler: s1.WithPeerIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, ok := PeerIdentityFromContext(r.Context())
+		if !ok {
+			http.Error(w, "no identity", http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, who.Node.Key.String())
+	}))}
+	defer hs.Close()
+	go hs.Serve(hln)
+
+	res, err := s2.HTTPClient().Get(fmt.Sprintf("http://%s:8082/", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || string(body) != s2PubKey.String() {
+		t.Errorf("got %v, %q; want 200, %q", res.Status, body, s2PubKey)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package tsnettest runs self-contained tailnets of tsnet.Server nodes for
+// use in tests.
+//
+// A [Tailnet] consists of an in-memory control server, a DERP and STUN
+// server and any number of tsnet nodes, all listening on loopback. No
+// network access is required.
+package tsnettest
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/net/netns"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsnet"
+	"tailscale.com/tstest/integration"
+	"tailscale.com/tstest/integration/testcontrol"
+	"tailscale.com/types/logger"
+)
+
+// Config configures a new Tailnet.
+type Config struct {
+	// Nodes are the hostnames of the nodes to start with the tailnet.
+	// More can be added later with Tailnet.AddNode.
+	Nodes []string
+
+	// Grants, if non-nil, are the access rules of the tailnet.
+	// If nil, all nodes can reach each other on all ports.
+	Grants []Grant
+
+	// MagicDNSDomain is the tailnet's MagicDNS suffix.
+	// If empty, "tail-scale.ts.net" is used.
+	MagicDNSDomain string
+
+	// Logf, if non-nil, receives logs from the control, DERP and STUN
+	// servers. If nil, they are discarded.
+	Logf logger.Logf
+
+	// NodeLogf, if non-nil, is used as the Logf and UserLogf of every
+	// node. If nil, node logs are discarded.
+	NodeLogf logger.Logf
+}
+
+// Grant is a simplified tailnet access rule, in the style of the "grants"
+// section of a tailnet policy file, that refers to nodes by hostname.
+type Grant struct {
+	// Src and Dst are the hostnames of the source and destination nodes
+	// the grant applies to. The special value "*" matches all nodes.
+	Src []string
+	Dst []string
+
+	// IP are the network-level permissions granted, in
+	// tailcfg.ProtoPortRange format (for example "tcp:80", "443" or "*").
+	IP []string
+
+	// App are the application capabilities granted to Src when
+	// talking to Dst.
+	App tailcfg.PeerCapMap
+}
+
+// Tailnet is a running test tailnet.
+type Tailnet struct {
+	// ControlURL is the base URL of the control server.
+	ControlURL string
+
+	// Control is the in-memory control server.
+	Control *testcontrol.Server
+
+	// DERPMap is the DERP map served to nodes; it has a single region
+	// running on loopback.
+	DERPMap *tailcfg.DERPMap
+
+	t        testing.TB
+	dir      string
+	nodeLogf logger.Logf
+
+	mu     sync.Mutex
+	nodes  map[string]*tsnet.Server
+	ips    map[string][]netip.Addr // hostname => Tailscale IPs
+	grants []Grant
+}
+
+// New starts a new tailnet with the nodes and grants in cfg and waits for
+// all nodes to be running. It calls t.Fatal on failure, and everything is
+// shut down when the test finishes.
+//
+// Tests using New must not run in parallel with other tests that depend on
+// network namespacing, as it is disabled while the tailnet runs.
+func New(t testing.TB, cfg Config) *Tailnet {
+	t.Helper()
+
+	// Test nodes only talk to each other over loopback; don't try to
+	// bind to a particular interface.
+	netns.SetEnabled(false)
+	t.Cleanup(func() { netns.SetEnabled(true) })
+
+	logf := cfg.Logf
+	if logf == nil {
+		logf = logger.Discard
+	}
+	nodeLogf := cfg.NodeLogf
+	if nodeLogf == nil {
+		nodeLogf = logger.Discard
+	}
+	magicDNSDomain := cfg.MagicDNSDomain
+	if magicDNSDomain == "" {
+		magicDNSDomain = "tail-scale.ts.net"
+	}
+
+	derpMap := integration.RunDERPAndSTUN(t, logf, "127.0.0.1")
+	control := &testcontrol.Server{
+		DERPMap: derpMap,
+		DNSConfig: &tailcfg.DNSConfig{
+			Proxied: true,
+		},
+		MagicDNSDomain: magicDNSDomain,
+		Logf:           logf,
+	}
+	control.HTTPTestServer = httptest.NewUnstartedServer(control)
+	control.HTTPTestServer.Start()
+	t.Cleanup(control.HTTPTestServer.Close)
+
+	tn := &Tailnet{
+		ControlURL: control.HTTPTestServer.URL,
+		Control:    control,
+		DERPMap:    derpMap,
+		t:          t,
+		dir:        t.TempDir(),
+		nodeLogf:   nodeLogf,
+		grants:     cfg.Grants,
+	}
+	if cfg.Grants != nil {
+		// Deny everything until the nodes' addresses are known.
+		control.SetPacketFilter([]tailcfg.FilterRule{})
+	}
+	for _, name := range cfg.Nodes {
+		tn.AddNode(name)
+	}
+	return tn
+}
+
+// AddNode starts a new ephemeral node with the given hostname, waits for
+// it to be running and returns it. The tailnet's grants are re-evaluated
+// to include the new node.
+func (tn *Tailnet) AddNode(hostname string) *tsnet.Server {
+	tn.t.Helper()
+	tn.mu.Lock()
+	_, dup := tn.nodes[hostname]
+	tn.mu.Unlock()
+	if dup {
+		tn.t.Fatalf("tsnettest: duplicate node %q", hostname)
+	}
+
+	s := &tsnet.Server{
+		Dir:        filepath.Join(tn.dir, hostname),
+		ControlURL: tn.ControlURL,
+		Hostname:   hostname,
+		Store:      new(mem.Store),
+		Ephemeral:  true,
+		Logf:       tn.nodeLogf,
+		UserLogf:   tn.nodeLogf,
+	}
+	tn.t.Cleanup(func() { s.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	st, err := s.Up(ctx)
+	if err != nil {
+		tn.t.Fatalf("tsnettest: starting %q: %v", hostname, err)
+	}
+
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	if tn.nodes == nil {
+		tn.nodes = make(map[string]*tsnet.Server)
+		tn.ips = make(map[string][]netip.Addr)
+	}
+	tn.nodes[hostname] = s
+	tn.ips[hostname] = st.TailscaleIPs
+	if err := tn.applyGrantsLocked(); err != nil {
+		tn.t.Fatal(err)
+	}
+	return s
+}
+
+// Node returns the node with the given hostname, or nil if there is none.
+func (tn *Tailnet) Node(hostname string) *tsnet.Server {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	return tn.nodes[hostname]
+}
+
+// SetGrants replaces the tailnet's access rules. A nil grants allows all
+// traffic between all nodes.
+func (tn *Tailnet) SetGrants(grants []Grant) {
+	tn.t.Helper()
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	tn.grants = grants
+	if err := tn.applyGrantsLocked(); err != nil {
+		tn.t.Fatal(err)
+	}
+}
+
+func (tn *Tailnet) applyGrantsLocked() error {
+	if tn.grants == nil {
+		tn.Control.SetPacketFilter(nil)
+		return nil
+	}
+	rules, err := tn.filterRulesLocked()
+	if err != nil {
+		return err
+	}
+	tn.Control.SetPacketFilter(rules)
+	return nil
+}
+
+// filterRulesLocked converts tn.grants to packet filter rules using the
+// addresses of the currently known nodes. tn.mu must be held.
+func (tn *Tailnet) filterRulesLocked() ([]tailcfg.FilterRule, error) {
+	rules := []tailcfg.FilterRule{}
+	for i, g := range tn.grants {
+		srcs, err := tn.addrsLocked(g.Src)
+		if err != nil {
+			return nil, fmt.Errorf("tsnettest: grant %d: %w", i, err)
+		}
+		dsts, err := tn.addrsLocked(g.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("tsnettest: grant %d: %w", i, err)
+		}
+		if len(srcs) == 0 || len(dsts) == 0 {
+			continue
+		}
+		var srcIPs []string
+		for _, ip := range srcs {
+			srcIPs = append(srcIPs, ip.String())
+		}
+		if len(g.IP) > 0 {
+			pprs, err := tailcfg.ParseProtoPortRanges(g.IP)
+			if err != nil {
+				return nil, fmt.Errorf("tsnettest: grant %d: %w", i, err)
+			}
+			for _, ppr := range pprs {
+				r := tailcfg.FilterRule{SrcIPs: srcIPs}
+				if ppr.Proto != 0 {
+					r.IPProto = []int{ppr.Proto}
+				}
+				for _, ip := range dsts {
+					r.DstPorts = append(r.DstPorts, tailcfg.NetPortRange{
+						IP:    ip.String(),
+						Ports: ppr.Ports,
+					})
+				}
+				rules = append(rules, r)
+			}
+		}
+		if len(g.App) > 0 {
+			cg := tailcfg.CapGrant{CapMap: g.App}
+			for _, ip := range dsts {
+				cg.Dsts = append(cg.Dsts, netip.PrefixFrom(ip, ip.BitLen()))
+			}
+			rules = append(rules, tailcfg.FilterRule{
+				SrcIPs:   srcIPs,
+				CapGrant: []tailcfg.CapGrant{cg},
+			})
+		}
+	}
+	return rules, nil
+}
+
+// addrsLocked returns the Tailscale IPs of the named nodes. Names of nodes
+// that haven't been added yet are ignored so grants can be declared up
+// front. tn.mu must be held.
+func (tn *Tailnet) addrsLocked(names []string) ([]netip.Addr, error) {
+	var ret []netip.Addr
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("empty node name")
+		}
+		if name == "*" {
+			for _, ips := range tn.ips {
+				ret = append(ret, ips...)
+			}
+			continue
+		}
+		ret = append(ret, tn.ips[name]...)
+	}
+	return ret, nil
+}
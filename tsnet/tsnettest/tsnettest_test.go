@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnettest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+)
+
+func TestTailnet(t *testing.T) {
+	tstest.ResourceCheck(t)
+	const capTest = tailcfg.PeerCapability("example.com/cap/test")
+	tn := New(t, Config{
+		Nodes: []string{"client", "server"},
+		Grants: []Grant{
+			{
+				Src: []string{"client"},
+				Dst: []string{"server"},
+				IP:  []string{"tcp:80"},
+				App: tailcfg.PeerCapMap{
+					capTest: {`{"role":"admin"}`},
+				},
+			},
+		},
+		Logf: t.Logf,
+	})
+	client, server := tn.Node("client"), tn.Node("server")
+	if client == nil || server == nil {
+		t.Fatal("missing node")
+	}
+	if tn.Node("nope") != nil {
+		t.Error("Node returned non-nil for unknown host")
+	}
+
+	ln, err := server.Listen("tcp", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln81, err := server.Listen("tcp", ":81")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln81.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(c, "hello")
+			c.Close()
+		}
+	}()
+
+	serverIP, _ := server.TailscaleIPs()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The packet filter reaches the nodes asynchronously, so wait for the
+	// capability grant to show up on the server first.
+	lc, err := server.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientIP, _ := client.TailscaleIPs()
+	if err := tstest.WaitFor(10*time.Second, func() error {
+		who, err := lc.WhoIs(ctx, clientIP.String())
+		if err != nil {
+			return err
+		}
+		got, err := tailcfg.UnmarshalCapJSON[struct{ Role string }](who.CapMap, capTest)
+		if err != nil {
+			return err
+		}
+		if len(got) != 1 || got[0].Role != "admin" {
+			return fmt.Errorf("CapMap[%q] = %+v", capTest, got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.Dial(ctx, "tcp", fmt.Sprintf("%s:80", serverIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q; want hello", got)
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if c, err := client.Dial(shortCtx, "tcp", fmt.Sprintf("%s:81", serverIP)); err == nil {
+		c.Close()
+		t.Error("dial to port 81 succeeded; want it blocked by the grants")
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	tn := &Tailnet{
+		grants: []Grant{
+			{Src: []string{"*"}, Dst: []string{"b"}, IP: []string{"443", "udp:53"}},
+			{Src: []string{"not-yet-added"}, Dst: []string{"*"}, IP: []string{"*"}},
+		},
+	}
+	tn.ips = map[string][]netip.Addr{
+		"a": {netip.MustParseAddr("100.64.0.1")},
+		"b": {netip.MustParseAddr("100.64.0.2")},
+	}
+	rules, err := tn.filterRulesLocked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules; want 2: %+v", len(rules), rules)
+	}
+	if got := rules[0].DstPorts; len(got) != 1 || got[0].IP != "100.64.0.2" || got[0].Ports != (tailcfg.PortRange{First: 443, Last: 443}) {
+		t.Errorf("rule 0 DstPorts = %+v", got)
+	}
+	if len(rules[0].SrcIPs) != 2 {
+		t.Errorf("rule 0 SrcIPs = %v; want both nodes", rules[0].SrcIPs)
+	}
+	if got := rules[1].IPProto; len(got) != 1 || got[0] != 17 {
+		t.Errorf("rule 1 IPProto = %v; want [17]", got)
+	}
+
+	tn.grants = []Grant{{Src: []string{""}}}
+	if _, err := tn.filterRulesLocked(); err == nil {
+		t.Error("empty node name accepted")
+	}
+}
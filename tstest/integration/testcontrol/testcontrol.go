@@ -88,6 +88,10 @@ type Server struct {
 	// nodeCapMaps overrides the capability map sent down to a client.
 	nodeCapMaps map[key.NodePublic]tailcfg.NodeCapMap
 
+	// packetFilter, if non-nil, overrides the default allow-all packet
+	// filter sent to all clients.
+	packetFilter []tailcfg.FilterRule
+
 	// suppressAutoMapResponses is the set of nodes that should not be sent
 	// automatic map responses from serveMap. (They should only get manually sent ones)
 	suppressAutoMapResponses set.Set[key.NodePublic]
@@ -426,6 +430,15 @@ func (s *Server) SetNodeCapMap(nodeKey key.NodePublic, capMap tailcfg.NodeCapMap
 	s.updateLocked("SetNodeCapMap", s.nodeIDsLocked(0))
 }
 
+// SetPacketFilter overrides the packet filter sent to all clients.
+// A nil rules restores the default, which allows all traffic.
+func (s *Server) SetPacketFilter(rules []tailcfg.FilterRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packetFilter = rules
+	s.updateLocked("SetPacketFilter", s.nodeIDsLocked(0))
+}
+
 // nodeIDsLocked returns the node IDs of all nodes in the server, except
 // for the node with the given ID.
 func (s *Server) nodeIDsLocked(except tailcfg.NodeID) []tailcfg.NodeID {
@@ -956,6 +969,7 @@ func (s *Server) MapResponse(req *tailcfg.MapRequest) (res *tailcfg.MapResponse,
 
 	s.mu.Lock()
 	nodeCapMap := maps.Clone(s.nodeCapMaps[nk])
+	packetFilter := s.packetFilter
 	s.mu.Unlock()
 
 	node.CapMap = nodeCapMap
@@ -979,6 +993,15 @@ func (s *Server) MapResponse(req *tailcfg.MapRequest) (res *tailcfg.MapResponse,
 		DNSConfig:       dns,
 		ControlTime:     &t,
 	}
+	if packetFilter != nil {
+		// Use the named packet filters so that an empty (block everything)
+		// filter survives JSON encoding.
+		res.PacketFilter = nil
+		res.PacketFilters = map[string][]tailcfg.FilterRule{
+			"*":    nil,
+			"base": packetFilter,
+		}
+	}
 
 	s.mu.Lock()
 	nodeMasqs := s.masquerades[node.Key]
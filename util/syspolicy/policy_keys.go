@@ -158,9 +158,25 @@
 	// would otherwise obtain from the OS, e.g. by calling os.Hostname().
 	Hostname Key = "Hostname"
 
+	// AuditLogFilePath is the path of the JSON lines file written by the "file"
+	// audit log sink. If blank, a platform-specific default path is used.
+	AuditLogFilePath Key = "AuditLog.FilePath"
+
+	// Keys with an integer value.
+	// AuditLogFileMaxSize is the size in bytes beyond which the audit log file
+	// is rotated. The default is 10 MiB.
+	AuditLogFileMaxSize Key = "AuditLog.FileMaxSize"
+	// AuditLogFileMaxBackups is the number of rotated audit log files to keep.
+	// The default is 5.
+	AuditLogFileMaxBackups Key = "AuditLog.FileMaxBackups"
+
 	// Keys with a string array value.
 	// AllowedSuggestedExitNodes's string array value is a list of exit node IDs that restricts which exit nodes are considered when generating suggestions for exit nodes.
 	AllowedSuggestedExitNodes Key = "AllowedSuggestedExitNodes"
+	// AuditLogLocalSinks's string array value lists the local destinations
+	// that audit logs are written to, in addition to being sent to the control plane.
+	// Valid values are "file", "syslog" and "journald". The default is none.
+	AuditLogLocalSinks Key = "AuditLog.LocalSinks"
 )
 
 // implicitDefinitions is a list of [setting.Definition] that will be registered
@@ -172,6 +188,10 @@
 	setting.NewDefinition(AlwaysOn, setting.DeviceSetting, setting.BooleanValue),
 	setting.NewDefinition(AlwaysOnOverrideWithReason, setting.DeviceSetting, setting.BooleanValue),
 	setting.NewDefinition(ApplyUpdates, setting.DeviceSetting, setting.PreferenceOptionValue),
+	setting.NewDefinition(AuditLogFileMaxBackups, setting.DeviceSetting, setting.IntegerValue),
+	setting.NewDefinition(AuditLogFileMaxSize, setting.DeviceSetting, setting.IntegerValue),
+	setting.NewDefinition(AuditLogFilePath, setting.DeviceSetting, setting.StringValue),
+	setting.NewDefinition(AuditLogLocalSinks, setting.DeviceSetting, setting.StringListValue),
 	setting.NewDefinition(AuthKey, setting.DeviceSetting, setting.StringValue),
 	setting.NewDefinition(CheckUpdates, setting.DeviceSetting, setting.PreferenceOptionValue),
 	setting.NewDefinition(ControlURL, setting.DeviceSetting, setting.StringValue),
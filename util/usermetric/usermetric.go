@@ -91,6 +91,62 @@ func (g *Gauge) WritePrometheus(w io.Writer, name string) {
 	fmt.Fprintf(w, " %v\n", g.m.Value())
 }
 
+// Counter is a counter metric with no labels.
+type Counter struct {
+	m    *expvar.Int
+	help string
+}
+
+// NewCounter creates and register a new counter metric with the given name and help text.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{&expvar.Int{}, help}
+	r.vars.Set(name, c)
+	return c
+}
+
+// Add adds delta to the counter.
+func (c *Counter) Add(delta int64) {
+	if c == nil {
+		return
+	}
+	c.m.Add(delta)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.m.Value()
+}
+
+// String returns the string of the underlying expvar.Int.
+// This satisfies the expvar.Var interface.
+func (c *Counter) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.m.String()
+}
+
+// WritePrometheus writes the counter metric in Prometheus format to the given writer.
+// This satisfies the varz.PrometheusWriter interface.
+func (c *Counter) WritePrometheus(w io.Writer, name string) {
+	io.WriteString(w, "# TYPE ")
+	io.WriteString(w, name)
+	io.WriteString(w, " counter\n")
+	if c.help != "" {
+		io.WriteString(w, "# HELP ")
+		io.WriteString(w, name)
+		io.WriteString(w, " ")
+		io.WriteString(w, c.help)
+		io.WriteString(w, "\n")
+	}
+
+	io.WriteString(w, name)
+	fmt.Fprintf(w, " %v\n", c.m.Value())
+}
+
 // Handler returns a varz.Handler that serves the userfacing expvar contained
 // in this package.
 func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
@@ -24,3 +24,20 @@ func TestGauge(t *testing.T) {
 	}
 
 }
+
+func TestCounter(t *testing.T) {
+	var reg Registry
+	c := reg.NewCounter("test_counter_total", "This is a test counter")
+	c.Add(3)
+	c.Add(4)
+
+	var buf bytes.Buffer
+	c.WritePrometheus(&buf, "test_counter_total")
+	const want = `# TYPE test_counter_total counter
+# HELP test_counter_total This is a test counter
+test_counter_total 7
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/packet"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/util/clientmetric"
+)
+
+var (
+	// The size of the connection tracking table, and the idle timeouts of
+	// its flows by protocol. A zero timeout means flows only leave the
+	// table when evicted to make room for new ones.
+	connTrackMaxEntries = envknob.RegisterInt("TS_FILTER_CONNTRACK_MAX")
+	icmpMaxEntries      = envknob.RegisterInt("TS_FILTER_CONNTRACK_ICMP_MAX")
+	udpIdleTimeout      = envknob.RegisterDuration("TS_FILTER_UDP_TIMEOUT")
+	sctpIdleTimeout     = envknob.RegisterDuration("TS_FILTER_SCTP_TIMEOUT")
+	icmpIdleTimeout     = envknob.RegisterDuration("TS_FILTER_ICMP_TIMEOUT")
+
+	// strictICMP, if set, drops incoming ICMP Echo replies that don't
+	// answer an Echo request this node sent.
+	strictICMP = envknob.RegisterBool("TS_FILTER_STRICT_ICMP")
+)
+
+const (
+	// lruMax is the default size of the LRU cache in filterState.
+	lruMax = 512
+
+	// icmpLRUMax is the default size of the LRU cache of ICMP Echo
+	// flows in filterState.
+	icmpLRUMax = 512
+
+	// defaultICMPTimeout is the default idle timeout of ICMP Echo flows.
+	defaultICMPTimeout = 30 * time.Second
+)
+
+var (
+	metricConnTrackEvicted = clientmetric.NewCounter("filter_conntrack_evicted")
+	metricConnTrackExpired = clientmetric.NewCounter("filter_conntrack_expired")
+)
+
+// connTrackConfig is the configuration of a filterState.
+type connTrackConfig struct {
+	maxEntries     int
+	icmpMaxEntries int
+	udpTimeout     time.Duration
+	sctpTimeout    time.Duration
+	icmpTimeout    time.Duration
+	strictICMP     bool
+}
+
+// defaultConnTrackConfig returns the connection tracking configuration from
+// the environment.
+func defaultConnTrackConfig() connTrackConfig {
+	c := connTrackConfig{
+		maxEntries:     connTrackMaxEntries(),
+		icmpMaxEntries: icmpMaxEntries(),
+		udpTimeout:     udpIdleTimeout(),
+		sctpTimeout:    sctpIdleTimeout(),
+		icmpTimeout:    icmpIdleTimeout(),
+		strictICMP:     strictICMP(),
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = lruMax
+	}
+	if c.icmpMaxEntries <= 0 {
+		c.icmpMaxEntries = icmpLRUMax
+	}
+	if c.icmpTimeout <= 0 {
+		c.icmpTimeout = defaultICMPTimeout
+	}
+	return c
+}
+
+// timeout returns the idle timeout of flows of protocol proto, or zero if
+// they don't time out.
+func (c *connTrackConfig) timeout(proto ipproto.Proto) time.Duration {
+	switch proto {
+	case ipproto.UDP:
+		return c.udpTimeout
+	case ipproto.SCTP:
+		return c.sctpTimeout
+	case ipproto.ICMPv4, ipproto.ICMPv6:
+		return c.icmpTimeout
+	}
+	return 0
+}
+
+// filterState is a state cache of past seen packets.
+//
+// It tracks the UDP and SCTP flows and ICMP Echo requests this node
+// initiates, keyed by the tuple of the packets expected in return, so that
+// those are let in. ICMP Echo requests are tracked in a table of their own,
+// so that pings don't evict the UDP and SCTP flows.
+type filterState struct {
+	cfg connTrackConfig
+	now func() mono.Time
+
+	mu   sync.Mutex
+	lru  *flowtrack.Cache[mono.Time] // from flowtrack.Tuple -> time of the flow's last packet
+	icmp *flowtrack.Cache[mono.Time] // like lru, for ICMP Echo flows
+
+	// evicted and expired are the number of flows that left the table
+	// while still active, to make room for others, and because they were
+	// idle for longer than their timeout.
+	evicted, expired uint64
+}
+
+func newFilterState(cfg connTrackConfig) *filterState {
+	s := &filterState{cfg: cfg, now: mono.Now}
+	s.lru = &flowtrack.Cache[mono.Time]{
+		MaxEntries: cfg.maxEntries,
+		OnEvicted:  s.onEvictedLocked,
+	}
+	s.icmp = &flowtrack.Cache[mono.Time]{
+		MaxEntries: cfg.icmpMaxEntries,
+		OnEvicted:  s.onEvictedLocked,
+	}
+	return s
+}
+
+// cacheLocked returns the table of flows of protocol proto.
+func (s *filterState) cacheLocked(proto ipproto.Proto) *flowtrack.Cache[mono.Time] {
+	if proto == ipproto.ICMPv4 || proto == ipproto.ICMPv6 {
+		return s.icmp
+	}
+	return s.lru
+}
+
+// isExpired reports whether the flow t, whose last packet was at last, has
+// been idle for longer than its timeout at now.
+func (s *filterState) isExpired(t flowtrack.Tuple, last, now mono.Time) bool {
+	timeout := s.cfg.timeout(t.Proto())
+	return timeout > 0 && now.Sub(last) > timeout
+}
+
+func (s *filterState) onEvictedLocked(t flowtrack.Tuple, last mono.Time) {
+	if s.isExpired(t, last, s.now()) {
+		s.expired++
+		metricConnTrackExpired.Add(1)
+		return
+	}
+	s.evicted++
+	metricConnTrackEvicted.Add(1)
+}
+
+// track adds the flow t to the table, or refreshes it.
+func (s *filterState) track(t flowtrack.Tuple) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cacheLocked(t.Proto()).Add(t, now)
+}
+
+// lookup reports whether t is a flow in the table that has not expired,
+// refreshing it if so.
+func (s *filterState) lookup(t flowtrack.Tuple) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.cacheLocked(t.Proto())
+	last, ok := c.Get(t)
+	if !ok {
+		return false
+	}
+	if s.isExpired(t, *last, now) {
+		c.Remove(t)
+		s.expired++
+		metricConnTrackExpired.Add(1)
+		return false
+	}
+	*last = now
+	return true
+}
+
+// icmpEchoTuple returns the tuple of the ICMP Echo reply to q, if q is an
+// outgoing Echo request, or of q itself, if q is an incoming Echo reply. The
+// Echo identifier stands in for the port of this node.
+func icmpEchoTuple(q *packet.Parsed, dir direction) flowtrack.Tuple {
+	if dir == out {
+		return flowtrack.MakeTuple(q.IPProto,
+			netip.AddrPortFrom(q.Dst.Addr(), 0),
+			netip.AddrPortFrom(q.Src.Addr(), q.EchoID()))
+	}
+	return flowtrack.MakeTuple(q.IPProto,
+		netip.AddrPortFrom(q.Src.Addr(), 0),
+		netip.AddrPortFrom(q.Dst.Addr(), q.EchoID()))
+}
+
+// ConnTrackEntry is a flow in a Filter's connection tracking table.
+type ConnTrackEntry struct {
+	Proto ipproto.Proto
+
+	// Src and Dst are the source and destination of the packets the
+	// flow lets in: the remote end and this node. For ICMP, Src's port is
+	// zero and Dst's is the Echo identifier.
+	Src, Dst netip.AddrPort
+
+	// Idle is the time since the flow's last packet.
+	Idle time.Duration
+
+	// Timeout is how long the flow may be idle before it expires, or
+	// zero if it only leaves the table when evicted.
+	Timeout time.Duration
+}
+
+// ConnTrack is a snapshot of a Filter's connection tracking table.
+type ConnTrack struct {
+	// Entries are the flows in the table, the UDP and SCTP flows and then
+	// the ICMP Echo flows, each from the most to the least recently
+	// active. Expired flows are omitted.
+	Entries []ConnTrackEntry
+
+	// MaxEntries is the size of the table of UDP and SCTP flows, and
+	// MaxICMPEntries that of ICMP Echo flows. When one is full, its least
+	// recently active flow is evicted to make room for a new one.
+	MaxEntries     int
+	MaxICMPEntries int
+
+	// Evicted is the number of flows evicted while still active, and
+	// Expired the number that expired, over the life of the table.
+	// The table is shared by filters created with shareStateWith.
+	Evicted, Expired uint64
+}
+
+// ConnTrack returns a snapshot of f's connection tracking table.
+func (f *Filter) ConnTrack() ConnTrack {
+	s := f.state
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ct := ConnTrack{
+		Entries:        make([]ConnTrackEntry, 0, s.lru.Len()+s.icmp.Len()),
+		MaxEntries:     s.cfg.maxEntries,
+		MaxICMPEntries: s.cfg.icmpMaxEntries,
+		Evicted:        s.evicted,
+		Expired:        s.expired,
+	}
+	for _, c := range []*flowtrack.Cache[mono.Time]{s.lru, s.icmp} {
+		c.ForEach(func(t flowtrack.Tuple, last mono.Time) {
+			if s.isExpired(t, last, now) {
+				return
+			}
+			ct.Entries = append(ct.Entries, ConnTrackEntry{
+				Proto:   t.Proto(),
+				Src:     netip.AddrPortFrom(t.SrcAddr(), t.SrcPort()),
+				Dst:     netip.AddrPortFrom(t.DstAddr(), t.DstPort()),
+				Idle:    now.Sub(last),
+				Timeout: s.cfg.timeout(t.Proto()),
+			})
+		})
+	}
+	return ct
+}
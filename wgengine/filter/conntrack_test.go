@@ -0,0 +1,156 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"go4.org/netipx"
+	"tailscale.com/net/packet"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/logger"
+)
+
+// newConnTrackFilter returns a filter for 102.102.102.102 with no rules and
+// a connection tracking table with the given config, and a func to advance
+// its clock.
+func newConnTrackFilter(t *testing.T, cfg connTrackConfig) (_ *Filter, advance func(time.Duration)) {
+	var localNets netipx.IPSetBuilder
+	localNets.Add(netip.MustParseAddr("102.102.102.102"))
+	localNetsSet, err := localNets.IPSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(nil, nil, localNetsSet, nil, nil, logger.Discard)
+	f.state = newFilterState(cfg)
+	now := mono.Now()
+	f.state.now = func() mono.Time { return now }
+	return f, func(d time.Duration) { now = now.Add(d) }
+}
+
+func icmpEcho(src, dst string, typ packet.ICMP4Type, id uint16) *packet.Parsed {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload, id)
+	b := packet.Generate(packet.ICMP4Header{
+		IP4Header: packet.IP4Header{Src: mustIP(src), Dst: mustIP(dst)},
+		Type:      typ,
+	}, payload)
+	q := new(packet.Parsed)
+	q.Decode(b)
+	return q
+}
+
+func TestConnTrackUDPTimeout(t *testing.T) {
+	f, advance := newConnTrackFilter(t, connTrackConfig{maxEntries: 10, udpTimeout: time.Minute})
+
+	in := parsed(ipproto.UDP, "119.119.119.119", "102.102.102.102", 4242, 4343)
+	out := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", 4343, 4242)
+	f.runOut(&out)
+
+	// Incoming packets keep the flow alive.
+	for range 3 {
+		advance(50 * time.Second)
+		if got, why, _ := f.runIn4(&in); got != Accept {
+			t.Fatalf("runIn within timeout = %v, %q; want Accept", got, why)
+		}
+	}
+	ct := f.ConnTrack()
+	if len(ct.Entries) != 1 {
+		t.Fatalf("ConnTrack entries = %+v; want 1", ct.Entries)
+	}
+	e := ct.Entries[0]
+	if e.Proto != ipproto.UDP || e.Src != in.Src || e.Dst != in.Dst || e.Timeout != time.Minute {
+		t.Errorf("ConnTrack entry = %+v; want UDP %v => %v with 1m timeout", e, in.Src, in.Dst)
+	}
+
+	advance(2 * time.Minute)
+	if got := f.ConnTrack(); len(got.Entries) != 0 {
+		t.Errorf("ConnTrack entries after timeout = %+v; want none", got.Entries)
+	}
+	if got, why, _ := f.runIn4(&in); got != Drop {
+		t.Fatalf("runIn after timeout = %v, %q; want Drop", got, why)
+	}
+	if ct := f.ConnTrack(); ct.Expired != 1 || ct.Evicted != 0 {
+		t.Errorf("Expired, Evicted = %d, %d; want 1, 0", ct.Expired, ct.Evicted)
+	}
+}
+
+func TestConnTrackEvictions(t *testing.T) {
+	f, advance := newConnTrackFilter(t, connTrackConfig{maxEntries: 2, udpTimeout: time.Minute})
+
+	for _, port := range []uint16{1, 2, 3} {
+		out := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", port, 53)
+		f.runOut(&out)
+	}
+	if ct := f.ConnTrack(); ct.Evicted != 1 || ct.Expired != 0 || len(ct.Entries) != 2 {
+		t.Errorf("after overflow: Evicted, Expired, entries = %d, %d, %d; want 1, 0, 2", ct.Evicted, ct.Expired, len(ct.Entries))
+	}
+
+	// Flows evicted after their timeout count as expired.
+	advance(2 * time.Minute)
+	out := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", 4, 53)
+	f.runOut(&out)
+	if ct := f.ConnTrack(); ct.Evicted != 1 || ct.Expired != 1 {
+		t.Errorf("after expiry: Evicted, Expired = %d, %d; want 1, 1", ct.Evicted, ct.Expired)
+	}
+}
+
+func TestConnTrackICMPEcho(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		f, advance := newConnTrackFilter(t, connTrackConfig{maxEntries: 10, icmpTimeout: 10 * time.Second, strictICMP: strict})
+
+		req := icmpEcho("102.102.102.102", "119.119.119.119", packet.ICMP4EchoRequest, 1234)
+		reply := icmpEcho("119.119.119.119", "102.102.102.102", packet.ICMP4EchoReply, 1234)
+		otherReply := icmpEcho("119.119.119.119", "102.102.102.102", packet.ICMP4EchoReply, 5678)
+
+		untracked := Accept
+		if strict {
+			untracked = Drop
+		}
+		if got, why, _ := f.runIn4(reply); got != untracked {
+			t.Errorf("strict=%v: reply before request = %v, %q; want %v", strict, got, why, untracked)
+		}
+		f.runOut(req)
+		if got, why, _ := f.runIn4(reply); got != Accept || why != "icmp echo reply ok" {
+			t.Errorf("strict=%v: reply to request = %v, %q; want Accept, %q", strict, got, why, "icmp echo reply ok")
+		}
+		if got, why, _ := f.runIn4(otherReply); got != untracked {
+			t.Errorf("strict=%v: reply with other ID = %v, %q; want %v", strict, got, why, untracked)
+		}
+		advance(time.Minute)
+		if got, why, _ := f.runIn4(reply); got != untracked {
+			t.Errorf("strict=%v: reply after timeout = %v, %q; want %v", strict, got, why, untracked)
+		}
+	}
+}
+
+func TestConnTrackICMPSeparateTable(t *testing.T) {
+	f, _ := newConnTrackFilter(t, connTrackConfig{maxEntries: 2, icmpMaxEntries: 2, icmpTimeout: time.Minute, strictICMP: true})
+
+	udpIn := parsed(ipproto.UDP, "119.119.119.119", "102.102.102.102", 53, 4343)
+	udpOut := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", 4343, 53)
+	f.runOut(&udpOut)
+
+	// A burst of pings fills the ICMP table, not the UDP one.
+	for id := range uint16(10) {
+		f.runOut(icmpEcho("102.102.102.102", "119.119.119.119", packet.ICMP4EchoRequest, id))
+	}
+	if got, why, _ := f.runIn4(&udpIn); got != Accept {
+		t.Errorf("UDP reply after pings = %v, %q; want Accept", got, why)
+	}
+	if got, why, _ := f.runIn4(icmpEcho("119.119.119.119", "102.102.102.102", packet.ICMP4EchoReply, 9)); got != Accept {
+		t.Errorf("reply to the latest ping = %v, %q; want Accept", got, why)
+	}
+	if got, _, _ := f.runIn4(icmpEcho("119.119.119.119", "102.102.102.102", packet.ICMP4EchoReply, 0)); got != Drop {
+		t.Errorf("reply to an evicted ping = %v; want Drop", got)
+	}
+	ct := f.ConnTrack()
+	if len(ct.Entries) != 3 || ct.MaxEntries != 2 || ct.MaxICMPEntries != 2 || ct.Evicted != 8 {
+		t.Errorf("ConnTrack = %d entries of %d and %d, %d evicted; want 3 of 2 and 2, 8 evicted", len(ct.Entries), ct.MaxEntries, ct.MaxICMPEntries, ct.Evicted)
+	}
+}
@@ -55,6 +55,11 @@ type Filter struct {
 	matches4 matches
 	matches6 matches
 
+	// rules are the matches the filter was created with, and rules4 and
+	// rules6 the index in rules of each of matches4 and matches6.
+	rules          matches
+	rules4, rules6 []int
+
 	// cap4 and cap6 are the subsets of the matches that are about
 	// capability grants, partitioned by source IP address family.
 	cap4, cap6 matches
@@ -65,18 +70,12 @@ type Filter struct {
 	// incoming packets don't get accepted by matches above.
 	state *filterState
 
-	shieldsUp bool
-}
+	// stats are the counters of the filter's verdicts.
+	stats *filterStats
 
-// filterState is a state cache of past seen packets.
-type filterState struct {
-	mu  sync.Mutex
-	lru *flowtrack.Cache[struct{}] // from flowtrack.Tuple -> struct{}
+	shieldsUp bool
 }
 
-// lruMax is the size of the LRU cache in filterState.
-const lruMax = 512
-
 // Response is a verdict from the packet filter.
 type Response int
 
@@ -197,15 +196,18 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 	if shareStateWith != nil {
 		state = shareStateWith.state
 	} else {
-		state = &filterState{
-			lru: &flowtrack.Cache[struct{}]{MaxEntries: lruMax},
-		}
+		state = newFilterState(defaultConnTrackConfig())
 	}
 
+	matches4, rules4 := matchesFamily(matches, netip.Addr.Is4)
+	matches6, rules6 := matchesFamily(matches, netip.Addr.Is6)
 	f := &Filter{
 		logf:        logf,
-		matches4:    matchesFamily(matches, netip.Addr.Is4),
-		matches6:    matchesFamily(matches, netip.Addr.Is6),
+		matches4:    matches4,
+		matches6:    matches6,
+		rules:       matches,
+		rules4:      rules4,
+		rules6:      rules6,
 		cap4:        capMatchesFunc(matches, netip.Addr.Is4),
 		cap6:        capMatchesFunc(matches, netip.Addr.Is6),
 		local4:      ipset.FalseContainsIPFunc(),
@@ -213,6 +215,7 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 		logIPs4:     ipset.FalseContainsIPFunc(),
 		logIPs6:     ipset.FalseContainsIPFunc(),
 		state:       state,
+		stats:       newFilterStats(len(matches)),
 		srcIPHasCap: capTest,
 	}
 	if localNets != nil {
@@ -232,10 +235,10 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 }
 
 // matchesFamily returns the subset of ms for which keep(srcNet.IP)
-// and keep(dstNet.IP) are both true.
-func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
+// and keep(dstNet.IP) are both true, and the index in ms of each of them.
+func matchesFamily(ms matches, keep func(netip.Addr) bool) (_ matches, idx []int) {
 	var ret matches
-	for _, m := range ms {
+	for i, m := range ms {
 		var retm Match
 		retm.IPProto = m.IPProto
 		retm.SrcCaps = m.SrcCaps
@@ -253,9 +256,10 @@ func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
 		if (len(retm.Srcs) > 0 || len(retm.SrcCaps) > 0) && len(retm.Dsts) > 0 {
 			retm.SrcsContains = ipset.NewContainsIPFunc(views.SliceOf(retm.Srcs))
 			ret = append(ret, retm)
+			idx = append(idx, i)
 		}
 	}
-	return ret
+	return ret, idx
 }
 
 // capMatchesFunc returns a copy of the subset of ms for which keep(srcNet.IP)
@@ -344,28 +348,7 @@ func (f *Filter) logRateLimit(runflags RunFlags, q *packet.Parsed, dir direction
 // Check determines whether traffic from srcIP to dstIP:dstPort is allowed
 // using protocol proto.
 func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) Response {
-	pkt := &packet.Parsed{}
-	pkt.Decode(dummyPacket) // initialize private fields
-	switch {
-	case (srcIP.Is4() && dstIP.Is6()) || (srcIP.Is6() && srcIP.Is4()):
-		// Mismatched address families, no filters will
-		// match.
-		return Drop
-	case srcIP.Is4():
-		pkt.IPVersion = 4
-	case srcIP.Is6():
-		pkt.IPVersion = 6
-	default:
-		panic("unreachable")
-	}
-	pkt.Src = netip.AddrPortFrom(srcIP, 0)
-	pkt.Dst = netip.AddrPortFrom(dstIP, dstPort)
-	pkt.IPProto = proto
-	if proto == ipproto.TCP {
-		pkt.TCPFlags = packet.TCPSyn
-	}
-
-	return f.RunIn(pkt, 0)
+	return f.Explain(srcIP, dstIP, dstPort, proto).Verdict
 }
 
 // CheckTCP determines whether TCP traffic from srcIP to dstIP:dstPort
@@ -411,23 +394,40 @@ func (f *Filter) ShieldsUp() bool { return f.shieldsUp }
 // Tailscale peer.
 func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
 	dir := in
-	r, _ := f.pre(q, rf, dir)
+	r, reason := f.pre(q, rf, dir)
 	if r == Accept || r == Drop {
 		// already logged
+		if r == Drop {
+			f.stats.drop(string(reason))
+		}
 		return r
 	}
 
-	var why string
+	r, why, rule := f.runIn(q)
+	f.stats.record(r, why, rule)
+	f.logRateLimit(rf, q, dir, r, why)
+	return r
+}
+
+// runIn runs the input-specific part of the filter logic. rule is the
+// index in f.rules of the match that accepted q, or -1 if there is none.
+func (f *Filter) runIn(q *packet.Parsed) (r Response, why string, rule int) {
+	var mi int
 	switch q.IPVersion {
 	case 4:
-		r, why = f.runIn4(q)
+		r, why, mi = f.runIn4(q)
+		if mi >= 0 {
+			return r, why, f.rules4[mi]
+		}
 	case 6:
-		r, why = f.runIn6(q)
+		r, why, mi = f.runIn6(q)
+		if mi >= 0 {
+			return r, why, f.rules6[mi]
+		}
 	default:
 		r, why = Drop, "not-ip"
 	}
-	f.logRateLimit(rf, q, dir, r, why)
-	return r
+	return r, why, -1
 }
 
 // RunOut determines whether this node is allowed to send q to a
@@ -455,26 +455,32 @@ func unknownProtoString(proto ipproto.Proto) string {
 	return s
 }
 
-func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
+// runIn4 is runIn for IPv4 packets. mi is the index in f.matches4 of
+// the match that accepted q, or -1 if there is none.
+func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string, mi int) {
 	// A compromised peer could try to send us packets for
 	// destinations we didn't explicitly advertise. This check is to
 	// prevent that.
 	if !f.local4(q.Dst.Addr()) {
-		return Drop, "destination not allowed"
+		return Drop, "destination not allowed", -1
 	}
 
 	switch q.IPProto {
 	case ipproto.ICMPv4:
-		if q.IsEchoResponse() || q.IsError() {
-			// ICMP responses are allowed.
+		if q.IsEchoResponse() && f.state.lookup(icmpEchoTuple(q, in)) {
+			return Accept, "icmp echo reply ok", -1
+		}
+		if q.IsError() || (q.IsEchoResponse() && !f.state.cfg.strictICMP) {
+			// ICMP errors are allowed, and so are Echo replies to
+			// untracked requests unless strictICMP is set.
 			// TODO(apenwarr): consider using conntrack state.
 			//  We could choose to reject all packets that aren't
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
-			return Accept, "icmp response ok"
-		} else if f.matches4.matchIPsOnly(q, f.srcIPHasCap) {
+			return Accept, "icmp response ok", -1
+		} else if mi := f.matches4.matchIPsOnly(q, f.srcIPHasCap); mi >= 0 {
 			// If any port is open to an IP, allow ICMP to it.
-			return Accept, "icmp ok"
+			return Accept, "icmp ok", mi
 		}
 	case ipproto.TCP:
 		// For TCP, we want to allow *outgoing* connections,
@@ -486,55 +492,55 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		// It happens to also be much faster.
 		// TODO(apenwarr): Skip the rest of decoding in this path?
 		if !q.IsTCPSyn() {
-			return Accept, "tcp non-syn"
+			return Accept, "tcp non-syn", -1
 		}
-		if f.matches4.match(q, f.srcIPHasCap) {
-			return Accept, "tcp ok"
+		if mi := f.matches4.match(q, f.srcIPHasCap); mi >= 0 {
+			return Accept, "tcp ok", mi
 		}
 	case ipproto.UDP, ipproto.SCTP:
-		t := flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)
-
-		f.state.mu.Lock()
-		_, ok := f.state.lru.Get(t)
-		f.state.mu.Unlock()
-
-		if ok {
-			return Accept, "cached"
+		if f.state.lookup(flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)) {
+			return Accept, "cached", -1
 		}
-		if f.matches4.match(q, f.srcIPHasCap) {
-			return Accept, "ok"
+		if mi := f.matches4.match(q, f.srcIPHasCap); mi >= 0 {
+			return Accept, "ok", mi
 		}
 	case ipproto.TSMP:
-		return Accept, "tsmp ok"
+		return Accept, "tsmp ok", -1
 	default:
-		if f.matches4.matchProtoAndIPsOnlyIfAllPorts(q) {
-			return Accept, "other-portless ok"
+		if mi := f.matches4.matchProtoAndIPsOnlyIfAllPorts(q); mi >= 0 {
+			return Accept, "other-portless ok", mi
 		}
-		return Drop, unknownProtoString(q.IPProto)
+		return Drop, unknownProtoString(q.IPProto), -1
 	}
-	return Drop, "no rules matched"
+	return Drop, "no rules matched", -1
 }
 
-func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
+// runIn6 is runIn for IPv6 packets. mi is the index in f.matches6 of
+// the match that accepted q, or -1 if there is none.
+func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string, mi int) {
 	// A compromised peer could try to send us packets for
 	// destinations we didn't explicitly advertise. This check is to
 	// prevent that.
 	if !f.local6(q.Dst.Addr()) {
-		return Drop, "destination not allowed"
+		return Drop, "destination not allowed", -1
 	}
 
 	switch q.IPProto {
 	case ipproto.ICMPv6:
-		if q.IsEchoResponse() || q.IsError() {
-			// ICMP responses are allowed.
+		if q.IsEchoResponse() && f.state.lookup(icmpEchoTuple(q, in)) {
+			return Accept, "icmp echo reply ok", -1
+		}
+		if q.IsError() || (q.IsEchoResponse() && !f.state.cfg.strictICMP) {
+			// ICMP errors are allowed, and so are Echo replies to
+			// untracked requests unless strictICMP is set.
 			// TODO(apenwarr): consider using conntrack state.
 			//  We could choose to reject all packets that aren't
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
-			return Accept, "icmp response ok"
-		} else if f.matches6.matchIPsOnly(q, f.srcIPHasCap) {
+			return Accept, "icmp response ok", -1
+		} else if mi := f.matches6.matchIPsOnly(q, f.srcIPHasCap); mi >= 0 {
 			// If any port is open to an IP, allow ICMP to it.
-			return Accept, "icmp ok"
+			return Accept, "icmp ok", mi
 		}
 	case ipproto.TCP:
 		// For TCP, we want to allow *outgoing* connections,
@@ -546,43 +552,38 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		// It happens to also be much faster.
 		// TODO(apenwarr): Skip the rest of decoding in this path?
 		if q.IPProto == ipproto.TCP && !q.IsTCPSyn() {
-			return Accept, "tcp non-syn"
+			return Accept, "tcp non-syn", -1
 		}
-		if f.matches6.match(q, f.srcIPHasCap) {
-			return Accept, "tcp ok"
+		if mi := f.matches6.match(q, f.srcIPHasCap); mi >= 0 {
+			return Accept, "tcp ok", mi
 		}
 	case ipproto.UDP, ipproto.SCTP:
-		t := flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)
-
-		f.state.mu.Lock()
-		_, ok := f.state.lru.Get(t)
-		f.state.mu.Unlock()
-
-		if ok {
-			return Accept, "cached"
+		if f.state.lookup(flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)) {
+			return Accept, "cached", -1
 		}
-		if f.matches6.match(q, f.srcIPHasCap) {
-			return Accept, "ok"
+		if mi := f.matches6.match(q, f.srcIPHasCap); mi >= 0 {
+			return Accept, "ok", mi
 		}
 	case ipproto.TSMP:
-		return Accept, "tsmp ok"
+		return Accept, "tsmp ok", -1
 	default:
-		if f.matches6.matchProtoAndIPsOnlyIfAllPorts(q) {
-			return Accept, "other-portless ok"
+		if mi := f.matches6.matchProtoAndIPsOnlyIfAllPorts(q); mi >= 0 {
+			return Accept, "other-portless ok", mi
 		}
-		return Drop, unknownProtoString(q.IPProto)
+		return Drop, unknownProtoString(q.IPProto), -1
 	}
-	return Drop, "no rules matched"
+	return Drop, "no rules matched", -1
 }
 
-// runIn runs the output-specific part of the filter logic.
+// runOut runs the output-specific part of the filter logic.
 func (f *Filter) runOut(q *packet.Parsed) (r Response, why string) {
 	switch q.IPProto {
 	case ipproto.UDP, ipproto.SCTP:
-		tuple := flowtrack.MakeTuple(q.IPProto, q.Dst, q.Src) // src/dst reversed
-		f.state.mu.Lock()
-		f.state.lru.Add(tuple, struct{}{})
-		f.state.mu.Unlock()
+		f.state.track(flowtrack.MakeTuple(q.IPProto, q.Dst, q.Src)) // src/dst reversed
+	case ipproto.ICMPv4, ipproto.ICMPv6:
+		if q.IsEchoRequest() {
+			f.state.track(icmpEchoTuple(q, out))
+		}
 	}
 	return Accept, "ok out"
 }
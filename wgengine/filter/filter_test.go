@@ -8,6 +8,7 @@
 	"encoding/json"
 	"flag"
 	"fmt"
+	"maps"
 	"net/netip"
 	"os"
 	"slices"
@@ -175,7 +176,7 @@ type InOut struct {
 		if test.p.IPVersion == 6 {
 			aclFunc = filt.runIn6
 		}
-		if got, why := aclFunc(&test.p); test.want != got {
+		if got, why, _ := aclFunc(&test.p); test.want != got {
 			t.Errorf("#%d runIn got=%v want=%v why=%q packet:%v", i, got, test.want, why, test.p)
 			continue
 		}
@@ -191,7 +192,7 @@ type InOut struct {
 			}
 			// TCP and UDP are treated equivalently in the filter - verify that.
 			test.p.IPProto = ipproto.UDP
-			if got, why := aclFunc(&test.p); test.want != got {
+			if got, why, _ := aclFunc(&test.p); test.want != got {
 				t.Errorf("#%d runIn (UDP) got=%v want=%v why=%q packet:%v", i, got, test.want, why, test.p)
 			}
 		}
@@ -237,6 +238,61 @@ func TestUDPState(t *testing.T) {
 	}
 }
 
+func TestStatsAndExplain(t *testing.T) {
+	filt := newFilter(t.Logf)
+
+	tests := []struct {
+		src, dst  string
+		port      uint16
+		proto     ipproto.Proto
+		want      Response
+		wantWhy   string
+		wantRule  int
+		wantMatch string
+	}{
+		{"8.1.1.1", "1.2.3.4", 22, ipproto.TCP, Accept, "tcp ok", 0, "{[6 17 1 58]}[8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]"},
+		{"9.1.1.1", "5.6.7.8", 23, ipproto.SCTP, Accept, "ok", 1, ""},
+		{"::1", "2001::2", 22, ipproto.TCP, Accept, "tcp ok", 7, ""},
+		{"2.2.2.2", "8.1.1.1", 22, ipproto.ICMPv4, Accept, "icmp ok", 3, ""},
+		{"8.1.1.1", "1.2.3.4", 80, ipproto.TCP, Drop, "no rules matched", -1, ""},
+		{"8.1.1.1", "9.9.9.9", 22, ipproto.TCP, Drop, "destination not allowed", -1, ""},
+		{"8.1.1.1", "2001::1", 22, ipproto.TCP, Drop, "address family mismatch", -1, ""},
+	}
+	for _, tt := range tests {
+		e := filt.Explain(mustIP(tt.src), mustIP(tt.dst), tt.port, tt.proto)
+		if e.Verdict != tt.want || e.Reason != tt.wantWhy || e.Rule != tt.wantRule {
+			t.Errorf("Explain(%s, %s:%d, %v) = %v, %q, rule %d; want %v, %q, rule %d", tt.src, tt.dst, tt.port, tt.proto, e.Verdict, e.Reason, e.Rule, tt.want, tt.wantWhy, tt.wantRule)
+		}
+		if tt.wantMatch != "" && e.Match.String() != tt.wantMatch {
+			t.Errorf("Explain(%s, %s:%d, %v) match = %v; want %v", tt.src, tt.dst, tt.port, tt.proto, e.Match, tt.wantMatch)
+		}
+	}
+
+	// Explain and Check don't count towards the stats, but RunIn does.
+	filt.CheckTCP(mustIP("8.1.1.1"), mustIP("1.2.3.4"), 22)
+	for _, p := range []packet.Parsed{
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 1234, 22),
+		parsed(ipproto.TCP, "8.2.2.2", "5.6.7.8", 1234, 24),
+		parsed(ipproto.TCP, "::2", "2001::1", 1234, 22),
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 1234, 80),
+	} {
+		filt.RunIn(&p, 0)
+	}
+	rules, drops := filt.Stats()
+	if len(rules) != 12 {
+		t.Fatalf("got stats for %d rules; want 12", len(rules))
+	}
+	for i, r := range rules {
+		want := map[int]uint64{0: 2, 7: 1}[i]
+		if r.Hits != want {
+			t.Errorf("rule %d (%v) hits = %d; want %d", i, r.Match, r.Hits, want)
+		}
+	}
+	if want := map[string]uint64{"no rules matched": 1}; !maps.Equal(drops, want) {
+		t.Errorf("drops = %v; want %v", drops, want)
+	}
+}
+
 func TestNoAllocs(t *testing.T) {
 	acl := newFilter(t.Logf)
 
@@ -920,7 +976,7 @@ func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			matches := matches{tt.m}
-			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p)
+			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p) >= 0
 			if got != tt.want {
 				t.Errorf("got = %v; want %v", got, tt.want)
 			}
@@ -1121,9 +1177,7 @@ func benchmarkFile(b *testing.B, file string, opt benchOpt) {
 			netip.AddrPortFrom(srcIP, sport),
 			netip.AddrPortFrom(dstIP, dport),
 		)
-		f.state.mu.Lock()
-		f.state.lru.Add(tuple, struct{}{})
-		f.state.mu.Unlock()
+		f.state.track(tuple)
 	}
 
 	want := Drop
@@ -14,7 +14,9 @@
 
 type matches []filtertype.Match
 
-func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) bool {
+// match returns the index in ms of the first Match that matches q, or -1 if
+// none does.
+func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) int {
 	for i := range ms {
 		m := &ms[i]
 		if !views.SliceContains(m.IPProto, q.IPProto) {
@@ -30,10 +32,10 @@ func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) bool {
 			if !dst.Ports.Contains(q.Dst.Port()) {
 				continue
 			}
-			return true
+			return i
 		}
 	}
-	return false
+	return -1
 }
 
 // srcMatches reports whether srcAddr matche the src requirements in m, either
@@ -59,35 +61,38 @@ func srcMatches(m *filtertype.Match, srcAddr netip.Addr, hasCap CapTestFunc) boo
 // It it used in the fast path of evaluating filter rules so should be fast.
 type CapTestFunc = func(srcIP netip.Addr, cap tailcfg.NodeCapability) bool
 
-func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) bool {
+// matchIPsOnly is like match, but ignores the protocol and ports of the
+// Matches in ms.
+func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) int {
 	srcAddr := q.Src.Addr()
-	for _, m := range ms {
+	for i, m := range ms {
 		if !m.SrcsContains(srcAddr) {
 			continue
 		}
 		for _, dst := range m.Dsts {
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return i
 			}
 		}
 	}
 	if hasCap != nil {
-		for _, m := range ms {
+		for i, m := range ms {
 			for _, c := range m.SrcCaps {
 				if hasCap(srcAddr, c) {
-					return true
+					return i
 				}
 			}
 		}
 	}
-	return false
+	return -1
 }
 
-// matchProtoAndIPsOnlyIfAllPorts reports q matches any Match in ms where the
-// Match if for the right IP Protocol and IP address, but ports are
-// ignored, as long as the match is for the entire uint16 port range.
-func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
-	for _, m := range ms {
+// matchProtoAndIPsOnlyIfAllPorts returns the index of the first Match in ms
+// that matches q's IP Protocol and IP addresses, ignoring ports, as long as
+// the match is for the entire uint16 port range. It returns -1 if there is
+// none.
+func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) int {
+	for i, m := range ms {
 		if !views.SliceContains(m.IPProto, q.IPProto) {
 			continue
 		}
@@ -99,9 +104,9 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 				continue
 			}
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return i
 			}
 		}
 	}
-	return false
+	return -1
 }
@@ -0,0 +1,170 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+	"sync/atomic"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/usermetric"
+)
+
+// The reasons a Filter counts the incoming packets it drops by, as indexes
+// in filterStats.drops.
+const (
+	dropTooShort = iota
+	dropUnknownProtocol
+	dropMulticast
+	dropLinkLocalUnicast
+	dropNotIP
+	dropDestinationNotAllowed
+	dropNoRulesMatched
+
+	numDropReasons
+)
+
+// dropReasonNames are the names of the drop reasons in Filter.Stats.
+var dropReasonNames = [numDropReasons]string{
+	dropTooShort:              string(usermetric.ReasonTooShort),
+	dropUnknownProtocol:       string(usermetric.ReasonUnknownProtocol),
+	dropMulticast:             string(usermetric.ReasonMulticast),
+	dropLinkLocalUnicast:      string(usermetric.ReasonLinkLocalUnicast),
+	dropNotIP:                 "not-ip",
+	dropDestinationNotAllowed: "destination not allowed",
+	dropNoRulesMatched:        "no rules matched",
+}
+
+// dropReasonIndex returns the drop reason of a packet dropped for the reason
+// why, as returned by Filter.pre or Filter.runIn. Packets of the protocols
+// the filter has no rules for are all counted as dropUnknownProtocol.
+func dropReasonIndex(why string) int {
+	switch why {
+	case string(usermetric.ReasonTooShort):
+		return dropTooShort
+	case string(usermetric.ReasonMulticast):
+		return dropMulticast
+	case string(usermetric.ReasonLinkLocalUnicast):
+		return dropLinkLocalUnicast
+	case "not-ip":
+		return dropNotIP
+	case "destination not allowed":
+		return dropDestinationNotAllowed
+	case "no rules matched":
+		return dropNoRulesMatched
+	}
+	return dropUnknownProtocol
+}
+
+// filterStats are the counters of a Filter's verdicts on incoming packets.
+type filterStats struct {
+	hits  []atomic.Uint64               // by index in Filter.rules
+	drops [numDropReasons]atomic.Uint64 // by drop reason
+}
+
+func newFilterStats(rules int) *filterStats {
+	return &filterStats{hits: make([]atomic.Uint64, rules)}
+}
+
+// record counts the verdict r of RunIn for the reason why. rule is the index
+// of the rule that accepted the packet, or -1 if none did.
+func (s *filterStats) record(r Response, why string, rule int) {
+	switch {
+	case rule >= 0:
+		s.hits[rule].Add(1)
+	case r == Drop:
+		s.drop(why)
+	}
+}
+
+func (s *filterStats) drop(why string) {
+	s.drops[dropReasonIndex(why)].Add(1)
+}
+
+// RuleStats is a rule of a Filter and its counters.
+type RuleStats struct {
+	Match Match
+
+	// Hits is the number of incoming packets the rule accepted. Only
+	// packets that need a rule to be accepted are counted: TCP SYNs, UDP
+	// and SCTP packets that are not replies to a flow started by this
+	// node, and ICMP and other protocol packets.
+	Hits uint64
+}
+
+// Stats returns the counters of f's rules, in the order of the matches f
+// was created with, and the number of incoming packets f dropped, by
+// reason. The counters start at zero when f is created, so are reset
+// whenever the packet filter changes.
+func (f *Filter) Stats() (rules []RuleStats, drops map[string]uint64) {
+	rules = make([]RuleStats, len(f.rules))
+	for i, m := range f.rules {
+		rules[i] = RuleStats{Match: m, Hits: f.stats.hits[i].Load()}
+	}
+	for i := range f.stats.drops {
+		if n := f.stats.drops[i].Load(); n > 0 {
+			mak.Set(&drops, dropReasonNames[i], n)
+		}
+	}
+	return rules, drops
+}
+
+// Explanation is the verdict of a Filter on a new incoming flow, and why.
+type Explanation struct {
+	Verdict Response
+
+	// Reason is the reason for the verdict, as in the filter's logs.
+	Reason string
+
+	// Rule is the index of the rule that accepted the flow in the matches
+	// the filter was created with, or -1 if no rule did.
+	Rule int
+
+	// Match is the rule that accepted the flow, if Rule is not -1.
+	Match Match
+}
+
+// Explain reports whether f allows a new flow of protocol proto from srcIP
+// to dstIP:dstPort, like Check, and the rule that decided it. The flow is
+// not counted in f's Stats.
+func (f *Filter) Explain(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) Explanation {
+	pkt := flowPacket(srcIP, dstIP, dstPort, proto)
+	if pkt == nil {
+		// Mismatched address families, no filters will match.
+		return Explanation{Verdict: Drop, Reason: "address family mismatch", Rule: -1}
+	}
+	if r, reason := f.pre(pkt, 0, in); r == Accept || r == Drop {
+		return Explanation{Verdict: r, Reason: string(reason), Rule: -1}
+	}
+	r, why, rule := f.runIn(pkt)
+	e := Explanation{Verdict: r, Reason: why, Rule: rule}
+	if rule >= 0 {
+		e.Match = f.rules[rule]
+	}
+	return e
+}
+
+// flowPacket returns the first packet of a new flow of protocol proto from
+// srcIP to dstIP:dstPort, or nil if the addresses are invalid or of
+// different families.
+func flowPacket(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) *packet.Parsed {
+	if srcIP.Is4() != dstIP.Is4() || !srcIP.IsValid() || !dstIP.IsValid() {
+		return nil
+	}
+	pkt := &packet.Parsed{}
+	pkt.Decode(dummyPacket) // initialize private fields
+	pkt.IPVersion = 4
+	if srcIP.Is6() {
+		pkt.IPVersion = 6
+	}
+	pkt.Src = netip.AddrPortFrom(srcIP, 0)
+	pkt.Dst = netip.AddrPortFrom(dstIP, dstPort)
+	pkt.IPProto = proto
+	if proto == ipproto.TCP {
+		pkt.TCPFlags = packet.TCPSyn
+	}
+	return pkt
+}